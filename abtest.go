@@ -0,0 +1,302 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+const scorePrompt = "You are scoring an AI agent's answer to a task, without knowing how it was produced. " +
+	"Score it from 1 to 10 for how correct and complete it is and how well it follows the task's instructions, " +
+	"and briefly say why."
+
+var scoreSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"score":  map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
+		"reason": map[string]any{"type": "string"},
+	},
+	"required":             []any{"score", "reason"},
+	"additionalProperties": false,
+}
+
+// abRun is one run of the task under a prompt profile, with the judge's
+// score of its answer, 0 if it failed or couldn't be scored.
+type abRun struct {
+	comparison
+	score  int
+	reason string
+}
+
+// abArm is what the runs of one prompt profile took on average.
+type abArm struct {
+	profile   string
+	runs      []abRun
+	failed    int
+	turns     float64
+	toolCalls float64
+	tokens    float64
+	cost      float64
+	duration  time.Duration
+	score     float64
+	scored    int
+}
+
+// runAB runs task under two prompt profiles with the same model, runs times
+// each and all in parallel, has a judge score every answer, and prints what
+// each profile took and scored on average.
+func runAB(ctx context.Context, cfg *config, task, model string, profiles []string, runs int, judgeModel string) error {
+	if task == "" {
+		return fmt.Errorf("-ab needs -task")
+	}
+	if len(profiles) != 2 {
+		return fmt.Errorf("-ab needs two prompt profiles, such as default,concise")
+	}
+	if runs < 1 {
+		return fmt.Errorf("-ab-runs must be at least 1")
+	}
+
+	arms := make([]abArm, len(profiles))
+	prompts := make([][]string, len(profiles))
+	for i, profile := range profiles {
+		profile = strings.TrimSpace(profile)
+
+		var err error
+		if prompts[i], err = profilePrompts(cfg, profile); err != nil {
+			return err
+		}
+		arms[i] = abArm{profile: profile, runs: make([]abRun, runs)}
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range arms {
+		for j := range runs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				sess := newSession(workspace, model)
+				sess.Messages = promptMessages(prompts[i])
+
+				run := abRun{comparison: compareSession(ctx, b, sess, task)}
+				if run.err == nil {
+					run.score, run.reason = scoreAnswer(ctx, b, cmp.Or(judgeModel, model), task, run.answer)
+				}
+				arms[i].runs[j] = run
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	for i := range arms {
+		arms[i].summarize()
+	}
+
+	if cfg.Output.Format == "json" {
+		return writeABRecord(task, model, arms)
+	}
+
+	if runs == 1 {
+		var results []comparison
+		for _, arm := range arms {
+			c := arm.runs[0].comparison
+			c.model = arm.profile
+			results = append(results, c)
+		}
+		printComparison(results)
+	}
+
+	printABSummary(arms)
+
+	return nil
+}
+
+func (arm *abArm) summarize() {
+	var succeeded int
+
+	for _, run := range arm.runs {
+		if run.err != nil {
+			arm.failed++
+			continue
+		}
+		succeeded++
+
+		arm.turns += float64(run.turns)
+		arm.toolCalls += float64(run.toolCalls)
+		arm.tokens += float64(run.usage.PromptTokens + run.usage.CompletionTokens)
+		arm.cost += run.usage.Cost
+		arm.duration += run.duration
+
+		if run.score > 0 {
+			arm.score += float64(run.score)
+			arm.scored++
+		}
+	}
+
+	if succeeded > 0 {
+		n := float64(succeeded)
+		arm.turns /= n
+		arm.toolCalls /= n
+		arm.tokens /= n
+		arm.cost /= n
+		arm.duration /= time.Duration(succeeded)
+	}
+	if arm.scored > 0 {
+		arm.score /= float64(arm.scored)
+	}
+}
+
+// scoreAnswer has model score answer from 1 to 10, or returns 0 if it
+// couldn't.
+func scoreAnswer(ctx context.Context, b *backend, model, task, answer string) (int, string) {
+	judge := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(model),
+	)
+	judge.Schema = scoreSchema
+
+	result, err := judge.Run(ctx, fmt.Sprintf("%s\n\n## Task\n%s\n\n## Answer\n%s\n", scorePrompt, task, answer))
+	if err != nil {
+		return 0, fmt.Sprintf("judge failed: %v", err)
+	}
+
+	var verdict struct {
+		Score  int    `json:"score"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(result.Answer), &verdict); err != nil {
+		return 0, fmt.Sprintf("judge gave an invalid score: %v", err)
+	}
+
+	return min(max(verdict.Score, 1), 10), verdict.Reason
+}
+
+func printABSummary(arms []abArm) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "PROFILE\tRUNS\tFAILED\tTURNS\tTOOL CALLS\tTOKENS\tCOST\tTIME\tSCORE")
+	for _, arm := range arms {
+		score := "-"
+		if arm.scored > 0 {
+			score = fmt.Sprintf("%.1f", arm.score)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\t%.0f\t$%.4f\t%s\t%s\n",
+			arm.profile, len(arm.runs), arm.failed, arm.turns, arm.toolCalls, arm.tokens, arm.cost, arm.duration.Round(100*time.Millisecond), score)
+	}
+
+	w.Flush()
+
+	a, b := arms[0], arms[1]
+	if a.scored == 0 || b.scored == 0 {
+		return
+	}
+	if b.score > a.score || b.score == a.score && b.tokens < a.tokens {
+		a, b = b, a
+	}
+
+	summary := fmt.Sprintf("%s scored %.1f higher than %s", a.profile, a.score-b.score, b.profile)
+	if a.score == b.score {
+		summary = fmt.Sprintf("%s and %s scored the same", a.profile, b.profile)
+	}
+	switch {
+	case b.tokens == 0 || a.tokens == b.tokens:
+	case a.tokens < b.tokens:
+		summary += fmt.Sprintf(", with %.0f%% fewer tokens", 100*(b.tokens-a.tokens)/b.tokens)
+	default:
+		summary += fmt.Sprintf(", with %.0f%% more tokens", 100*(a.tokens-b.tokens)/b.tokens)
+	}
+	print("%s", statusStyle.Render(summary))
+}
+
+// abRecord is the -output json record of -ab.
+type abRecord struct {
+	Task  string        `json:"task"`
+	Model string        `json:"model"`
+	Arms  []abArmRecord `json:"arms"`
+}
+
+type abArmRecord struct {
+	Profile    string        `json:"profile"`
+	Failed     int           `json:"failed"`
+	Turns      float64       `json:"turns"`
+	ToolCalls  float64       `json:"tool_calls"`
+	Tokens     float64       `json:"tokens"`
+	Cost       float64       `json:"cost"`
+	DurationMS int64         `json:"duration_ms"`
+	Score      float64       `json:"score,omitempty"`
+	Runs       []abRunRecord `json:"runs"`
+}
+
+type abRunRecord struct {
+	Answer     string      `json:"answer"`
+	Error      string      `json:"error,omitempty"`
+	Score      int         `json:"score,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Turns      int         `json:"turns"`
+	ToolCalls  int         `json:"tool_calls"`
+	DurationMS int64       `json:"duration_ms"`
+	Usage      agent.Usage `json:"usage"`
+}
+
+func writeABRecord(task, model string, arms []abArm) error {
+	record := abRecord{Task: task, Model: model}
+
+	for _, arm := range arms {
+		a := abArmRecord{
+			Profile:    arm.profile,
+			Failed:     arm.failed,
+			Turns:      arm.turns,
+			ToolCalls:  arm.toolCalls,
+			Tokens:     arm.tokens,
+			Cost:       arm.cost,
+			DurationMS: arm.duration.Milliseconds(),
+			Score:      arm.score,
+		}
+
+		for _, run := range arm.runs {
+			r := abRunRecord{
+				Answer:     redact(run.answer),
+				Score:      run.score,
+				Reason:     run.reason,
+				Turns:      run.turns,
+				ToolCalls:  run.toolCalls,
+				DurationMS: run.duration.Milliseconds(),
+				Usage:      run.usage,
+			}
+			if run.err != nil {
+				r.Error = run.err.Error()
+			}
+
+			a.Runs = append(a.Runs, r)
+		}
+
+		record.Arms = append(record.Arms, a)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(record)
+}
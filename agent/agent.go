@@ -0,0 +1,856 @@
+// Package agent runs a tool-calling loop between a chat completion provider
+// and one or more MCP servers.
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+var errNoChoices = errors.New("the completion has no choices")
+
+// ErrCompletion and ErrToolCall wrap the errors of runs that failed because
+// the provider couldn't complete a request or a tool couldn't be called.
+// ErrServerUnreachable wraps those of LoadTools when a server couldn't be
+// initialized, such as because it is down.
+var (
+	ErrCompletion        = errors.New("failed to create chat completion")
+	ErrToolCall          = errors.New("failed to call tool")
+	ErrServerUnreachable = errors.New("failed to initialize MCP client")
+)
+
+const continuePrompt = "Your previous response was cut off. Continue exactly where you left off, without repeating anything."
+
+type Agent struct {
+	// Model is used for every completion unless a policy overrides it for a
+	// single request, Routing for a turn, or Escalation for the rest of a
+	// run.
+	Model string
+
+	// Messages is the conversation so far. Run appends to it.
+	Messages []openai.ChatCompletionMessageParamUnion
+
+	// Prepare, if set, is called with the parameters of every completion
+	// request before it is sent. Changes only affect that request.
+	Prepare func(params *openai.ChatCompletionNewParams)
+
+	// Retrieve, if set, is called with every task and returns context
+	// relevant to it, such as excerpts from documents. The context is sent
+	// with the requests of that run but isn't stored in the conversation.
+	Retrieve func(ctx context.Context, task string) (string, error)
+
+	// SelectTools, if set, is called with every task and returns the names
+	// of the tools to offer for it, or nil for all of them, to save sending
+	// hundreds of schemas every turn. Local tools, and tools called earlier
+	// in the conversation, are offered too.
+	SelectTools func(ctx context.Context, task string, tools []openai.ChatCompletionToolParam) ([]string, error)
+
+	// Streaming, if set, streams completions from providers that support it
+	// and emits TextDelta and ToolCallDelta events as they arrive.
+	Streaming bool
+
+	// StartToolCalls, if set while streaming, starts each tool call that
+	// will run without asking as soon as its arguments are complete, while
+	// the model writes the rest of its response. If the response fails, its
+	// calls may have run anyway.
+	StartToolCalls bool
+
+	// Events, if set, receives every event as it happens. Sends block, so
+	// the receiver must keep draining the channel while Run is in progress.
+	Events chan<- Event
+
+	ContentFilter    ContentFilterPolicy
+	MaxContinuations int
+
+	// FallbackModels are tried in order when a completion fails, for example
+	// because the model is rate limited, unavailable or doesn't support
+	// tools. The next turn tries the model asked for again.
+	FallbackModels []string
+
+	// ArgumentRepairs is how many tool calls with arguments that aren't
+	// valid JSON are sent back to the model in a run before it fails.
+	ArgumentRepairs int
+
+	// Schema, if set, is a JSON schema the final answer must match. Invalid
+	// answers are sent back to the model up to SchemaRepairs times.
+	Schema        map[string]any
+	SchemaRepairs int
+
+	// Tokens counts tokens for compaction and truncation. Nil estimates
+	// them like a GPT tokenizer.
+	Tokens TokenCounter
+
+	Compaction Compaction
+	Summarizer Summarizer
+	Truncation Truncation
+	Prefetch   Prefetch
+	Delegation Delegation
+
+	// ToolCache, if set, keeps the tools listed by servers, to share them
+	// with other agents connecting to the same servers.
+	ToolCache *ToolCache
+
+	// ToolDescriptions replace or add to the descriptions servers give
+	// their tools, by tool name.
+	ToolDescriptions map[string]ToolDescription
+
+	// Budget limits what a run may spend.
+	Budget Budget
+
+	// Escalation switches a run that is stuck to a stronger model.
+	Escalation Escalation
+
+	// Routing picks the model of each turn.
+	Routing Routing
+
+	// Loops nudges a run going round in circles, then stops it.
+	Loops LoopDetection
+
+	// Batching reminds a model making one tool call at a time to batch
+	// them.
+	Batching BatchingHint
+
+	// Concurrency limits tool calls running in parallel.
+	Concurrency Concurrency
+
+	// Roots, if set, are advertised to servers as the directories they may
+	// work in. Answering their roots/list requests is up to the client's
+	// transport.
+	Roots []mcp.Root
+
+	// ServerLogs, if set, is called with the messages servers log of
+	// LogLevel and above, from the goroutine reading notifications. It must
+	// not block.
+	ServerLogs func(ServerLog)
+	LogLevel   mcp.LoggingLevel
+
+	// ResourceUpdated, if set, is called with the server and URI of a
+	// resource subscribed to with Subscribe when it changes, from the
+	// goroutine reading notifications. It must not block.
+	ResourceUpdated func(server, uri string)
+
+	// RateLimits limits how often completions are requested and tools
+	// called.
+	RateLimits RateLimits
+
+	// DryRun, if set, skips every tool call and tells the model so, for it
+	// to describe what it would have done.
+	DryRun bool
+
+	// Provenance, if set, appends a footer to answers listing the tools
+	// called to produce them, so readers know the answer was computed.
+	Provenance bool
+
+	// Tripwires are checked before every tool call, whatever else is
+	// configured. Approve is asked about calls matching a tripwire with the
+	// approve action, calls to ApproveTools, or every call if ApproveAll is
+	// set, and returns the arguments to run them with, which may have been
+	// edited, and whether they may run.
+	Tripwires    []Tripwire
+	ApproveTools []string
+	ApproveAll   bool
+	Approve      func(ctx context.Context, call ToolCallStarted, reason string) (map[string]any, bool, error)
+
+	// Guardrails check responses before they are shown or their tool calls
+	// run, and OverrideGuardrail, if set, is asked whether a response they
+	// block may go through anyway.
+	Guardrails        Guardrails
+	OverrideGuardrail func(ctx context.Context, tripped GuardrailTripped) (bool, error)
+
+	// ToolRules allow, ask about or deny tool calls by tool name and
+	// arguments, ahead of ApproveTools and ApproveAll.
+	ToolRules []ToolRule
+
+	// PreToolCall, if set, is called before every tool call that would run.
+	// It blocks the call if it returns a reason, which the model is told,
+	// and otherwise may return other arguments to make the call with, which
+	// the model sees it as having made. Calls aren't made ahead of time while
+	// it is set, as it couldn't block or change them.
+	//
+	// PostToolCall, if set, is called with every tool call that ran, and
+	// returns the result to give the model.
+	PreToolCall  func(ctx context.Context, call ToolCallStarted) (map[string]any, string, error)
+	PostToolCall func(ctx context.Context, call ToolCallFinished) (string, error)
+
+	// ContextLength reports the context window of a model, or 0 if unknown.
+	ContextLength func(model string) int64
+
+	// CheckModel, if set, is called before the first run with each model
+	// and fails it if the model can't be used, such as because it doesn't
+	// support tool calls, rather than the run failing midway.
+	CheckModel func(ctx context.Context, model string) error
+
+	Usage      Usage
+	Turns      []Turn
+	RawOutputs map[string]string
+
+	// Pinned holds the PinKey of messages that compaction keeps verbatim,
+	// such as the result of loading a dataset, rather than summarizing.
+	Pinned map[string]bool
+
+	provider    Provider
+	clients     []*mcpclient.Client
+	initOptions map[*mcpclient.Client]InitOptions
+	servers     []ServerInfo
+	routes      map[string]*mcpclient.Client
+	local       map[string]LocalTool
+	composites  map[string]CompositeTool
+
+	// subscriptions maps the resources subscribed to to their server.
+	subscriptions map[string]*mcpclient.Client
+	tools         []openai.ChatCompletionToolParam
+	loaded        bool
+
+	// connected holds the servers of the clients LoadTools initialized, and
+	// toolsChanged is set when one of them notifies that its tools changed.
+	connected    map[*mcpclient.Client]ServerInfo
+	toolsChanged atomic.Bool
+
+	// toolServers maps tools to the name of the server offering them, and
+	// readOnly holds the tools annotated as read-only.
+	toolServers map[string]string
+	readOnly    map[string]bool
+
+	// schemas are the input schemas of the tools, to validate arguments.
+	schemas map[string]map[string]any
+
+	observers []func(Event)
+
+	speculation     *speculation
+	nextTurn        *nextTurn
+	earlyCalls      map[string]*earlyCall
+	finishedCalls   map[string]string
+	argumentRepairs int
+	limiters        map[string]*limiter
+	checkedModels   map[string]bool
+
+	// mu guards the state tool calls running in parallel share, and
+	// eventsMu keeps events in the same order in the result and on Events.
+	mu       sync.Mutex
+	eventsMu sync.Mutex
+
+	modelOverride string
+	escalation    escalation
+	routed        string
+	loop          loopState
+	batching      batchingState
+	retrieved     string
+	spent         spent
+	selected      map[string]bool
+	result        *Result
+
+	recording *Cassette
+	replaying *Cassette
+}
+
+// ContentFilterPolicy controls what happens when the provider blocks a
+// response with a content filter.
+type ContentFilterPolicy struct {
+	RetryPrompt string
+	Model       string
+	MaxRetries  int
+}
+
+type Result struct {
+	Answer string
+	Events []Event
+}
+
+// New returns an agent configured by opts. Without options, it uses OpenAI
+// with no tools.
+func New(opts ...Option) *Agent {
+	a := &Agent{
+		local:            make(map[string]LocalTool),
+		composites:       make(map[string]CompositeTool),
+		subscriptions:    make(map[string]*mcpclient.Client),
+		MaxContinuations: 3,
+		SchemaRepairs:    2,
+		ArgumentRepairs:  2,
+		ContentFilter: ContentFilterPolicy{
+			MaxRetries: 1,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.provider == nil {
+		a.provider = defaultProvider()
+	}
+
+	return a
+}
+
+// Run adds task to the conversation and runs the agent loop until the model
+// produces an answer without calling tools.
+func (a *Agent) Run(ctx context.Context, task string) (*Result, error) {
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, err
+	}
+	if err := a.checkModels(ctx); err != nil {
+		return nil, err
+	}
+
+	a.Messages = append(a.Messages, openai.UserMessage(task))
+
+	return a.run(ctx, task)
+}
+
+// Continue runs the agent loop on the conversation as it is, without adding
+// a task, such as to retry a turn after removing it from Messages.
+func (a *Agent) Continue(ctx context.Context) (*Result, error) {
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, err
+	}
+	if err := a.checkModels(ctx); err != nil {
+		return nil, err
+	}
+
+	return a.run(ctx, a.lastQuestion())
+}
+
+func (a *Agent) run(ctx context.Context, task string) (*Result, error) {
+	if a.Budget.Deadline <= 0 {
+		a.spent = spent{usage: a.Usage, turns: len(a.Turns)}
+		return a.runTask(ctx, task)
+	}
+
+	a.spent = spent{usage: a.Usage, turns: len(a.Turns), deadline: time.Now().Add(a.Budget.Deadline)}
+
+	runCtx, cancel := context.WithDeadline(ctx, a.spent.deadline)
+	result, err := a.runTask(runCtx, task)
+	cancel()
+
+	if err == nil || ctx.Err() != nil || !a.pastDeadline() {
+		return result, err
+	}
+
+	return a.finishAtDeadline(ctx, result)
+}
+
+func (a *Agent) runTask(ctx context.Context, task string) (*Result, error) {
+	a.result = &Result{}
+	a.argumentRepairs = 0
+	a.escalation = escalation{}
+	a.loop = loopState{}
+	a.batching = batchingState{}
+	defer func() { a.result = nil }()
+
+	a.retrieved = ""
+	if a.Retrieve != nil {
+		retrieved, err := a.Retrieve(ctx, task)
+		if err != nil {
+			a.warn("Failed to retrieve context: %v", err)
+		}
+		a.retrieved = retrieved
+	}
+
+	a.selectTools(ctx, task)
+
+	defer a.discardSpeculation()
+	defer a.discardNextTurn()
+	defer a.discardEarlyCalls()
+
+	var (
+		turn, filterRetries, repairs, continuations int
+
+		partial      string
+		partialStart int
+	)
+
+	if err := a.answerPendingCalls(ctx); err != nil {
+		return a.fail(fmt.Errorf("%w: %w", ErrToolCall, err))
+	}
+
+	for {
+		if a.toolsChanged.Load() {
+			a.discardNextTurn()
+			a.warn("The MCP server's tools changed, listing them again...")
+			if err := a.LoadTools(ctx); err != nil {
+				a.warn("Failed to reload tools: %v", err)
+			}
+		}
+
+		// Compaction would shift the messages a continuation is stitched into.
+		if continuations == 0 {
+			if err := a.compactIfNeeded(ctx); err != nil {
+				a.warn("Failed to compact context: %v", err)
+			}
+		}
+
+		a.discardEarlyCalls()
+		a.prefetch(ctx)
+
+		// Tool calls that don't stop at the deadline finish before it's seen.
+		if a.pastDeadline() {
+			return a.fail(context.DeadlineExceeded)
+		}
+
+		turn++
+		a.emit(TurnStarted{Turn: turn})
+
+		var (
+			timing   completionTiming
+			fallback bool
+			err      error
+		)
+
+		completion := a.takeNextTurn(&timing)
+		if completion == nil {
+			completion, fallback, err = a.completeTurn(ctx, &timing)
+			if err != nil {
+				return a.fail(fmt.Errorf("%w: %w", ErrCompletion, err))
+			}
+		}
+
+		a.recordTurn(completion, fallback, timing)
+
+		if err := a.checkBudget(); err != nil {
+			return a.fail(err)
+		}
+
+		finishReason := completion.Choices[0].FinishReason
+		message := completion.Choices[0].Message
+
+		if reasoning := messageReasoning(message); reasoning != "" {
+			a.emit(Reasoning{Text: reasoning})
+		}
+		a.emitRemoteCalls(message)
+
+		if finishReason == "content_filter" {
+			a.warn("The response was blocked by the provider's content filter.")
+
+			if !a.retryContentFilter(filterRetries) {
+				break
+			}
+
+			filterRetries++
+			continue
+		}
+
+		if finishReason == "length" && len(message.ToolCalls) == 0 && continuations < a.MaxContinuations {
+			if continuations == 0 {
+				partialStart = len(a.Messages)
+			}
+			continuations++
+			partial += message.Content
+
+			a.warn("The response hit the token limit, asking the model to continue (%d/%d)...", continuations, a.MaxContinuations)
+
+			a.Messages = append(
+				a.Messages,
+				message.ToParam(),
+				openai.UserMessage(continuePrompt),
+			)
+			continue
+		}
+
+		// Stitch continued pieces back into a single assistant message so
+		// neither the output nor the history shows the seams.
+		if continuations > 0 {
+			message.Content = partial + message.Content
+			a.Messages = a.Messages[:partialStart]
+			partial, continuations = "", 0
+		}
+
+		switch {
+		case finishReason != "length":
+		case len(message.ToolCalls) > 0:
+			a.warn("The response hit the token limit while calling tools; arguments may be incomplete.")
+		default:
+			a.warn("The response hit the token limit and may be incomplete.")
+		}
+
+		if err := a.checkGuardrails(ctx, message); err != nil {
+			return a.fail(err)
+		}
+
+		content := message.Content
+		toolCalls := message.ToolCalls
+		structured := a.Schema != nil && len(toolCalls) == 0
+
+		// The footer is only added to the answer, not the history.
+		if a.Provenance && len(toolCalls) == 0 && !structured {
+			content += a.provenance()
+		}
+
+		if content != "" && !structured {
+			a.emit(AssistantText{Text: content})
+		}
+
+		a.Messages = append(a.Messages, message.ToParam())
+		a.checkpointResponse()
+
+		if structured {
+			if a.checkStructuredAnswer(content, repairs) {
+				break
+			}
+
+			repairs++
+			continue
+		}
+
+		if len(toolCalls) == 0 {
+			if diagnosis := a.detectEmptyResponse(message.Content); diagnosis != "" {
+				if err := a.nudge(diagnosis); err != nil {
+					return a.fail(err)
+				}
+				continue
+			}
+
+			a.result.Answer = content
+			break
+		}
+
+		events := len(a.result.Events)
+
+		results, err := a.callTools(ctx, toolCalls)
+		if err != nil {
+			return a.fail(fmt.Errorf("%w: %w", ErrToolCall, err))
+		}
+
+		a.checkEscalation(toolCalls, a.result.Events[events:])
+
+		for i, toolCall := range toolCalls {
+			result := a.truncateToolResult(toolCall, a.summarizeToolResult(ctx, toolCall, results[i]))
+
+			a.Messages = append(
+				a.Messages,
+				openai.ToolMessage(result, toolCall.ID),
+			)
+		}
+
+		if diagnosis := a.detectToolLoop(toolCalls, results); diagnosis != "" {
+			if err := a.nudge(diagnosis); err != nil {
+				return a.fail(err)
+			}
+		} else {
+			a.hintBatching(toolCalls)
+		}
+
+		a.turnFinished()
+	}
+
+	a.turnFinished()
+
+	return a.result, nil
+}
+
+// Stream runs a task like Run, but in the background, and returns a channel
+// receiving its events. Completions are streamed, so text and tool calls
+// arrive in TextDelta and ToolCallDelta events as the model writes them. The
+// last event is Done, after which the channel is closed. It can't be used
+// with Events, which it sets for the duration of the run, as it does
+// Streaming.
+func (a *Agent) Stream(ctx context.Context, task string) (<-chan Event, error) {
+	if a.Events != nil {
+		return nil, fmt.Errorf("events are already sent to a channel")
+	}
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 64)
+	streaming := a.Streaming
+	a.Events = events
+	a.Streaming = true
+
+	go func() {
+		defer close(events)
+
+		result, err := func() (*Result, error) {
+			defer func() {
+				a.Events = nil
+				a.Streaming = streaming
+			}()
+			return a.Run(ctx, task)
+		}()
+
+		events <- Done{Result: result, Err: err}
+	}()
+
+	return events, nil
+}
+
+// complete requests a completion for the agent loop, streaming it if enabled,
+// and adds how long it was queued and took to start to timing.
+func (a *Agent) complete(ctx context.Context, params openai.ChatCompletionNewParams, timing *completionTiming) (*openai.ChatCompletion, error) {
+	waited := time.Now()
+	if err := a.waitRate(ctx, "requesting a completion", a.RateLimits.Completions); err != nil {
+		return nil, err
+	}
+	timing.queued += time.Since(waited)
+
+	provider, ok := a.provider.(StreamingProvider)
+	if !a.Streaming || !ok {
+		return a.provider.Complete(ctx, params)
+	}
+
+	var (
+		toolCalls []ToolCallDelta
+
+		// Calls are started early in order, up to the first that can't be
+		// when they must run one at a time.
+		early   *earlyCall
+		checked int
+		blocked bool
+	)
+
+	sent := time.Now()
+	timing.firstToken = 0
+
+	completion, err := provider.Stream(ctx, params, func(chunk openai.ChatCompletionChunk) {
+		if timing.firstToken == 0 {
+			timing.firstToken = time.Since(sent)
+		}
+
+		for _, choice := range chunk.Choices {
+			// Nothing is shown before the guardrails have seen all of it.
+			if a.Guardrails.blocks() {
+				continue
+			}
+
+			if choice.Delta.Content != "" {
+				a.emit(TextDelta{Text: choice.Delta.Content})
+			}
+
+			for _, delta := range choice.Delta.ToolCalls {
+				i := int(delta.Index)
+
+				// The calls before this one are complete once it starts.
+				for ; checked < min(i, len(toolCalls)); checked++ {
+					if blocked || toolCalls[checked].ID == "" {
+						continue
+					}
+
+					if c := a.startEarlyCall(ctx, toolCalls[checked], early); c != nil {
+						early = c
+					} else if a.Concurrency.Max <= 1 {
+						blocked = true
+					}
+				}
+
+				for len(toolCalls) <= i {
+					toolCalls = append(toolCalls, ToolCallDelta{Index: len(toolCalls)})
+				}
+
+				call := &toolCalls[i]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				call.Name += delta.Function.Name
+				call.Arguments += delta.Function.Arguments
+
+				a.emit(*call)
+			}
+		}
+	})
+	if err != nil {
+		a.discardEarlyCalls()
+	}
+
+	return completion, err
+}
+
+// completeWithFallback makes a completion, moving down FallbackModels while
+// it fails. It reports whether a fallback model made it.
+func (a *Agent) completeWithFallback(ctx context.Context, params openai.ChatCompletionNewParams, timing *completionTiming) (*openai.ChatCompletion, bool, error) {
+	completion, err := a.complete(ctx, params, timing)
+	if err == nil && len(completion.Choices) == 0 {
+		err = errNoChoices
+	}
+
+	fallback := false
+
+	for _, model := range a.FallbackModels {
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if model == params.Model {
+			continue
+		}
+
+		a.warn("%s failed, retrying with %s: %v", params.Model, model, err)
+
+		params.Model = model
+		fallback = true
+
+		completion, err = a.complete(ctx, params, timing)
+		if err == nil && len(completion.Choices) == 0 {
+			err = errNoChoices
+		}
+	}
+
+	return completion, fallback, err
+}
+
+func (a *Agent) emit(event Event) {
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+
+	// Deltas and checkpoints are only useful as they happen.
+	switch event.(type) {
+	case TextDelta, ToolCallDelta, Checkpoint:
+	default:
+		if a.result != nil {
+			a.result.Events = append(a.result.Events, event)
+		}
+	}
+
+	for _, observe := range a.observers {
+		observe(event)
+	}
+
+	if a.Events != nil {
+		a.Events <- event
+	}
+}
+
+func (a *Agent) warn(format string, args ...any) {
+	a.emit(Warning{Text: fmt.Sprintf(format, args...)})
+}
+
+func (a *Agent) fail(err error) (*Result, error) {
+	// Runs stopped by their deadline report it once they have summarized.
+	if !a.pastDeadline() {
+		a.emit(Error{Err: err})
+	}
+	return a.result, err
+}
+
+func (a *Agent) turnFinished() {
+	a.emit(TurnFinished{
+		Messages:   slices.Clone(a.Messages),
+		Usage:      a.Usage,
+		Turns:      slices.Clone(a.Turns),
+		RawOutputs: maps.Clone(a.RawOutputs),
+	})
+}
+
+func (a *Agent) requestParams() openai.ChatCompletionNewParams {
+	params := a.paramsFor(a.Messages)
+
+	if a.modelOverride != "" {
+		params.Model = a.modelOverride
+		a.modelOverride = ""
+	} else {
+		a.routeModel(&params)
+	}
+
+	return params
+}
+
+// paramsFor prepares a request for the next completion of a conversation.
+func (a *Agent) paramsFor(messages []openai.ChatCompletionMessageParamUnion) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Model:    cmp.Or(a.escalation.model, a.Model),
+		Messages: messages,
+		Tools:    a.offeredTools(),
+	}
+
+	if a.Schema != nil {
+		params.ResponseFormat = responseFormat(a.Schema)
+	}
+
+	if a.retrieved != "" {
+		start := 0
+		for start < len(params.Messages) && params.Messages[start].OfSystem != nil {
+			start++
+		}
+
+		params.Messages = slices.Insert(slices.Clone(params.Messages), start, openai.SystemMessage(a.retrieved))
+	}
+
+	// What is left of the budget goes last, so the rest of the conversation
+	// stays a cacheable prefix as it changes every turn.
+	if remaining := a.remainingBudget(); remaining != "" {
+		params.Messages = append(slices.Clone(params.Messages), openai.SystemMessage(remaining))
+	}
+
+	if a.Prepare != nil {
+		params.Messages = slices.Clone(params.Messages)
+		a.Prepare(&params)
+	}
+
+	return params
+}
+
+// retryContentFilter prepares the next request after a content filter block,
+// either by rewording the request, switching model, or both. It returns false
+// if no retry is configured or retries are exhausted.
+func (a *Agent) retryContentFilter(attempt int) bool {
+	filter := a.ContentFilter
+
+	if filter.RetryPrompt == "" && filter.Model == "" {
+		return false
+	}
+	if attempt >= filter.MaxRetries {
+		a.warn("Giving up after %d content filter retries.", attempt)
+		return false
+	}
+
+	if filter.RetryPrompt != "" {
+		a.Messages = append(a.Messages, openai.UserMessage(filter.RetryPrompt))
+	}
+	if filter.Model != "" {
+		// Only the retry goes to this model; its turn records it.
+		a.modelOverride = filter.Model
+		a.warn("Retrying with %s (%d/%d)...", filter.Model, attempt+1, filter.MaxRetries)
+		return true
+	}
+
+	a.warn("Retrying (%d/%d)...", attempt+1, filter.MaxRetries)
+
+	return true
+}
+
+// checkStructuredAnswer validates a final answer against the JSON schema.
+// Invalid answers are fed back to the model while repair attempts remain; it
+// returns false if another turn is needed.
+func (a *Agent) checkStructuredAnswer(content string, attempt int) bool {
+	var value any
+
+	var errs []string
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		errs = []string{fmt.Sprintf("answer is not valid JSON: %v", err)}
+	} else {
+		errs = ValidateSchema(a.Schema, value)
+	}
+
+	if len(errs) == 0 {
+		pretty, _ := json.MarshalIndent(value, "", "  ")
+		a.result.Answer = string(pretty)
+		a.emit(AssistantText{Text: a.result.Answer, Structured: true})
+		return true
+	}
+
+	if attempt >= a.SchemaRepairs {
+		a.warn("The answer does not match the JSON schema:\n- %s", strings.Join(errs, "\n- "))
+		a.result.Answer = content
+		a.emit(AssistantText{Text: content})
+		return true
+	}
+
+	a.warn("The answer does not match the JSON schema, asking the model to repair it (%d/%d)...", attempt+1, a.SchemaRepairs)
+
+	a.Messages = append(a.Messages, openai.UserMessage(
+		"Your answer does not match the required JSON schema:\n- "+strings.Join(errs, "\n- ")+
+			"\nRespond again with only the corrected JSON.",
+	))
+
+	return false
+}
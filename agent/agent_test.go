@@ -0,0 +1,264 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/agent/agenttest"
+	"github.com/openai/openai-go"
+)
+
+var echo = agenttest.Tool{
+	Name:        "echo",
+	Description: "Echo the text back.",
+	Params:      []string{"text"},
+	Handler: func(args map[string]any) (string, error) {
+		return args["text"].(string), nil
+	},
+}
+
+var broken = agenttest.Tool{
+	Name:        "broken",
+	Description: "Always fails.",
+	Handler: func(args map[string]any) (string, error) {
+		return "", errors.New("disk on fire")
+	},
+}
+
+func newAgent(t *testing.T, provider *agenttest.Provider, tools ...agenttest.Tool) *agent.Agent {
+	t.Helper()
+
+	client, err := agenttest.NewClient(context.Background(), tools...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return agent.New(
+		agent.WithToolSource(client),
+		agent.WithProvider(provider),
+		agent.WithModel("test-model"),
+	)
+}
+
+func toolResults(result *agent.Result) []agent.ToolCallFinished {
+	var finished []agent.ToolCallFinished
+	for _, event := range result.Events {
+		if event, ok := event.(agent.ToolCallFinished); ok {
+			finished = append(finished, event)
+		}
+	}
+
+	return finished
+}
+
+func TestRunToolCallLoop(t *testing.T) {
+	provider := agenttest.NewProvider(
+		agenttest.CallTools(agenttest.ToolCall{Name: "echo", Arguments: map[string]any{"text": "one"}}),
+		agenttest.CallTools(
+			agenttest.ToolCall{Name: "echo", Arguments: map[string]any{"text": "two"}},
+			agenttest.ToolCall{Name: "echo", Arguments: map[string]any{"text": "three"}},
+		),
+		agenttest.Reply("done"),
+	)
+	a := newAgent(t, provider, echo)
+
+	result, err := a.Run(context.Background(), "echo some things")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Answer != "done" {
+		t.Errorf("answer = %q, want %q", result.Answer, "done")
+	}
+
+	var got []string
+	for _, finished := range toolResults(result) {
+		got = append(got, finished.Result)
+	}
+	if strings.Join(got, ",") != "one,two,three" {
+		t.Errorf("tool results = %v, want [one two three]", got)
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("got %d completion requests, want 3", len(requests))
+	}
+
+	// The last request carries every tool result back to the model.
+	var toolMessages int
+	for _, message := range requests[2].Messages {
+		if message.OfTool != nil {
+			toolMessages++
+		}
+	}
+	if toolMessages != 3 {
+		t.Errorf("last request has %d tool messages, want 3", toolMessages)
+	}
+
+	if len(a.Turns) != 3 {
+		t.Errorf("recorded %d turns, want 3", len(a.Turns))
+	}
+	if a.Usage.PromptTokens != 30 || a.Usage.CompletionTokens != 15 {
+		t.Errorf("usage = %+v, want 30 prompt and 15 completion tokens", a.Usage)
+	}
+}
+
+func TestRunUnknownTool(t *testing.T) {
+	provider := agenttest.NewProvider(
+		agenttest.CallTools(agenttest.ToolCall{Name: "missing", Arguments: map[string]any{}}),
+		agenttest.CallTools(agenttest.ToolCall{Name: "echo", Arguments: map[string]any{"text": "found it"}}),
+		agenttest.Reply("done"),
+	)
+	a := newAgent(t, provider, echo)
+
+	result, err := a.Run(context.Background(), "call a tool that doesn't exist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The model is told which tools exist and gets to call one of them.
+	requests := provider.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("got %d completion requests, want 3", len(requests))
+	}
+	messages := requests[1].Messages
+	tool := messages[len(messages)-1].OfTool
+	if tool == nil || tool.Content.OfString.Value != "unknown tool missing; available: echo" {
+		t.Errorf("the model wasn't told the tool doesn't exist")
+	}
+
+	finished := toolResults(result)
+	if len(finished) != 2 || finished[0].Err == nil || finished[1].Result != "found it" {
+		t.Errorf("tool calls = %+v, want a failed call to missing then echo", finished)
+	}
+	if result.Answer != "done" {
+		t.Errorf("answer = %q, want %q", result.Answer, "done")
+	}
+}
+
+func TestRunToolErrorResult(t *testing.T) {
+	provider := agenttest.NewProvider(
+		agenttest.CallTools(agenttest.ToolCall{Name: "broken", Arguments: map[string]any{}}),
+		agenttest.Reply("the tool failed"),
+	)
+	a := newAgent(t, provider, broken)
+
+	result, err := a.Run(context.Background(), "call the broken tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := toolResults(result)
+	if len(finished) != 1 {
+		t.Fatalf("got %d tool calls, want 1", len(finished))
+	}
+	if !finished[0].Failed || finished[0].Err != nil {
+		t.Errorf("Failed = %v, Err = %v, want a failed result without an error", finished[0].Failed, finished[0].Err)
+	}
+	if !strings.Contains(finished[0].Result, "disk on fire") {
+		t.Errorf("result = %q, want the tool's error", finished[0].Result)
+	}
+
+	// The error is sent to the model like any other result.
+	requests := provider.Requests()
+	last := requests[len(requests)-1].Messages
+	if tool := last[len(last)-1].OfTool; tool == nil || !strings.Contains(tool.Content.OfString.Value, "disk on fire") {
+		t.Errorf("the tool's error wasn't sent to the model")
+	}
+
+	if result.Answer != "the tool failed" {
+		t.Errorf("answer = %q, want %q", result.Answer, "the tool failed")
+	}
+}
+
+func TestRunProviderFailure(t *testing.T) {
+	boom := errors.New("boom")
+	provider := agenttest.NewProvider(agenttest.Fail(boom))
+	a := newAgent(t, provider, echo)
+
+	_, err := a.Run(context.Background(), "anything")
+	if !errors.Is(err, agent.ErrCompletion) || !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want ErrCompletion wrapping the provider's error", err)
+	}
+}
+
+func TestRunContinuesAfterLength(t *testing.T) {
+	provider := agenttest.NewProvider(
+		agenttest.Finish("The answer is ", "length"),
+		agenttest.Finish("forty", "length"),
+		agenttest.Reply("-two."),
+	)
+	a := newAgent(t, provider, echo)
+
+	result, err := a.Run(context.Background(), "what is the answer?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "The answer is forty-two."; result.Answer != want {
+		t.Errorf("answer = %q, want %q", result.Answer, want)
+	}
+
+	// The pieces are stitched into one message, without the prompts asking
+	// to continue.
+	var assistant int
+	for _, message := range a.Messages {
+		if message.OfAssistant != nil {
+			assistant++
+		}
+		if message.OfUser != nil && strings.Contains(message.OfUser.Content.OfString.Value, "cut off") {
+			t.Errorf("history still has a prompt to continue")
+		}
+	}
+	if assistant != 1 {
+		t.Errorf("history has %d assistant messages, want 1", assistant)
+	}
+
+	var warnings int
+	for _, event := range result.Events {
+		if _, ok := event.(agent.Warning); ok {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("got %d warnings, want one per continuation", warnings)
+	}
+}
+
+func TestRunCompactsForRoutedModel(t *testing.T) {
+	provider := agenttest.NewProvider(
+		agenttest.Reply("They asked about the weather."),
+		agenttest.Reply("done"),
+	)
+	a := newAgent(t, provider, echo)
+
+	// Only the model routing picks has a context small enough to compact.
+	a.Routing.Rules = []agent.RoutingRule{{Model: "small-model"}}
+	a.ContextLength = func(model string) int64 {
+		if model == "small-model" {
+			return 20
+		}
+		return 1_000_000
+	}
+	a.Compaction = agent.Compaction{Threshold: 0.5, KeepRecent: 1}
+	a.Messages = []openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage("what's the weather like?"),
+		openai.AssistantMessage("Sunny."),
+	}
+
+	if _, err := a.Run(context.Background(), "and tomorrow?"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d completion requests, want a summary and a turn", len(requests))
+	}
+	if requests[0].Model != "small-model" || !strings.Contains(requests[0].Messages[0].OfSystem.Content.OfString.Value, "Summarize") {
+		t.Errorf("the conversation wasn't compacted for the routed model")
+	}
+}
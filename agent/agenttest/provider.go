@@ -0,0 +1,141 @@
+// Package agenttest provides a scripted provider and an in-process MCP server
+// so the agent loop can be exercised deterministically, without network
+// access or an API key.
+package agenttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+// Step produces the response to a single completion request.
+type Step func(params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)
+
+// ToolCall is a tool call made by a scripted response.
+type ToolCall struct {
+	Name      string
+	Arguments map[string]any
+}
+
+// Provider is an agent.Provider that replays a script of steps, one per
+// completion request, and records every request it receives.
+type Provider struct {
+	mu       sync.Mutex
+	steps    []Step
+	requests []openai.ChatCompletionNewParams
+}
+
+func NewProvider(steps ...Step) *Provider {
+	return &Provider{steps: steps}
+}
+
+func (p *Provider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, params)
+
+	if len(p.requests) > len(p.steps) {
+		return nil, fmt.Errorf("unexpected completion request %d, the script has %d steps", len(p.requests), len(p.steps))
+	}
+
+	return p.steps[len(p.requests)-1](params)
+}
+
+// Requests returns the requests received so far.
+func (p *Provider) Requests() []openai.ChatCompletionNewParams {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]openai.ChatCompletionNewParams(nil), p.requests...)
+}
+
+// Reply responds with text and a stop finish reason.
+func Reply(content string) Step {
+	return Finish(content, "stop")
+}
+
+// Finish responds with text and the given finish reason, such as length or
+// content_filter.
+func Finish(content, finishReason string) Step {
+	return func(params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		return completion(params.Model, finishReason, map[string]any{
+			"role":    "assistant",
+			"content": content,
+		})
+	}
+}
+
+// CallTools responds with tool calls. Call IDs are derived from the position
+// of the call in the conversation, so they are stable across runs.
+func CallTools(calls ...ToolCall) Step {
+	return func(params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		var toolCalls []map[string]any
+
+		for i, call := range calls {
+			args, err := json.Marshal(call.Arguments)
+			if err != nil {
+				return nil, err
+			}
+
+			toolCalls = append(toolCalls, map[string]any{
+				"id":   fmt.Sprintf("call_%d_%d", len(params.Messages), i),
+				"type": "function",
+				"function": map[string]any{
+					"name":      call.Name,
+					"arguments": string(args),
+				},
+			})
+		}
+
+		return completion(params.Model, "tool_calls", map[string]any{
+			"role":       "assistant",
+			"content":    "",
+			"tool_calls": toolCalls,
+		})
+	}
+}
+
+// Fail makes the request fail with err.
+func Fail(err error) Step {
+	return func(openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		return nil, err
+	}
+}
+
+// completion builds a response by decoding JSON, the same way a real one
+// arrives, so fields only reachable through JSON metadata are populated.
+// Every completion uses 10 prompt and 5 completion tokens and costs $0.001.
+func completion(model, finishReason string, message map[string]any) (*openai.ChatCompletion, error) {
+	data, err := json.Marshal(map[string]any{
+		"id":      "fake",
+		"object":  "chat.completion",
+		"created": 0,
+		"model":   model,
+		"choices": []map[string]any{{
+			"index":         0,
+			"finish_reason": finishReason,
+			"message":       message,
+		}},
+		"usage": map[string]any{
+			"prompt_tokens":     10,
+			"completion_tokens": 5,
+			"total_tokens":      15,
+			"cost":              0.001,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var c openai.ChatCompletion
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,58 @@
+package agenttest
+
+import (
+	"context"
+	"fmt"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Tool is a tool offered by the mock MCP server. Returning an error reports
+// a tool error to the caller, like a real server would.
+type Tool struct {
+	Name        string
+	Description string
+	Params      []string
+	Handler     func(args map[string]any) (string, error)
+}
+
+// NewServer returns an MCP server offering the given tools. Each parameter
+// is declared as a string.
+func NewServer(tools ...Tool) *server.MCPServer {
+	srv := server.NewMCPServer("agenttest", "1.0.0")
+
+	for _, tool := range tools {
+		opts := []mcp.ToolOption{mcp.WithDescription(tool.Description)}
+		for _, param := range tool.Params {
+			opts = append(opts, mcp.WithString(param))
+		}
+
+		srv.AddTool(mcp.NewTool(tool.Name, opts...), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			text, err := tool.Handler(request.GetArguments())
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(text), nil
+		})
+	}
+
+	return srv
+}
+
+// NewClient starts an in-process client connected to a mock server offering
+// the given tools. The agent initializes it when loading tools.
+func NewClient(ctx context.Context, tools ...Tool) (*mcpclient.Client, error) {
+	client, err := mcpclient.NewInProcessClient(NewServer(tools...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-process client: %v", err)
+	}
+
+	if err := client.Start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start in-process client: %v", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
+
+const batchingPrompt = "Your last %d responses each made a single tool call. " +
+	"When calls don't depend on each other's results, make them together in one response: it is faster and cheaper."
+
+// BatchingHint reminds a model that makes one tool call per response to
+// batch them. Once Turns responses in a row made a single call, Prompt, or a
+// built-in reminder, is added as a system message, at most Max times a run.
+// Zero Turns or Max is off.
+type BatchingHint struct {
+	Turns  int
+	Max    int
+	Prompt string
+}
+
+// batchingState counts the responses in a row that made a single call.
+type batchingState struct {
+	single int
+	hints  int
+}
+
+// hintBatching counts the calls of a response, and reminds the model to
+// batch them if it keeps making one at a time.
+func (a *Agent) hintBatching(toolCalls []openai.ChatCompletionMessageToolCall) {
+	policy := a.Batching
+	if policy.Turns <= 0 || policy.Max <= 0 {
+		return
+	}
+
+	s := &a.batching
+	if len(toolCalls) != 1 {
+		s.single = 0
+		return
+	}
+
+	s.single++
+	if s.single < policy.Turns || s.hints >= policy.Max {
+		return
+	}
+
+	s.hints++
+	s.single = 0
+
+	a.warn("The model made one tool call per response %d times in a row, reminding it to batch them (%d/%d)...", policy.Turns, s.hints, policy.Max)
+
+	a.Messages = append(a.Messages, openai.SystemMessage(cmp.Or(policy.Prompt, fmt.Sprintf(batchingPrompt, policy.Turns))))
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Budget stops a run once it has spent too much, counting from the start of
+// the run. It is checked after every completion, so a run can go over by one
+// completion. Zero fields are unlimited.
+//
+// What is left is told to the model with every request, so it can plan
+// around it. A run still going at its Deadline is cut short, and the model
+// asked to sum up what it has so far without tools.
+type Budget struct {
+	MaxCost   float64
+	MaxTokens int64
+	MaxTurns  int
+	Deadline  time.Duration
+}
+
+// ErrBudgetExceeded is returned by runs stopped by their Budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+const (
+	remainingPrompt = "Budget left for this task: %s. Plan the remaining work to fit it, " +
+		"favouring fewer and broader steps as it runs low, and answer with what you have before it runs out."
+
+	deadlinePrompt = "The time for this task is up and your tool calls were stopped. " +
+		"Don't call any more tools. Answer now with your best effort: what you found or did so far, " +
+		"your answer as far as it goes, and what is left unfinished."
+
+	deadlineCut = "This call was stopped at the deadline of the task."
+
+	// deadlineGrace is how long the summary at the deadline may take.
+	deadlineGrace = time.Minute
+)
+
+// spent is where the budget of the current run is counted from.
+type spent struct {
+	usage    Usage
+	turns    int
+	deadline time.Time
+}
+
+func (a *Agent) spentUsage() Usage {
+	return Usage{
+		PromptTokens:     a.Usage.PromptTokens - a.spent.usage.PromptTokens,
+		CompletionTokens: a.Usage.CompletionTokens - a.spent.usage.CompletionTokens,
+		Cost:             a.Usage.Cost - a.spent.usage.Cost,
+	}
+}
+
+// checkBudget returns an error if the current run has spent its budget.
+func (a *Agent) checkBudget() error {
+	budget := a.Budget
+	spent := a.spentUsage()
+
+	switch {
+	case budget.MaxCost > 0 && spent.Cost > budget.MaxCost:
+		return fmt.Errorf("%w: spent $%.4f of $%.4f", ErrBudgetExceeded, spent.Cost, budget.MaxCost)
+	case budget.MaxTokens > 0 && spent.PromptTokens+spent.CompletionTokens > budget.MaxTokens:
+		return fmt.Errorf("%w: used %d of %d tokens", ErrBudgetExceeded, spent.PromptTokens+spent.CompletionTokens, budget.MaxTokens)
+	case budget.MaxTurns > 0 && len(a.Turns)-a.spent.turns > budget.MaxTurns:
+		return fmt.Errorf("%w: made %d of %d completions", ErrBudgetExceeded, len(a.Turns)-a.spent.turns, budget.MaxTurns)
+	}
+
+	return nil
+}
+
+// remainingBudget describes what is left of the budget for the model, or
+// nothing without one or once the deadline has passed.
+func (a *Agent) remainingBudget() string {
+	if a.pastDeadline() {
+		return ""
+	}
+
+	budget := a.Budget
+	spent := a.spentUsage()
+
+	var left []string
+	if !a.spent.deadline.IsZero() {
+		remaining := max(time.Until(a.spent.deadline), 0).Round(time.Second)
+		left = append(left, fmt.Sprintf("%s of %s before the deadline", remaining, budget.Deadline))
+	}
+	if budget.MaxCost > 0 {
+		left = append(left, fmt.Sprintf("$%.4f of $%.4f", max(budget.MaxCost-spent.Cost, 0), budget.MaxCost))
+	}
+	if budget.MaxTokens > 0 {
+		left = append(left, fmt.Sprintf("%d of %d tokens", max(budget.MaxTokens-spent.PromptTokens-spent.CompletionTokens, 0), budget.MaxTokens))
+	}
+	if budget.MaxTurns > 0 {
+		left = append(left, fmt.Sprintf("%d of %d completions", max(budget.MaxTurns-(len(a.Turns)-a.spent.turns), 0), budget.MaxTurns))
+	}
+
+	if len(left) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(remainingPrompt, strings.Join(left, ", "))
+}
+
+func (a *Agent) pastDeadline() bool {
+	return !a.spent.deadline.IsZero() && !time.Now().Before(a.spent.deadline)
+}
+
+// finishAtDeadline asks the model for a last answer, without tools, once a
+// run was cut short by its deadline. The run still fails, with the summary as
+// its answer.
+func (a *Agent) finishAtDeadline(ctx context.Context, result *Result) (*Result, error) {
+	err := fmt.Errorf("%w: ran past the deadline of %s", ErrBudgetExceeded, a.Budget.Deadline)
+	if result == nil {
+		result = &Result{}
+	}
+	a.result = result
+	defer func() { a.result = nil }()
+
+	a.warn("The task ran past its deadline of %s, asking for a summary...", a.Budget.Deadline)
+
+	// Tool calls stopped midway still need an answer for the conversation to
+	// be sent again.
+	i := len(a.Messages) - 1
+	answered := make(map[string]bool)
+	for i >= 0 && a.Messages[i].OfTool != nil {
+		answered[a.Messages[i].OfTool.ToolCallID] = true
+		i--
+	}
+	if i >= 0 && a.Messages[i].OfAssistant != nil {
+		for _, call := range a.Messages[i].OfAssistant.ToolCalls {
+			if !answered[call.ID] {
+				a.Messages = append(a.Messages, openai.ToolMessage(deadlineCut, call.ID))
+			}
+		}
+	}
+
+	a.Messages = append(a.Messages, openai.UserMessage(deadlinePrompt))
+
+	ctx, cancel := context.WithTimeout(ctx, deadlineGrace)
+	defer cancel()
+
+	params := a.paramsFor(a.Messages)
+	params.Tools = nil
+
+	start := time.Now()
+	completion, cerr := a.provider.Complete(ctx, params)
+	if cerr == nil && len(completion.Choices) == 0 {
+		cerr = errNoChoices
+	}
+	if cerr != nil {
+		a.warn("Failed to summarize the task at its deadline: %v", cerr)
+		a.emit(Error{Err: err})
+		return result, err
+	}
+
+	a.recordTurn(completion, false, completionTiming{duration: time.Since(start)})
+
+	message := completion.Choices[0].Message
+	message.ToolCalls = nil
+	a.Messages = append(a.Messages, message.ToParam())
+
+	result.Answer = message.Content
+	if result.Answer != "" {
+		a.emit(AssistantText{Text: result.Answer})
+	}
+	a.turnFinished()
+
+	a.emit(Error{Err: err})
+
+	return result, err
+}
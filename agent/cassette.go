@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+// Cassette holds the completions, tool definitions and tool results of one
+// or more runs, so they can be replayed later without a provider or MCP
+// servers.
+type Cassette struct {
+	Tools       []mcp.Tool         `json:"tools"`
+	Completions []json.RawMessage  `json:"completions"`
+	ToolCalls   []CassetteToolCall `json:"tool_calls"`
+
+	mu          sync.Mutex
+	path        string
+	completions int
+	toolCalls   int
+}
+
+type CassetteToolCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+	Result    string         `json:"result"`
+	Images    []Image        `json:"images,omitempty"`
+	Failed    bool           `json:"failed,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// NewCassette returns an empty cassette that is written to path every time
+// something is recorded, so runs that fail half way are kept.
+func NewCassette(path string) *Cassette {
+	return &Cassette{path: path}
+}
+
+// LoadCassette reads a cassette written by a recording run.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %v", path, err)
+	}
+
+	return &c, nil
+}
+
+func (c *Cassette) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, data, 0o600)
+}
+
+func (c *Cassette) recordTools(tools []mcp.Tool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Tools = tools
+	return c.save()
+}
+
+func (c *Cassette) recordCompletion(completion *openai.ChatCompletion) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Completions = append(c.Completions, json.RawMessage(completion.RawJSON()))
+	return c.save()
+}
+
+func (c *Cassette) recordToolCall(call CassetteToolCall) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ToolCalls = append(c.ToolCalls, call)
+	return c.save()
+}
+
+func (c *Cassette) nextCompletion() (*openai.ChatCompletion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.completions >= len(c.Completions) {
+		return nil, fmt.Errorf("cassette has no more completions (%d recorded)", len(c.Completions))
+	}
+
+	var completion openai.ChatCompletion
+	if err := json.Unmarshal(c.Completions[c.completions], &completion); err != nil {
+		return nil, err
+	}
+
+	c.completions++
+
+	return &completion, nil
+}
+
+func (c *Cassette) nextToolCall(name string) (string, []Image, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.toolCalls >= len(c.ToolCalls) {
+		return "", nil, false, fmt.Errorf("cassette has no more tool calls (%d recorded)", len(c.ToolCalls))
+	}
+
+	call := c.ToolCalls[c.toolCalls]
+	if call.Name != name {
+		return "", nil, false, fmt.Errorf("replay diverged: recorded a call to %s, got %s", call.Name, name)
+	}
+
+	c.toolCalls++
+
+	if call.Error != "" {
+		return "", nil, false, fmt.Errorf("%s", call.Error)
+	}
+
+	return call.Result, call.Images, call.Failed, nil
+}
+
+// Record makes the agent add every completion, its tools and every tool
+// result to c. It must be called before the first Run.
+func (a *Agent) Record(c *Cassette) {
+	a.provider = &recordingProvider{provider: a.provider, cassette: c}
+	a.recording = c
+}
+
+// Replay makes the agent take its tools, completions and tool results from c
+// instead of the provider and MCP servers, in the order they were recorded.
+// It must be called before the first Run.
+func (a *Agent) Replay(c *Cassette) {
+	a.provider = &replayProvider{cassette: c}
+	a.clients = nil
+	a.replaying = c
+}
+
+type recordingProvider struct {
+	provider Provider
+	cassette *Cassette
+}
+
+func (p *recordingProvider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	completion, err := p.provider.Complete(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.cassette.recordCompletion(completion); err != nil {
+		return nil, fmt.Errorf("failed to record completion: %v", err)
+	}
+
+	return completion, nil
+}
+
+// Stream records streamed completions the same way, if the provider can
+// stream.
+func (p *recordingProvider) Stream(ctx context.Context, params openai.ChatCompletionNewParams, onChunk func(openai.ChatCompletionChunk)) (*openai.ChatCompletion, error) {
+	provider, ok := p.provider.(StreamingProvider)
+	if !ok {
+		return p.Complete(ctx, params)
+	}
+
+	completion, err := provider.Stream(ctx, params, onChunk)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.cassette.recordCompletion(completion); err != nil {
+		return nil, fmt.Errorf("failed to record completion: %v", err)
+	}
+
+	return completion, nil
+}
+
+type replayProvider struct {
+	cassette *Cassette
+}
+
+func (p *replayProvider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return p.cassette.nextCompletion()
+}
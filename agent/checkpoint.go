@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/openai/openai-go"
+)
+
+// Checkpoint is emitted after every completion and every tool call that
+// finishes, with the state of the loop, so a run cut short, such as by a
+// crash, can be picked up where it was with Resume. Messages may end with a
+// response whose tool calls haven't all been answered; Results holds the
+// results of those that finished, by tool call ID.
+type Checkpoint struct {
+	Messages   []openai.ChatCompletionMessageParamUnion `json:"messages"`
+	Usage      Usage                                    `json:"usage"`
+	Turns      []Turn                                   `json:"turns,omitempty"`
+	RawOutputs map[string]string                        `json:"raw_outputs,omitempty"`
+	Results    map[string]string                        `json:"results,omitempty"`
+}
+
+func (Checkpoint) isEvent() {}
+
+// Resume restores the state of a checkpoint and continues the run from there
+// like Continue. Tool calls of the last response that had finished aren't
+// made again, their results are taken from the checkpoint.
+func (a *Agent) Resume(ctx context.Context, checkpoint Checkpoint) (*Result, error) {
+	a.Messages = slices.Clone(checkpoint.Messages)
+	a.Usage = checkpoint.Usage
+	a.Turns = slices.Clone(checkpoint.Turns)
+	a.RawOutputs = maps.Clone(checkpoint.RawOutputs)
+
+	a.mu.Lock()
+	a.finishedCalls = maps.Clone(checkpoint.Results)
+	a.mu.Unlock()
+
+	return a.Continue(ctx)
+}
+
+func (a *Agent) checkpoint() {
+	a.mu.Lock()
+	messages := slices.Clone(a.Messages)
+	results := maps.Clone(a.finishedCalls)
+	a.mu.Unlock()
+
+	a.emit(Checkpoint{
+		Messages:   messages,
+		Usage:      a.Usage,
+		Turns:      slices.Clone(a.Turns),
+		RawOutputs: maps.Clone(a.RawOutputs),
+		Results:    results,
+	})
+}
+
+// checkpointResponse checkpoints a response just added to the conversation,
+// whose tool calls are yet to be made.
+func (a *Agent) checkpointResponse() {
+	a.mu.Lock()
+	a.finishedCalls = nil
+	a.mu.Unlock()
+
+	a.checkpoint()
+}
+
+// checkpointResult checkpoints the result of a tool call of the last
+// response.
+func (a *Agent) checkpointResult(id, result string) {
+	a.mu.Lock()
+	if a.finishedCalls == nil {
+		a.finishedCalls = make(map[string]string)
+	}
+	a.finishedCalls[id] = result
+	a.mu.Unlock()
+
+	a.checkpoint()
+}
+
+// answerPendingCalls answers the tool calls of the last response that the
+// conversation has no result for, as left by a run cut short while making
+// them: with the result in the checkpoint it was resumed from, or else by
+// making the call.
+func (a *Agent) answerPendingCalls(ctx context.Context) error {
+	i := len(a.Messages) - 1
+	for i >= 0 && a.Messages[i].OfTool != nil {
+		i--
+	}
+	if i < 0 || a.Messages[i].OfAssistant == nil {
+		return nil
+	}
+
+	answered := make(map[string]bool)
+	for _, message := range a.Messages[i+1:] {
+		answered[message.OfTool.ToolCallID] = true
+	}
+
+	a.mu.Lock()
+	finished := maps.Clone(a.finishedCalls)
+	a.mu.Unlock()
+
+	var pending, calls []openai.ChatCompletionMessageToolCall
+	for _, call := range a.Messages[i].OfAssistant.ToolCalls {
+		if answered[call.ID] {
+			continue
+		}
+
+		toolCall := openai.ChatCompletionMessageToolCall{
+			ID: call.ID,
+			Function: openai.ChatCompletionMessageToolCallFunction{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+		}
+		pending = append(pending, toolCall)
+		if _, ok := finished[call.ID]; !ok {
+			calls = append(calls, toolCall)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	a.warn("Resuming %d unanswered tool calls, %d of which had finished.", len(pending), len(pending)-len(calls))
+
+	results, err := a.callTools(ctx, calls)
+	if err != nil {
+		return err
+	}
+	for j, call := range calls {
+		finished[call.ID] = results[j]
+	}
+
+	for _, toolCall := range pending {
+		result := a.truncateToolResult(toolCall, a.summarizeToolResult(ctx, toolCall, finished[toolCall.ID]))
+		a.Messages = append(a.Messages, openai.ToolMessage(result, toolCall.ID))
+	}
+
+	a.turnFinished()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const compactionPrompt = "Summarize the following conversation between a user and an AI agent that uses tools. " +
+	"Preserve the user's requests, decisions made, important facts and numbers, and the outcome of each tool call. " +
+	"Be concise; the summary replaces the original messages."
+
+// Compaction controls summarization of older messages once the conversation
+// reaches Threshold of the model's context window. A zero Threshold disables
+// compaction. The results of PinTools, like messages in Agent.Pinned, are
+// kept verbatim.
+type Compaction struct {
+	Threshold  float64
+	KeepRecent int
+	Model      string
+	PinTools   []string
+}
+
+// compactIfNeeded summarizes older messages once the conversation approaches
+// the model's context window. Leading system messages, the most recent
+// messages and pinned messages are kept verbatim.
+func (a *Agent) compactIfNeeded(ctx context.Context) error {
+	compaction := a.Compaction
+	if compaction.Threshold <= 0 || a.ContextLength == nil {
+		return nil
+	}
+
+	model := a.turnModel()
+
+	limit := a.ContextLength(model)
+	if limit == 0 {
+		return nil
+	}
+
+	tokens := a.CountTokens(model, a.Messages)
+	if float64(tokens) < float64(limit)*compaction.Threshold {
+		return nil
+	}
+
+	messages := a.Messages
+
+	start := 0
+	for start < len(messages) && messages[start].OfSystem != nil {
+		start++
+	}
+
+	// Tool messages must stay with the assistant message that requested them,
+	// so only cut in front of a non-tool message.
+	end := len(messages) - max(compaction.KeepRecent, 1)
+	for end > start && messages[end].OfTool != nil {
+		end--
+	}
+	if end <= start {
+		return nil
+	}
+
+	a.warn("Context is at ~%d of %d tokens, compacting %d messages...", tokens, limit, end-start)
+
+	if compaction.Model != "" {
+		model = compaction.Model
+	}
+
+	if err := a.waitRate(ctx, "compacting", a.RateLimits.Completions); err != nil {
+		return err
+	}
+
+	completion, err := a.provider.Complete(ctx, openai.ChatCompletionNewParams{
+		Model: model,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(compactionPrompt),
+			openai.UserMessage(renderTranscript(messages[start:end])),
+		},
+	})
+	if err == nil && len(completion.Choices) == 0 {
+		err = errNoChoices
+	}
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation: %v", err)
+	}
+
+	a.Usage.Add(completion.Usage)
+
+	summary := openai.SystemMessage("Summary of the earlier conversation:\n" + completion.Choices[0].Message.Content)
+
+	compacted := slices.Clone(messages[:start])
+	if pinned := a.pinned(messages[start:end]); pinned != "" {
+		compacted = append(compacted, openai.SystemMessage(pinned))
+	}
+	compacted = append(compacted, summary)
+	compacted = append(compacted, messages[end:]...)
+
+	a.Messages = compacted
+
+	return nil
+}
+
+func renderTranscript(messages []openai.ChatCompletionMessageParamUnion) string {
+	var b strings.Builder
+
+	for _, message := range messages {
+		switch {
+		case message.OfSystem != nil:
+			fmt.Fprintf(&b, "[system]\n%s\n\n", message.OfSystem.Content.OfString.Value)
+		case message.OfUser != nil:
+			fmt.Fprintf(&b, "[user]\n%s\n\n", message.OfUser.Content.OfString.Value)
+		case message.OfAssistant != nil:
+			if content := message.OfAssistant.Content.OfString.Value; content != "" {
+				fmt.Fprintf(&b, "[assistant]\n%s\n\n", content)
+			}
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				fmt.Fprintf(&b, "[tool call %s]\n%s\n\n", toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+		case message.OfTool != nil:
+			fmt.Fprintf(&b, "[tool result]\n%s\n\n", message.OfTool.Content.OfString.Value)
+		}
+	}
+
+	return b.String()
+}
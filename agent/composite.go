@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// CompositeTool is a tool offered to the model that the agent expands into a
+// call of another tool, so routine workflows can be offered as simpler tools
+// than those they are made with. Calls are checked against the policy and
+// approved as the composite tool, and made as Target with the arguments
+// Expand returns.
+type CompositeTool struct {
+	Tool   mcp.Tool
+	Target string
+	Expand func(args map[string]any) (map[string]any, error)
+}
+
+// AddCompositeTool registers a composite tool. It must be called before the
+// first Run.
+func (a *Agent) AddCompositeTool(tool CompositeTool) {
+	a.composites[tool.Tool.Name] = tool
+}
+
+// compositeTools returns the composite tools to offer after checking their
+// targets are among tools.
+func (a *Agent) compositeTools(tools []mcp.Tool) ([]mcp.Tool, error) {
+	var composites []mcp.Tool
+
+	for _, name := range slices.Sorted(maps.Keys(a.composites)) {
+		composite := a.composites[name]
+
+		if _, ok := a.composites[composite.Target]; ok {
+			return nil, fmt.Errorf("composite tool %s calls composite tool %s, which isn't supported", name, composite.Target)
+		}
+		if !slices.ContainsFunc(tools, func(tool mcp.Tool) bool { return tool.Name == composite.Target }) {
+			return nil, fmt.Errorf("composite tool %s calls %s, which no server offers", name, composite.Target)
+		}
+
+		composites = append(composites, composite.Tool)
+	}
+
+	return composites, nil
+}
+
+// dispatchComposite expands a call of a composite tool and makes it.
+func (a *Agent) dispatchComposite(ctx context.Context, composite CompositeTool, request mcp.CallToolRequest) (string, []Image, bool, error) {
+	args, err := composite.Expand(request.GetArguments())
+	if err != nil {
+		return "", nil, false, fmt.Errorf("failed to expand %s: %v", request.Params.Name, err)
+	}
+
+	request.Params.Name = composite.Target
+	request.Params.Arguments = args
+
+	return a.dispatch(ctx, request)
+}
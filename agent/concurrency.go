@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Concurrency controls how many tool calls from one response run at once on
+// each server. The limit adapts AIMD-style between 1 and Max: it grows by one
+// for every limit's worth of calls that finish in good time, and halves when
+// a call fails or takes more than twice as long as usual. A Max of 1 or less
+// runs calls one at a time.
+type Concurrency struct {
+	Max int
+}
+
+// limiter is the adaptive concurrency limit of one server.
+type limiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	max     int
+	limit   float64
+	running int
+
+	// latency is the smoothed duration of successful calls.
+	latency time.Duration
+}
+
+func newLimiter(max int) *limiter {
+	l := &limiter{max: max, limit: 1}
+	l.cond = sync.NewCond(&l.mu)
+
+	return l
+}
+
+// acquire waits until another call fits under the limit. Waiting ends when a
+// running call finishes, which they do promptly once ctx is done.
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.running >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.running++
+}
+
+// release records how a call went and adjusts the limit.
+func (l *limiter) release(duration time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.running--
+
+	switch {
+	case failed, l.latency > 0 && duration > 2*l.latency:
+		l.limit = max(l.limit/2, 1)
+	default:
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+	}
+
+	if !failed {
+		if l.latency == 0 {
+			l.latency = duration
+		} else {
+			l.latency = (l.latency*7 + duration) / 8
+		}
+	}
+
+	l.cond.Broadcast()
+}
+
+// limiter returns the limiter of the server offering a tool. Local tools
+// share one.
+func (a *Agent) limiter(name string) *limiter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	server := a.toolServers[name]
+
+	if a.limiters == nil {
+		a.limiters = make(map[string]*limiter)
+	}
+	l, ok := a.limiters[server]
+	if !ok {
+		l = newLimiter(max(a.Concurrency.Max, 1))
+		a.limiters[server] = l
+	}
+
+	return l
+}
+
+// callTools runs the tool calls of one response and returns their results in
+// order. Calls run in parallel when Concurrency allows, except with cassettes,
+// which record and replay tool calls in order. The first error cancels the
+// remaining calls.
+func (a *Agent) callTools(ctx context.Context, toolCalls []openai.ChatCompletionMessageToolCall) ([]string, error) {
+	results := make([]string, len(toolCalls))
+
+	if a.Concurrency.Max <= 1 || len(toolCalls) == 1 || a.replaying != nil || a.recording != nil {
+		for i, toolCall := range toolCalls {
+			result, err := a.callTool(ctx, toolCall, len(toolCalls) == 1)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+			a.checkpointResult(toolCall.ID, result)
+		}
+
+		return results, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for i, toolCall := range toolCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := a.callTool(ctx, toolCall, false)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+			a.checkpointResult(toolCall.ID, result)
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+const delegatePrompt = "You are a sub-agent working on one subtask for another agent, which only sees your final answer, not your tool calls or their output. " +
+	"Do the subtask, then answer with everything the other agent needs from it, as briefly as you can."
+
+// Delegation lets the model hand subtasks to sub-agents through the
+// delegate_task tool. Each sub-agent is made by New, with a conversation of
+// its own, so the output of its tool calls stays out of this agent's context.
+// Models lists the models the model may pick for a sub-agent; it gets this
+// agent's model if empty. A nil New disables it.
+type Delegation struct {
+	New    func(ctx context.Context) (*Agent, func(), error)
+	Models []string
+}
+
+func (a *Agent) delegateTool() LocalTool {
+	modelOptions := []mcp.PropertyOption{
+		mcp.Description("The model to run the sub-agent with. Defaults to yours."),
+	}
+	if len(a.Delegation.Models) > 0 {
+		modelOptions = append(modelOptions, mcp.Enum(a.Delegation.Models...))
+	}
+
+	return LocalTool{
+		Tool: mcp.NewTool("delegate_task",
+			mcp.WithDescription("Hand a self-contained subtask to a sub-agent with the same tools and a fresh context, and get back its answer. "+
+				"Use it for subtasks whose tool output you don't need to see, such as exploring data or trying approaches. "+
+				"Several calls in one response may run in parallel."),
+			mcp.WithString("task", mcp.Required(), mcp.Description("Everything the sub-agent needs to know: it sees nothing of this conversation.")),
+			mcp.WithString("model", modelOptions...),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			task, err := request.RequireString("task")
+			if err != nil {
+				return "", err
+			}
+
+			model := request.GetString("model", "")
+			if model != "" && !slices.Contains(a.Delegation.Models, model) {
+				return fmt.Sprintf("The model %q isn't available for sub-agents.", model), nil
+			}
+			if model == "" {
+				model = a.Model
+			}
+
+			return a.delegate(ctx, task, model)
+		},
+	}
+}
+
+// delegate runs task in a new sub-agent. Its usage counts toward this
+// agent's, and its tool calls are approved the same way.
+func (a *Agent) delegate(ctx context.Context, task, model string) (string, error) {
+	sub, closeSub, err := a.Delegation.New(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create sub-agent: %v", err)
+	}
+	defer closeSub()
+
+	sub.Model = model
+	sub.Messages = []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(delegatePrompt)}
+	sub.Prepare = a.Prepare
+	sub.Approve = a.Approve
+
+	result, err := sub.Run(ctx, task)
+
+	a.mu.Lock()
+	a.Usage = a.Usage.Plus(sub.Usage)
+	a.mu.Unlock()
+
+	if err != nil {
+		return fmt.Sprintf("The sub-agent failed: %v", err), nil
+	}
+
+	return result.Answer, nil
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// earlyCall is a tool call started while the response asking for it was still
+// streaming in. done is closed once it has finished.
+type earlyCall struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	name   string
+	args   map[string]any
+	result string
+	images []Image
+	failed bool
+	err    error
+}
+
+// startEarlyCall starts a streamed tool call whose arguments are complete, if
+// it would run without asking anyone. With Concurrency allowing one call at a
+// time, it waits for the call started before it, if any, so calls still run
+// in order. Like prefetching, it doesn't wait for rate limits; the call is
+// left to the agent loop instead.
+func (a *Agent) startEarlyCall(ctx context.Context, call ToolCallDelta, previous *earlyCall) *earlyCall {
+	var args map[string]any
+	if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+		return nil
+	}
+	if !a.runsUnasked(call.Name, args) || !allowRate(a.RateLimits.toolLimiters(call.Name)...) {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &earlyCall{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		name:   call.Name,
+		args:   args,
+	}
+
+	a.mu.Lock()
+	if a.earlyCalls == nil {
+		a.earlyCalls = make(map[string]*earlyCall)
+	}
+	a.earlyCalls[call.ID] = c
+	a.mu.Unlock()
+
+	go func() {
+		defer close(c.done)
+
+		if previous != nil && a.Concurrency.Max <= 1 {
+			select {
+			case <-previous.done:
+			case <-ctx.Done():
+				c.err = ctx.Err()
+				return
+			}
+		}
+
+		l := a.limiter(call.Name)
+		l.acquire()
+		dispatched := time.Now()
+		c.result, c.images, c.failed, c.err = a.dispatch(ctx, mcp.CallToolRequest{
+			Request: mcp.Request{
+				Method: "tools/call",
+			},
+			Params: mcp.CallToolParams{
+				Name:      call.Name,
+				Arguments: args,
+			},
+		})
+		l.release(time.Since(dispatched), c.err != nil)
+	}()
+
+	return c
+}
+
+// runsUnasked reports whether a call to a server's tool is sure to run as it
+// is: its arguments are valid, no guardrail may block it, and neither a
+// tripwire, a policy rule nor ApproveAll or ApproveTools has it asked about or
+// denied.
+func (a *Agent) runsUnasked(name string, args map[string]any) bool {
+	if !a.StartToolCalls || a.DryRun || a.PreToolCall != nil || a.replaying != nil || a.recording != nil || a.Guardrails.blocks() {
+		return false
+	}
+	if _, ok := a.route(name); !ok {
+		return false
+	}
+	if errs := ValidateSchema(a.schemas[name], args); len(errs) > 0 {
+		return false
+	}
+
+	for _, tripwire := range a.Tripwires {
+		if _, ok := matchArguments(tripwire.Pattern, args); ok {
+			return false
+		}
+	}
+
+	if rule, ok := a.matchRule(name, args); ok {
+		return rule.Action == RuleAllow
+	}
+
+	return !a.ApproveAll && !slices.Contains(a.ApproveTools, name)
+}
+
+// takeEarlyCall returns the early call with the ID of a call, if it was made
+// with the same arguments, waiting for it to finish.
+func (a *Agent) takeEarlyCall(id, name string, args map[string]any) (*earlyCall, bool) {
+	a.mu.Lock()
+	c, ok := a.earlyCalls[id]
+	delete(a.earlyCalls, id)
+	a.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	if c.name != name || !reflect.DeepEqual(c.args, args) {
+		c.cancel()
+		return nil, false
+	}
+
+	<-c.done
+	c.cancel()
+
+	return c, true
+}
+
+// discardEarlyCalls cancels the early calls no tool call took, such as those
+// of a response that failed to finish.
+func (a *Agent) discardEarlyCalls() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, c := range a.earlyCalls {
+		c.cancel()
+		delete(a.earlyCalls, id)
+	}
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
+
+// Escalation switches the rest of a run to Model once the model is stuck:
+// Failures tool calls in a row failed, because their server flagged the
+// result as an error or their arguments were invalid, or the same call, with
+// the same arguments, was made Repeats times in a row. Zero thresholds are
+// off, and an empty Model disables it.
+type Escalation struct {
+	Model    string
+	Failures int
+	Repeats  int
+}
+
+// escalation is how close a run is to escalating, or the model it escalated
+// to.
+type escalation struct {
+	failures int
+	repeats  int
+	last     string
+	model    string
+}
+
+// checkEscalation counts the failed and repeated calls of a turn, given the
+// events emitted while they were made, and escalates if either reached its
+// threshold.
+func (a *Agent) checkEscalation(toolCalls []openai.ChatCompletionMessageToolCall, events []Event) {
+	policy := a.Escalation
+	if policy.Model == "" || a.escalation.model != "" {
+		return
+	}
+
+	finished := make(map[string]ToolCallFinished)
+	for _, event := range events {
+		if event, ok := event.(ToolCallFinished); ok {
+			finished[event.ToolCall.ID] = event
+		}
+	}
+
+	e := &a.escalation
+
+	for _, toolCall := range toolCalls {
+		// Calls with arguments that aren't valid JSON finish without an
+		// event.
+		event, ok := finished[toolCall.ID]
+		if !ok || event.Failed || errors.Is(event.Err, errInvalidArguments) || errors.Is(event.Err, errUnknownTool) {
+			e.failures++
+		} else {
+			e.failures = 0
+		}
+
+		if key := callKey(toolCall); key == e.last {
+			e.repeats++
+		} else {
+			e.last, e.repeats = key, 1
+		}
+
+		var reason string
+		switch {
+		case policy.Failures > 0 && e.failures >= policy.Failures:
+			reason = fmt.Sprintf("%d tool calls failed in a row", e.failures)
+		case policy.Repeats > 1 && e.repeats >= policy.Repeats:
+			reason = fmt.Sprintf("%s was called with the same arguments %d times in a row", toolCall.Function.Name, e.repeats)
+		default:
+			continue
+		}
+
+		if policy.Model == a.Model {
+			return
+		}
+
+		e.model = policy.Model
+		a.warn("Escalating to %s for the rest of the task: %s.", policy.Model, reason)
+		return
+	}
+}
+
+// callKey identifies a call by its tool and arguments, however they are
+// formatted.
+func callKey(toolCall openai.ChatCompletionMessageToolCall) string {
+	arguments := toolCall.Function.Arguments
+
+	var args any
+	if err := json.Unmarshal([]byte(arguments), &args); err == nil {
+		normalized, _ := json.Marshal(args)
+		arguments = string(normalized)
+	}
+
+	return toolCall.Function.Name + "\x00" + arguments
+}
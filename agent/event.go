@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Event is emitted by the agent as it runs. The concrete types are
+// TurnStarted, AssistantText, Reasoning, TextDelta, ToolCallDelta,
+// ToolCallStarted, ToolCallFinished, UsageUpdated, Warning, TurnFinished,
+// Checkpoint, Error and Done, and ServerLog, which is passed to
+// Agent.ServerLogs instead.
+type Event interface {
+	isEvent()
+}
+
+// TurnStarted is emitted before every completion of a run, Turn counting
+// them from 1.
+type TurnStarted struct {
+	Turn int
+}
+
+// AssistantText carries text produced by the model. Structured is set when
+// the text is a validated JSON answer.
+type AssistantText struct {
+	Text       string
+	Structured bool
+}
+
+// Reasoning carries the reasoning a model returned with a completion, before
+// its text or tool calls. It isn't part of the conversation.
+type Reasoning struct {
+	Text string
+}
+
+// TextDelta is emitted while a streamed completion is writing text. Text is
+// the new text only. The whole text follows in AssistantText.
+type TextDelta struct {
+	Text string
+}
+
+// ToolCallDelta is emitted while a streamed completion is calling a tool.
+// Arguments holds the JSON received so far, which is usually incomplete.
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ToolCallStarted is emitted before a tool is called.
+type ToolCallStarted struct {
+	ToolCall  openai.ChatCompletionMessageToolCall
+	Arguments map[string]any
+}
+
+// ToolCallFinished is emitted after a tool call returns. Images returned by
+// the tool are only described to the model, but are included here so they
+// can be shown.
+type ToolCallFinished struct {
+	ToolCall  openai.ChatCompletionMessageToolCall
+	Arguments map[string]any
+	Result    string
+	Images    []Image
+	Err       error
+	Duration  time.Duration
+
+	// Failed is set when the server flagged Result as an error. The call
+	// still ran, and its result is sent to the model like any other.
+	Failed bool
+
+	// Queued is how much of Duration was spent waiting for rate and
+	// concurrency limits before the call was sent.
+	Queued time.Duration
+
+	// Prefetched is set when the result came from a call made ahead of
+	// time, speculatively or while the response was streaming in, in which
+	// case Duration is only the time waited for it.
+	Prefetched bool
+
+	// Ran is set when the tool was called, even if the call failed. Calls
+	// that were declined, denied, skipped in a dry run or rejected for
+	// their arguments didn't run.
+	Ran bool
+
+	// Server is the name of the server that ran the call, and Approval how
+	// it came to run or not. Approval is empty for calls that were never
+	// considered for approval, such as those with invalid arguments.
+	Server   string
+	Approval Approval
+}
+
+// UsageUpdated is emitted after every completion.
+type UsageUpdated struct {
+	Turn  Turn
+	Total Usage
+}
+
+// Warning reports a recoverable problem.
+type Warning struct {
+	Text string
+}
+
+// TurnFinished is emitted whenever the conversation reaches a consistent
+// state. It carries a copy of that state so it can be persisted while the
+// agent keeps running.
+type TurnFinished struct {
+	Messages   []openai.ChatCompletionMessageParamUnion
+	Usage      Usage
+	Turns      []Turn
+	RawOutputs map[string]string
+}
+
+// Error is emitted when Run fails, just before it returns the error.
+type Error struct {
+	Err error
+}
+
+// Done is the last event sent by Stream, with what Run returned.
+type Done struct {
+	Result *Result
+	Err    error
+}
+
+func (TurnStarted) isEvent()      {}
+func (AssistantText) isEvent()    {}
+func (Reasoning) isEvent()        {}
+func (TextDelta) isEvent()        {}
+func (ToolCallDelta) isEvent()    {}
+func (ToolCallStarted) isEvent()  {}
+func (ToolCallFinished) isEvent() {}
+func (UsageUpdated) isEvent()     {}
+func (Warning) isEvent()          {}
+func (TurnFinished) isEvent()     {}
+func (Error) isEvent()            {}
+func (Done) isEvent()             {}
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// GuardrailAction is what happens when a guardrail matches a response.
+type GuardrailAction string
+
+const (
+	// GuardrailFlag emits the match and carries on.
+	GuardrailFlag GuardrailAction = "flag"
+	// GuardrailBlock withholds the response, and fails the run, unless
+	// OverrideGuardrail allows it.
+	GuardrailBlock GuardrailAction = "block"
+)
+
+// Guardrails check what the model writes, its messages and the arguments of
+// its tool calls, such as code, before they are shown or run. Classifier, if
+// set, is a model asked about every response, whose verdict is acted on with
+// ClassifierAction. While any guardrail blocks, completions aren't shown as
+// they stream in, and tool calls aren't started early.
+type Guardrails struct {
+	Rules            []Guardrail
+	Classifier       string
+	ClassifierAction GuardrailAction
+}
+
+// Guardrail is a pattern matched against the content of every response and
+// every string in the arguments of its tool calls.
+type Guardrail struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  GuardrailAction
+}
+
+func (g Guardrail) name() string {
+	return cmp.Or(g.Name, g.Pattern.String())
+}
+
+func (g Guardrails) blocks() bool {
+	if g.Classifier != "" && g.ClassifierAction == GuardrailBlock {
+		return true
+	}
+
+	for _, rule := range g.Rules {
+		if rule.Action == GuardrailBlock {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GuardrailTripped is emitted when a guardrail matches a response. Where is
+// the part of it that matched, and Match what matched, or the classifier's
+// reason. Blocked responses are withheld, and Overridden ones were blocked
+// until OverrideGuardrail allowed them.
+type GuardrailTripped struct {
+	Guardrail  string
+	Action     GuardrailAction
+	Where      string
+	Match      string
+	Blocked    bool
+	Overridden bool
+}
+
+func (GuardrailTripped) isEvent() {}
+
+func (g GuardrailTripped) String() string {
+	if g.Guardrail == "classifier" {
+		return fmt.Sprintf("the classifier flagged %s: %s", g.Where, g.Match)
+	}
+
+	return fmt.Sprintf("%s matched %q in %s", g.Guardrail, g.Match, g.Where)
+}
+
+// ErrGuardrail is returned by runs stopped by a guardrail.
+var ErrGuardrail = errors.New("blocked by guardrail")
+
+const classifierPrompt = `You review the responses of an AI agent before they are shown to the user or their tool calls run. Reply with UNSAFE and a short reason if the response contains destructive commands, exfiltrates credentials or other secrets, or otherwise does something harmful the user is unlikely to want. Otherwise reply with SAFE.`
+
+// checkGuardrails checks a response against the guardrails, asking
+// OverrideGuardrail about those that block it. An error means the response
+// must be withheld and the run stop.
+func (a *Agent) checkGuardrails(ctx context.Context, message openai.ChatCompletionMessage) error {
+	var tripped []GuardrailTripped
+
+	for _, rule := range a.Guardrails.Rules {
+		trip := GuardrailTripped{Guardrail: rule.name(), Action: rule.Action}
+
+		if match, ok := matchArguments(rule.Pattern, message.Content); ok {
+			trip.Where, trip.Match = "the response", match
+			tripped = append(tripped, trip)
+			continue
+		}
+
+		for _, toolCall := range message.ToolCalls {
+			var args any = toolCall.Function.Arguments
+			json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
+
+			if match, ok := matchArguments(rule.Pattern, args); ok {
+				trip.Where, trip.Match = "the call of "+toolCall.Function.Name, match
+				tripped = append(tripped, trip)
+				break
+			}
+		}
+	}
+
+	if reason, ok := a.classify(ctx, message); ok {
+		tripped = append(tripped, GuardrailTripped{
+			Guardrail: "classifier",
+			Action:    a.Guardrails.ClassifierAction,
+			Where:     "the response",
+			Match:     reason,
+		})
+	}
+
+	for _, trip := range tripped {
+		if trip.Action != GuardrailBlock {
+			a.emit(trip)
+			continue
+		}
+
+		if a.OverrideGuardrail != nil {
+			allowed, err := a.OverrideGuardrail(ctx, trip)
+			if err != nil {
+				return fmt.Errorf("failed to ask to override a guardrail: %v", err)
+			}
+			if allowed {
+				trip.Overridden = true
+				a.emit(trip)
+				continue
+			}
+		}
+
+		trip.Blocked = true
+		a.emit(trip)
+
+		return fmt.Errorf("%w: %s", ErrGuardrail, trip)
+	}
+
+	return nil
+}
+
+// classify asks the classifier model about a response, returning its reason
+// if it flagged it. Responses it can't be asked about pass.
+func (a *Agent) classify(ctx context.Context, message openai.ChatCompletionMessage) (string, bool) {
+	if a.Guardrails.Classifier == "" {
+		return "", false
+	}
+
+	var response strings.Builder
+	response.WriteString(message.Content)
+	for _, toolCall := range message.ToolCalls {
+		fmt.Fprintf(&response, "\n\nTool call %s: %s", toolCall.Function.Name, toolCall.Function.Arguments)
+	}
+
+	if strings.TrimSpace(response.String()) == "" {
+		return "", false
+	}
+
+	completion, err := a.provider.Complete(ctx, openai.ChatCompletionNewParams{
+		Model: a.Guardrails.Classifier,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(classifierPrompt),
+			openai.UserMessage(response.String()),
+		},
+	})
+	if err == nil && len(completion.Choices) == 0 {
+		err = errNoChoices
+	}
+	if err != nil {
+		a.warn("Failed to classify the response, letting it through: %v", err)
+		return "", false
+	}
+
+	a.Usage.Add(completion.Usage)
+
+	verdict := strings.TrimSpace(completion.Choices[0].Message.Content)
+	if !strings.HasPrefix(strings.ToUpper(verdict), "UNSAFE") {
+		return "", false
+	}
+
+	return cmp.Or(strings.TrimLeft(verdict[len("UNSAFE"):], " :.-\n"), "no reason given"), true
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"unicode/utf8"
+)
+
+// ValidateSchema checks value (as decoded by encoding/json) against a JSON
+// schema. Only the commonly used subset of keywords is supported; unknown
+// keywords are ignored. It returns one message per violation.
+func ValidateSchema(schema map[string]any, value any) []string {
+	var errs []string
+	validateAt(schema, value, "$", &errs)
+	return errs
+}
+
+func validateAt(schema map[string]any, value any, path string, errs *[]string) {
+	fail := func(format string, a ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, a...))
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok && !slices.ContainsFunc(types, func(t string) bool { return hasType(value, t) }) {
+		fail("expected %s, got %s", joinTypes(types), typeOf(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(v any) bool { return reflect.DeepEqual(v, value) }) {
+		fail("value %v is not one of %v", value, enum)
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		fail("value must be %v", constant)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if n, ok := number(schema["minLength"]); ok && float64(utf8.RuneCountInString(v)) < n {
+			fail("string shorter than %v", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && float64(utf8.RuneCountInString(v)) > n {
+			fail("string longer than %v", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("string does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && v < n {
+			fail("%v is less than minimum %v", v, n)
+		}
+		if n, ok := number(schema["maximum"]); ok && v > n {
+			fail("%v is greater than maximum %v", v, n)
+		}
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("array has fewer than %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(v)) > n {
+			fail("array has more than %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validateAt(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+
+		for _, name := range requiredNames(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if propSchema, ok := properties[key].(map[string]any); ok {
+				validateAt(propSchema, v[key], path+"."+key, errs)
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", key)
+				}
+			case map[string]any:
+				validateAt(additional, v[key], path+"."+key, errs)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]any); ok {
+				validateAt(subSchema, value, path, errs)
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok && countMatching(anyOf, value) == 0 {
+		fail("value does not match any of the allowed schemas")
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok && countMatching(oneOf, value) != 1 {
+		fail("value must match exactly one of the allowed schemas")
+	}
+}
+
+func countMatching(schemas []any, value any) int {
+	var n int
+
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		var errs []string
+		if validateAt(subSchema, value, "$", &errs); len(errs) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+func schemaTypes(v any) ([]string, bool) {
+	switch t := v.(type) {
+	case string:
+		return []string{t}, true
+	case []any:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types, len(types) > 0
+	case []string:
+		return t, len(t) > 0
+	}
+
+	return nil, false
+}
+
+func requiredNames(v any) []string {
+	switch t := v.(type) {
+	case []string:
+		return t
+	case []any:
+		var names []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+
+	return nil
+}
+
+func hasType(value any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeOf(value) == t
+	}
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+
+	return fmt.Sprintf("one of %v", types)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
+
+const loopPrompt = "You seem to be stuck: %s. Don't repeat what hasn't worked. " +
+	"Try a different approach, or give your answer with what you know if you can't make progress."
+
+// LoopDetection stops the model from going round in circles. A run is stuck
+// once it makes the same call with the same result Repeats times in a row,
+// alternates between two such calls Cycles times, or responds with neither
+// text nor tool calls Empty times in a row. The model is told so in a system
+// message up to Nudges times a run, after which the run fails with ErrLoop.
+// Zero thresholds are off.
+type LoopDetection struct {
+	Repeats int
+	Cycles  int
+	Empty   int
+	Nudges  int
+}
+
+// ErrLoop is returned by runs that stayed stuck after being nudged.
+var ErrLoop = errors.New("stuck in a loop")
+
+// loopState is what a run has done lately, to tell if it is stuck.
+type loopState struct {
+	calls  []string
+	empty  int
+	nudges int
+}
+
+// detectToolLoop adds the calls of a turn to the history, and describes how
+// the run is stuck, if it is.
+func (a *Agent) detectToolLoop(toolCalls []openai.ChatCompletionMessageToolCall, results []string) string {
+	policy := a.Loops
+	if policy.Repeats <= 1 && policy.Cycles <= 1 {
+		return ""
+	}
+
+	s := &a.loop
+	s.empty = 0
+
+	for i, toolCall := range toolCalls {
+		s.calls = append(s.calls, callKey(toolCall)+"\x00"+results[i])
+	}
+
+	n := len(s.calls)
+	if keep := max(policy.Repeats, 2*policy.Cycles); n > keep {
+		s.calls = s.calls[n-keep:]
+		n = keep
+	}
+
+	last := toolCalls[len(toolCalls)-1].Function.Name
+
+	if policy.Repeats > 1 && n >= policy.Repeats && repeats(s.calls[n-policy.Repeats:], 1) {
+		return fmt.Sprintf("%s was called %d times in a row with the same arguments and result", last, policy.Repeats)
+	}
+
+	if policy.Cycles > 1 && n >= 2*policy.Cycles && s.calls[n-1] != s.calls[n-2] && repeats(s.calls[n-2*policy.Cycles:], 2) {
+		return fmt.Sprintf("the same two tool calls alternated %d times with the same results", policy.Cycles)
+	}
+
+	return ""
+}
+
+// detectEmptyResponse counts responses with neither text nor tool calls, and
+// describes how the run is stuck, if it is.
+func (a *Agent) detectEmptyResponse(content string) string {
+	if a.Loops.Empty <= 0 {
+		return ""
+	}
+
+	if content != "" {
+		a.loop.empty = 0
+		return ""
+	}
+
+	a.loop.empty++
+	if a.loop.empty < a.Loops.Empty {
+		return ""
+	}
+
+	if a.loop.empty == 1 {
+		return "the response was empty"
+	}
+
+	return fmt.Sprintf("%d responses in a row were empty", a.loop.empty)
+}
+
+// nudge tells the model how it is stuck, or returns ErrLoop if it was told
+// too many times already.
+func (a *Agent) nudge(diagnosis string) error {
+	if a.loop.nudges >= a.Loops.Nudges {
+		return fmt.Errorf("%w: %s", ErrLoop, diagnosis)
+	}
+
+	a.loop.nudges++
+	a.loop.calls, a.loop.empty = nil, 0
+
+	a.warn("The model seems stuck: %s. Asking it to change course (%d/%d)...", diagnosis, a.loop.nudges, a.Loops.Nudges)
+
+	a.Messages = append(a.Messages, openai.SystemMessage(fmt.Sprintf(loopPrompt, diagnosis)))
+
+	return nil
+}
+
+// repeats reports whether calls is the same period calls over and over.
+func repeats(calls []string, period int) bool {
+	for i := period; i < len(calls); i++ {
+		if calls[i] != calls[i-period] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+// nextTurn is a call to a read-only tool made while the user decides whether
+// it may run, followed by the completion of the next turn with its result.
+// called is closed once the call has finished, and done once the completion
+// has too, or been skipped.
+type nextTurn struct {
+	cancel context.CancelFunc
+	called chan struct{}
+	done   chan struct{}
+
+	toolCallID string
+	result     string
+	images     []Image
+	failed     bool
+	err        error
+
+	// messages are the conversation the completion was requested with,
+	// besides the result of the call.
+	messages   []openai.ChatCompletionMessageParamUnion
+	completion *openai.ChatCompletion
+	duration   time.Duration
+}
+
+// speculateTurn reports whether a call is worth making while approval is
+// asked: it must be to a read-only tool that will be asked about only because
+// of ApproveAll or ApproveTools, not because of a tripwire or a policy rule.
+// Results rewritten by PostToolCall aren't what the completion would see.
+func (a *Agent) speculateTurn(name string, args map[string]any, rule *ToolRule) bool {
+	if !a.Prefetch.Turns || a.Approve == nil || a.PreToolCall != nil || a.PostToolCall != nil || rule != nil || a.replaying != nil || a.recording != nil || a.modelOverride != "" || a.Routing.enabled() {
+		return false
+	}
+	if !a.readOnly[name] && !slices.Contains(a.Prefetch.Tools, name) {
+		return false
+	}
+	if !a.ApproveAll && !slices.Contains(a.ApproveTools, name) {
+		return false
+	}
+
+	for _, tripwire := range a.Tripwires {
+		if _, ok := matchArguments(tripwire.Pattern, args); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startNextTurn makes a tool call in the background and, if its result can
+// be sent to the model as it is, requests the next completion with it. Like
+// prefetching, neither waits for rate limits; they are skipped instead.
+func (a *Agent) startNextTurn(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall, args map[string]any) {
+	name := toolCall.Function.Name
+	if !allowRate(a.RateLimits.toolLimiters(name)...) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	t := &nextTurn{
+		cancel:     cancel,
+		called:     make(chan struct{}),
+		done:       make(chan struct{}),
+		toolCallID: toolCall.ID,
+		messages:   slices.Clone(a.Messages),
+	}
+	a.nextTurn = t
+
+	// The request is prepared now, as Prepare and the conversation belong
+	// to the agent loop.
+	params := a.paramsFor(t.messages)
+
+	go func() {
+		defer close(t.done)
+
+		l := a.limiter(name)
+		l.acquire()
+		dispatched := time.Now()
+		t.result, t.images, t.failed, t.err = a.dispatch(ctx, mcp.CallToolRequest{
+			Request: mcp.Request{
+				Method: "tools/call",
+			},
+			Params: mcp.CallToolParams{
+				Name:      name,
+				Arguments: args,
+			},
+		})
+		l.release(time.Since(dispatched), t.err != nil)
+		close(t.called)
+
+		// Results that would be summarized or truncated first aren't worth
+		// the trouble.
+		if t.err != nil || a.needsProcessing(toolCall, t.result) || !allowRate(a.RateLimits.Completions) {
+			return
+		}
+
+		params.Messages = append(slices.Clone(params.Messages), openai.ToolMessage(t.result, toolCall.ID))
+
+		requested := time.Now()
+		completion, err := a.provider.Complete(ctx, params)
+		if err == nil && len(completion.Choices) > 0 {
+			t.completion = completion
+			t.duration = time.Since(requested)
+		}
+	}()
+}
+
+// needsProcessing reports whether a tool result would be summarized or
+// truncated before being sent to the model.
+func (a *Agent) needsProcessing(toolCall openai.ChatCompletionMessageToolCall, result string) bool {
+	if _, ok := a.local[toolCall.Function.Name]; !ok && a.Summarizer.Model != "" && len(result) > a.Summarizer.Threshold {
+		return true
+	}
+
+	_, over := a.truncationLimit(result)
+	return over && toolCall.Function.Name != "read_more"
+}
+
+// takeNextTurnCall returns the result of the call made while approval was
+// asked, waiting for it to finish.
+func (a *Agent) takeNextTurnCall(toolCallID string) (*nextTurn, bool) {
+	t := a.nextTurn
+	if t == nil || t.toolCallID != toolCallID {
+		return nil, false
+	}
+
+	<-t.called
+
+	return t, true
+}
+
+// takeNextTurn returns the completion requested ahead of time, waiting for
+// it to finish, if the conversation is still the one it was requested with,
+// ending in the same result. It may have been compacted, for one.
+func (a *Agent) takeNextTurn(timing *completionTiming) *openai.ChatCompletion {
+	t := a.nextTurn
+	if t == nil {
+		return nil
+	}
+
+	n := len(t.messages)
+	if a.modelOverride != "" || len(a.Messages) != n+1 || !reflect.DeepEqual(a.Messages[:n], t.messages) {
+		a.discardNextTurn()
+		return nil
+	}
+
+	<-t.called
+	if !reflect.DeepEqual(a.Messages[n], openai.ToolMessage(t.result, t.toolCallID)) {
+		a.discardNextTurn()
+		return nil
+	}
+
+	<-t.done
+	t.cancel()
+	a.nextTurn = nil
+
+	if t.completion == nil {
+		return nil
+	}
+
+	timing.duration = t.duration
+
+	return t.completion
+}
+
+// discardNextTurn cancels the completion requested ahead of time, if any,
+// because the call it was made with was declined or edited, or the
+// conversation changed.
+func (a *Agent) discardNextTurn() {
+	t := a.nextTurn
+	if t == nil {
+		return
+	}
+
+	t.cancel()
+	<-t.done
+	a.nextTurn = nil
+
+	a.countNextTurn(t)
+}
+
+// countNextTurn adds the usage of a completion that went unused.
+func (a *Agent) countNextTurn(t *nextTurn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t.completion != nil {
+		a.Usage.Add(t.completion.Usage)
+	}
+}
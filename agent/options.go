@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/openai/openai-go"
+)
+
+// Option configures an agent in New.
+type Option func(*Agent)
+
+// WithProvider sets the chat completion provider. The default is OpenAI,
+// configured from OPENAI_API_KEY and OPENAI_BASE_URL.
+func WithProvider(provider Provider) Option {
+	return func(a *Agent) {
+		a.provider = provider
+	}
+}
+
+// WithToolSource adds MCP servers whose tools are offered to the model. When
+// several offer a tool with the same name, the first one added wins.
+func WithToolSource(clients ...*mcpclient.Client) Option {
+	return func(a *Agent) {
+		a.clients = append(a.clients, clients...)
+	}
+}
+
+// WithInitOptions sets what the agent tells the server of a client about
+// itself when it initializes it.
+func WithInitOptions(client *mcpclient.Client, opts InitOptions) Option {
+	return func(a *Agent) {
+		if a.initOptions == nil {
+			a.initOptions = make(map[*mcpclient.Client]InitOptions)
+		}
+		a.initOptions[client] = opts
+	}
+}
+
+// WithLocalTools adds tools implemented in-process, like AddTool.
+func WithLocalTools(tools ...LocalTool) Option {
+	return func(a *Agent) {
+		for _, tool := range tools {
+			a.AddTool(tool)
+		}
+	}
+}
+
+// WithCompositeTools adds composite tools, like AddCompositeTool.
+func WithCompositeTools(tools ...CompositeTool) Option {
+	return func(a *Agent) {
+		for _, tool := range tools {
+			a.AddCompositeTool(tool)
+		}
+	}
+}
+
+// WithToolCache shares the tools listed by servers with other agents using
+// the same cache.
+func WithToolCache(cache *ToolCache) Option {
+	return func(a *Agent) {
+		a.ToolCache = cache
+	}
+}
+
+// WithModel sets the model used for completions.
+func WithModel(model string) Option {
+	return func(a *Agent) {
+		a.Model = model
+	}
+}
+
+// Policy decides which tool calls may run. The fields are those of the same
+// names on Agent.
+type Policy struct {
+	Tripwires    []Tripwire
+	ApproveTools []string
+	ApproveAll   bool
+	Approve      func(ctx context.Context, call ToolCallStarted, reason string) (map[string]any, bool, error)
+	DryRun       bool
+	ToolRules    []ToolRule
+}
+
+// WithPolicy sets the policy for tool calls.
+func WithPolicy(policy Policy) Option {
+	return func(a *Agent) {
+		a.Tripwires = policy.Tripwires
+		a.ApproveTools = policy.ApproveTools
+		a.ApproveAll = policy.ApproveAll
+		a.Approve = policy.Approve
+		a.DryRun = policy.DryRun
+		a.ToolRules = policy.ToolRules
+	}
+}
+
+// WithObserver adds a function called with every event as it happens, like
+// the receiver of Events. It must not block for long, as the run waits for it.
+func WithObserver(observe func(Event)) Option {
+	return func(a *Agent) {
+		a.observers = append(a.observers, observe)
+	}
+}
+
+// WithRateLimits sets the rate limits of completions and tool calls. Agents
+// given the same limiters share the rates.
+func WithRateLimits(limits RateLimits) Option {
+	return func(a *Agent) {
+		a.RateLimits = limits
+	}
+}
+
+// WithBudget limits what a single run may spend.
+func WithBudget(budget Budget) Option {
+	return func(a *Agent) {
+		a.Budget = budget
+	}
+}
+
+func defaultProvider() Provider {
+	return NewOpenAIProvider(openai.NewClient())
+}
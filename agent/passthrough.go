@@ -0,0 +1,276 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/responses"
+)
+
+// RemoteMCPServer is an MCP server the model's provider connects to itself.
+// Headers are sent with its requests, and AllowedTools, if set, are the only
+// tools offered.
+type RemoteMCPServer struct {
+	Label        string
+	URL          string
+	Headers      map[string]string
+	AllowedTools []string
+}
+
+// PassthroughProvider is a Provider whose provider calls MCP servers itself,
+// through the OpenAI Responses API, rather than returning their tool calls
+// for the agent to make. Local tools are still offered as functions. The
+// calls the provider made are returned with the completion, and emitted by
+// the agent as tool call events.
+type PassthroughProvider struct {
+	client  openai.Client
+	servers []RemoteMCPServer
+	opts    []option.RequestOption
+}
+
+// NewPassthroughProvider returns a provider using client, whose provider calls
+// servers. opts are applied to every request.
+func NewPassthroughProvider(client openai.Client, servers []RemoteMCPServer, opts ...option.RequestOption) *PassthroughProvider {
+	return &PassthroughProvider{client: client, servers: servers, opts: opts}
+}
+
+// remoteCall is a call of an MCP tool the provider made, as it is returned in
+// the mcp_calls field of a completion's message.
+type remoteCall struct {
+	ID        string `json:"id"`
+	Server    string `json:"server"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Output    string `json:"output"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (p *PassthroughProvider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	request := responses.ResponseNewParams{
+		Model:           params.Model,
+		Input:           responses.ResponseNewParamsInputUnion{OfInputItemList: responseInput(params.Messages)},
+		Temperature:     params.Temperature,
+		TopP:            params.TopP,
+		MaxOutputTokens: params.MaxCompletionTokens,
+		Store:           openai.Bool(false),
+	}
+	if params.MaxTokens.Valid() {
+		request.MaxOutputTokens = params.MaxTokens
+	}
+
+	if format := params.ResponseFormat.OfJSONSchema; format != nil {
+		schema, _ := format.JSONSchema.Schema.(map[string]any)
+		request.Text.Format.OfJSONSchema = &responses.ResponseFormatTextJSONSchemaConfigParam{
+			Name:   format.JSONSchema.Name,
+			Schema: schema,
+			Strict: format.JSONSchema.Strict,
+		}
+	}
+
+	for _, server := range p.servers {
+		tool := responses.ToolMcpParam{
+			ServerLabel: server.Label,
+			ServerURL:   server.URL,
+			Headers:     server.Headers,
+			// The agent can't ask before calls it doesn't make.
+			RequireApproval: responses.ToolMcpRequireApprovalUnionParam{OfMcpToolApprovalSetting: openai.String("never")},
+		}
+		if len(server.AllowedTools) > 0 {
+			tool.AllowedTools.OfMcpAllowedTools = server.AllowedTools
+		}
+		request.Tools = append(request.Tools, responses.ToolUnionParam{OfMcp: &tool})
+	}
+
+	for _, tool := range params.Tools {
+		function := responses.ToolParamOfFunction(tool.Function.Name, tool.Function.Parameters, false)
+		function.OfFunction.Description = tool.Function.Description
+		request.Tools = append(request.Tools, function)
+	}
+
+	response, err := p.client.Responses.New(ctx, request, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error.Message != "" {
+		return nil, errors.New(response.Error.Message)
+	}
+
+	return chatCompletion(response)
+}
+
+// responseInput turns chat messages into the input of a response.
+func responseInput(messages []openai.ChatCompletionMessageParamUnion) responses.ResponseInputParam {
+	var input responses.ResponseInputParam
+
+	for _, message := range messages {
+		switch {
+		case message.OfSystem != nil:
+			input = append(input, responses.ResponseInputItemParamOfMessage(message.OfSystem.Content.OfString.Value, responses.EasyInputMessageRoleSystem))
+		case message.OfDeveloper != nil:
+			input = append(input, responses.ResponseInputItemParamOfMessage(message.OfDeveloper.Content.OfString.Value, responses.EasyInputMessageRoleDeveloper))
+		case message.OfUser != nil:
+			if message.OfUser.Content.OfArrayOfContentParts == nil {
+				input = append(input, responses.ResponseInputItemParamOfMessage(message.OfUser.Content.OfString.Value, responses.EasyInputMessageRoleUser))
+				continue
+			}
+
+			var content responses.ResponseInputMessageContentListParam
+			for _, part := range message.OfUser.Content.OfArrayOfContentParts {
+				switch {
+				case part.OfText != nil:
+					content = append(content, responses.ResponseInputContentUnionParam{
+						OfInputText: &responses.ResponseInputTextParam{Text: part.OfText.Text},
+					})
+				case part.OfImageURL != nil:
+					content = append(content, responses.ResponseInputContentUnionParam{
+						OfInputImage: &responses.ResponseInputImageParam{
+							ImageURL: openai.String(part.OfImageURL.ImageURL.URL),
+							Detail:   responses.ResponseInputImageDetailAuto,
+						},
+					})
+				}
+			}
+			input = append(input, responses.ResponseInputItemParamOfMessage(content, responses.EasyInputMessageRoleUser))
+		case message.OfAssistant != nil:
+			if text := message.OfAssistant.Content.OfString.Value; text != "" {
+				input = append(input, responses.ResponseInputItemParamOfMessage(text, responses.EasyInputMessageRoleAssistant))
+			}
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				input = append(input, responses.ResponseInputItemParamOfFunctionCall(toolCall.Function.Arguments, toolCall.ID, toolCall.Function.Name))
+			}
+		case message.OfTool != nil:
+			input = append(input, responses.ResponseInputItemParamOfFunctionCallOutput(message.OfTool.ToolCallID, message.OfTool.Content.OfString.Value))
+		}
+	}
+
+	return input
+}
+
+// chatCompletion turns a response into a chat completion, with the MCP calls
+// the provider made in the mcp_calls field of its message, and its reasoning
+// summary where OpenRouter puts reasoning.
+func chatCompletion(response *responses.Response) (*openai.ChatCompletion, error) {
+	var (
+		toolCalls []map[string]any
+		calls     []remoteCall
+		reasoning []string
+	)
+
+	for _, item := range response.Output {
+		switch item.Type {
+		case "function_call":
+			toolCalls = append(toolCalls, map[string]any{
+				"id":       item.CallID,
+				"type":     "function",
+				"function": map[string]any{"name": item.Name, "arguments": item.Arguments},
+			})
+		case "mcp_call":
+			calls = append(calls, remoteCall{
+				ID:        item.ID,
+				Server:    item.ServerLabel,
+				Name:      item.Name,
+				Arguments: item.Arguments,
+				Output:    item.Output,
+				Error:     item.Error,
+			})
+		case "reasoning":
+			for _, summary := range item.Summary {
+				reasoning = append(reasoning, summary.Text)
+			}
+		}
+	}
+
+	finishReason := "stop"
+	switch {
+	case response.IncompleteDetails.Reason == "max_output_tokens":
+		finishReason = "length"
+	case response.IncompleteDetails.Reason == "content_filter":
+		finishReason = "content_filter"
+	case len(toolCalls) > 0:
+		finishReason = "tool_calls"
+	}
+
+	message := map[string]any{
+		"role":    "assistant",
+		"content": response.OutputText(),
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	if len(calls) > 0 {
+		message["mcp_calls"] = calls
+	}
+	if len(reasoning) > 0 {
+		message["reasoning"] = strings.Join(reasoning, "\n\n")
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"id":      response.ID,
+		"object":  "chat.completion",
+		"created": int64(response.CreatedAt),
+		"model":   response.Model,
+		"choices": []map[string]any{{
+			"index":         0,
+			"finish_reason": finishReason,
+			"message":       message,
+		}},
+		"usage": map[string]any{
+			"prompt_tokens":     response.Usage.InputTokens,
+			"completion_tokens": response.Usage.OutputTokens,
+			"total_tokens":      response.Usage.TotalTokens,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var completion openai.ChatCompletion
+	if err := json.Unmarshal(data, &completion); err != nil {
+		return nil, fmt.Errorf("failed to convert response: %v", err)
+	}
+
+	return &completion, nil
+}
+
+// emitRemoteCalls emits the MCP calls a PassthroughProvider's provider made
+// for a completion as tool calls that started and finished, so they are
+// rendered and observed like the agent's own.
+func (a *Agent) emitRemoteCalls(message openai.ChatCompletionMessage) {
+	raw := message.JSON.ExtraFields["mcp_calls"].Raw()
+	if raw == "" {
+		return
+	}
+
+	var calls []remoteCall
+	if err := json.Unmarshal([]byte(raw), &calls); err != nil {
+		a.warn("Failed to read the provider's MCP calls: %v", err)
+		return
+	}
+
+	for _, call := range calls {
+		toolCall := openai.ChatCompletionMessageToolCall{
+			ID:   call.ID,
+			Type: "function",
+			Function: openai.ChatCompletionMessageToolCallFunction{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		}
+
+		var args map[string]any
+		json.Unmarshal([]byte(call.Arguments), &args)
+
+		var err error
+		if call.Error != "" {
+			err = errors.New(call.Error)
+		}
+
+		a.emit(ToolCallStarted{ToolCall: toolCall, Arguments: args})
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: call.Output, Err: err, Server: call.Server, Ran: true})
+	}
+}
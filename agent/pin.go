@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const pinnedPrefix = "Pinned from the earlier conversation, kept as it was:\n\n"
+
+// PinKey identifies a message in Pinned: a tool result by its tool call ID,
+// and other messages by their content, so pins survive the conversation
+// being saved and messages being removed before them.
+func PinKey(message openai.ChatCompletionMessageParamUnion) string {
+	switch {
+	case message.OfTool != nil:
+		return "tool:" + message.OfTool.ToolCallID
+	case message.OfUser != nil:
+		return contentKey("user", message.OfUser.Content.OfString.Value)
+	case message.OfAssistant != nil:
+		return contentKey("assistant", message.OfAssistant.Content.OfString.Value)
+	}
+
+	return ""
+}
+
+func contentKey(role, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return role + ":" + hex.EncodeToString(sum[:8])
+}
+
+// Pin pins a message, so compaction keeps it verbatim.
+func (a *Agent) Pin(message openai.ChatCompletionMessageParamUnion) {
+	if a.Pinned == nil {
+		a.Pinned = make(map[string]bool)
+	}
+	a.Pinned[PinKey(message)] = true
+}
+
+// pinned returns the messages of messages that are pinned, either in Pinned
+// or as results of Compaction.PinTools, rendered to be kept through
+// compaction.
+func (a *Agent) pinned(messages []openai.ChatCompletionMessageParamUnion) string {
+	toolNames := make(map[string]string)
+	for _, message := range messages {
+		if message.OfAssistant != nil {
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				toolNames[toolCall.ID] = toolCall.Function.Name
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, message := range messages {
+		if message.OfSystem != nil {
+			continue
+		}
+
+		name := ""
+		if message.OfTool != nil {
+			name = toolNames[message.OfTool.ToolCallID]
+		}
+		if !a.Pinned[PinKey(message)] && !slices.Contains(a.Compaction.PinTools, name) {
+			continue
+		}
+
+		switch {
+		case message.OfUser != nil:
+			fmt.Fprintf(&b, "[user]\n%s\n\n", message.OfUser.Content.OfString.Value)
+		case message.OfAssistant != nil:
+			fmt.Fprintf(&b, "[assistant]\n%s\n\n", message.OfAssistant.Content.OfString.Value)
+		case message.OfTool != nil:
+			fmt.Fprintf(&b, "[result of %s]\n%s\n\n", name, message.OfTool.Content.OfString.Value)
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+
+	return pinnedPrefix + strings.TrimSpace(b.String())
+}
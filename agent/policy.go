@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RuleAction is what a ToolRule does with the calls it matches.
+type RuleAction string
+
+const (
+	// RuleAllow runs the call without asking, even with ApproveAll set or
+	// the tool in ApproveTools. Tripwires are still checked.
+	RuleAllow RuleAction = "allow"
+	// RuleAsk asks Approve whether the call may run. Without Approve, the run
+	// is aborted.
+	RuleAsk RuleAction = "ask"
+	// RuleDeny doesn't make the call, and tells the model why.
+	RuleDeny RuleAction = "deny"
+)
+
+// ToolRule decides what happens to calls to the tools matching Tool, a glob
+// such as "*_write", whose arguments meet every condition in When. The first
+// matching rule applies; calls no rule matches are handled as if there were
+// no rules.
+type ToolRule struct {
+	Tool   string
+	When   []Condition
+	Action RuleAction
+
+	// Reason is told to the user when asked, or to the model when denied.
+	Reason string
+}
+
+// Condition tests an argument of a tool call, named by its key, or a dotted
+// path for nested objects. It holds when the argument is a string, or an
+// array containing a string, that matches Pattern, if set, and is a path
+// inside none of Outside, if set. Relative paths are taken to be relative to
+// the first of Outside.
+type Condition struct {
+	Argument string
+	Pattern  *regexp.Regexp
+	Outside  []string
+}
+
+// matchRule returns the first rule matching a call, if any.
+func (a *Agent) matchRule(name string, args map[string]any) (ToolRule, bool) {
+	for _, rule := range a.ToolRules {
+		if ok, _ := path.Match(rule.Tool, name); !ok {
+			continue
+		}
+
+		if matchConditions(rule.When, args) {
+			return rule, true
+		}
+	}
+
+	return ToolRule{}, false
+}
+
+func matchConditions(conditions []Condition, args map[string]any) bool {
+	for _, condition := range conditions {
+		if !condition.match(args) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c Condition) match(args map[string]any) bool {
+	var value any = args
+	for _, key := range strings.Split(c.Argument, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		if value, ok = object[key]; !ok {
+			return false
+		}
+	}
+
+	var values []string
+	switch value := value.(type) {
+	case string:
+		values = []string{value}
+	case []any:
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	for _, v := range values {
+		if (c.Pattern == nil || c.Pattern.MatchString(v)) && (len(c.Outside) == 0 || c.outside(v)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// outside reports whether p is a path inside none of c.Outside.
+func (c Condition) outside(p string) bool {
+	if !path.IsAbs(p) {
+		p = path.Join(c.Outside[0], p)
+	}
+	p = path.Clean(p)
+
+	for _, dir := range c.Outside {
+		dir = path.Clean(dir)
+		if p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describe explains a rule to the user or the model.
+func (r ToolRule) describe(name string) string {
+	if r.Reason != "" {
+		return r.Reason
+	}
+
+	return fmt.Sprintf("%s matches the policy rule for %s", name, r.Tool)
+}
+
+// deniedResult is sent to the model in place of the result of a tool call a
+// policy rule denied.
+func deniedResult(reason string) string {
+	return fmt.Sprintf("This tool call was denied by policy: %s. Don't retry it; find another way or explain what you wanted to do.", reason)
+}
@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+const prefetchPrompt = "You are predicting the next tool call of an AI agent working on the conversation below, so it can be made ahead of time. " +
+	"Call the one tool the agent is most likely to call next, with exactly the arguments it will use. Call nothing if you can't tell."
+
+// Prefetch controls speculative tool calls. While the model works on a
+// completion, Model predicts its next call to a read-only tool, which is made
+// in parallel and used if the model makes that exact call. Tools are
+// read-only if their server annotates them as such, or if listed in Tools. An
+// empty Model disables it.
+//
+// Turns, if set, makes a response's only call to a read-only tool while
+// Approve is asked about it, and requests the next completion with its
+// result, so the turn after goes ahead as soon as the call is approved. Both
+// are discarded if the call is declined or edited. The result reaches the
+// provider before the call is approved.
+type Prefetch struct {
+	Model string
+	Tools []string
+	Turns bool
+}
+
+// speculation is a prefetched tool call. predicted is closed once the
+// prediction is in, and done once the call has finished too.
+type speculation struct {
+	cancel    context.CancelFunc
+	predicted chan struct{}
+	done      chan struct{}
+
+	usage openai.CompletionUsage
+
+	name   string
+	args   map[string]any
+	result string
+	images []Image
+	failed bool
+	err    error
+}
+
+func (a *Agent) readOnlyTools() []openai.ChatCompletionToolParam {
+	var tools []openai.ChatCompletionToolParam
+	for _, tool := range a.tools {
+		if name := tool.Function.Name; a.readOnly[name] || slices.Contains(a.Prefetch.Tools, name) {
+			tools = append(tools, tool)
+		}
+	}
+
+	return tools
+}
+
+// prefetch predicts the next tool call and makes it in the background. Calls
+// to tools that aren't read-only, or that would trip a tripwire or need a
+// policy's approval, are never made. Prefetching is off in dry runs, while
+// every call needs approval, and with cassettes, which record and replay tool
+// calls in order. Neither the prediction nor the call waits for rate limits;
+// they are skipped instead.
+func (a *Agent) prefetch(ctx context.Context) {
+	a.discardSpeculation()
+
+	if a.Prefetch.Model == "" || a.ApproveAll || a.DryRun || a.PreToolCall != nil || a.replaying != nil || a.recording != nil {
+		return
+	}
+
+	tools := a.readOnlyTools()
+	if len(tools) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &speculation{
+		cancel:    cancel,
+		predicted: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	a.speculation = s
+
+	params := openai.ChatCompletionNewParams{
+		Model:             a.Prefetch.Model,
+		Messages:          append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(prefetchPrompt)}, a.Messages...),
+		Tools:             tools,
+		ParallelToolCalls: openai.Bool(false),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		s.name, s.args = a.predictToolCall(ctx, params, &s.usage)
+		if s.name != "" && !allowRate(a.RateLimits.toolLimiters(s.name)...) {
+			s.name = ""
+		}
+		close(s.predicted)
+
+		if s.name == "" {
+			return
+		}
+
+		s.result, s.images, s.failed, s.err = a.callServerTool(ctx, mcp.CallToolRequest{
+			Request: mcp.Request{
+				Method: "tools/call",
+			},
+			Params: mcp.CallToolParams{
+				Name:      s.name,
+				Arguments: s.args,
+			},
+		})
+	}()
+}
+
+// predictToolCall asks the prefetch model for the next tool call. It returns
+// an empty name if there is nothing worth prefetching.
+func (a *Agent) predictToolCall(ctx context.Context, params openai.ChatCompletionNewParams, usage *openai.CompletionUsage) (string, map[string]any) {
+	if !allowRate(a.RateLimits.Completions) {
+		return "", nil
+	}
+
+	completion, err := a.provider.Complete(ctx, params)
+	if err != nil || len(completion.Choices) == 0 {
+		return "", nil
+	}
+
+	*usage = completion.Usage
+
+	toolCalls := completion.Choices[0].Message.ToolCalls
+	if len(toolCalls) == 0 {
+		return "", nil
+	}
+
+	name := toolCalls[0].Function.Name
+	if _, ok := a.route(name); !ok {
+		return "", nil
+	}
+
+	var args map[string]any
+	if err := json.Unmarshal([]byte(toolCalls[0].Function.Arguments), &args); err != nil {
+		return "", nil
+	}
+
+	for _, tripwire := range a.Tripwires {
+		if _, ok := matchArguments(tripwire.Pattern, args); ok {
+			return "", nil
+		}
+	}
+	if rule, ok := a.matchRule(name, args); ok && rule.Action != RuleAllow {
+		return "", nil
+	}
+
+	return name, args
+}
+
+// takeSpeculation returns the prefetched call if it is the call asked for,
+// waiting for it to finish. A speculation still waiting for its prediction is
+// of no use once the model has decided, so it is discarded.
+func (a *Agent) takeSpeculation(name string, args map[string]any) (*speculation, bool) {
+	a.mu.Lock()
+	s := a.speculation
+	if s == nil {
+		a.mu.Unlock()
+		return nil, false
+	}
+
+	select {
+	case <-s.predicted:
+	default:
+		a.discardLocked()
+		a.mu.Unlock()
+		return nil, false
+	}
+
+	if s.name != name || !reflect.DeepEqual(s.args, args) {
+		a.mu.Unlock()
+		return nil, false
+	}
+
+	// Only one call may take it.
+	a.speculation = nil
+	a.Usage.Add(s.usage)
+	a.mu.Unlock()
+
+	<-s.done
+	s.cancel()
+
+	return s, true
+}
+
+// discardSpeculation cancels the pending speculation, if any, and counts the
+// usage of its prediction.
+func (a *Agent) discardSpeculation() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.discardLocked()
+}
+
+func (a *Agent) discardLocked() {
+	s := a.speculation
+	if s == nil {
+		return
+	}
+
+	s.cancel()
+	a.speculation = nil
+
+	select {
+	case <-s.predicted:
+		a.Usage.Add(s.usage)
+	default:
+	}
+}
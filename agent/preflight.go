@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedModel is returned by runs that didn't start because
+// CheckModel rejected a model they may use.
+var ErrUnsupportedModel = errors.New("unsupported model")
+
+// checkModels calls CheckModel with every model the run may use with tools:
+// Model, FallbackModels, the Escalation model and those of Routing. Each is
+// only checked once.
+func (a *Agent) checkModels(ctx context.Context) error {
+	if a.CheckModel == nil {
+		return nil
+	}
+
+	models := append([]string{a.Model}, a.FallbackModels...)
+	models = append(models, a.Escalation.Model, a.Routing.Final)
+	for _, rule := range a.Routing.Rules {
+		models = append(models, rule.Model)
+	}
+
+	for _, model := range models {
+		if model == "" || a.checkedModels[model] {
+			continue
+		}
+
+		if err := a.CheckModel(ctx, model); err != nil {
+			return fmt.Errorf("%w: %w", ErrUnsupportedModel, err)
+		}
+
+		if a.checkedModels == nil {
+			a.checkedModels = make(map[string]bool)
+		}
+		a.checkedModels[model] = true
+	}
+
+	return nil
+}
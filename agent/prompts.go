@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Prompt is a prompt offered by a server.
+type Prompt struct {
+	mcp.Prompt
+	Server string
+
+	client *mcpclient.Client
+}
+
+// ResourceTemplate is a template of resources offered by a server, whose
+// variables are filled in to read a resource.
+type ResourceTemplate struct {
+	mcp.ResourceTemplate
+	Server string
+
+	client *mcpclient.Client
+}
+
+// Prompts lists the prompts of the servers, initializing them if needed.
+// Servers without prompts are skipped.
+func (a *Agent) Prompts(ctx context.Context) ([]Prompt, error) {
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, err
+	}
+
+	var prompts []Prompt
+	for _, client := range a.clients {
+		if client.GetServerCapabilities().Prompts == nil {
+			continue
+		}
+
+		listed, err := client.ListPrompts(ctx, mcp.ListPromptsRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prompts of %s: %v", a.connected[client].Name, err)
+		}
+		for _, prompt := range listed.Prompts {
+			prompts = append(prompts, Prompt{Prompt: prompt, Server: a.connected[client].Name, client: client})
+		}
+	}
+
+	return prompts, nil
+}
+
+// GetPrompt gets the messages of a prompt, with its arguments filled in.
+func (a *Agent) GetPrompt(ctx context.Context, prompt Prompt, arguments map[string]string) (*mcp.GetPromptResult, error) {
+	request := mcp.GetPromptRequest{}
+	request.Params.Name = prompt.Name
+	request.Params.Arguments = arguments
+
+	result, err := prompt.client.GetPrompt(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt %s: %v", prompt.Name, err)
+	}
+
+	return result, nil
+}
+
+// ResourceTemplates lists the resource templates of the servers, initializing
+// them if needed. Servers without resources are skipped.
+func (a *Agent) ResourceTemplates(ctx context.Context) ([]ResourceTemplate, error) {
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, err
+	}
+
+	var templates []ResourceTemplate
+	for _, client := range a.clients {
+		if client.GetServerCapabilities().Resources == nil {
+			continue
+		}
+
+		listed, err := client.ListResourceTemplates(ctx, mcp.ListResourceTemplatesRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resource templates of %s: %v", a.connected[client].Name, err)
+		}
+		for _, template := range listed.ResourceTemplates {
+			templates = append(templates, ResourceTemplate{ResourceTemplate: template, Server: a.connected[client].Name, client: client})
+		}
+	}
+
+	return templates, nil
+}
+
+// ReadResource reads the resource of a template at uri.
+func (a *Agent) ReadResource(ctx context.Context, template ResourceTemplate, uri string) ([]mcp.ResourceContents, error) {
+	request := mcp.ReadResourceRequest{}
+	request.Params.URI = uri
+
+	result, err := template.client.ReadResource(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", uri, err)
+	}
+
+	return result.Contents, nil
+}
+
+// CompletePrompt returns the values the server suggests for an argument of a
+// prompt, given what has been typed so far.
+func (a *Agent) CompletePrompt(ctx context.Context, prompt Prompt, argument, value string) ([]string, error) {
+	return complete(ctx, prompt.client, mcp.PromptReference{Type: "ref/prompt", Name: prompt.Name}, argument, value)
+}
+
+// CompleteResource returns the values the server suggests for a variable of
+// a resource template, given what has been typed so far.
+func (a *Agent) CompleteResource(ctx context.Context, template ResourceTemplate, variable, value string) ([]string, error) {
+	return complete(ctx, template.client, mcp.ResourceReference{Type: "ref/resource", URI: template.URITemplate.Raw()}, variable, value)
+}
+
+func complete(ctx context.Context, client *mcpclient.Client, ref any, argument, value string) ([]string, error) {
+	request := mcp.CompleteRequest{}
+	request.Params.Ref = ref
+	request.Params.Argument.Name = argument
+	request.Params.Argument.Value = value
+
+	result, err := client.Complete(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete %s: %v", argument, err)
+	}
+
+	return result.Completion.Values, nil
+}
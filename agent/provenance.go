@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// provenance describes the tool calls made during the current run as a
+// Markdown footer.
+func (a *Agent) provenance() string {
+	counts := make(map[string]int)
+	var names []string
+	var calls int
+
+	for _, event := range a.result.Events {
+		finished, ok := event.(ToolCallFinished)
+		if !ok || finished.Err != nil {
+			continue
+		}
+
+		name := finished.ToolCall.Function.Name
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name]++
+		calls++
+	}
+
+	if calls == 0 {
+		return "\n\n---\n_Answered without calling any tools._"
+	}
+
+	slices.SortStableFunc(names, func(a, b string) int {
+		return counts[b] - counts[a]
+	})
+
+	var parts []string
+	for _, name := range names {
+		part := fmt.Sprintf("`%s` ×%d", name, counts[name])
+
+		if _, ok := a.local[name]; ok {
+			part += " locally"
+		} else if server := a.toolServers[name]; server != "" {
+			part += " on " + server
+		}
+
+		parts = append(parts, part)
+	}
+
+	noun := "tool calls"
+	if calls == 1 {
+		noun = "tool call"
+	}
+
+	return fmt.Sprintf("\n\n---\n_Computed with %d %s: %s._", calls, noun, strings.Join(parts, ", "))
+}
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// Provider produces chat completions.
+type Provider interface {
+	Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)
+}
+
+// StreamingProvider is a Provider that can also stream completions. onChunk
+// is called with every chunk as it arrives, and the accumulated completion
+// is returned at the end.
+type StreamingProvider interface {
+	Provider
+	Stream(ctx context.Context, params openai.ChatCompletionNewParams, onChunk func(openai.ChatCompletionChunk)) (*openai.ChatCompletion, error)
+}
+
+// OpenAIProvider is a Provider backed by an OpenAI-compatible API.
+type OpenAIProvider struct {
+	client openai.Client
+	opts   []option.RequestOption
+}
+
+// NewOpenAIProvider returns a provider using client. opts are applied to every
+// completion request.
+func NewOpenAIProvider(client openai.Client, opts ...option.RequestOption) *OpenAIProvider {
+	return &OpenAIProvider{client: client, opts: opts}
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return p.client.Chat.Completions.New(ctx, params, p.opts...)
+}
+
+func (p *OpenAIProvider) Stream(ctx context.Context, params openai.ChatCompletionNewParams, onChunk func(openai.ChatCompletionChunk)) (*openai.ChatCompletion, error) {
+	params.StreamOptions.IncludeUsage = openai.Bool(true)
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params, p.opts...)
+	defer stream.Close()
+
+	var (
+		acc       openai.ChatCompletionAccumulator
+		usage     string
+		reasoning strings.Builder
+	)
+
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+
+		if chunk.JSON.Usage.Valid() {
+			usage = chunk.Usage.RawJSON()
+		}
+		if len(chunk.Choices) > 0 {
+			reasoning.WriteString(rawString(chunk.Choices[0].Delta.JSON.ExtraFields["reasoning"].Raw()))
+		}
+
+		onChunk(chunk)
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	if len(acc.Choices) == 0 {
+		return nil, fmt.Errorf("stream ended without a completion")
+	}
+
+	return rawCompletion(acc.ChatCompletion, usage, reasoning.String())
+}
+
+// rawCompletion round trips an accumulated completion through JSON, so it
+// has raw JSON like one that wasn't streamed. The accumulator drops fields
+// that aren't in the OpenAI API, such as OpenRouter's cost and reasoning, so
+// usage is replaced with the raw usage from the stream and the reasoning
+// streamed is added back to the message.
+func rawCompletion(completion openai.ChatCompletion, usage, reasoning string) (*openai.ChatCompletion, error) {
+	data, err := json.Marshal(completion)
+	if err != nil {
+		return nil, err
+	}
+
+	if usage != "" || reasoning != "" {
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+
+		if usage != "" {
+			fields["usage"] = json.RawMessage(usage)
+		}
+		if choices, ok := fields["choices"].([]any); ok && len(choices) > 0 && reasoning != "" {
+			if choice, ok := choices[0].(map[string]any); ok {
+				if message, ok := choice["message"].(map[string]any); ok {
+					message["reasoning"] = reasoning
+				}
+			}
+		}
+
+		if data, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var res openai.ChatCompletion
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// messageReasoning returns the reasoning OpenRouter sends alongside a
+// message, which the OpenAI types don't have a field for.
+func messageReasoning(message openai.ChatCompletionMessage) string {
+	return rawString(message.JSON.ExtraFields["reasoning"].Raw())
+}
+
+// rawString decodes a raw JSON string, returning "" for anything else.
+func rawString(raw string) string {
+	var s string
+	if raw == "" || json.Unmarshal([]byte(raw), &s) != nil {
+		return ""
+	}
+
+	return s
+}
+
+func responseFormat(schema map[string]any) openai.ChatCompletionNewParamsResponseFormatUnion {
+	return openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   "answer",
+				Schema: schema,
+				Strict: openai.Bool(true),
+			},
+		},
+	}
+}
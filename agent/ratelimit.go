@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimits delay completions and tool calls that would go over a rate.
+// Limiters can be shared between agents, so that the rates hold for all of
+// them together. Nil limiters don't limit anything.
+type RateLimits struct {
+	// Completions limits requests to the provider.
+	Completions *RateLimiter
+
+	// ToolCalls limits calls to all tools together, and Tools calls to each
+	// tool by name.
+	ToolCalls *RateLimiter
+	Tools     map[string]*RateLimiter
+}
+
+// RateLimiter is a token bucket refilling at a steady rate. It starts full,
+// so bursts up to its capacity go through without waiting.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute operations a minute, in
+// bursts of up to burst. perMinute must be positive, and a burst below 1 is
+// 1.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// tryTake takes a token if one is available right away.
+func (l *RateLimiter) tryTake() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
+
+// Allow takes a token if one is available right away, for callers that turn
+// operations away rather than wait.
+func (l *RateLimiter) Allow() bool {
+	return l.tryTake()
+}
+
+// cancel gives back a token reserved for an operation that didn't happen.
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = min(l.tokens+1, l.burst)
+}
+
+// toolLimiters returns the limiters that apply to a tool.
+func (r RateLimits) toolLimiters(name string) []*RateLimiter {
+	var limiters []*RateLimiter
+	if r.ToolCalls != nil {
+		limiters = append(limiters, r.ToolCalls)
+	}
+	if l := r.Tools[name]; l != nil {
+		limiters = append(limiters, l)
+	}
+
+	return limiters
+}
+
+// waitRate waits until every limiter allows another operation, warning when
+// that takes a while so a run that seems stuck can be told apart from one
+// being held back.
+func (a *Agent) waitRate(ctx context.Context, what string, limiters ...*RateLimiter) error {
+	var wait time.Duration
+	for _, l := range limiters {
+		if l != nil {
+			wait = max(wait, l.reserve())
+		}
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	if wait >= time.Second {
+		a.warn("Rate limited, waiting %s before %s...", wait.Round(time.Second), what)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		for _, l := range limiters {
+			if l != nil {
+				l.cancel()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// allowRate takes a token from every limiter if they all have one available,
+// for work that is only worth doing without waiting.
+func allowRate(limiters ...*RateLimiter) bool {
+	var taken []*RateLimiter
+
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if !l.tryTake() {
+			for _, t := range taken {
+				t.cancel()
+			}
+			return false
+		}
+		taken = append(taken, l)
+	}
+
+	return true
+}
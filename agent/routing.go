@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Routing picks the model of each turn of a run. Rules are tried in order,
+// and the first that matches a turn picks its model; turns none match use
+// Model. Final, if set, writes the answers: when another model answers
+// without calling tools, the turn is made again with Final, so cheap models
+// can orchestrate the tool calls and a strong one synthesize their results.
+// Escalation and content filter retries override it.
+type Routing struct {
+	Rules []RoutingRule
+	Final string
+}
+
+// RoutingRule matches turns by what they follow, "task" for a message of the
+// user and "tools" for tool results, and by the estimated tokens of their
+// request. Empty and zero conditions match any turn.
+type RoutingRule struct {
+	Model     string
+	After     string
+	MinTokens int64
+	MaxTokens int64
+}
+
+func (r Routing) enabled() bool {
+	return len(r.Rules) > 0 || r.Final != ""
+}
+
+// ModelRouted is emitted when Routing picks the model of a turn, with why.
+type ModelRouted struct {
+	Model  string
+	Reason string
+}
+
+func (ModelRouted) isEvent() {}
+
+// routeModel sets the model of a request by the routing rules.
+func (a *Agent) routeModel(params *openai.ChatCompletionNewParams) {
+	if model, reason := a.matchRoute(params); model != "" {
+		params.Model = model
+		a.routeTo(model, reason)
+	}
+}
+
+// matchRoute returns the model the routing rules pick for a request and
+// why, or an empty model if none do.
+func (a *Agent) matchRoute(params *openai.ChatCompletionNewParams) (string, string) {
+	if len(a.Routing.Rules) == 0 || a.escalation.model != "" || len(params.Messages) == 0 {
+		return "", ""
+	}
+
+	after := "task"
+	if params.Messages[len(params.Messages)-1].OfTool != nil {
+		after = "tools"
+	}
+
+	var tokens int64
+
+	for i, rule := range a.Routing.Rules {
+		if rule.After != "" && rule.After != after {
+			continue
+		}
+
+		var conditions []string
+		if rule.After != "" {
+			conditions = append(conditions, "after "+after)
+		}
+
+		if rule.MinTokens > 0 || rule.MaxTokens > 0 {
+			if tokens == 0 {
+				tokens = a.CountRequestTokens(params)
+			}
+			if tokens < rule.MinTokens || (rule.MaxTokens > 0 && tokens > rule.MaxTokens) {
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("~%d tokens", tokens))
+		}
+
+		reason := fmt.Sprintf("rule %d", i+1)
+		if len(conditions) > 0 {
+			reason += ": " + strings.Join(conditions, ", ")
+		}
+
+		return rule.Model, reason
+	}
+
+	return "", ""
+}
+
+// turnModel returns the model the next turn will be requested from: one a
+// retry overrides it with, the model escalated to, or the one routing
+// picks.
+func (a *Agent) turnModel() string {
+	if a.modelOverride != "" {
+		return a.modelOverride
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model:    cmp.Or(a.escalation.model, a.Model),
+		Messages: a.Messages,
+		Tools:    a.offeredTools(),
+	}
+	if model, _ := a.matchRoute(&params); model != "" {
+		return model
+	}
+
+	return params.Model
+}
+
+func (a *Agent) routeTo(model, reason string) {
+	a.routed = reason
+	a.emit(ModelRouted{Model: model, Reason: reason})
+}
+
+// completeTurn makes the completion of a turn, and again with Routing.Final
+// if another model answered. The first answer is discarded, but its usage
+// is recorded.
+func (a *Agent) completeTurn(ctx context.Context, timing *completionTiming) (*openai.ChatCompletion, bool, error) {
+	params := a.requestParams()
+
+	requested := time.Now()
+	completion, fallback, err := a.completeWithFallback(ctx, params, timing)
+	timing.duration = time.Since(requested)
+
+	if err != nil || !a.rewritesAnswer(params.Model, completion) {
+		return completion, fallback, err
+	}
+
+	a.recordTurn(completion, fallback, *timing)
+	a.routeTo(a.Routing.Final, "final answer")
+
+	params.Model = a.Routing.Final
+	*timing = completionTiming{}
+
+	requested = time.Now()
+	completion, fallback, err = a.completeWithFallback(ctx, params, timing)
+	timing.duration = time.Since(requested)
+
+	return completion, fallback, err
+}
+
+// rewritesAnswer reports whether a completion requested from model is an
+// answer Routing.Final should write instead.
+func (a *Agent) rewritesAnswer(model string, completion *openai.ChatCompletion) bool {
+	final := a.Routing.Final
+	if final == "" || a.escalation.model != "" || model == final {
+		return false
+	}
+
+	choice := completion.Choices[0]
+	return choice.FinishReason == "stop" && len(choice.Message.ToolCalls) == 0
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"github.com/cedws/mcp-experiment/toolschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+// ConvertTools converts MCP tool definitions to OpenAI function tools. Tools
+// whose schema can't be converted are given one without parameters.
+//
+// Deprecated: use toolschema.OpenAI, which reports such tools.
+func ConvertTools(tools []mcp.Tool) []openai.ChatCompletionToolParam {
+	var openaiTools []openai.ChatCompletionToolParam
+
+	for _, tool := range tools {
+		converted, err := toolschema.OpenAI([]mcp.Tool{tool})
+		if err != nil {
+			tool.InputSchema = mcp.ToolInputSchema{Type: "object"}
+			tool.RawInputSchema = nil
+			converted, _ = toolschema.OpenAI([]mcp.Tool{tool})
+		}
+
+		openaiTools = append(openaiTools, converted...)
+	}
+
+	return openaiTools
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// LogLevels are the levels of server logs, from least to most severe.
+var LogLevels = []mcp.LoggingLevel{
+	mcp.LoggingLevelDebug,
+	mcp.LoggingLevelInfo,
+	mcp.LoggingLevelNotice,
+	mcp.LoggingLevelWarning,
+	mcp.LoggingLevelError,
+	mcp.LoggingLevelCritical,
+	mcp.LoggingLevelAlert,
+	mcp.LoggingLevelEmergency,
+}
+
+// ServerLog is a message a server logged with a notifications/message
+// notification. It isn't emitted like other events, as servers log between
+// runs too, but passed to Agent.ServerLogs.
+type ServerLog struct {
+	Server string
+	Level  mcp.LoggingLevel
+	Logger string
+	Text   string
+}
+
+func (ServerLog) isEvent() {}
+
+// setLogLevel asks a server that supports logging to send the messages of
+// LogLevel and above. Servers that can't are left to send what they like.
+func (a *Agent) setLogLevel(ctx context.Context, client *mcpclient.Client, server string, capabilities mcp.ServerCapabilities) {
+	if a.LogLevel == "" || a.ServerLogs == nil || capabilities.Logging == nil {
+		return
+	}
+
+	err := client.SetLevel(ctx, mcp.SetLevelRequest{
+		Params: mcp.SetLevelParams{Level: a.LogLevel},
+	})
+	if err != nil {
+		a.warn("Failed to set the log level of %s: %v", server, err)
+	}
+}
+
+// serverLog passes a notifications/message notification from server to
+// ServerLogs, unless it is below LogLevel, which servers may not respect.
+func (a *Agent) serverLog(server string, notification mcp.JSONRPCNotification) {
+	if a.ServerLogs == nil {
+		return
+	}
+
+	fields := notification.Params.AdditionalFields
+
+	level, _ := fields["level"].(string)
+	if slices.Index(LogLevels, mcp.LoggingLevel(level)) < slices.Index(LogLevels, a.LogLevel) {
+		return
+	}
+
+	log := ServerLog{
+		Server: server,
+		Level:  mcp.LoggingLevel(level),
+	}
+	log.Logger, _ = fields["logger"].(string)
+
+	// Data may be any JSON, but is usually a string.
+	switch data := fields["data"].(type) {
+	case string:
+		log.Text = data
+	default:
+		text, _ := json.Marshal(data)
+		log.Text = string(text)
+	}
+
+	a.ServerLogs(log)
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Subscribe asks the server offering the resource at uri to notify when it
+// changes, which is passed to ResourceUpdated. The server is the one listing
+// the resource, or else one with a template it matches.
+func (a *Agent) Subscribe(ctx context.Context, uri string) error {
+	if err := a.LoadTools(ctx); err != nil {
+		return err
+	}
+
+	client, err := a.resourceClient(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	request := mcp.SubscribeRequest{}
+	request.Params.URI = uri
+
+	if err := client.Subscribe(ctx, request); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", uri, err)
+	}
+
+	a.mu.Lock()
+	a.subscriptions[uri] = client
+	a.mu.Unlock()
+
+	return nil
+}
+
+// Unsubscribe stops the notifications of a resource subscribed to.
+func (a *Agent) Unsubscribe(ctx context.Context, uri string) error {
+	a.mu.Lock()
+	client, ok := a.subscriptions[uri]
+	delete(a.subscriptions, uri)
+	a.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("not subscribed to %s", uri)
+	}
+
+	request := mcp.UnsubscribeRequest{}
+	request.Params.URI = uri
+
+	if err := client.Unsubscribe(ctx, request); err != nil {
+		return fmt.Errorf("failed to unsubscribe from %s: %v", uri, err)
+	}
+
+	return nil
+}
+
+// resourceClient returns the client of the server offering the resource at
+// uri, among those that support subscriptions.
+func (a *Agent) resourceClient(ctx context.Context, uri string) (*mcpclient.Client, error) {
+	var matched *mcpclient.Client
+
+	for _, client := range a.clients {
+		resources := client.GetServerCapabilities().Resources
+		if resources == nil || !resources.Subscribe {
+			continue
+		}
+
+		listed, err := client.ListResources(ctx, mcp.ListResourcesRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resources of %s: %v", a.connected[client].Name, err)
+		}
+		if slices.ContainsFunc(listed.Resources, func(resource mcp.Resource) bool { return resource.URI == uri }) {
+			return client, nil
+		}
+
+		if matched != nil {
+			continue
+		}
+
+		templates, err := client.ListResourceTemplates(ctx, mcp.ListResourceTemplatesRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resource templates of %s: %v", a.connected[client].Name, err)
+		}
+		if slices.ContainsFunc(templates.ResourceTemplates, func(template mcp.ResourceTemplate) bool {
+			return template.URITemplate != nil && template.URITemplate.Regexp().MatchString(uri)
+		}) {
+			matched = client
+		}
+	}
+
+	if matched == nil {
+		return nil, fmt.Errorf("no server that supports subscriptions offers %s", uri)
+	}
+
+	return matched, nil
+}
+
+// resourceUpdated passes a notifications/resources/updated notification from
+// server to ResourceUpdated.
+func (a *Agent) resourceUpdated(server string, notification mcp.JSONRPCNotification) {
+	if a.ResourceUpdated == nil {
+		return
+	}
+
+	if uri, ok := notification.Params.AdditionalFields["uri"].(string); ok {
+		a.ResourceUpdated(server, uri)
+	}
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
+
+const summarizerPrompt = "Summarize the output of a tool call for an AI agent working on the task below. " +
+	"Keep every number, identifier, error message and conclusion the agent may need; drop repetition and noise."
+
+// Summarizer controls summarization of tool outputs longer than Threshold
+// characters. An empty Model disables it.
+type Summarizer struct {
+	Model     string
+	Threshold int
+}
+
+// summarizeToolResult replaces large tool results with a summary produced by
+// the summarizer model. The raw output is kept in RawOutputs and can be read
+// back through the read_more tool.
+func (a *Agent) summarizeToolResult(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall, result string) string {
+	summarizer := a.Summarizer
+	if summarizer.Model == "" || len(result) <= summarizer.Threshold {
+		return result
+	}
+	if _, ok := a.local[toolCall.Function.Name]; ok {
+		return result
+	}
+
+	if err := a.waitRate(ctx, "summarizing "+toolCall.Function.Name, a.RateLimits.Completions); err != nil {
+		return result
+	}
+
+	completion, err := a.provider.Complete(ctx, openai.ChatCompletionNewParams{
+		Model: summarizer.Model,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(summarizerPrompt),
+			openai.UserMessage(fmt.Sprintf("Task:\n%s\n\nTool: %s\nArguments: %s\n\nOutput:\n%s",
+				a.lastQuestion(), toolCall.Function.Name, toolCall.Function.Arguments, result)),
+		},
+	})
+	if err != nil {
+		a.warn("Failed to summarize tool output, using it verbatim: %v", err)
+		return result
+	}
+
+	a.Usage.Add(completion.Usage)
+
+	if a.RawOutputs == nil {
+		a.RawOutputs = make(map[string]string)
+	}
+	a.RawOutputs[toolCall.ID] = result
+
+	return fmt.Sprintf("[Summary of %d characters of output. Call read_more with id %q to read the full output.]\n%s",
+		len(result), toolCall.ID, completion.Choices[0].Message.Content)
+}
+
+func (a *Agent) lastQuestion() string {
+	for i := len(a.Messages) - 1; i >= 0; i-- {
+		if user := a.Messages[i].OfUser; user != nil {
+			return user.Content.OfString.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/openai/openai-go"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// TokenCounter counts the tokens text takes up with the tokenizer of a
+// model. The agent uses it to tell when to compact the conversation and
+// where to truncate tool results.
+type TokenCounter interface {
+	CountTokens(model, text string) int
+}
+
+// messageOverhead is what every message costs on top of its content, for its
+// role and delimiters.
+const messageOverhead = 4
+
+// familyScales are how many tokens the tokenizers of model families take for
+// text that a GPT tokenizer encodes in one, roughly.
+var familyScales = map[string]float64{
+	"GPT":      1,
+	"Claude":   1.15,
+	"Gemini":   0.95,
+	"Llama3":   1,
+	"Llama2":   1.25,
+	"Llama4":   1,
+	"Mistral":  1.15,
+	"Qwen":     1,
+	"Qwen3":    1,
+	"DeepSeek": 1.05,
+	"Cohere":   1.1,
+	"Grok":     1,
+}
+
+// TokenEstimator counts the tokens of OpenAI models with their BPE
+// tokenizers, and approximates the tokenizers of other models without their
+// vocabularies. Text is split the way GPT tokenizers split it before
+// encoding, into words, runs of up to three digits, punctuation and
+// whitespace, and each piece is counted from its length. Other families are
+// scaled from that. Tokenizer names the family of a model, as OpenRouter
+// does in the architecture of its models; unknown families are counted like
+// GPT.
+type TokenEstimator struct {
+	Tokenizer func(model string) string
+}
+
+func (e TokenEstimator) CountTokens(model, text string) int {
+	family := ""
+	if e.Tokenizer != nil {
+		family = e.Tokenizer(model)
+	}
+
+	if family == "" || family == "GPT" {
+		if encoder := bpeEncoder(openAIEncoding(model)); encoder != nil {
+			return len(encoder.EncodeOrdinary(text))
+		}
+	}
+
+	tokens := estimateGPTTokens(text)
+
+	if scale, ok := familyScales[family]; ok {
+		return int(float64(tokens)*scale + 0.5)
+	}
+
+	return tokens
+}
+
+// openAIEncodings are the BPE encodings of OpenAI models by the prefix of
+// their names, longest first where one prefixes another.
+var openAIEncodings = []struct {
+	prefix, encoding string
+}{
+	{"gpt-4o", "o200k_base"},
+	{"gpt-4.1", "o200k_base"},
+	{"gpt-4.5", "o200k_base"},
+	{"gpt-5", "o200k_base"},
+	{"gpt-oss", "o200k_base"},
+	{"chatgpt-4o", "o200k_base"},
+	{"o1", "o200k_base"},
+	{"o3", "o200k_base"},
+	{"o4", "o200k_base"},
+	{"gpt-4", "cl100k_base"},
+	{"gpt-3.5-turbo", "cl100k_base"},
+}
+
+// openAIEncoding returns the BPE encoding of an OpenAI model, named as
+// OpenAI or OpenRouter name it, or an empty string for other models.
+func openAIEncoding(model string) string {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	model, _, _ = strings.Cut(model, ":")
+
+	for _, e := range openAIEncodings {
+		if strings.HasPrefix(model, e.prefix) {
+			return e.encoding
+		}
+	}
+
+	return ""
+}
+
+// bpeEncoders are the BPE tokenizers loaded by encoding. Their vocabularies
+// are downloaded, so they're loaded in the background the first time
+// they're needed, and text is estimated until they're ready or if they fail
+// to load.
+var bpeEncoders sync.Map
+
+type bpeLoad struct {
+	encoder atomic.Pointer[tiktoken.Tiktoken]
+}
+
+// bpeEncoder returns the tokenizer of encoding, or nil if it isn't loaded.
+func bpeEncoder(encoding string) *tiktoken.Tiktoken {
+	if encoding == "" {
+		return nil
+	}
+
+	v, loading := bpeEncoders.LoadOrStore(encoding, new(bpeLoad))
+	load := v.(*bpeLoad)
+
+	if !loading {
+		go func() {
+			if encoder, err := tiktoken.GetEncoding(encoding); err == nil {
+				load.encoder.Store(encoder)
+			}
+		}()
+	}
+
+	return load.encoder.Load()
+}
+
+// estimateGPTTokens counts the pieces of text like a GPT tokenizer would,
+// give or take. Common words are a token, long ones one every eight letters,
+// and ideographs and the like a token each.
+func estimateGPTTokens(text string) int {
+	var (
+		tokens int
+		run    int
+		kind   int
+	)
+
+	const (
+		none = iota
+		letter
+		digit
+		space
+		symbol
+	)
+
+	flush := func() {
+		switch kind {
+		case letter:
+			tokens += (run + 7) / 8
+		case digit:
+			tokens += (run + 2) / 3
+		case space:
+			tokens++
+		case symbol:
+			tokens += (run + 1) / 2
+		}
+		run, kind = 0, none
+	}
+
+	for _, r := range text {
+		var k int
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			tokens++
+			continue
+		case unicode.IsLetter(r) || r == '\'':
+			k = letter
+		case unicode.IsDigit(r):
+			k = digit
+		case unicode.IsSpace(r):
+			k = space
+		default:
+			k = symbol
+		}
+
+		// A space starts the word after it, as in " word".
+		if k != kind && !(kind == space && run == 1 && k == letter) {
+			flush()
+		}
+		kind = k
+		run++
+	}
+	flush()
+
+	return tokens
+}
+
+// tokenCounter returns the agent's TokenCounter, or a TokenEstimator that
+// counts every model like GPT.
+func (a *Agent) tokenCounter() TokenCounter {
+	if a.Tokens != nil {
+		return a.Tokens
+	}
+
+	return TokenEstimator{}
+}
+
+// CountTokens counts the tokens of messages for model.
+func (a *Agent) CountTokens(model string, messages []openai.ChatCompletionMessageParamUnion) int64 {
+	return int64(a.tokenCounter().CountTokens(model, renderTranscript(messages)) + messageOverhead*len(messages))
+}
+
+// CountRequestTokens counts the tokens of a request: its messages, and the
+// definitions of its tools.
+func (a *Agent) CountRequestTokens(params *openai.ChatCompletionNewParams) int64 {
+	tokens := a.CountTokens(params.Model, params.Messages)
+
+	if len(params.Tools) > 0 {
+		tools, _ := json.Marshal(params.Tools)
+		tokens += int64(a.tokenCounter().CountTokens(params.Model, string(tools)))
+	}
+
+	return tokens
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolCache keeps the tools listed by MCP servers, so agents connecting to
+// the same server don't list them again. Entries expire after their TTL, or
+// as soon as the server notifies an agent that its tools changed. A nil cache
+// keeps nothing. It is safe for concurrent use.
+type ToolCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]toolCacheEntry
+}
+
+type toolCacheEntry struct {
+	tools   []mcp.Tool
+	expires time.Time
+}
+
+// NewToolCache returns a cache keeping tool lists for ttl.
+func NewToolCache(ttl time.Duration) *ToolCache {
+	return &ToolCache{
+		ttl:     ttl,
+		entries: make(map[string]toolCacheEntry),
+	}
+}
+
+// cacheKey identifies a server in a ToolCache. Servers that didn't report a
+// name aren't cached, as they can't be told apart.
+func (s ServerInfo) cacheKey() string {
+	if s.Name == "" {
+		return ""
+	}
+
+	return s.Name + "@" + s.Version
+}
+
+func (c *ToolCache) get(key string) ([]mcp.Tool, bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.tools, true
+}
+
+func (c *ToolCache) put(key string, tools []mcp.Tool) {
+	if c == nil || key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = toolCacheEntry{tools: tools, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *ToolCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/toolschema"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolDescription replaces or adds to the descriptions of a tool and its
+// parameters, for servers whose descriptions are too terse and can't be
+// changed. Description replaces the tool's description and Append is added
+// after it. Parameters replace the descriptions of parameters, and
+// AppendParameters are added after them.
+type ToolDescription struct {
+	Description      string
+	Append           string
+	Parameters       map[string]string
+	AppendParameters map[string]string
+}
+
+// describeTool applies the ToolDescription of a tool, if it has one. The
+// schema is rewritten as RawInputSchema, as tools may be shared with
+// ToolCache.
+func (a *Agent) describeTool(tool mcp.Tool) (mcp.Tool, error) {
+	override, ok := a.ToolDescriptions[tool.Name]
+	if !ok {
+		return tool, nil
+	}
+
+	tool.Description = joinDescription(cmp.Or(override.Description, tool.Description), override.Append)
+
+	if len(override.Parameters) == 0 && len(override.AppendParameters) == 0 {
+		return tool, nil
+	}
+
+	schema, err := toolschema.InputSchema(tool)
+	if err != nil {
+		return tool, err
+	}
+
+	properties := schema["properties"].(map[string]any)
+	for name, property := range properties {
+		property, ok := property.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		description, _ := property["description"].(string)
+		if replace, ok := override.Parameters[name]; ok {
+			description = replace
+		}
+		if description = joinDescription(description, override.AppendParameters[name]); description != "" {
+			property["description"] = description
+		}
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return tool, fmt.Errorf("failed to marshal input schema of %s: %v", tool.Name, err)
+	}
+	tool.InputSchema = mcp.ToolInputSchema{}
+	tool.RawInputSchema = data
+
+	return tool, nil
+}
+
+func joinDescription(description, add string) string {
+	if add == "" {
+		return description
+	}
+	if description == "" {
+		return add
+	}
+
+	return strings.TrimRight(description, " \n") + "\n\n" + add
+}
@@ -0,0 +1,635 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/toolschema"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+// LocalTool is a tool implemented in-process rather than by an MCP server.
+// It is described with the same mcp.Tool schema so it can be offered to the
+// model alongside server tools.
+type LocalTool struct {
+	Tool    mcp.Tool
+	Handler func(ctx context.Context, request mcp.CallToolRequest) (string, error)
+}
+
+// Image is an image returned by a tool.
+type Image struct {
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// ServerInfo describes an MCP server as reported during initialization, with
+// the protocol version and capabilities it negotiated.
+type ServerInfo struct {
+	Name            string                 `json:"name"`
+	Version         string                 `json:"version"`
+	ProtocolVersion string                 `json:"protocol_version"`
+	Capabilities    mcp.ServerCapabilities `json:"capabilities"`
+}
+
+// InitOptions are what a client tells a server about itself in the
+// initialize request. Empty fields keep the defaults: the latest protocol
+// version mcp-go supports, and mcp-client 1.0.0 as the client.
+type InitOptions struct {
+	ProtocolVersion string
+	ClientInfo      mcp.Implementation
+	Experimental    map[string]any
+}
+
+// Servers returns the servers initialized by LoadTools.
+func (a *Agent) Servers() []ServerInfo {
+	return a.servers
+}
+
+// AddTool registers a local tool. It must be called before the first Run.
+func (a *Agent) AddTool(tool LocalTool) {
+	a.local[tool.Tool.Name] = tool
+}
+
+// Tools returns the tools offered to the model. It is empty until LoadTools
+// has been called.
+func (a *Agent) Tools() []openai.ChatCompletionToolParam {
+	return a.tools
+}
+
+// LoadTools initializes the MCP clients if needed and lists their tools. When
+// several servers offer a tool with the same name, the first one wins. It is
+// called by Run, but may be called earlier to fail fast. Tools are listed
+// again once a server notifies that they changed.
+func (a *Agent) LoadTools(ctx context.Context) error {
+	if a.loaded && !a.toolsChanged.Load() {
+		return nil
+	}
+	a.toolsChanged.Store(false)
+
+	var tools []mcp.Tool
+	routes := make(map[string]*mcpclient.Client)
+	toolServers := make(map[string]string)
+	readOnly := make(map[string]bool)
+
+	if a.connected == nil {
+		a.connected = make(map[*mcpclient.Client]ServerInfo)
+	}
+
+	for _, client := range a.clients {
+		info, ok := a.connected[client]
+
+		if !ok && !client.IsInitialized() {
+			opts := a.initOptions[client]
+			result, err := InitializeWith(ctx, client, opts, a.Roots...)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrServerUnreachable, err)
+			}
+
+			info = ServerInfo{
+				Name:            result.ServerInfo.Name,
+				Version:         result.ServerInfo.Version,
+				ProtocolVersion: result.ProtocolVersion,
+				Capabilities:    result.Capabilities,
+			}
+			if opts.ProtocolVersion != "" && result.ProtocolVersion != opts.ProtocolVersion {
+				a.warn("MCP server %s answered with protocol version %s rather than %s.", info.Name, result.ProtocolVersion, opts.ProtocolVersion)
+			}
+			a.servers = append(a.servers, info)
+			a.connected[client] = info
+
+			key := info.cacheKey()
+			client.OnNotification(func(notification mcp.JSONRPCNotification) {
+				switch notification.Method {
+				case mcp.MethodNotificationToolsListChanged:
+					a.ToolCache.invalidate(key)
+					a.toolsChanged.Store(true)
+				case "notifications/message":
+					a.serverLog(info.Name, notification)
+				case mcp.MethodNotificationResourceUpdated:
+					a.resourceUpdated(info.Name, notification)
+				}
+			})
+
+			a.setLogLevel(ctx, client, info.Name, result.Capabilities)
+		}
+
+		listed, err := a.listTools(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		for _, tool := range listed {
+			if _, ok := routes[tool.Name]; ok {
+				continue
+			}
+
+			routes[tool.Name] = client
+			toolServers[tool.Name] = info.Name
+			if hint := tool.Annotations.ReadOnlyHint; hint != nil && *hint {
+				readOnly[tool.Name] = true
+			}
+			tools = append(tools, tool)
+		}
+	}
+
+	if a.replaying != nil {
+		tools = a.replaying.Tools
+	}
+	if a.recording != nil {
+		if err := a.recording.recordTools(tools); err != nil {
+			return fmt.Errorf("failed to record tools: %v", err)
+		}
+	}
+
+	if a.Summarizer.Model != "" || a.Truncation.MaxTokens > 0 {
+		a.AddTool(a.readMoreTool())
+	}
+	if a.Delegation.New != nil {
+		a.AddTool(a.delegateTool())
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(a.local)) {
+		tools = append(tools, a.local[name].Tool)
+	}
+
+	composites, err := a.compositeTools(tools)
+	if err != nil {
+		return err
+	}
+	for _, tool := range composites {
+		toolServers[tool.Name] = toolServers[a.composites[tool.Name].Target]
+	}
+	tools = append(tools, composites...)
+
+	var (
+		described []mcp.Tool
+		invalid   []error
+	)
+	for _, tool := range tools {
+		tool, err := a.describeTool(tool)
+		if err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		described = append(described, tool)
+
+		if err := toolschema.Validate(tool); err != nil {
+			invalid = append(invalid, err)
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("incompatible tools: %w", errors.Join(invalid...))
+	}
+
+	converted, err := toolschema.OpenAI(described)
+	if err != nil {
+		return fmt.Errorf("failed to convert tools: %v", err)
+	}
+
+	// Schemas are validated in their JSON form, the way the model sees them.
+	schemas := make(map[string]map[string]any)
+	for _, tool := range converted {
+		data, err := json.Marshal(tool.Function.Parameters)
+		if err != nil {
+			return fmt.Errorf("failed to marshal schema of %s: %v", tool.Function.Name, err)
+		}
+
+		var schema map[string]any
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return fmt.Errorf("failed to unmarshal schema of %s: %v", tool.Function.Name, err)
+		}
+		schemas[tool.Function.Name] = schema
+	}
+
+	// A speculative call may be looking tools up while they are reloaded.
+	a.mu.Lock()
+	a.routes = routes
+	a.toolServers = toolServers
+	a.readOnly = readOnly
+	a.tools = converted
+	a.schemas = schemas
+	a.mu.Unlock()
+
+	a.loaded = true
+
+	return nil
+}
+
+// listTools lists the tools of a server, from ToolCache if it has them.
+func (a *Agent) listTools(ctx context.Context, client *mcpclient.Client) ([]mcp.Tool, error) {
+	key := a.connected[client].cacheKey()
+	if tools, ok := a.ToolCache.get(key); ok {
+		return tools, nil
+	}
+
+	result, err := client.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tools: %v", err)
+	}
+	a.ToolCache.put(key, result.Tools)
+
+	return result.Tools, nil
+}
+
+// toolServer returns the name of the server offering a tool, which is empty
+// for local tools.
+func (a *Agent) toolServer(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.toolServers[name]
+}
+
+// route returns the client of the server offering a tool.
+func (a *Agent) route(name string) (*mcpclient.Client, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	client, ok := a.routes[name]
+	return client, ok
+}
+
+// Initialize performs the MCP handshake on a client, advertising the roots
+// capability if roots are given. The agent does this itself in LoadTools; it
+// is for programs using the client directly.
+func Initialize(ctx context.Context, client *mcpclient.Client, roots ...mcp.Root) (*mcp.InitializeResult, error) {
+	return InitializeWith(ctx, client, InitOptions{}, roots...)
+}
+
+// InitializeWith performs the MCP handshake like Initialize, telling the
+// server what opts say.
+func InitializeWith(ctx context.Context, client *mcpclient.Client, opts InitOptions, roots ...mcp.Root) (*mcp.InitializeResult, error) {
+	experimental := opts.Experimental
+	if experimental == nil {
+		experimental = map[string]any{}
+	}
+
+	initRequest := mcp.InitializeRequest{
+		Request: mcp.Request{
+			Method: "initialize",
+		},
+		Params: mcp.InitializeParams{
+			ProtocolVersion: cmp.Or(opts.ProtocolVersion, mcp.LATEST_PROTOCOL_VERSION),
+			Capabilities: mcp.ClientCapabilities{
+				Experimental: experimental,
+			},
+			ClientInfo: mcp.Implementation{
+				Name:    cmp.Or(opts.ClientInfo.Name, "mcp-client"),
+				Version: cmp.Or(opts.ClientInfo.Version, "1.0.0"),
+			},
+		},
+	}
+
+	if len(roots) > 0 {
+		initRequest.Params.Capabilities.Roots = &struct {
+			ListChanged bool `json:"listChanged,omitempty"`
+		}{}
+	}
+
+	return client.Initialize(ctx, initRequest)
+}
+
+var (
+	errSkipped          = errors.New("skipped in a dry run")
+	errInvalidArguments = errors.New("arguments don't match the tool's schema")
+	errDenied           = errors.New("denied by policy")
+	errUnknownTool      = errors.New("no such tool")
+)
+
+// unknownToolResult tells the model a tool it called doesn't exist, and which
+// do, so it can call one of those instead.
+func (a *Agent) unknownToolResult(name string) string {
+	var names []string
+	for _, tool := range a.tools {
+		names = append(names, tool.Function.Name)
+	}
+
+	return fmt.Sprintf("unknown tool %s; available: %s", name, strings.Join(names, ", "))
+}
+
+// skippedResult is sent to the model in place of the result of a tool call in
+// a dry run.
+const skippedResult = "Execution skipped: this is a dry run and no tools are executed. Assume the call would have succeeded, and continue by describing the rest of your plan, including the tool calls you would make."
+
+// invalidArgumentsResult is sent to the model in place of the result of a
+// tool call with arguments that don't match the tool's input schema.
+func invalidArgumentsResult(name string, errs []string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "The arguments don't match the input schema of %s, so it wasn't called:\n", name)
+	for _, err := range errs {
+		fmt.Fprintf(&sb, "- %s\n", err)
+	}
+	sb.WriteString("Fix the arguments and call the tool again.")
+
+	return sb.String()
+}
+
+// repairArguments answers a tool call whose arguments aren't valid JSON with
+// the parse error, for the model to try again, until ArgumentRepairs runs out.
+func (a *Agent) repairArguments(toolCall openai.ChatCompletionMessageToolCall, err error) (string, error) {
+	a.mu.Lock()
+	a.argumentRepairs++
+	attempt := a.argumentRepairs
+	a.mu.Unlock()
+
+	if attempt > a.ArgumentRepairs {
+		return "", fmt.Errorf("failed to unmarshal tool arguments: %v", err)
+	}
+
+	a.warn("The arguments of %s aren't valid JSON, asking the model to fix them (%d/%d)...", toolCall.Function.Name, attempt, a.ArgumentRepairs)
+
+	return fmt.Sprintf("The arguments aren't valid JSON, so %s wasn't called: %v. Call it again with a valid JSON object.", toolCall.Function.Name, err), nil
+}
+
+// callTool makes a tool call and returns its result for the model. only is
+// set for the only call of a response, whose next turn may be requested
+// while it waits for approval.
+func (a *Agent) callTool(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall, only bool) (string, error) {
+	var args map[string]any
+
+	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+		return a.repairArguments(toolCall, err)
+	}
+
+	started := ToolCallStarted{ToolCall: toolCall, Arguments: args}
+	a.emit(started)
+
+	if _, ok := a.schemas[toolCall.Function.Name]; !ok {
+		result := a.unknownToolResult(toolCall.Function.Name)
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: result, Err: errUnknownTool})
+		return result, nil
+	}
+
+	if errs := ValidateSchema(a.schemas[toolCall.Function.Name], args); len(errs) > 0 {
+		result := invalidArgumentsResult(toolCall.Function.Name, errs)
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: result, Err: errInvalidArguments})
+		return result, nil
+	}
+
+	var rule *ToolRule
+	if r, ok := a.matchRule(toolCall.Function.Name, args); ok {
+		rule = &r
+	}
+
+	if rule != nil && rule.Action == RuleDeny {
+		result := deniedResult(rule.describe(toolCall.Function.Name))
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: result, Err: errDenied, Approval: ApprovalDenied})
+		return result, nil
+	}
+
+	if a.DryRun {
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: skippedResult, Err: errSkipped})
+		return skippedResult, nil
+	}
+
+	if a.PreToolCall != nil {
+		rewritten, reason, err := a.PreToolCall(ctx, started)
+		if err != nil {
+			a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Err: err})
+			return "", err
+		}
+		if reason != "" {
+			result := deniedResult(reason)
+			a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: result, Err: errDenied, Approval: ApprovalDenied})
+			return result, nil
+		}
+
+		if rewritten != nil && !reflect.DeepEqual(rewritten, args) {
+			arguments, err := json.Marshal(rewritten)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal rewritten tool arguments: %v", err)
+			}
+
+			args = rewritten
+			started.Arguments = args
+			toolCall.Function.Arguments = string(arguments)
+			a.rewriteToolCall(toolCall)
+		}
+	}
+
+	if only && a.speculateTurn(toolCall.Function.Name, args, rule) {
+		a.startNextTurn(ctx, toolCall, args)
+	}
+
+	approvedArgs, approval, err := a.approveToolCall(ctx, started, rule)
+	if err != nil {
+		a.discardNextTurn()
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Err: err})
+		return "", err
+	}
+	if approval == ApprovalDeclined {
+		a.discardNextTurn()
+		a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Result: declinedResult, Err: errDeclined, Approval: approval})
+		return declinedResult, nil
+	}
+
+	if !reflect.DeepEqual(approvedArgs, args) {
+		a.discardNextTurn()
+
+		arguments, err := json.Marshal(approvedArgs)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal edited tool arguments: %v", err)
+		}
+
+		args = approvedArgs
+		toolCall.Function.Arguments = string(arguments)
+		a.rewriteToolCall(toolCall)
+	}
+
+	mcpToolRequest := mcp.CallToolRequest{
+		Request: mcp.Request{
+			Method: "tools/call",
+		},
+		Params: mcp.CallToolParams{
+			Name:      toolCall.Function.Name,
+			Arguments: args,
+		},
+	}
+
+	start := time.Now()
+
+	var (
+		resultText string
+		images     []Image
+		failed     bool
+		queued     time.Duration
+		prefetched bool
+	)
+
+	if t, ok := a.takeNextTurnCall(toolCall.ID); ok {
+		resultText, images, failed, err = t.result, t.images, t.failed, t.err
+		prefetched = true
+	} else if c, ok := a.takeEarlyCall(toolCall.ID, toolCall.Function.Name, args); ok {
+		resultText, images, failed, err = c.result, c.images, c.failed, c.err
+		prefetched = true
+	} else if s, ok := a.takeSpeculation(toolCall.Function.Name, args); ok {
+		resultText, images, failed, err = s.result, s.images, s.failed, s.err
+		prefetched = true
+	} else {
+		if err := a.waitRate(ctx, "calling "+toolCall.Function.Name, a.RateLimits.toolLimiters(toolCall.Function.Name)...); err != nil {
+			a.emit(ToolCallFinished{ToolCall: toolCall, Arguments: args, Err: err, Duration: time.Since(start), Queued: time.Since(start), Approval: approval})
+			return "", err
+		}
+
+		l := a.limiter(toolCall.Function.Name)
+		l.acquire()
+		dispatched := time.Now()
+		queued = dispatched.Sub(start)
+		resultText, images, failed, err = a.dispatch(ctx, mcpToolRequest)
+		l.release(time.Since(dispatched), err != nil)
+	}
+
+	finished := ToolCallFinished{
+		ToolCall:   toolCall,
+		Arguments:  args,
+		Result:     resultText,
+		Images:     images,
+		Failed:     failed,
+		Err:        err,
+		Duration:   time.Since(start),
+		Queued:     queued,
+		Prefetched: prefetched,
+		Ran:        true,
+		Server:     a.toolServer(toolCall.Function.Name),
+		Approval:   approval,
+	}
+
+	if a.PostToolCall != nil && err == nil {
+		finished.Result, finished.Err = a.PostToolCall(ctx, finished)
+	}
+
+	a.emit(finished)
+
+	return finished.Result, finished.Err
+}
+
+// rewriteToolCall replaces the arguments of a tool call in the last assistant
+// message, so the model sees the call as it was made after the user edited
+// it.
+func (a *Agent) rewriteToolCall(toolCall openai.ChatCompletionMessageToolCall) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := len(a.Messages) - 1; i >= 0; i-- {
+		message := a.Messages[i].OfAssistant
+		if message == nil {
+			continue
+		}
+
+		for j := range message.ToolCalls {
+			if message.ToolCalls[j].ID == toolCall.ID {
+				message.ToolCalls[j].Function.Arguments = toolCall.Function.Arguments
+			}
+		}
+
+		return
+	}
+}
+
+func (a *Agent) dispatch(ctx context.Context, request mcp.CallToolRequest) (string, []Image, bool, error) {
+	if composite, ok := a.composites[request.Params.Name]; ok {
+		return a.dispatchComposite(ctx, composite, request)
+	}
+	if local, ok := a.local[request.Params.Name]; ok {
+		result, err := local.Handler(ctx, request)
+		return result, nil, false, err
+	}
+
+	if a.replaying != nil {
+		return a.replaying.nextToolCall(request.Params.Name)
+	}
+
+	result, images, failed, err := a.callServerTool(ctx, request)
+
+	if a.recording != nil {
+		call := CassetteToolCall{
+			Name:      request.Params.Name,
+			Arguments: request.GetArguments(),
+			Result:    result,
+			Images:    images,
+			Failed:    failed,
+		}
+		if err != nil {
+			call.Error = err.Error()
+		}
+
+		if err := a.recording.recordToolCall(call); err != nil {
+			return "", nil, false, fmt.Errorf("failed to record tool call: %v", err)
+		}
+	}
+
+	return result, images, failed, err
+}
+
+// CallTool calls a tool of the MCP servers directly, outside of a run: it
+// isn't approved, checked or recorded, and emits no events. It reports
+// whether the server flagged the result as an error.
+func (a *Agent) CallTool(ctx context.Context, name string, args map[string]any) (string, bool, error) {
+	if err := a.LoadTools(ctx); err != nil {
+		return "", false, err
+	}
+
+	result, _, failed, err := a.callServerTool(ctx, mcp.CallToolRequest{
+		Request: mcp.Request{
+			Method: "tools/call",
+		},
+		Params: mcp.CallToolParams{
+			Name:      name,
+			Arguments: args,
+		},
+	})
+
+	return result, failed, err
+}
+
+// callServerTool calls a tool on the MCP server offering it. Text content is
+// joined into the result for the model; images are returned separately and
+// only mentioned in the result. It reports whether the server flagged the
+// result as an error.
+func (a *Agent) callServerTool(ctx context.Context, request mcp.CallToolRequest) (string, []Image, bool, error) {
+	client, ok := a.route(request.Params.Name)
+	if !ok {
+		return "", nil, false, fmt.Errorf("unknown tool %q", request.Params.Name)
+	}
+
+	toolResult, err := client.CallTool(ctx, request)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("failed to call tool: %v", err)
+	}
+
+	var (
+		parts  []string
+		images []Image
+	)
+
+	for _, content := range toolResult.Content {
+		switch content := content.(type) {
+		case mcp.TextContent:
+			parts = append(parts, content.Text)
+		case mcp.ImageContent:
+			data, err := base64.StdEncoding.DecodeString(content.Data)
+			if err != nil {
+				return "", nil, false, fmt.Errorf("failed to decode image: %v", err)
+			}
+
+			images = append(images, Image{MIMEType: content.MIMEType, Data: data})
+			parts = append(parts, fmt.Sprintf("[%s image returned to the user]", content.MIMEType))
+		default:
+			parts = append(parts, fmt.Sprintf("%v", content))
+		}
+	}
+
+	return strings.Join(parts, "\n"), images, toolResult.IsError, nil
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"context"
+	"slices"
+
+	"github.com/openai/openai-go"
+)
+
+// selectTools picks the tools to offer for a task with SelectTools. Without
+// a task, as when continuing, the tools picked last stay.
+func (a *Agent) selectTools(ctx context.Context, task string) {
+	if a.SelectTools == nil || task == "" {
+		return
+	}
+
+	selected, err := a.SelectTools(ctx, task, a.tools)
+	if err != nil {
+		a.warn("Failed to select tools, offering them all: %v", err)
+		selected = nil
+	}
+
+	if selected == nil {
+		a.selected = nil
+		return
+	}
+
+	a.selected = make(map[string]bool)
+	for _, name := range selected {
+		a.selected[name] = true
+	}
+}
+
+// offeredTools returns the tools to send with the next request: the selected
+// tools, with local tools and those called earlier in the conversation, so
+// the model can carry on with them.
+func (a *Agent) offeredTools() []openai.ChatCompletionToolParam {
+	if a.selected == nil {
+		return a.tools
+	}
+
+	called := make(map[string]bool)
+	for _, message := range a.Messages {
+		if message.OfAssistant == nil {
+			continue
+		}
+		for _, toolCall := range message.OfAssistant.ToolCalls {
+			called[toolCall.Function.Name] = true
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(a.tools), func(tool openai.ChatCompletionToolParam) bool {
+		name := tool.Function.Name
+		_, local := a.local[name]
+
+		return !a.selected[name] && !called[name] && !local
+	})
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TripwireAction is what happens when a tripwire matches a tool call.
+type TripwireAction string
+
+const (
+	// TripwireApprove asks Approve whether the call may run. Without Approve,
+	// the run is aborted.
+	TripwireApprove TripwireAction = "approve"
+	// TripwireAbort fails the run before the call is made.
+	TripwireAbort TripwireAction = "abort"
+)
+
+// Tripwire is a pattern that is never allowed to reach a tool unchecked. It
+// is matched against every string in a tool call's arguments.
+type Tripwire struct {
+	Pattern *regexp.Regexp
+	Action  TripwireAction
+}
+
+var errDeclined = errors.New("declined by the user")
+
+// Approval is how a tool call came to be made or not.
+type Approval string
+
+const (
+	// ApprovalNotNeeded is set on calls nothing asked approval for.
+	ApprovalNotNeeded Approval = "not_needed"
+	// ApprovalAllowed is set on calls a policy rule allowed without asking.
+	ApprovalAllowed Approval = "allowed"
+	// ApprovalApproved and ApprovalDeclined are set on calls Approve was
+	// asked about.
+	ApprovalApproved Approval = "approved"
+	ApprovalDeclined Approval = "declined"
+	// ApprovalDenied is set on calls a policy rule denied.
+	ApprovalDenied Approval = "denied"
+)
+
+// ErrTripwire is returned by runs aborted by a tripwire.
+var ErrTripwire = errors.New("aborted by tripwire")
+
+// declinedResult is sent to the model in place of the result of a tool call
+// the user declined.
+const declinedResult = "The user declined to run this tool call. Don't retry it; explain what you wanted to do instead."
+
+// approveToolCall checks a tool call against the tripwires, and asks
+// Approve about it if one matched, the call's policy rule says to, or,
+// unless the rule allows the call, ApproveAll is set or the tool is one of
+// ApproveTools. It returns the arguments to call the tool with, which the
+// user may have edited, and how the call was approved, which is
+// ApprovalDeclined if the user declined it. An error means the run must
+// stop.
+func (a *Agent) approveToolCall(ctx context.Context, call ToolCallStarted, rule *ToolRule) (map[string]any, Approval, error) {
+	name := call.ToolCall.Function.Name
+
+	var reasons []string
+
+	for _, tripwire := range a.Tripwires {
+		match, ok := matchArguments(tripwire.Pattern, call.Arguments)
+		if !ok {
+			continue
+		}
+
+		detail := fmt.Sprintf("%s matched %q in %q", name, tripwire.Pattern, match)
+
+		if tripwire.Action == TripwireAbort || a.Approve == nil {
+			return nil, "", fmt.Errorf("%w: %s", ErrTripwire, detail)
+		}
+
+		reasons = append(reasons, "Tripwire: "+detail)
+	}
+
+	approveAll := a.ApproveAll
+
+	switch {
+	case rule != nil && rule.Action == RuleAsk:
+		if a.Approve == nil {
+			return nil, "", fmt.Errorf("nobody to approve %s, which the policy requires", name)
+		}
+		reasons = append(reasons, "Policy: "+rule.describe(name))
+	case rule != nil && rule.Action == RuleAllow:
+		approveAll = false
+	case slices.Contains(a.ApproveTools, name):
+		reasons = append(reasons, name+" always asks first")
+	}
+
+	if len(reasons) == 0 && !approveAll {
+		if rule != nil && rule.Action == RuleAllow {
+			return call.Arguments, ApprovalAllowed, nil
+		}
+		return call.Arguments, ApprovalNotNeeded, nil
+	}
+	if a.Approve == nil {
+		return nil, "", fmt.Errorf("nobody to approve %s", name)
+	}
+
+	args, approved, err := a.Approve(ctx, call, strings.Join(reasons, "; "))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to ask for approval: %v", err)
+	}
+
+	if !approved {
+		return nil, ApprovalDeclined, nil
+	}
+
+	return args, ApprovalApproved, nil
+}
+
+// matchArguments returns the first match of pattern in the strings of args,
+// including those nested in objects and arrays.
+func matchArguments(pattern *regexp.Regexp, value any) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		if loc := pattern.FindStringIndex(value); loc != nil {
+			return value[loc[0]:loc[1]], true
+		}
+	case map[string]any:
+		for _, v := range value {
+			if match, ok := matchArguments(pattern, v); ok {
+				return match, true
+			}
+		}
+	case []any:
+		for _, v := range value {
+			if match, ok := matchArguments(pattern, v); ok {
+				return match, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+const (
+	rawOutputPageSize = 8000
+
+	// charsPerToken estimates the length of a page of read_more from a
+	// number of tokens.
+	charsPerToken = 4
+)
+
+// Truncation cuts tool results longer than MaxTokens, as counted by the
+// agent's TokenCounter. The full output is kept in RawOutputs, and the model
+// can page through it with the read_more tool. Zero disables it.
+type Truncation struct {
+	MaxTokens int
+}
+
+// truncateToolResult cuts a long tool result down to the truncation budget,
+// keeping the full output. Results that are already stored, such as
+// summarized ones, and pages from read_more are left alone.
+func (a *Agent) truncateToolResult(toolCall openai.ChatCompletionMessageToolCall, result string) string {
+	limit, over := a.truncationLimit(result)
+	if !over || toolCall.Function.Name == "read_more" {
+		return result
+	}
+	if _, ok := a.RawOutputs[toolCall.ID]; ok {
+		return result
+	}
+
+	if a.RawOutputs == nil {
+		a.RawOutputs = make(map[string]string)
+	}
+	a.RawOutputs[toolCall.ID] = result
+
+	end := cutAt(result, limit)
+
+	return result[:end] + fmt.Sprintf("\n[Output truncated at %d of %d characters. Call read_more with id %q and offset %d to read on.]",
+		end, len(result), toolCall.ID, end)
+}
+
+// truncationLimit reports whether result is over the truncation budget, and
+// if so how many of its characters fit in it.
+func (a *Agent) truncationLimit(result string) (int, bool) {
+	if a.Truncation.MaxTokens <= 0 || len(result) <= a.Truncation.MaxTokens {
+		return len(result), false
+	}
+
+	tokens := a.tokenCounter().CountTokens(cmp.Or(a.escalation.model, a.Model), result)
+	if tokens <= a.Truncation.MaxTokens {
+		return len(result), false
+	}
+
+	return len(result) * a.Truncation.MaxTokens / tokens, true
+}
+
+// cutAt returns the largest index up to n that doesn't split a UTF-8
+// character.
+func cutAt(s string, n int) int {
+	if n >= len(s) {
+		return len(s)
+	}
+	for n > 0 && s[n]&0xc0 == 0x80 {
+		n--
+	}
+
+	return n
+}
+
+func (a *Agent) pageSize() int {
+	if a.Truncation.MaxTokens > 0 {
+		return a.Truncation.MaxTokens * charsPerToken
+	}
+
+	return rawOutputPageSize
+}
+
+func (a *Agent) readMoreTool() LocalTool {
+	return LocalTool{
+		Tool: mcp.NewTool("read_more",
+			mcp.WithDescription("Read the full output of a tool call that was truncated or summarized, one page at a time."),
+			mcp.WithString("id", mcp.Required(), mcp.Description("The id given where the output was cut.")),
+			mcp.WithNumber("offset", mcp.Description("Character offset to start reading from."), mcp.DefaultNumber(0)),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			id, err := request.RequireString("id")
+			if err != nil {
+				return "", err
+			}
+
+			raw, ok := a.RawOutputs[id]
+			if !ok {
+				return fmt.Sprintf("No stored output with id %q.", id), nil
+			}
+
+			offset := cutAt(raw, min(max(request.GetInt("offset", 0), 0), len(raw)))
+			end := cutAt(raw, offset+a.pageSize())
+
+			page := raw[offset:end]
+			if end < len(raw) {
+				page += fmt.Sprintf("\n[%d more characters, continue with offset %d]", len(raw)-end, end)
+			}
+
+			return page, nil
+		},
+	}
+}
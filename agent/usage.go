@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Usage accumulates token counts and cost. Cost is only known for providers
+// that report it, such as OpenRouter.
+type Usage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (u *Usage) Add(completionUsage openai.CompletionUsage) {
+	u.PromptTokens += completionUsage.PromptTokens
+	u.CompletionTokens += completionUsage.CompletionTokens
+
+	if cost, ok := completionUsage.JSON.ExtraFields["cost"]; ok {
+		if v, err := strconv.ParseFloat(cost.Raw(), 64); err == nil {
+			u.Cost += v
+		}
+	}
+}
+
+func (u Usage) Plus(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%d in / %d out tokens, $%.4f", u.PromptTokens, u.CompletionTokens, u.Cost)
+}
+
+// Turn records a single completion. Fallback is set when the model asked for
+// failed and Model is the fallback model that made it instead. Duration is
+// how long the completion took, including any failed attempts and the time
+// Queued behind rate limits. FirstToken is how long the first chunk of a
+// streamed completion took to arrive once requested. ToolCalls is how many
+// tool calls it made. Fingerprint is the
+// provider's system_fingerprint, which changes with the backend that served
+// the completion, so it tells whether a seed should have reproduced it.
+type Turn struct {
+	Time         time.Time     `json:"time"`
+	Model        string        `json:"model"`
+	FinishReason string        `json:"finish_reason"`
+	Usage        Usage         `json:"usage"`
+	Fallback     bool          `json:"fallback,omitempty"`
+	Route        string        `json:"route,omitempty"`
+	Duration     time.Duration `json:"duration,omitempty"`
+	FirstToken   time.Duration `json:"first_token,omitempty"`
+	Queued       time.Duration `json:"queued,omitempty"`
+	ToolCalls    int           `json:"tool_calls,omitempty"`
+	Fingerprint  string        `json:"system_fingerprint,omitempty"`
+}
+
+// completionTiming is measured while making a completion, for its Turn.
+type completionTiming struct {
+	duration   time.Duration
+	firstToken time.Duration
+	queued     time.Duration
+}
+
+func (a *Agent) recordTurn(completion *openai.ChatCompletion, fallback bool, timing completionTiming) {
+	turn := Turn{
+		Time:         time.Now(),
+		Model:        completion.Model,
+		FinishReason: completion.Choices[0].FinishReason,
+		Fallback:     fallback,
+		Route:        a.routed,
+		Duration:     timing.duration,
+		FirstToken:   timing.firstToken,
+		Queued:       timing.queued,
+		ToolCalls:    len(completion.Choices[0].Message.ToolCalls),
+		Fingerprint:  completion.SystemFingerprint,
+	}
+	turn.Usage.Add(completion.Usage)
+	a.routed = ""
+
+	a.Usage = a.Usage.Plus(turn.Usage)
+	a.Turns = append(a.Turns, turn)
+
+	a.emit(UsageUpdated{Turn: turn, Total: a.Usage})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// artifactsConfig collects what tools produce, files they mention and data
+// they return, into a directory per session.
+type artifactsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Files bigger than maxArtifactBytes aren't collected.
+const maxArtifactBytes = 50 << 20
+
+var (
+	artifactPathPattern    = regexp.MustCompile(`[\w./~@-]*\.[A-Za-z][A-Za-z0-9]{0,4}\b`)
+	artifactDataURIPattern = regexp.MustCompile(`data:([\w.+-]+/[\w.+-]+);base64,([A-Za-z0-9+/]{64,}={0,2})`)
+)
+
+// artifact is an entry of the index of a session's artifacts. Path is where a
+// file was found, and empty for data returned by the tool.
+type artifact struct {
+	File     string    `json:"file"`
+	Tool     string    `json:"tool"`
+	CallID   string    `json:"call_id"`
+	Path     string    `json:"path,omitempty"`
+	MIMEType string    `json:"mime_type,omitempty"`
+	Bytes    int       `json:"bytes"`
+	Time     time.Time `json:"time"`
+}
+
+// artifactCollector collects the artifacts of the tool calls of a task: files
+// mentioned in their results that were written since it started, in the
+// session's scratch directory or the workspace, and images and data URIs
+// they returned.
+type artifactCollector struct {
+	sess    *session
+	workdir string
+	started time.Time
+	seen    map[string]time.Time
+
+	collected []artifact
+}
+
+func artifactsDir(sess *session) (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "artifacts", sess.ID), nil
+}
+
+func newArtifactCollector(sess *session, workdir string) *artifactCollector {
+	return &artifactCollector{
+		sess:    sess,
+		workdir: workdir,
+		// File times may be coarser than the clock.
+		started: time.Now().Add(-time.Second),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+func (c *artifactCollector) observe(finished agent.ToolCallFinished) error {
+	var errs []error
+
+	tool, id := finished.ToolCall.Function.Name, finished.ToolCall.ID
+
+	for _, img := range finished.Images {
+		errs = append(errs, c.save(artifact{Tool: tool, CallID: id, MIMEType: img.MIMEType}, img.Data))
+	}
+
+	for _, match := range artifactDataURIPattern.FindAllStringSubmatch(finished.Result, -1) {
+		data, err := base64.StdEncoding.DecodeString(match[2])
+		if err != nil {
+			continue
+		}
+		errs = append(errs, c.save(artifact{Tool: tool, CallID: id, MIMEType: match[1]}, data))
+	}
+
+	// Data URIs would otherwise be taken apart into paths.
+	result := artifactDataURIPattern.ReplaceAllString(finished.Result, "")
+
+	for _, match := range artifactPathPattern.FindAllString(result, -1) {
+		path, info, ok := c.resolve(match)
+		if !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		c.seen[path] = info.ModTime()
+
+		errs = append(errs, c.save(artifact{
+			File:     filepath.Base(path),
+			Tool:     tool,
+			CallID:   id,
+			Path:     path,
+			MIMEType: mime.TypeByExtension(filepath.Ext(path)),
+		}, data))
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolve finds a file a result mentions, which must have been written since
+// the task started and not collected since. Results aren't trusted, so the
+// file must be in the working directory or the session's workspace, after
+// following symlinks.
+func (c *artifactCollector) resolve(mention string) (string, os.FileInfo, bool) {
+	var candidates []string
+
+	switch {
+	case strings.HasPrefix(mention, "~/"):
+		if home, err := os.UserHomeDir(); err == nil {
+			candidates = append(candidates, filepath.Join(home, mention[2:]))
+		}
+	case filepath.IsAbs(mention):
+		candidates = append(candidates, mention)
+	default:
+		candidates = append(candidates, filepath.Join(c.workdir, mention))
+		if c.sess.Workspace != "" {
+			candidates = append(candidates, filepath.Join(c.sess.Workspace, mention))
+		}
+	}
+
+	for _, path := range candidates {
+		path, err := filepath.EvalSymlinks(path)
+		if err != nil || !inDirs(path, c.workdir, c.sess.Workspace) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxArtifactBytes {
+			continue
+		}
+		if info.ModTime().Before(c.started) || !info.ModTime().After(c.seen[path]) {
+			continue
+		}
+
+		return path, info, true
+	}
+
+	return "", nil, false
+}
+
+// inDirs reports whether a path without symlinks is in one of dirs.
+func inDirs(path string, dirs ...string) bool {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save writes an artifact into the session's directory, under a name not
+// taken yet, and adds it to the index.
+func (c *artifactCollector) save(a artifact, data []byte) error {
+	dir, err := artifactsDir(c.sess)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	if a.File == "" {
+		ext := ".bin"
+		if exts, _ := mime.ExtensionsByType(a.MIMEType); len(exts) > 0 {
+			ext = exts[len(exts)-1]
+		}
+		a.File = a.Tool + ext
+	}
+
+	ext := filepath.Ext(a.File)
+	base := strings.TrimSuffix(a.File, ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, a.File)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		a.File = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, a.File), data, 0o600); err != nil {
+		return err
+	}
+
+	a.Bytes = len(data)
+	a.Time = time.Now()
+	c.collected = append(c.collected, a)
+
+	return appendArtifactIndex(dir, a)
+}
+
+// readArtifactIndex returns the index of the artifacts in dir, which is
+// empty if none were collected.
+func readArtifactIndex(dir string) ([]artifact, error) {
+	var index []artifact
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to read artifact index: %v", err)
+	}
+
+	return index, nil
+}
+
+func appendArtifactIndex(dir string, a artifact) error {
+	index, err := readArtifactIndex(dir)
+	if err != nil {
+		return err
+	}
+	index = append(index, a)
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "index.json"), data, 0o600)
+}
+
+// printArtifacts lists the artifacts collected during the last task.
+func (r *repl) printArtifacts(c *artifactCollector) {
+	if c == nil || len(c.collected) == 0 {
+		return
+	}
+
+	dir, _ := artifactsDir(r.sess)
+	r.print("%s", statusStyle.Render(tr("Artifacts saved to %s:", dir)))
+
+	for _, a := range c.collected {
+		source := a.Tool
+		if a.Path != "" {
+			source += ", " + a.Path
+		}
+		r.print("  %s  %s", a.File, statusStyle.Render(fmt.Sprintf("(%s, %d bytes)", source, a.Bytes)))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// attachmentConfig limits files attached to tasks. Files larger than
+// MaxBytes are refused, unless Truncate keeps their head, tail or both.
+type attachmentConfig struct {
+	MaxBytes int    `json:"max_bytes"`
+	Truncate string `json:"truncate,omitempty"`
+}
+
+var truncateModes = []string{"head", "tail", "both"}
+
+// attachment is a file read to be sent with a task.
+type attachment struct {
+	path      string
+	content   string
+	size      int
+	truncated bool
+}
+
+func readAttachment(path string, cfg attachmentConfig) (*attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAttachment(path, data, cfg)
+}
+
+// newAttachment attaches data read from path, which may also be the URI of a
+// resource, truncating it as configured.
+func newAttachment(path string, data []byte, cfg attachmentConfig) (*attachment, error) {
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, fmt.Errorf("%s is not a text file", path)
+	}
+
+	a := &attachment{path: path, content: string(data), size: len(data)}
+
+	if cfg.MaxBytes <= 0 || len(data) <= cfg.MaxBytes {
+		return a, nil
+	}
+
+	limit := cfg.MaxBytes
+
+	switch cfg.Truncate {
+	case "head":
+		a.content = truncateLines(a.content[:limit], false) + "\n[…]"
+	case "tail":
+		a.content = "[…]\n" + truncateLines(a.content[len(a.content)-limit:], true)
+	case "both":
+		head := truncateLines(a.content[:limit/2], false)
+		tail := truncateLines(a.content[len(a.content)-limit/2:], true)
+		a.content = head + "\n[…]\n" + tail
+	default:
+		return nil, fmt.Errorf("%s is %d bytes, more than the limit of %d; set a truncation mode to attach part of it", path, len(data), limit)
+	}
+	a.truncated = true
+
+	return a, nil
+}
+
+// truncateLines drops the partial line at the end of s, or at its start when
+// fromStart is set, so a cut doesn't split a line or a UTF-8 sequence. A
+// single line is kept, less the split sequence.
+func truncateLines(s string, fromStart bool) string {
+	if fromStart {
+		if i := strings.IndexByte(s, '\n'); i >= 0 && i < len(s)-1 {
+			return s[i+1:]
+		}
+	} else if i := strings.LastIndexByte(s, '\n'); i > 0 {
+		return s[:i]
+	}
+
+	return strings.ToValidUTF8(s, "")
+}
+
+// String formats the file for the model: its name, then its content fenced
+// with more backticks than it contains.
+func (a *attachment) String() string {
+	fence := "```"
+	for strings.Contains(a.content, fence) {
+		fence += "`"
+	}
+
+	header := "File: " + a.path
+	if a.truncated {
+		header += fmt.Sprintf(" (truncated, %d bytes in total)", a.size)
+	}
+
+	language := strings.TrimPrefix(filepath.Ext(a.path), ".")
+
+	return fmt.Sprintf("%s\n%s%s\n%s\n%s", header, fence, language, strings.TrimRight(a.content, "\n"), fence)
+}
+
+// withAttachments appends attached files to a task.
+func withAttachments(task string, attachments []*attachment) string {
+	parts := []string{task}
+	for _, a := range attachments {
+		parts = append(parts, a.String())
+	}
+
+	return strings.Join(parts, "\n\n")
+}
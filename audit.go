@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// auditConfig appends a record of every tool call to File, as JSON lines.
+// When HMACKeyEnv names an environment variable holding a key, every record
+// carries an HMAC over itself and the previous record's HMAC, so records
+// that are altered, removed or reordered break the chain.
+type auditConfig struct {
+	File       string `json:"file,omitempty"`
+	HMACKeyEnv string `json:"hmac_key_env,omitempty"`
+}
+
+// auditRecord describes a tool call without its arguments or result, which
+// may be sensitive; ArgumentsSHA256 ties it to the arguments if they are
+// kept elsewhere, such as in the session.
+type auditRecord struct {
+	Time            time.Time `json:"time"`
+	Server          string    `json:"server"`
+	Tool            string    `json:"tool"`
+	ArgumentsSHA256 string    `json:"arguments_sha256"`
+	ResultBytes     int       `json:"result_bytes"`
+	DurationMS      int64     `json:"duration_ms"`
+	Approval        string    `json:"approval,omitempty"`
+	Executed        bool      `json:"executed"`
+	Error           string    `json:"error,omitempty"`
+
+	Prev string `json:"prev,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// auditLog appends to the audit log. Other processes may append to the same
+// file, so the file is locked for every record, and the record chained to is
+// the last one in the file rather than the last one written here.
+type auditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	key []byte
+}
+
+func openAuditLog(cfg auditConfig) (*auditLog, error) {
+	var key []byte
+	if cfg.HMACKeyEnv != "" {
+		key = []byte(os.Getenv(cfg.HMACKeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%s is empty, so the audit log can't be signed", cfg.HMACKeyEnv)
+		}
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	return &auditLog{f: f, key: key}, nil
+}
+
+func readAuditLog(path string) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []auditRecord
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// observe records finished tool calls. It is given to agents as an observer.
+func (l *auditLog) observe(event agent.Event) {
+	finished, ok := event.(agent.ToolCallFinished)
+	if !ok {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(finished.ToolCall.Function.Arguments))
+
+	record := auditRecord{
+		Time:            time.Now().UTC(),
+		Server:          cmp.Or(finished.Server, "local"),
+		Tool:            finished.ToolCall.Function.Name,
+		ArgumentsSHA256: hex.EncodeToString(sum[:]),
+		ResultBytes:     len(finished.Result),
+		DurationMS:      finished.Duration.Milliseconds(),
+		Approval:        string(finished.Approval),
+		Executed:        finished.Ran,
+	}
+	if finished.Err != nil {
+		record.Error = redact(finished.Err.Error())
+	}
+
+	if err := l.append(record); err != nil {
+		printWarning("Failed to write audit log: %v", err)
+	}
+}
+
+func (l *auditLog) append(record auditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := lockFile(l.f); err != nil {
+		return err
+	}
+	defer unlockFile(l.f)
+
+	if l.key != nil {
+		last, err := lastAuditRecord(l.f)
+		if err != nil {
+			return err
+		}
+		record.Prev = last.MAC
+
+		mac, err := auditMAC(l.key, record)
+		if err != nil {
+			return err
+		}
+		record.MAC = mac
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.f.Write(append(data, '\n'))
+
+	return err
+}
+
+// lastAuditRecord returns the last record of the audit log, read from the end
+// of the file, or an empty one if there is none.
+func lastAuditRecord(f *os.File) (auditRecord, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return auditRecord{}, err
+	}
+
+	// Records are read back in growing chunks until one holds a whole line.
+	end := info.Size()
+	for size := int64(4096); ; size *= 2 {
+		start := max(end-size, 0)
+
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return auditRecord{}, err
+		}
+
+		line := bytes.TrimRight(buf, "\n")
+		i := bytes.LastIndexByte(line, '\n')
+		if i < 0 && start > 0 {
+			continue
+		}
+		line = line[i+1:]
+
+		var record auditRecord
+		if len(line) == 0 {
+			return record, nil
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return auditRecord{}, fmt.Errorf("failed to parse the last audit log record: %v", err)
+		}
+
+		return record, nil
+	}
+}
+
+// auditMAC returns the HMAC of a record, computed over its JSON without the
+// MAC itself.
+func auditMAC(key []byte, record auditRecord) (string, error) {
+	record.MAC = ""
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyAuditLog checks the HMAC chain of the audit log at path, returning
+// how many records it holds.
+func verifyAuditLog(path string, key []byte) (int, error) {
+	records, err := readAuditLog(path)
+	if err != nil {
+		return 0, err
+	}
+
+	prev := ""
+	for i, record := range records {
+		if record.Prev != prev {
+			return i, fmt.Errorf("record %d doesn't follow the one before it", i+1)
+		}
+
+		mac, err := auditMAC(key, record)
+		if err != nil {
+			return i, err
+		}
+		if !hmac.Equal([]byte(mac), []byte(record.MAC)) {
+			return i, fmt.Errorf("record %d has been altered, or was signed with another key", i+1)
+		}
+
+		prev = record.MAC
+	}
+
+	return len(records), nil
+}
+
+// auditCommand checks the audit log: audit verify [file].
+func auditCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 || args[0] != "verify" || len(args) > 2 {
+		return fmt.Errorf("usage: audit verify [file]")
+	}
+
+	path := cfg.Audit.File
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if path == "" {
+		return fmt.Errorf("no audit log configured, pass its path")
+	}
+	if cfg.Audit.HMACKeyEnv == "" {
+		return fmt.Errorf("the audit log isn't signed without hmac_key_env, so there is nothing to verify")
+	}
+
+	key := []byte(os.Getenv(cfg.Audit.HMACKeyEnv))
+	if len(key) == 0 {
+		return fmt.Errorf("%s is empty", cfg.Audit.HMACKeyEnv)
+	}
+
+	n, err := verifyAuditLog(path, key)
+	if err != nil {
+		return fmt.Errorf("audit log %s is invalid after %d good records: %v", path, n, err)
+	}
+
+	print("%s: %d records, chain intact", path, n)
+
+	return nil
+}
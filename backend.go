@@ -0,0 +1,535 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const mcpServerURL = "http://127.0.0.1:5555/mcp"
+
+// backend holds what is shared by every agent: the configuration, the LLM
+// provider and its model list.
+type backend struct {
+	mu  sync.Mutex
+	cfg *config
+
+	httpClient *http.Client
+	openai     openai.Client
+	models     []modelInfo
+
+	// modelsCached is set when models came from the cache, so the provider
+	// hasn't been reached yet.
+	modelsCached bool
+
+	// probed holds the outcome of probing models for tool calls, guarded
+	// by mu.
+	probed map[string]error
+
+	// knowledge, if set, is the index of the documents sent with tasks, and
+	// memory what is remembered about the user.
+	knowledge *knowledgeBase
+	memory    *memoryStore
+
+	// tools, if set, picks the tools offered with each task.
+	tools *toolIndex
+
+	// reranker, if set, reorders the memories, excerpts and tools found for
+	// each task.
+	reranker *reranker
+
+	// cassette, if set, records what every new agent does.
+	cassette *agent.Cassette
+
+	// audit, if set, records every tool call.
+	audit *auditLog
+
+	// events, if set, streams the events of the agent with -events-jsonl.
+	events *eventStream
+
+	// metrics, if set, counts the completions and tool calls of every agent.
+	metrics *metrics
+
+	// toolCache keeps the MCP server's tools, so every new session doesn't
+	// list them again.
+	toolCache *agent.ToolCache
+
+	// rateLimits are shared by every agent, so they hold across tasks run
+	// in parallel.
+	rateLimits agent.RateLimits
+}
+
+func newBackend(ctx context.Context, cfg *config) (*backend, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %v", err)
+	}
+
+	apiKey, err := cfg.apiKey()
+	if err != nil {
+		return nil, err
+	}
+
+	openaiClient := openai.NewClient(
+		option.WithBaseURL(cfg.baseURL()),
+		option.WithAPIKey(apiKey),
+		option.WithHTTPClient(httpClient),
+	)
+
+	models, cached, err := cachedFetchModels(ctx, cfg, openaiClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errFetchModels, err)
+	}
+
+	var toolCache *agent.ToolCache
+	if cfg.ToolCacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.ToolCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tool cache TTL: %v", err)
+		}
+		if ttl > 0 {
+			toolCache = agent.NewToolCache(ttl)
+		}
+	}
+
+	var audit *auditLog
+	if cfg.Audit.File != "" {
+		audit, err = openAuditLog(cfg.Audit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	events, err := openEventStream(cfg.Output.EventsFD)
+	if err != nil {
+		return nil, err
+	}
+
+	reranker := newReranker(cfg.Embeddings.Reranker, httpClient)
+
+	var knowledge *knowledgeBase
+	if cfg.Knowledge.Dir != "" {
+		embedder := newEmbedder(cfg.Embeddings, cfg.Knowledge.Model, httpClient, openaiClient)
+		knowledge, err = openKnowledge(ctx, cfg.Knowledge, embedder, reranker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index knowledge: %v", err)
+		}
+	}
+
+	var tools *toolIndex
+	if cfg.ToolSelection.Results > 0 {
+		embedder := newEmbedder(cfg.Embeddings, cfg.ToolSelection.Model, httpClient, openaiClient)
+		tools = newToolIndex(cfg.ToolSelection, embedder, reranker)
+	}
+
+	var memory *memoryStore
+	if cfg.Memory.Enabled {
+		memory, err = openMemory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open memory: %v", err)
+		}
+	}
+
+	return &backend{
+		cfg:          cfg,
+		httpClient:   httpClient,
+		openai:       openaiClient,
+		models:       models,
+		modelsCached: cached,
+		knowledge:    knowledge,
+		tools:        tools,
+		reranker:     reranker,
+		memory:       memory,
+		audit:        audit,
+		events:       events,
+		toolCache:    toolCache,
+		rateLimits:   cfg.RateLimits.limits(),
+	}, nil
+}
+
+// cachedFetchModels lists the provider's models, reusing the list stored
+// within cfg.ModelCacheTTL, and reports whether it did.
+func cachedFetchModels(ctx context.Context, cfg *config, openaiClient openai.Client) ([]modelInfo, bool, error) {
+	ttl, err := time.ParseDuration(cmp.Or(cfg.ModelCacheTTL, "0"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid model cache TTL: %v", err)
+	}
+
+	if ttl > 0 {
+		if models, ok := store.cachedModels(cfg.baseURL(), ttl); ok {
+			return models, true, nil
+		}
+	}
+
+	models, err := fetchModels(ctx, openaiClient)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ttl > 0 {
+		if err := store.cacheModels(cfg.baseURL(), models); err != nil {
+			log.Printf("Failed to cache models: %v", err)
+		}
+	}
+
+	return models, false, nil
+}
+
+func (b *backend) config() *config {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.cfg
+}
+
+// reload replaces the config used for new agents. Agents that are already
+// running keep the config they were created with. Settings baked into the
+// backend, such as debug logging, rate limits and the tool cache, only change
+// on restart.
+func (b *backend) reload(cfg *config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cfg = cfg
+}
+
+// newAgent connects to the MCP server and returns a configured agent with its
+// tools, including any local tools, loaded. Each agent gets its own MCP
+// session, so state such as sandbox variables isn't shared between
+// conversations. The caller must close the returned client.
+func (b *backend) newAgent(ctx context.Context, tools ...agent.LocalTool) (*agent.Agent, *mcpclient.Client, error) {
+	return b.connectAgent(ctx, false, false, tools...)
+}
+
+// newOfflineAgent returns an agent that doesn't connect to the MCP server,
+// for chatting without its tools while the server is down. Built-in and
+// local tools are still offered.
+func (b *backend) newOfflineAgent(tools ...agent.LocalTool) (*agent.Agent, *mcpclient.Client, error) {
+	return b.connectAgent(context.Background(), false, true, tools...)
+}
+
+// newSubagent returns an agent for a delegated subtask. It can't delegate
+// further and isn't recorded, as its calls would interleave with the
+// parent's.
+func (b *backend) newSubagent(ctx context.Context) (*agent.Agent, func(), error) {
+	a, mcpClient, err := b.connectAgent(ctx, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a, func() { mcpClient.Close() }, nil
+}
+
+func (b *backend) connectAgent(ctx context.Context, sub, offline bool, tools ...agent.LocalTool) (*agent.Agent, *mcpclient.Client, error) {
+	roots, err := mcpRoots(b.config())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve roots: %v", err)
+	}
+
+	// With passthrough, the provider connects to the server, and offline
+	// nothing does, so the client is never started, only closed like any
+	// other.
+	passthrough := b.config().Passthrough.enabled()
+
+	var mcpClient *mcpclient.Client
+	switch {
+	case passthrough:
+		mcpClient, err = mcpclient.NewStreamableHttpClient(b.config().Passthrough.URL)
+	case offline:
+		mcpClient, err = mcpclient.NewStreamableHttpClient(mcpServerURL)
+	default:
+		mcpClient, err = connectMCP(ctx, b.httpClient, roots)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	builtins, approve, err := builtinTools(b.config().Builtins, b.httpClient)
+	if err != nil {
+		mcpClient.Close()
+		return nil, nil, fmt.Errorf("failed to set up built-in tools: %v", err)
+	}
+
+	composites, err := compositeTools(b.config().CompositeTools)
+	if err != nil {
+		mcpClient.Close()
+		return nil, nil, err
+	}
+
+	opts := []agent.Option{
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithToolCache(b.toolCache),
+		agent.WithLocalTools(append(builtins, tools...)...),
+		agent.WithCompositeTools(composites...),
+	}
+	if !passthrough && !offline {
+		opts = append(opts, agent.WithToolSource(mcpClient), agent.WithInitOptions(mcpClient, b.config().MCPInitialize.options()))
+	}
+	if b.audit != nil {
+		opts = append(opts, agent.WithObserver(b.audit.observe))
+	}
+	if b.metrics != nil {
+		opts = append(opts, agent.WithObserver(b.metrics.observeEvent))
+	}
+	// Sub-agents' turns would be mistaken for the agent's own.
+	if b.events != nil && !sub {
+		opts = append(opts, agent.WithObserver(b.events.observe))
+	}
+	hooks := b.config().Hooks
+	if len(hooks.PostToolCall) > 0 {
+		opts = append(opts, agent.WithObserver(newHookQueue(hooks).observe))
+	}
+
+	a := agent.New(opts...)
+
+	if err := configureAgent(a, b.config(), b.models); err != nil {
+		mcpClient.Close()
+		return nil, nil, fmt.Errorf("failed to configure agent: %v", err)
+	}
+	a.ApproveTools = approve
+	a.Roots = roots
+	a.CheckModel = b.checkModel
+	if a.LogLevel != "" {
+		a.ServerLogs = newMCPLogger(b.httpClient).serverLog
+	}
+
+	if b.knowledge != nil || b.memory != nil {
+		a.Retrieve = b.retrieve
+	}
+	if b.tools != nil {
+		a.SelectTools = b.tools.selectTools
+	}
+
+	if !sub && b.config().Delegation.Enabled {
+		a.Delegation = agent.Delegation{
+			New:    b.newSubagent,
+			Models: b.config().Delegation.Models,
+		}
+	}
+
+	if b.cassette != nil && !sub {
+		a.Record(b.cassette)
+	}
+
+	if err := a.LoadTools(ctx); err != nil {
+		mcpClient.Close()
+		return nil, nil, fmt.Errorf("failed to load tools: %w", err)
+	}
+	if len(a.Tools()) == 0 && !passthrough && !offline {
+		mcpClient.Close()
+		return nil, nil, fmt.Errorf("no tools available from MCP server")
+	}
+
+	return a, mcpClient, nil
+}
+
+// checkModel fails for models the provider says don't support tool calls.
+// When it doesn't say and PreflightProbe is set, the model is asked with a
+// completion offering a tool, whose outcome is kept for later agents.
+func (b *backend) checkModel(ctx context.Context, model string) error {
+	supported, known := findModel(b.models, model).supportsTools()
+	if known && !supported {
+		return fmt.Errorf("%s doesn't support tool calls, according to the provider", model)
+	}
+	if known || !b.config().PreflightProbe {
+		return nil
+	}
+
+	b.mu.Lock()
+	err, probed := b.probed[model]
+	b.mu.Unlock()
+	if probed {
+		return err
+	}
+
+	err = b.probeModel(ctx, model)
+	if ctx.Err() != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.probed == nil {
+		b.probed = make(map[string]error)
+	}
+	b.probed[model] = err
+	b.mu.Unlock()
+
+	return err
+}
+
+// probeModel makes a completion of a few tokens offering a tool, which
+// providers reject for models without tool calls.
+func (b *backend) probeModel(ctx context.Context, model string) error {
+	params := openai.ChatCompletionNewParams{
+		Model:     model,
+		Messages:  []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Call the ping tool.")},
+		MaxTokens: openai.Int(16),
+		Tools: []openai.ChatCompletionToolParam{{
+			Function: openai.FunctionDefinitionParam{
+				Name:        "ping",
+				Description: openai.String("Checks that tool calls work."),
+				Parameters:  openai.FunctionParameters{"type": "object", "properties": map[string]any{}},
+			},
+		}},
+	}
+
+	if _, err := b.openai.Chat.Completions.New(ctx, params, b.config().Routing.requestOptions()...); err != nil {
+		return fmt.Errorf("%s failed a request offering a tool: %v", model, err)
+	}
+
+	return nil
+}
+
+// provider returns the completion provider, with the routing of the current
+// config.
+func (b *backend) provider() agent.Provider {
+	// Routing is OpenRouter's, which the Responses API doesn't take.
+	if passthrough := b.config().Passthrough; passthrough.enabled() {
+		provider := agent.NewPassthroughProvider(b.openai, []agent.RemoteMCPServer{passthrough.server()})
+		if len(redactions) > 0 {
+			return redactingCompleter{provider}
+		}
+		return provider
+	}
+
+	provider := agent.NewOpenAIProvider(b.openai, b.config().Routing.requestOptions()...)
+	if len(redactions) > 0 {
+		return redactingProvider{provider}
+	}
+
+	return provider
+}
+
+// promptPrice is what a prompt token of model costs in dollars, or zero if
+// the provider doesn't say.
+func (b *backend) promptPrice(model string) float64 {
+	return findModel(b.models, model).PromptPrice
+}
+
+// retrieve returns the memories and document excerpts to send with a task.
+func (b *backend) retrieve(ctx context.Context, task string) (string, error) {
+	var parts []string
+
+	if b.memory != nil {
+		memories, err := b.memory.retrieve(ctx, task, cmp.Or(b.config().Memory.Limit, 20), b.reranker)
+		if err != nil {
+			return "", fmt.Errorf("failed to read memory: %v", err)
+		}
+		parts = append(parts, memories)
+	}
+
+	if b.knowledge != nil {
+		excerpts, err := b.knowledge.retrieve(ctx, task)
+		if err != nil {
+			return strings.Join(parts, "\n"), err
+		}
+		parts = append(parts, excerpts)
+	}
+
+	return strings.Join(slices.DeleteFunc(parts, func(s string) bool { return s == "" }), "\n"), nil
+}
+
+// connectMCP starts a new session with the MCP server, answering its
+// requests for roots. The caller must close the returned client.
+func connectMCP(ctx context.Context, httpClient *http.Client, roots []mcp.Root) (*mcpclient.Client, error) {
+	mcpClient, err := mcpclient.NewStreamableHttpClient(
+		mcpServerURL,
+		transport.WithHTTPBasicClient(&http.Client{
+			Transport: &rootsTransport{base: cmp.Or(httpClient.Transport, http.DefaultTransport), roots: roots},
+		}),
+		transport.WithLogger(newMCPLogger(httpClient)),
+		// Listen for notifications between requests too, such as the
+		// server's tools changing.
+		transport.WithContinuousListening(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMCPConnection, err)
+	}
+
+	if err := mcpClient.Start(ctx); err != nil {
+		mcpClient.Close()
+		return nil, fmt.Errorf("%w: %v", errMCPConnection, err)
+	}
+
+	return mcpClient, nil
+}
+
+// mcpLogger writes the MCP client's own logs, such as its attempts to
+// reconnect, to the debug log, and drops them unless debugging.
+type mcpLogger struct {
+	t *tracingTransport
+}
+
+func newMCPLogger(httpClient *http.Client) mcpLogger {
+	t, _ := httpClient.Transport.(*tracingTransport)
+	return mcpLogger{t: t}
+}
+
+func (l mcpLogger) Infof(format string, v ...any) {
+	l.log(format, v...)
+}
+
+func (l mcpLogger) Errorf(format string, v ...any) {
+	l.log("error: "+format, v...)
+}
+
+func (l mcpLogger) log(format string, v ...any) {
+	if l.t == nil {
+		return
+	}
+
+	l.t.mu.Lock()
+	defer l.t.mu.Unlock()
+
+	fmt.Fprintf(l.t.w, "[debug mcp %s] %s\n", time.Now().Format(time.TimeOnly), fmt.Sprintf(format, v...))
+}
+
+// serverLog writes a log message from the MCP server to the debug log.
+func (l mcpLogger) serverLog(log agent.ServerLog) {
+	l.log("%s: %s", log.Server, serverLogText(log))
+}
+
+// replayAgent returns an agent that replays the cassette in path instead of
+// talking to the provider and MCP server.
+func replayAgent(ctx context.Context, cfg *config, path string) (*agent.Agent, error) {
+	cassette, err := agent.LoadCassette(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cassette: %v", err)
+	}
+
+	var opts []agent.Option
+	events, err := openEventStream(cfg.Output.EventsFD)
+	if err != nil {
+		return nil, err
+	}
+	if events != nil {
+		opts = append(opts, agent.WithObserver(events.observe))
+	}
+
+	a := agent.New(opts...)
+	if err := configureAgent(a, cfg, nil); err != nil {
+		return nil, fmt.Errorf("failed to configure agent: %v", err)
+	}
+
+	a.Replay(cassette)
+
+	if err := a.LoadTools(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load tools: %v", err)
+	}
+
+	return a, nil
+}
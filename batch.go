@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// batchTask is a line of a -batch file. Model defaults to -model, and Vars
+// add to the template variables of the config and -var.
+type batchTask struct {
+	ID    string            `json:"id,omitempty"`
+	Task  string            `json:"task"`
+	Model string            `json:"model,omitempty"`
+	Vars  map[string]string `json:"vars,omitempty"`
+}
+
+// batchRecord is a line of the output of -batch, the -output json record of
+// a task with where it came from.
+type batchRecord struct {
+	Line int    `json:"line"`
+	ID   string `json:"id,omitempty"`
+	runRecord
+}
+
+func loadBatch(path string) ([]batchTask, []int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var (
+		tasks []batchTask
+		lines []int
+	)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var task batchTask
+		if err := json.Unmarshal([]byte(text), &task); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if task.Task == "" {
+			return nil, nil, fmt.Errorf("%s:%d: no task", path, line)
+		}
+
+		tasks = append(tasks, task)
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	return tasks, lines, nil
+}
+
+// runBatch runs every task in the file at path, up to parallel at once, each
+// in its own session with its own MCP connection. A record of every task is
+// written to output as a line of JSON when it finishes.
+func runBatch(ctx context.Context, cfg *config, path string, parallel int, output io.Writer, attachments []*attachment, model string, templated bool) error {
+	tasks, lines, err := loadBatch(path)
+	if err != nil {
+		return err
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		finished int
+		failed   int
+		writeErr error
+	)
+
+	enc := json.NewEncoder(output)
+	sem := make(chan struct{}, max(parallel, 1))
+
+	var wg sync.WaitGroup
+
+	for i, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			record := batchRecord{Line: lines[i], ID: task.ID}
+
+			vars := maps.Clone(cfg.Vars)
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			maps.Copy(vars, task.Vars)
+
+			text, err := expandTask(task.Task, vars, templated || len(task.Vars) > 0)
+			if err != nil {
+				record.runRecord = newRunRecord(report{Task: task.Task, Model: cmp.Or(task.Model, model)}, time.Now())
+				record.setError(err)
+			} else {
+				record.runRecord = runBatchTask(ctx, b, workspace, withAttachments(text, attachments), cmp.Or(task.Model, model))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			finished++
+			if record.Error != "" {
+				failed++
+			}
+			if err := enc.Encode(record); err != nil && writeErr == nil {
+				writeErr = err
+			}
+
+			if !cfg.Output.Quiet {
+				log.Printf("Finished line %d (%d/%d)%s", record.Line, finished, len(tasks), batchStatus(record))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if writeErr != nil {
+		return fmt.Errorf("failed to write results: %v", writeErr)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tasks failed", failed, len(tasks))
+	}
+
+	return nil
+}
+
+func runBatchTask(ctx context.Context, b *backend, workspace, task, model string) runRecord {
+	start := time.Now()
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		record := newRunRecord(report{Task: task, Model: model}, start)
+		record.setError(err)
+		return record
+	}
+	defer mcpClient.Close()
+
+	sess := newSession(workspace, model)
+
+	r := newREPL(b.config(), sess, a)
+	r.render = func(agent.Event) {}
+
+	result, err := r.runTask(ctx, task)
+	if result == nil {
+		result = &agent.Result{}
+	}
+
+	record := newRunRecord(newReport("", task, result, sess), start)
+	if err != nil {
+		record.setError(err)
+	}
+
+	return record
+}
+
+func batchStatus(record batchRecord) string {
+	if record.Error != "" {
+		return ": " + record.Error
+	}
+
+	return ""
+}
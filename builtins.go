@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// builtinsConfig enables the built-in local tools, which are offered
+// alongside the MCP server's. Tools touching files are rooted in the
+// session's scratch directory.
+type builtinsConfig struct {
+	ReadFile  bool `json:"read_file,omitempty"`
+	WriteFile bool `json:"write_file,omitempty"`
+	HTTPFetch bool `json:"http_fetch,omitempty"`
+	ShellExec bool `json:"shell_exec,omitempty"`
+
+	WebSearch webSearchConfig `json:"web_search"`
+}
+
+const (
+	builtinOutputLimit = 100_000
+	builtinTimeout     = time.Minute
+)
+
+// builtinTools returns the enabled built-in tools, and the names of those
+// that must be approved before every call.
+func builtinTools(cfg builtinsConfig, httpClient *http.Client) ([]agent.LocalTool, []string, error) {
+	var (
+		tools   []agent.LocalTool
+		approve []string
+	)
+
+	if cfg.ReadFile {
+		tools = append(tools, readFileTool())
+	}
+	if cfg.WriteFile {
+		tools = append(tools, writeFileTool())
+	}
+	if cfg.HTTPFetch {
+		tools = append(tools, httpFetchTool(httpClient))
+	}
+	if cfg.ShellExec {
+		tools = append(tools, shellExecTool())
+		approve = append(approve, "shell_exec")
+	}
+	if cfg.WebSearch.Provider != "" {
+		searcher, err := newWebSearcher(cfg.WebSearch, httpClient)
+		if err != nil {
+			return nil, nil, err
+		}
+		tools = append(tools, webSearchTool(searcher))
+	}
+
+	return tools, approve, nil
+}
+
+// openWorkdir opens the scratch directory of the session running the task.
+// Paths, including symlinks, can't escape it.
+func openWorkdir(ctx context.Context) (*os.Root, error) {
+	dir, ok := workdirFrom(ctx)
+	if !ok {
+		return nil, errors.New("no working directory for this task")
+	}
+
+	return os.OpenRoot(dir)
+}
+
+func readFileTool() agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("read_file",
+			mcp.WithDescription("Read a text file, or list a directory, in the local working directory."),
+			mcp.WithString("path", mcp.Description("Path relative to the working directory. Empty for the directory itself.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			name := strings.Trim(request.GetString("path", ""), "/")
+			if name == "" {
+				name = "."
+			}
+
+			root, err := openWorkdir(ctx)
+			if err != nil {
+				return "", err
+			}
+			defer root.Close()
+
+			fsys := root.FS()
+
+			if entries, err := fs.ReadDir(fsys, name); err == nil {
+				var sb strings.Builder
+				for _, entry := range entries {
+					kind := "file"
+					if entry.IsDir() {
+						kind = "dir"
+					}
+					fmt.Fprintf(&sb, "%s\t%s\n", kind, entry.Name())
+				}
+
+				return sb.String(), nil
+			}
+
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return fmt.Sprintf("Failed to read %q: %v", name, err), nil
+			}
+
+			return limitOutput(string(data)), nil
+		},
+	}
+}
+
+func writeFileTool() agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("write_file",
+			mcp.WithDescription("Write a text file in the local working directory, replacing it if it exists. Parent directories are created."),
+			mcp.WithString("path", mcp.Required(), mcp.Description("Path relative to the working directory.")),
+			mcp.WithString("content", mcp.Required(), mcp.Description("The full content of the file.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			name, err := request.RequireString("path")
+			if err != nil {
+				return "", err
+			}
+			content, err := request.RequireString("content")
+			if err != nil {
+				return "", err
+			}
+			name = path.Clean(strings.Trim(name, "/"))
+
+			root, err := openWorkdir(ctx)
+			if err != nil {
+				return "", err
+			}
+			defer root.Close()
+
+			if err := mkdirAll(root, path.Dir(name)); err != nil {
+				return fmt.Sprintf("Failed to create the directory of %q: %v", name, err), nil
+			}
+
+			f, err := root.Create(name)
+			if err != nil {
+				return fmt.Sprintf("Failed to write %q: %v", name, err), nil
+			}
+			defer f.Close()
+
+			if _, err := f.WriteString(content); err != nil {
+				return fmt.Sprintf("Failed to write %q: %v", name, err), nil
+			}
+
+			return fmt.Sprintf("Wrote %d bytes to %s.", len(content), name), nil
+		},
+	}
+}
+
+// mkdirAll creates a directory and its parents inside root.
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if err := mkdirAll(root, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	if err := root.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	return nil
+}
+
+func httpFetchTool(httpClient *http.Client) agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("http_fetch",
+			mcp.WithDescription("Fetch a URL with an HTTP GET request and return the status and body."),
+			mcp.WithString("url", mcp.Required(), mcp.Description("The http or https URL to fetch.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			url, err := request.RequireString("url")
+			if err != nil {
+				return "", err
+			}
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				return "Only http and https URLs can be fetched.", nil
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, builtinTimeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return fmt.Sprintf("Invalid URL: %v", err), nil
+			}
+
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return fmt.Sprintf("Failed to fetch %s: %v", url, err), nil
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(io.LimitReader(resp.Body, builtinOutputLimit+1))
+			if err != nil {
+				return fmt.Sprintf("Failed to read the response from %s: %v", url, err), nil
+			}
+
+			return fmt.Sprintf("%s\nContent-Type: %s\n\n%s", resp.Status, resp.Header.Get("Content-Type"), limitOutput(string(body))), nil
+		},
+	}
+}
+
+func shellExecTool() agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("shell_exec",
+			mcp.WithDescription("Run a shell command on the user's machine, in the local working directory, and return its output. The user is asked before every command."),
+			mcp.WithString("command", mcp.Required(), mcp.Description("The command, run with sh -c.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			command, err := request.RequireString("command")
+			if err != nil {
+				return "", err
+			}
+
+			dir, ok := workdirFrom(ctx)
+			if !ok {
+				return "", errors.New("no working directory for this task")
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, builtinTimeout)
+			defer cancel()
+
+			var output bytes.Buffer
+
+			cmd := exec.CommandContext(ctx, "sh", "-c", command)
+			cmd.Dir = dir
+			cmd.Stdout = &output
+			cmd.Stderr = &output
+
+			err = cmd.Run()
+
+			var exitErr *exec.ExitError
+			switch {
+			case errors.As(err, &exitErr):
+				return fmt.Sprintf("%s\n[exit status %d]", limitOutput(output.String()), exitErr.ExitCode()), nil
+			case err != nil:
+				return fmt.Sprintf("Failed to run the command: %v", err), nil
+			}
+
+			return limitOutput(output.String()), nil
+		},
+	}
+}
+
+// cutAt returns the largest index up to n that doesn't split a UTF-8
+// character.
+func cutAt(s string, n int) int {
+	if n >= len(s) {
+		return len(s)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return n
+}
+
+// limitOutput cuts tool output at builtinOutputLimit bytes, saying so.
+func limitOutput(s string) string {
+	if len(s) <= builtinOutputLimit {
+		return s
+	}
+
+	return s[:cutAt(s, builtinOutputLimit)] + fmt.Sprintf("\n[output cut at %d bytes]", builtinOutputLimit)
+}
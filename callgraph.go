@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss/tree"
+)
+
+// toolCallStats is what a session keeps about a tool call besides its
+// arguments and result, which are in the conversation.
+type toolCallStats struct {
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Failed     bool   `json:"failed,omitempty"`
+}
+
+// recordToolCall keeps the duration and outcome of a finished tool call.
+func (s *session) recordToolCall(finished agent.ToolCallFinished) {
+	if s.ToolCalls == nil {
+		s.ToolCalls = make(map[string]toolCallStats)
+	}
+
+	stats := toolCallStats{
+		DurationMS: finished.Duration.Milliseconds(),
+		Failed:     finished.Failed,
+	}
+	if finished.Err != nil {
+		stats.Error = redact(finished.Err.Error())
+	}
+
+	s.ToolCalls[finished.ToolCall.ID] = stats
+}
+
+// callNode is a tool call in the graph of a session. parents are the earlier
+// calls whose results its arguments use.
+type callNode struct {
+	name      string
+	arguments string
+	result    string
+	stats     toolCallStats
+	recorded  bool
+	parents   []int
+}
+
+// minTokenLength is the shortest word of a result that links it to the
+// arguments of a later call.
+const minTokenLength = 4
+
+// sessionCallGraph lists the tool calls of a session in order, linking each
+// to the earlier calls that fed it. A call is taken to use a result when its
+// arguments contain a distinctive word of it, such as a path, an ID or a
+// number, that the user didn't write.
+func sessionCallGraph(s *session) []callNode {
+	var (
+		nodes []callNode
+		byID  = make(map[string]int)
+		given = make(map[string]bool)
+	)
+
+	for _, message := range s.Messages {
+		switch {
+		case message.OfUser != nil:
+			for _, token := range distinctiveTokens(message.OfUser.Content.OfString.Value) {
+				given[token] = true
+			}
+		case message.OfAssistant != nil:
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				byID[toolCall.ID] = len(nodes)
+
+				stats, recorded := s.ToolCalls[toolCall.ID]
+				nodes = append(nodes, callNode{
+					name:      toolCall.Function.Name,
+					arguments: toolCall.Function.Arguments,
+					stats:     stats,
+					recorded:  recorded,
+				})
+			}
+		case message.OfTool != nil:
+			if i, ok := byID[message.OfTool.ToolCallID]; ok {
+				nodes[i].result = message.OfTool.Content.OfString.Value
+			}
+		}
+	}
+
+	produced := make([]map[string]bool, len(nodes))
+	for i, node := range nodes {
+		produced[i] = make(map[string]bool)
+		for _, token := range distinctiveTokens(node.result) {
+			if !given[token] {
+				produced[i][token] = true
+			}
+		}
+	}
+
+	for i := range nodes {
+		tokens := distinctiveTokens(nodes[i].arguments)
+
+		for j := range i {
+			if slices.ContainsFunc(tokens, func(token string) bool { return produced[j][token] }) {
+				nodes[i].parents = append(nodes[i].parents, j)
+			}
+		}
+	}
+
+	return nodes
+}
+
+// distinctiveTokens returns the words of text that are likely to be copied
+// rather than written anew: those with a digit or punctuation, like paths and
+// IDs, and long ones.
+func distinctiveTokens(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !isTokenRune(r)
+	})
+
+	var tokens []string
+	for _, word := range words {
+		word = strings.Trim(word, ".:-")
+		if len(word) < minTokenLength {
+			continue
+		}
+		if len(word) >= 8 || strings.ContainsAny(word, "0123456789./_-:") {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+func isTokenRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("./_-:", r)
+}
+
+// label describes a call in a line: its number, tool, arguments, duration
+// and whether it failed.
+func (n callNode) label(i int, width int) string {
+	arguments := strings.Join(strings.Fields(n.arguments), " ")
+	if len(arguments) > width {
+		arguments = arguments[:cutAt(arguments, width-3)] + "..."
+	}
+
+	parts := []string{fmt.Sprintf("%d. %s %s", i+1, n.name, arguments)}
+	if n.recorded {
+		parts = append(parts, formatTiming(time.Duration(n.stats.DurationMS)*time.Millisecond))
+	}
+	switch {
+	case n.stats.Error != "":
+		parts = append(parts, "failed: "+n.stats.Error)
+	case n.stats.Failed:
+		parts = append(parts, "failed")
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+func (n callNode) failed() bool {
+	return n.stats.Error != "" || n.stats.Failed
+}
+
+// callTree draws the calls as a tree in the terminal, each under the latest
+// call that fed it. Calls fed by more than one also name the others.
+func callTree(s *session, nodes []callNode) string {
+	children := make(map[int][]int)
+	for i, node := range nodes {
+		parent := -1
+		if len(node.parents) > 0 {
+			parent = node.parents[len(node.parents)-1]
+		}
+		children[parent] = append(children[parent], i)
+	}
+
+	var add func(t *tree.Tree, i int)
+	add = func(t *tree.Tree, i int) {
+		node := nodes[i]
+
+		label := node.label(i, 60)
+		if len(node.parents) > 1 {
+			var others []string
+			for _, p := range node.parents[:len(node.parents)-1] {
+				others = append(others, fmt.Sprint(p+1))
+			}
+			label += " · also uses " + strings.Join(others, ", ")
+		}
+		if node.failed() {
+			label = failStyle.Render(label)
+		}
+
+		child := tree.Root(label)
+		for _, c := range children[i] {
+			add(child, c)
+		}
+		t.Child(child)
+	}
+
+	root := tree.Root(fmt.Sprintf("%s  %s", sessionIDStyle.Render(s.ID), s.title()))
+	for _, i := range children[-1] {
+		add(root, i)
+	}
+
+	return root.String()
+}
+
+// callGraphDOT writes the calls as a Graphviz digraph.
+func callGraphDOT(nodes []callNode) string {
+	var sb strings.Builder
+
+	sb.WriteString("digraph calls {\n  node [shape=box];\n")
+	for i, node := range nodes {
+		attrs := fmt.Sprintf("label=%q", node.label(i, 40))
+		if node.failed() {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(&sb, "  c%d [%s];\n", i+1, attrs)
+	}
+	for i, node := range nodes {
+		for _, p := range node.parents {
+			fmt.Fprintf(&sb, "  c%d -> c%d;\n", p+1, i+1)
+		}
+	}
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
+
+// callGraphMermaid writes the calls as a Mermaid flowchart.
+func callGraphMermaid(nodes []callNode) string {
+	var sb strings.Builder
+
+	sb.WriteString("flowchart TD\n")
+	for i, node := range nodes {
+		label := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(node.label(i, 40))
+		fmt.Fprintf(&sb, "  c%d[\"%s\"]\n", i+1, label)
+		if node.failed() {
+			fmt.Fprintf(&sb, "  style c%d stroke:#d33,stroke-width:2px\n", i+1)
+		}
+	}
+	for i, node := range nodes {
+		for _, p := range node.parents {
+			fmt.Fprintf(&sb, "  c%d --> c%d\n", p+1, i+1)
+		}
+	}
+
+	return sb.String()
+}
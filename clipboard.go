@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/atotto/clipboard"
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+var codeBlockPattern = regexp.MustCompile("(?s)```[^\\n]*\\n(.*?)\\n?```")
+
+// lastCodeBlock returns the last fenced code block in an answer, or else the
+// last code run by a code tool while producing it.
+func lastCodeBlock(result *agent.Result, codeTools map[string]codeToolConfig) (string, bool) {
+	if blocks := codeBlockPattern.FindAllStringSubmatch(result.Answer, -1); len(blocks) > 0 {
+		return blocks[len(blocks)-1][1], true
+	}
+
+	for i := len(result.Events) - 1; i >= 0; i-- {
+		started, ok := result.Events[i].(agent.ToolCallStarted)
+		if !ok {
+			continue
+		}
+
+		if code, _, ok := toolCode(codeTools, started.ToolCall.Function.Name, started.Arguments); ok {
+			return code, true
+		}
+	}
+
+	return "", false
+}
+
+func copyToClipboard(text string) error {
+	if err := clipboard.WriteAll(text); err != nil {
+		return fmt.Errorf("failed to copy to the clipboard: %v", err)
+	}
+
+	return nil
+}
+
+func pasteFromClipboard() (string, error) {
+	text, err := clipboard.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the clipboard: %v", err)
+	}
+
+	return normalizeNewlines(text), nil
+}
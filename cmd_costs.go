@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// costDimensions are what spend can be grouped by.
+var costDimensions = []string{"day", "model", "profile", "user", "session"}
+
+// costRow is the usage of one group of completions.
+type costRow struct {
+	keys  []string
+	turns int
+	usage agent.Usage
+}
+
+// costsCommand sums the usage recorded in sessions, grouped by day, model,
+// profile or session, and prints it as a table or CSV.
+func costsCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("costs", flag.ExitOnError)
+	since := fs.String("since", "", "only count completions since this date (2006-01-02) or this long ago (such as 7d or 12h)")
+	by := fs.String("by", "day", "comma-separated groups: "+strings.Join(costDimensions, ", "))
+	csvPath := fs.String("csv", "", "write CSV to this file instead of printing a table (- for stdout)")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: costs [-since date|duration] [-by day,model,profile,user,session] [-csv file]")
+	}
+
+	dimensions := strings.Split(*by, ",")
+	for _, dimension := range dimensions {
+		if !slices.Contains(costDimensions, dimension) {
+			return fmt.Errorf("unknown group %q, expected %s", dimension, strings.Join(costDimensions, ", "))
+		}
+	}
+
+	var start time.Time
+	if *since != "" {
+		var err error
+		if start, err = parseSince(*since, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	records, err := store.usage(start)
+	if err != nil {
+		return err
+	}
+
+	rows := aggregateCosts(records, dimensions)
+
+	if *csvPath != "" {
+		w := io.Writer(os.Stdout)
+		if *csvPath != "-" {
+			f, err := os.Create(*csvPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		return writeCostsCSV(w, dimensions, rows)
+	}
+
+	printCosts(dimensions, rows)
+
+	return nil
+}
+
+// parseSince accepts a date, a duration, or a number of days such as 7d.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid -since %q, expected a date like 2006-01-02 or a duration like 7d", value)
+}
+
+// aggregateCosts sums completions by dimensions, sorted by their keys.
+func aggregateCosts(records []usageRecord, dimensions []string) []costRow {
+	groups := make(map[string]*costRow)
+
+	for _, record := range records {
+		turn := record.Turn
+
+		keys := make([]string, len(dimensions))
+		for i, dimension := range dimensions {
+			switch dimension {
+			case "day":
+				keys[i] = turn.Time.Local().Format(time.DateOnly)
+			case "model":
+				keys[i] = turn.Model
+			case "profile":
+				keys[i] = record.Profile
+			case "user":
+				keys[i] = record.User
+			case "session":
+				keys[i] = record.Session
+			}
+		}
+
+		id := strings.Join(keys, "\x00")
+		row, ok := groups[id]
+		if !ok {
+			row = &costRow{keys: keys}
+			groups[id] = row
+		}
+		row.turns++
+		row.usage = row.usage.Plus(turn.Usage)
+	}
+
+	var rows []costRow
+	for _, id := range slices.Sorted(maps.Keys(groups)) {
+		rows = append(rows, *groups[id])
+	}
+
+	return rows
+}
+
+func printCosts(dimensions []string, rows []costRow) {
+	if len(rows) == 0 {
+		print("No usage recorded.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	header := append(slices.Clone(dimensions), "turns", "in", "out", "cost")
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+
+	var (
+		total agent.Usage
+		turns int
+	)
+
+	for _, row := range rows {
+		fields := append(slices.Clone(row.keys), strconv.Itoa(row.turns), strconv.FormatInt(row.usage.PromptTokens, 10), strconv.FormatInt(row.usage.CompletionTokens, 10), fmt.Sprintf("$%.4f", row.usage.Cost))
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+
+		total = total.Plus(row.usage)
+		turns += row.turns
+	}
+
+	fields := make([]string, len(dimensions))
+	fields[0] = "total"
+	fields = append(fields, strconv.Itoa(turns), strconv.FormatInt(total.PromptTokens, 10), strconv.FormatInt(total.CompletionTokens, 10), fmt.Sprintf("$%.4f", total.Cost))
+	fmt.Fprintln(w, strings.Join(fields, "\t"))
+
+	w.Flush()
+}
+
+func writeCostsCSV(w io.Writer, dimensions []string, rows []costRow) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write(append(slices.Clone(dimensions), "turns", "prompt_tokens", "completion_tokens", "cost"))
+	for _, row := range rows {
+		cw.Write(append(slices.Clone(row.keys),
+			strconv.Itoa(row.turns),
+			strconv.FormatInt(row.usage.PromptTokens, 10),
+			strconv.FormatInt(row.usage.CompletionTokens, 10),
+			strconv.FormatFloat(row.usage.Cost, 'f', 6, 64),
+		))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// daemonCommand runs the scheduled tasks from the config, delivering each
+// answer as configured. With -once every schedule runs immediately and the
+// command exits, for use from cron.
+func daemonCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	once := fs.Bool("once", false, "run every schedule once now and exit")
+	fs.Parse(args)
+
+	if err := validateSchedules(cfg); err != nil {
+		return err
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	if *once {
+		for _, s := range cfg.Schedules {
+			if err := runSchedule(ctx, b, workspace, s); err != nil {
+				log.Printf("Schedule %s failed: %v", s.Name, err)
+			}
+		}
+
+		return nil
+	}
+
+	signals, stop := notifySignals()
+	defer stop()
+
+	// Cancelling runCtx interrupts a running schedule. Its session is kept
+	// as of the last finished turn.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	schedules := cfg.Schedules
+	due := nextRuns(schedules)
+
+	for {
+		next := 0
+		for i := range due {
+			if due[i].Before(due[next]) {
+				next = i
+			}
+		}
+
+		timer := time.NewTimer(time.Until(due[next]))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case sig := <-signals:
+			timer.Stop()
+
+			if sig == syscall.SIGHUP {
+				if cfg, ok := reloadConfig(b, validateSchedules); ok {
+					schedules = cfg.Schedules
+					due = nextRuns(schedules)
+				}
+				continue
+			}
+
+			log.Printf("Stopping")
+			return nil
+		case <-timer.C:
+		}
+
+		s := schedules[next]
+
+		done := make(chan error, 1)
+		go func() {
+			done <- runSchedule(runCtx, b, workspace, s)
+		}()
+
+		var draining, reload bool
+
+	wait:
+		for {
+			select {
+			case err := <-done:
+				if err != nil {
+					log.Printf("Schedule %s failed: %v", s.Name, err)
+				}
+				break wait
+			case sig := <-signals:
+				switch {
+				case sig == syscall.SIGHUP:
+					reload = true
+				case !draining:
+					draining = true
+					log.Printf("Finishing schedule %s before stopping, signal again to stop now", s.Name)
+				default:
+					cancel()
+				}
+			}
+		}
+
+		if draining {
+			log.Printf("Stopping")
+			return nil
+		}
+
+		due[next], _ = s.next(time.Now())
+
+		if reload {
+			if cfg, ok := reloadConfig(b, validateSchedules); ok {
+				schedules = cfg.Schedules
+				due = nextRuns(schedules)
+			}
+		} else {
+			log.Printf("Schedule %s next runs at %s", s.Name, due[next].Format(time.DateTime))
+		}
+	}
+}
+
+func validateSchedules(cfg *config) error {
+	if len(cfg.Schedules) == 0 {
+		return fmt.Errorf("no schedules configured")
+	}
+
+	for _, s := range cfg.Schedules {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nextRuns(schedules []scheduleConfig) []time.Time {
+	due := make([]time.Time, len(schedules))
+
+	for i, s := range schedules {
+		due[i], _ = s.next(time.Now())
+		log.Printf("Schedule %s next runs at %s", s.Name, due[i].Format(time.DateTime))
+	}
+
+	return due
+}
+
+func runSchedule(ctx context.Context, b *backend, workspace string, s scheduleConfig) error {
+	log.Printf("Running schedule %s", s.Name)
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		return err
+	}
+	defer mcpClient.Close()
+
+	model := s.Model
+	if model == "" {
+		model = defaultModel
+	}
+
+	sess := newSession(workspace, model)
+
+	r := newREPL(b.config(), sess, a)
+	r.render = logEvent
+
+	result, err := r.runTask(ctx, s.Task)
+	if err != nil {
+		return err
+	}
+
+	return s.Deliver.deliver(ctx, b.httpClient, newReport(s.Name, s.Task, result, sess))
+}
+
+func logEvent(event agent.Event) {
+	switch event := event.(type) {
+	case agent.ToolCallStarted:
+		log.Printf("Calling tool %s", event.ToolCall.Function.Name)
+	case agent.Warning:
+		log.Printf("Warning: %s", event.Text)
+	case agent.ServerLog:
+		log.Printf("MCP server %s", serverLogText(event))
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/toolschema"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/muesli/termenv"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// doctorCheck is the outcome of one of the doctor's checks. Warnings point
+// out what works, but not as well as it could, and checks are skipped when
+// one they depend on failed.
+type doctorCheck struct {
+	name   string
+	status string
+	detail string
+}
+
+const doctorTimeout = 15 * time.Second
+
+// doctorCommand checks the config, storage, credentials, provider, model,
+// MCP server and terminal, printing what to do about each that fails.
+func doctorCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	model := fs.String("model", defaultModel, "model to check")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: doctor [-model model]")
+	}
+
+	// An invalid theme is reported below, and shouldn't keep the results
+	// from being shown.
+	if err := applyTheme(cfg.Theme); err != nil {
+		applyTheme(themeConfig{})
+	}
+
+	var checks []doctorCheck
+	report := func(check doctorCheck) {
+		printDoctorCheck(check)
+		checks = append(checks, check)
+	}
+
+	report(checkConfig(cfg))
+	report(checkStorage(ctx, cfg))
+
+	apiKey := checkAPIKey(cfg)
+	report(apiKey)
+
+	if apiKey.status == "fail" {
+		report(doctorCheck{name: "Provider", status: "skip", detail: "there is no API key"})
+	} else {
+		models, provider := checkProvider(ctx, cfg)
+		report(provider)
+
+		if provider.status == "fail" {
+			report(doctorCheck{name: "Model", status: "skip", detail: "the provider couldn't be reached"})
+		} else {
+			report(checkModel(*model, models))
+		}
+	}
+
+	report(checkMCPServer(ctx, cfg))
+	report(checkTerminal(cfg))
+
+	var failed int
+	for _, check := range checks {
+		if check.status == "fail" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+
+	return nil
+}
+
+func printDoctorCheck(check doctorCheck) {
+	var status string
+	switch check.status {
+	case "pass":
+		status = passStyle.Render("PASS")
+	case "warn":
+		status = warnStyle.Render("WARN")
+	case "skip":
+		status = warnStyle.Render("SKIP")
+	default:
+		status = failStyle.Render("FAIL")
+	}
+
+	print("%s  %-10s %s", status, check.name, strings.ReplaceAll(check.detail, "\n", "\n                 "))
+}
+
+func passCheck(name, format string, a ...any) doctorCheck {
+	return doctorCheck{name: name, status: "pass", detail: fmt.Sprintf(format, a...)}
+}
+
+func warnCheck(name, format string, a ...any) doctorCheck {
+	return doctorCheck{name: name, status: "warn", detail: fmt.Sprintf(format, a...)}
+}
+
+func failCheck(name, format string, a ...any) doctorCheck {
+	return doctorCheck{name: name, status: "fail", detail: fmt.Sprintf(format, a...)}
+}
+
+// checkConfig reports fields of the config file that aren't known, which
+// are otherwise ignored, and settings that are invalid.
+func checkConfig(cfg *config) doctorCheck {
+	dir, err := appDir()
+	if err != nil {
+		return failCheck("Config", "%v", err)
+	}
+	path := filepath.Join(dir, "config.json")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		path = "no config file, using the defaults and flags"
+	} else if err != nil {
+		return failCheck("Config", "failed to read %s: %v", path, err)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&config{}); err != nil {
+			return failCheck("Config", "%s: %v\nfix or remove the setting, it is ignored", path, err)
+		}
+	}
+
+	validate := []func() error{
+		func() error { return validateConfig(cfg) },
+		func() error { return applyTheme(cfg.Theme) },
+		func() error { return applyRedaction(cfg.Redaction) },
+		func() error { return applyPromptProfile(cfg) },
+		func() error { return cfg.Serve.validate() },
+		func() error { return configureAgent(agent.New(), cfg, nil) },
+	}
+	for _, fn := range validate {
+		if err := fn(); err != nil {
+			return failCheck("Config", "%s: %v", path, err)
+		}
+	}
+
+	return passCheck("Config", "%s", path)
+}
+
+func checkStorage(ctx context.Context, cfg *config) doctorCheck {
+	if err := applyEncryption(ctx, cfg.Encryption); err != nil {
+		return failCheck("Storage", "failed to set up encryption: %v", err)
+	}
+
+	s, err := openStore(cfg.Storage)
+	if err != nil {
+		return failCheck("Storage", "failed to open %s storage: %v\ncheck storage.dsn, or pick another driver with -storage", cmp.Or(cfg.Storage.Driver, "sqlite"), err)
+	}
+	defer s.Close()
+
+	if _, err := s.list(""); err != nil {
+		return failCheck("Storage", "failed to list sessions: %v", err)
+	}
+
+	detail := cmp.Or(cfg.Storage.Driver, "sqlite")
+	if cfg.Encryption.Enabled {
+		detail += ", encrypted"
+	}
+
+	return passCheck("Storage", "%s", detail)
+}
+
+func checkAPIKey(cfg *config) doctorCheck {
+	name, _ := cfg.profile()
+
+	key, err := cfg.apiKey()
+	if err != nil {
+		return failCheck("API key", "%v", err)
+	}
+	if key == "" {
+		return failCheck("API key", "the API key of profile %q is empty\nrun auth login %s", name, name)
+	}
+
+	if _, ok := os.LookupEnv("OPENAI_API_KEY"); ok && cfg.Profile == "" {
+		return passCheck("API key", "from OPENAI_API_KEY")
+	}
+
+	return passCheck("API key", "stored for profile %q", name)
+}
+
+// checkProvider lists the provider's models, which needs a valid API key.
+func checkProvider(ctx context.Context, cfg *config) ([]modelInfo, doctorCheck) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, failCheck("Provider", "failed to create HTTP client: %v\ncheck the network settings", err)
+	}
+
+	apiKey, _ := cfg.apiKey()
+
+	client := openai.NewClient(
+		option.WithBaseURL(cfg.baseURL()),
+		option.WithAPIKey(apiKey),
+		option.WithHTTPClient(httpClient),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	models, err := fetchModels(ctx, client)
+	if err == nil {
+		// OpenRouter lists models to anyone, but describes the key only to
+		// its owner. Other providers don't have the endpoint.
+		var key json.RawMessage
+		err = client.Get(ctx, "key", nil, &key)
+
+		var apiErr *openai.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			err = nil
+		}
+	}
+
+	var apiErr *openai.Error
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		return nil, failCheck("Provider", "%s rejected the API key\nrun auth login, or set OPENAI_API_KEY", cfg.baseURL())
+	case err != nil:
+		return nil, failCheck("Provider", "failed to reach %s: %v\ncheck the profile's base_url and the network settings", cfg.baseURL(), err)
+	}
+
+	return models, passCheck("Provider", "%s offers %d models", cfg.baseURL(), len(models))
+}
+
+func checkModel(model string, models []modelInfo) doctorCheck {
+	info := findModel(models, model)
+	if info.ID == "" {
+		return failCheck("Model", "%s isn't offered by the provider\npick another with -model", model)
+	}
+
+	supported, known := info.supportsTools()
+	switch {
+	case !known:
+		return warnCheck("Model", "%s is offered, but the provider doesn't say whether it supports tool calls\n-preflight-probe checks before running", model)
+	case !supported:
+		return failCheck("Model", "%s doesn't support tool calls\npick another with -model", model)
+	}
+
+	return passCheck("Model", "%s supports tool calls", model)
+}
+
+// checkMCPServer makes the initialize handshake and checks the tools the
+// server offers would be accepted by the APIs.
+func checkMCPServer(ctx context.Context, cfg *config) doctorCheck {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return failCheck("MCP server", "failed to create HTTP client: %v", err)
+	}
+
+	roots, err := mcpRoots(cfg)
+	if err != nil {
+		return failCheck("MCP server", "failed to resolve roots: %v\ncheck the roots setting", err)
+	}
+
+	client, err := connectMCP(ctx, httpClient, roots)
+	if err != nil {
+		return failCheck("MCP server", "%v\nstart the server on %s", err, mcpServerURL)
+	}
+	defer client.Close()
+
+	result, err := agent.InitializeWith(ctx, client, cfg.MCPInitialize.options(), roots...)
+	if err != nil {
+		return failCheck("MCP server", "the initialize handshake with %s failed: %v\nstart an MCP server there that speaks streamable HTTP", mcpServerURL, err)
+	}
+
+	server := fmt.Sprintf("%s %s, protocol %s", result.ServerInfo.Name, result.ServerInfo.Version, result.ProtocolVersion)
+
+	tools, err := client.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil {
+		return failCheck("MCP server", "%s, but listing its tools failed: %v", server, err)
+	}
+	if len(tools.Tools) == 0 {
+		return failCheck("MCP server", "%s offers no tools", server)
+	}
+
+	var invalid []string
+	for _, tool := range tools.Tools {
+		if err := toolschema.Validate(tool); err != nil {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	if len(invalid) > 0 {
+		return failCheck("MCP server", "%s offers tools the APIs would reject:\n%s", server, strings.Join(invalid, "\n"))
+	}
+
+	capabilities := describeCapabilities(result.Capabilities)
+	if requested := cfg.MCPInitialize.ProtocolVersion; requested != "" && requested != result.ProtocolVersion {
+		return warnCheck("MCP server", "%s, %d tools, capabilities: %s\nthe server answered with protocol %s rather than %s", server, len(tools.Tools), capabilities, result.ProtocolVersion, requested)
+	}
+
+	return passCheck("MCP server", "%s, %d tools, capabilities: %s", server, len(tools.Tools), capabilities)
+}
+
+func checkTerminal(cfg *config) doctorCheck {
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		return warnCheck("Terminal", "output isn't a terminal, so there are no colors, TUI or inline images")
+	}
+
+	var colors string
+	switch lipgloss.ColorProfile() {
+	case termenv.TrueColor:
+		colors = "true color"
+	case termenv.ANSI256:
+		colors = "256 colors"
+	case termenv.ANSI:
+		colors = "16 colors"
+	default:
+		colors = "no colors"
+	}
+
+	images := imageProtocol(cfg.Output.Images)
+	if images == "file" {
+		return warnCheck("Terminal", "%s, images are written to files\nuse a terminal supporting the kitty, iTerm or sixel protocols, or set -images", colors)
+	}
+
+	return passCheck("Terminal", "%s, images shown inline with the %s protocol", colors, images)
+}
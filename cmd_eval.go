@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/openai/openai-go"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	passStyle lipgloss.Style
+	failStyle lipgloss.Style
+	warnStyle lipgloss.Style
+)
+
+type evalSuite struct {
+	Model      string     `yaml:"model"`
+	JudgeModel string     `yaml:"judge_model"`
+	Tasks      []evalTask `yaml:"tasks"`
+}
+
+type evalTask struct {
+	Name   string     `yaml:"name"`
+	Task   string     `yaml:"task"`
+	Expect evalExpect `yaml:"expect"`
+}
+
+// evalExpect describes a correct answer. Exactly one field should be set;
+// judge is a description of a correct answer for an LLM to grade against.
+type evalExpect struct {
+	Exact string `yaml:"exact"`
+	Regex string `yaml:"regex"`
+	Judge string `yaml:"judge"`
+}
+
+type evalResult struct {
+	Name     string      `json:"name"`
+	Model    string      `json:"model"`
+	Passed   bool        `json:"passed"`
+	Reason   string      `json:"reason,omitempty"`
+	Answer   string      `json:"answer"`
+	Seconds  float64     `json:"seconds"`
+	Turns    int         `json:"turns"`
+	Usage    agent.Usage `json:"usage"`
+	duration time.Duration
+}
+
+type evalReport struct {
+	Model    string       `json:"model"`
+	Time     time.Time    `json:"time"`
+	Passed   int          `json:"passed"`
+	Total    int          `json:"total"`
+	Accuracy float64      `json:"accuracy"`
+	Usage    agent.Usage  `json:"usage"`
+	Results  []evalResult `json:"results"`
+}
+
+var judgeSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"pass":   map[string]any{"type": "boolean"},
+		"reason": map[string]any{"type": "string"},
+	},
+	"required":             []any{"pass", "reason"},
+	"additionalProperties": false,
+}
+
+// evalCommand runs a suite of tasks with expected answers and reports
+// accuracy, token usage and latency. It fails if any task fails, so it can
+// gate CI.
+func evalCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	model := fs.String("model", "", "model to evaluate (defaults to the suite's model)")
+	parallel := fs.Int("parallel", 1, "how many tasks to run at once")
+	jsonPath := fs.String("json", "", "also write the report as JSON to this file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: eval [flags] suite.yaml")
+	}
+
+	suite, err := loadSuite(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *model != "" {
+		suite.Model = *model
+	}
+	if suite.Model == "" {
+		suite.Model = defaultModel
+	}
+	if suite.JudgeModel == "" {
+		suite.JudgeModel = suite.Model
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	results := make([]evalResult, len(suite.Tasks))
+	sem := make(chan struct{}, max(*parallel, 1))
+
+	var wg sync.WaitGroup
+
+	for i, task := range suite.Tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = runEvalTask(ctx, b, suite, task)
+			printEvalResult(results[i])
+		}()
+	}
+
+	wg.Wait()
+
+	report := newEvalReport(suite.Model, results)
+	printEvalReport(report, results)
+
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(*jsonPath, data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	if report.Passed < report.Total {
+		return fmt.Errorf("%d of %d tasks failed", report.Total-report.Passed, report.Total)
+	}
+
+	return nil
+}
+
+func loadSuite(path string) (*evalSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var suite evalSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("failed to parse suite %s: %v", path, err)
+	}
+
+	for i, task := range suite.Tasks {
+		if task.Name == "" {
+			suite.Tasks[i].Name = fmt.Sprintf("task %d", i+1)
+		}
+		if task.Task == "" {
+			return nil, fmt.Errorf("%s has no task", suite.Tasks[i].Name)
+		}
+		if task.Expect.Exact == "" && task.Expect.Regex == "" && task.Expect.Judge == "" {
+			return nil, fmt.Errorf("%s has no expected answer", suite.Tasks[i].Name)
+		}
+		if task.Expect.Regex != "" {
+			if _, err := regexp.Compile(task.Expect.Regex); err != nil {
+				return nil, fmt.Errorf("%s: %v", suite.Tasks[i].Name, err)
+			}
+		}
+	}
+
+	return &suite, nil
+}
+
+func runEvalTask(ctx context.Context, b *backend, suite *evalSuite, task evalTask) evalResult {
+	result := evalResult{Name: task.Name, Model: suite.Model}
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+	defer mcpClient.Close()
+
+	// Evals don't create sessions, so they don't clutter the resume list.
+	a.Model = suite.Model
+	a.Messages = systemMessages()
+	a.Prepare = func(params *openai.ChatCompletionNewParams) {
+		prepareRequest(b.config(), params)
+	}
+
+	start := time.Now()
+	run, err := a.Run(ctx, task.Task)
+	result.duration = time.Since(start)
+	result.Seconds = result.duration.Seconds()
+	result.Turns = len(a.Turns)
+	result.Usage = a.Usage
+
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+
+	result.Answer = run.Answer
+	result.Passed, result.Reason = checkAnswer(ctx, b, suite.JudgeModel, task, run.Answer)
+
+	return result
+}
+
+func checkAnswer(ctx context.Context, b *backend, judgeModel string, task evalTask, answer string) (bool, string) {
+	answer = strings.TrimSpace(answer)
+
+	switch {
+	case task.Expect.Exact != "":
+		if answer == strings.TrimSpace(task.Expect.Exact) {
+			return true, ""
+		}
+
+		return false, fmt.Sprintf("expected %q", task.Expect.Exact)
+	case task.Expect.Regex != "":
+		if regexp.MustCompile(task.Expect.Regex).MatchString(answer) {
+			return true, ""
+		}
+
+		return false, fmt.Sprintf("does not match %s", task.Expect.Regex)
+	default:
+		return judgeAnswer(ctx, b, judgeModel, task, answer)
+	}
+}
+
+// judgeAnswer asks a model whether answer meets the task's criteria. The
+// judge has no tools and must answer with a verdict matching judgeSchema.
+func judgeAnswer(ctx context.Context, b *backend, judgeModel string, task evalTask, answer string) (bool, string) {
+	judge := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(judgeModel),
+	)
+	judge.Schema = judgeSchema
+
+	prompt := fmt.Sprintf(
+		"You are grading an AI agent's answer to a task.\n\nTask:\n%s\n\nA correct answer: %s\n\nThe agent's answer:\n%s\n\nDecide whether the agent's answer is correct, and briefly say why.",
+		task.Task, task.Expect.Judge, answer,
+	)
+
+	result, err := judge.Run(ctx, prompt)
+	if err != nil {
+		return false, fmt.Sprintf("judge failed: %v", err)
+	}
+
+	var verdict struct {
+		Pass   bool   `json:"pass"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(result.Answer), &verdict); err != nil {
+		return false, fmt.Sprintf("judge gave an invalid verdict: %v", err)
+	}
+
+	return verdict.Pass, verdict.Reason
+}
+
+func newEvalReport(model string, results []evalResult) evalReport {
+	report := evalReport{
+		Model:   model,
+		Time:    time.Now(),
+		Total:   len(results),
+		Results: results,
+	}
+
+	for _, r := range results {
+		if r.Passed {
+			report.Passed++
+		}
+		report.Usage = report.Usage.Plus(r.Usage)
+	}
+
+	if report.Total > 0 {
+		report.Accuracy = float64(report.Passed) / float64(report.Total)
+	}
+
+	return report
+}
+
+func printEvalResult(r evalResult) {
+	status := passStyle.Render("PASS")
+	if !r.Passed {
+		status = failStyle.Render("FAIL")
+	}
+
+	line := fmt.Sprintf("%s  %s  (%s, %d turns, %s)", status, r.Name, r.duration.Round(100*time.Millisecond), r.Turns, r.Usage)
+	if r.Reason != "" {
+		line += "\n      " + r.Reason
+	}
+
+	print("%s", line)
+}
+
+func printEvalReport(report evalReport, results []evalResult) {
+	durations := make([]time.Duration, len(results))
+	for i, r := range results {
+		durations[i] = r.duration
+	}
+	slices.Sort(durations)
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+
+	print("")
+	print("Model:    %s", report.Model)
+	print("Accuracy: %d/%d (%.1f%%)", report.Passed, report.Total, report.Accuracy*100)
+	print("Usage:    %s", report.Usage)
+
+	if len(durations) > 0 {
+		print("Latency:  mean %s, p50 %s, max %s",
+			(total / time.Duration(len(durations))).Round(100*time.Millisecond),
+			durations[len(durations)/2].Round(100*time.Millisecond),
+			durations[len(durations)-1].Round(100*time.Millisecond),
+		)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/huh"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const maxPatchLength = 2000
+
+type triage struct {
+	Summary string   `json:"summary"`
+	Labels  []string `json:"labels"`
+}
+
+func githubCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 || args[0] != "triage" {
+		return fmt.Errorf("usage: github triage [flags] <number>")
+	}
+
+	return triageCommand(ctx, cfg, args[1:])
+}
+
+func triageCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("github triage", flag.ExitOnError)
+	repo := fs.String("repo", os.Getenv("GITHUB_REPOSITORY"), "repository as owner/name")
+	model := fs.String("model", defaultModel, "model to triage with")
+	repoContext := fs.Bool("repo-context", false, "let the model read files from the repository")
+	post := fs.Bool("post", false, "offer to post the summary as a comment and apply the labels")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *repo == "" {
+		return fmt.Errorf("usage: github triage -repo owner/name [flags] <number>")
+	}
+
+	number, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid issue number %q", fs.Arg(0))
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	gh := &githubClient{
+		httpClient: b.httpClient,
+		token:      os.Getenv("GITHUB_TOKEN"),
+		repo:       *repo,
+	}
+
+	prompt, labels, err := triagePrompt(ctx, gh, number)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s#%d: %v", *repo, number, err)
+	}
+
+	var tools []agent.LocalTool
+	if *repoContext {
+		tools = append(tools, gh.readFileTool())
+	}
+
+	a, mcpClient, err := b.newAgent(ctx, tools...)
+	if err != nil {
+		return err
+	}
+	defer mcpClient.Close()
+
+	a.Schema = triageSchema(labels)
+	a.SchemaRepairs = cfg.Output.SchemaRepairs
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	r := newREPL(cfg, newSession(workspace, *model), a)
+
+	result, err := r.runTask(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	var t triage
+	if err := json.Unmarshal([]byte(result.Answer), &t); err != nil {
+		return fmt.Errorf("failed to parse triage: %v", err)
+	}
+
+	if !*post {
+		return nil
+	}
+	if gh.token == "" {
+		return fmt.Errorf("GITHUB_TOKEN environment variable not set")
+	}
+
+	confirmed := false
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Post this summary to %s#%d?", *repo, number)).
+				Description(triageLabelsDescription(t.Labels)).
+				Value(&confirmed),
+		),
+	)
+	if err := form.RunWithContext(ctx); err != nil {
+		return err
+	}
+	if !confirmed {
+		return nil
+	}
+
+	if err := gh.comment(ctx, number, t.Summary); err != nil {
+		return fmt.Errorf("failed to post comment: %v", err)
+	}
+	if len(t.Labels) > 0 {
+		if err := gh.addLabels(ctx, number, t.Labels); err != nil {
+			return fmt.Errorf("failed to add labels: %v", err)
+		}
+	}
+
+	print("Posted to %s#%d", *repo, number)
+
+	return nil
+}
+
+func triageLabelsDescription(labels []string) string {
+	if len(labels) == 0 {
+		return "No labels will be added."
+	}
+
+	return "Labels to add: " + strings.Join(labels, ", ")
+}
+
+// triagePrompt fetches an issue or pull request and describes it for the
+// model. It also returns the labels defined in the repository, which the
+// model is asked to choose from.
+func triagePrompt(ctx context.Context, gh *githubClient, number int) (string, []githubLabel, error) {
+	issue, err := gh.issue(ctx, number)
+	if err != nil {
+		return "", nil, err
+	}
+
+	comments, err := gh.comments(ctx, number)
+	if err != nil {
+		return "", nil, err
+	}
+
+	labels, err := gh.labels(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kind := "issue"
+	if issue.PullRequest != nil {
+		kind = "pull request"
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Triage this GitHub %s from %s. Write a short summary for maintainers as a Markdown comment, and pick the labels that apply from the repository's labels. Don't use the Python sandbox unless it helps.\n\n", kind, gh.repo)
+	fmt.Fprintf(&sb, "# #%d %s\n\nAuthor: @%s\nState: %s\n", issue.Number, issue.Title, issue.User.Login, issue.State)
+
+	if len(issue.Labels) > 0 {
+		var names []string
+		for _, label := range issue.Labels {
+			names = append(names, label.Name)
+		}
+		fmt.Fprintf(&sb, "Current labels: %s\n", strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(&sb, "\n%s\n", issue.Body)
+
+	for _, comment := range comments {
+		fmt.Fprintf(&sb, "\n## Comment by @%s\n\n%s\n", comment.User.Login, comment.Body)
+	}
+
+	if issue.PullRequest != nil {
+		files, err := gh.pullFiles(ctx, number)
+		if err != nil {
+			return "", nil, err
+		}
+
+		sb.WriteString("\n## Changed files\n")
+
+		for _, file := range files {
+			patch := file.Patch
+			if len(patch) > maxPatchLength {
+				cut := maxPatchLength
+				for cut > 0 && !utf8.RuneStart(patch[cut]) {
+					cut--
+				}
+				patch = patch[:cut] + "\n[truncated]"
+			}
+
+			fmt.Fprintf(&sb, "\n### %s (%s)\n\n```diff\n%s\n```\n", file.Filename, file.Status, patch)
+		}
+	}
+
+	if len(labels) > 0 {
+		sb.WriteString("\n## Repository labels\n\n")
+
+		for _, label := range labels {
+			fmt.Fprintf(&sb, "- %s: %s\n", label.Name, label.Description)
+		}
+	}
+
+	return sb.String(), labels, nil
+}
+
+func triageSchema(labels []githubLabel) map[string]any {
+	labelSchema := map[string]any{"type": "string"}
+
+	if len(labels) > 0 {
+		var names []any
+		for _, label := range labels {
+			names = append(names, label.Name)
+		}
+		labelSchema["enum"] = names
+	}
+
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"summary": map[string]any{"type": "string"},
+			"labels": map[string]any{
+				"type":  "array",
+				"items": labelSchema,
+			},
+		},
+		"required":             []any{"summary", "labels"},
+		"additionalProperties": false,
+	}
+}
+
+// readFileTool lets the model read files and list directories in the
+// repository being triaged.
+func (c *githubClient) readFileTool() agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("read_repo_file",
+			mcp.WithDescription("Read a file or list a directory in the GitHub repository being triaged, at the default branch."),
+			mcp.WithString("path", mcp.Description("Path relative to the repository root. Empty for the root directory.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			path := strings.Trim(request.GetString("path", ""), "/")
+
+			raw, err := c.contents(ctx, path)
+			if err != nil {
+				return fmt.Sprintf("Failed to read %q: %v", path, err), nil
+			}
+
+			var entries []githubContent
+			if err := json.Unmarshal(raw, &entries); err == nil {
+				var sb strings.Builder
+				for _, entry := range entries {
+					fmt.Fprintf(&sb, "%s\t%s\n", entry.Type, entry.Path)
+				}
+
+				return sb.String(), nil
+			}
+
+			var file githubContent
+			if err := json.Unmarshal(raw, &file); err != nil {
+				return "", err
+			}
+			if file.Encoding != "base64" {
+				return fmt.Sprintf("%s is a %s and can't be read.", path, file.Type), nil
+			}
+
+			data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+			if err != nil {
+				return "", err
+			}
+
+			return string(data), nil
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// mcpServerCommand serves the agent itself as an MCP tool, so another agent
+// can delegate tasks to it. It speaks stdio by default, or streamable HTTP
+// with -http.
+func mcpServerCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("mcp-server", flag.ExitOnError)
+	addr := fs.String("http", "", "serve streamable HTTP on this address instead of stdio")
+	fs.Parse(args)
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	srv := server.NewMCPServer("mcp-experiment", binaryVersion(), server.WithRecovery())
+
+	srv.AddTool(
+		mcp.NewTool("run_agent",
+			mcp.WithDescription("Delegate a task to an agent that solves it by writing and running Python code in a sandbox. Returns the agent's final answer."),
+			mcp.WithString("task", mcp.Required(), mcp.Description("The task to solve, with all the context the agent needs.")),
+			mcp.WithString("model", mcp.Description("Model for the agent to use."), mcp.DefaultString(defaultModel)),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			task, err := request.RequireString("task")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			answer, err := delegateTask(ctx, b, srv, workspace, request, task, request.GetString("model", defaultModel))
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("The agent failed", err), nil
+			}
+
+			return mcp.NewToolResultText(answer), nil
+		},
+	)
+
+	if *addr != "" {
+		return server.NewStreamableHTTPServer(srv).Start(*addr)
+	}
+
+	return server.ServeStdio(srv)
+}
+
+func delegateTask(ctx context.Context, b *backend, srv *server.MCPServer, workspace string, request mcp.CallToolRequest, task, model string) (string, error) {
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer mcpClient.Close()
+
+	r := newREPL(b.config(), newSession(workspace, model), a)
+	r.render = func(event agent.Event) {}
+
+	// Report tool calls as progress if the caller asked for it.
+	if meta := request.Params.Meta; meta != nil && meta.ProgressToken != nil {
+		progress := 0
+
+		r.render = func(event agent.Event) {
+			started, ok := event.(agent.ToolCallStarted)
+			if !ok {
+				return
+			}
+
+			progress++
+			srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+				"progressToken": meta.ProgressToken,
+				"progress":      progress,
+				"message":       fmt.Sprintf("Calling %s", started.ToolCall.Function.Name),
+			})
+		}
+	}
+
+	result, err := r.runTask(ctx, task)
+	if err != nil {
+		return "", err
+	}
+
+	return result.Answer, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"gopkg.in/yaml.v3"
+)
+
+// playbook is a recurring workflow saved as YAML: a task template with the
+// model, system prompt, tools and output it needs. Vars are the task's
+// variables with their defaults; those without one must be given.
+type playbook struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Task        string            `yaml:"task"`
+	Vars        map[string]string `yaml:"vars"`
+	Model       string            `yaml:"model"`
+	System      string            `yaml:"system"`
+	Tools       []string          `yaml:"tools"`
+
+	// Output is text, plain, json or quiet, and JSONSchema a schema file,
+	// relative to the playbook, the answer must match.
+	Output     string `yaml:"output"`
+	JSONSchema string `yaml:"json_schema"`
+
+	path string
+}
+
+var playbookOutputs = []string{"text", "plain", "json", "quiet"}
+
+// playbookDirs are searched in order for playbooks: the current directory's
+// playbooks directory, then the app directory's.
+func playbookDirs() []string {
+	dirs := []string{"playbooks"}
+	if dir, err := appDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "playbooks"))
+	}
+
+	return dirs
+}
+
+func loadPlaybook(path string) (*playbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pb playbook
+	if err := yaml.Unmarshal(data, &pb); err != nil {
+		return nil, fmt.Errorf("failed to parse playbook %s: %v", path, err)
+	}
+
+	pb.path = path
+	if pb.Name == "" {
+		pb.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if pb.Task == "" {
+		return nil, fmt.Errorf("playbook %s has no task", path)
+	}
+	if pb.Output != "" && !slices.Contains(playbookOutputs, pb.Output) {
+		return nil, fmt.Errorf("playbook %s: unknown output %q, expected one of %s", path, pb.Output, strings.Join(playbookOutputs, ", "))
+	}
+
+	return &pb, nil
+}
+
+// findPlaybook loads the playbook with the given name, or at the given path.
+func findPlaybook(name string) (*playbook, error) {
+	if strings.ContainsRune(name, os.PathSeparator) || filepath.Ext(name) != "" {
+		return loadPlaybook(name)
+	}
+
+	for _, dir := range playbookDirs() {
+		for _, ext := range []string{".yaml", ".yml"} {
+			pb, err := loadPlaybook(filepath.Join(dir, name+ext))
+			if !errors.Is(err, fs.ErrNotExist) {
+				return pb, err
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no playbook named %q in %s", name, strings.Join(playbookDirs(), " or "))
+}
+
+// listPlaybooks returns the playbooks found, those earlier in playbookDirs
+// hiding those of the same name after.
+func listPlaybooks() []*playbook {
+	seen := make(map[string]bool)
+
+	var playbooks []*playbook
+
+	for _, dir := range playbookDirs() {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.y*ml"))
+		slices.Sort(paths)
+
+		for _, path := range paths {
+			pb, err := loadPlaybook(path)
+			if err != nil {
+				printWarning("%v", err)
+				continue
+			}
+			if seen[pb.Name] {
+				continue
+			}
+			seen[pb.Name] = true
+
+			playbooks = append(playbooks, pb)
+		}
+	}
+
+	return playbooks
+}
+
+// playCommand runs a playbook, with its variables given as name=value
+// arguments. Without arguments it lists the playbooks.
+func playCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	model := fs.String("model", "", "model to use instead of the playbook's")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		for _, pb := range listPlaybooks() {
+			print("%s  %s", sessionIDStyle.Render(pb.Name), pb.Description)
+		}
+		return nil
+	}
+
+	pb, err := findPlaybook(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	vars := maps.Clone(pb.Vars)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	maps.Copy(vars, cfg.Vars)
+
+	for _, arg := range fs.Args()[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected name=value, got %q", arg)
+		}
+		vars[name] = value
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		if vars[name] == "" {
+			return fmt.Errorf("playbook %s needs %s, given as %s=value", pb.Name, name, name)
+		}
+	}
+
+	task, err := expandTask(pb.Task, vars, true)
+	if err != nil {
+		return err
+	}
+
+	cfg.SystemPrompt = strings.TrimSpace(cfg.localizedSystemPrompt() + "\n\n" + pb.System)
+	cfg.SystemPrompts = nil
+	switch pb.Output {
+	case "plain":
+		cfg.Output.Plain = true
+	case "json":
+		cfg.Output.Format = "json"
+	case "quiet":
+		cfg.Output.Quiet = true
+	}
+	if pb.JSONSchema != "" {
+		cfg.Output.JSONSchema = filepath.Join(filepath.Dir(pb.path), pb.JSONSchema)
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		return err
+	}
+	defer mcpClient.Close()
+
+	var missing []string
+	for _, name := range pb.Tools {
+		if !slices.ContainsFunc(a.Tools(), func(tool openai.ChatCompletionToolParam) bool { return tool.Function.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("playbook %s needs tools that aren't available: %s", pb.Name, strings.Join(missing, ", "))
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	sessionModel := cmp.Or(*model, pb.Model, defaultModel)
+
+	if cfg.Output.Format == "json" || cfg.Output.Quiet {
+		return runScripted(ctx, cfg, b, a, newSession(workspace, sessionModel), task)
+	}
+
+	r := newREPL(cfg, newSession(workspace, sessionModel), a)
+	r.credits = b.credits
+	r.verify = b.verify
+	r.commitMessage = b.commitMessage
+	r.context.price = b.promptPrice
+	r.notify = notifier(cfg)
+	a.Approve = r.approve
+	a.OverrideGuardrail = r.overrideGuardrail
+
+	return r.run(ctx, task)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/huh"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// playgroundCommand lets the user pick tools from the MCP server, fill in
+// their arguments and see the results, without involving a model.
+func playgroundCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("playground", flag.ExitOnError)
+	fs.Parse(args)
+
+	client, tools, err := listTools(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var options []huh.Option[int]
+	for i, tool := range tools {
+		options = append(options, huh.NewOption(toolLabel(tool), i))
+	}
+	options = append(options, huh.NewOption("Quit", -1))
+
+	for {
+		chosen := 0
+
+		form := newForm(
+			huh.NewGroup(
+				huh.NewSelect[int]().
+					Title("Choose a tool to call").
+					Value(&chosen).
+					Height(12).
+					Options(options...),
+			),
+		)
+		if err := form.RunWithContext(ctx); errors.Is(err, huh.ErrUserAborted) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if chosen < 0 {
+			return nil
+		}
+
+		if err := playgroundCall(ctx, cfg, client, tools[chosen]); err != nil {
+			printWarning("%v", err)
+		}
+	}
+}
+
+// listTools connects to the MCP server and lists its tools. The caller closes
+// the client.
+func listTools(ctx context.Context, cfg *config) (*mcpclient.Client, []mcp.Tool, error) {
+	client, _, err := initializeMCP(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := client.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to list tools: %v", err)
+	}
+	if len(result.Tools) == 0 {
+		client.Close()
+		return nil, nil, fmt.Errorf("no tools available from MCP server")
+	}
+
+	return client, result.Tools, nil
+}
+
+// initializeMCP connects to the MCP server and performs the handshake,
+// returning what the server said about itself.
+func initializeMCP(ctx context.Context, cfg *config) (*mcpclient.Client, *mcp.InitializeResult, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create HTTP client: %v", err)
+	}
+
+	roots, err := mcpRoots(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve roots: %v", err)
+	}
+
+	client, err := connectMCP(ctx, httpClient, roots)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := agent.InitializeWith(ctx, client, cfg.MCPInitialize.options(), roots...)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to initialize MCP client: %v", err)
+	}
+
+	return client, result, nil
+}
+
+func toolLabel(tool mcp.Tool) string {
+	description, _, _ := strings.Cut(strings.TrimSpace(tool.Description), "\n")
+	if runes := []rune(description); len(runes) > 60 {
+		description = string(runes[:57]) + "..."
+	}
+	if description == "" {
+		return tool.Name
+	}
+
+	return tool.Name + "  " + statusStyle.UnsetMarginLeft().Render(description)
+}
+
+func playgroundCall(ctx context.Context, cfg *config, client *mcpclient.Client, tool mcp.Tool) error {
+	args, err := askToolArguments(ctx, tool)
+	if err != nil || args == nil {
+		return err
+	}
+
+	return callAndPrint(ctx, cfg, client, tool.Name, args)
+}
+
+// callAndPrint calls a tool and prints everything it returned.
+func callAndPrint(ctx context.Context, cfg *config, client *mcpclient.Client, name string, args map[string]any) error {
+	print("%s", toolNameStyle.Render(name))
+	printCodeBox(toolArguments(args, true), "json")
+
+	start := time.Now()
+
+	result, err := client.CallTool(ctx, mcp.CallToolRequest{
+		Request: mcp.Request{
+			Method: "tools/call",
+		},
+		Params: mcp.CallToolParams{
+			Name:      name,
+			Arguments: args,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to call tool: %v", err)
+	}
+
+	for _, content := range result.Content {
+		switch content := content.(type) {
+		case mcp.TextContent:
+			language := "plaintext"
+			if json.Valid([]byte(content.Text)) {
+				language = "json"
+			}
+			printCodeBox(content.Text, language)
+		case mcp.ImageContent:
+			data, err := base64.StdEncoding.DecodeString(content.Data)
+			if err != nil {
+				return fmt.Errorf("failed to decode image: %v", err)
+			}
+			printImage(agent.Image{MIMEType: content.MIMEType, Data: data}, cfg.Output.Images)
+		default:
+			printCodeBox(fmt.Sprintf("%v", content), "plaintext")
+		}
+	}
+
+	status := fmt.Sprintf("Finished in %s", time.Since(start).Round(time.Millisecond))
+	if result.IsError {
+		printWarning("The tool reported an error")
+	}
+	print("%s", statusStyle.Render(status))
+
+	return nil
+}
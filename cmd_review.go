@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var (
+	reviewFileStyle      lipgloss.Style
+	reviewLineStyle      lipgloss.Style
+	reviewSeverityStyles map[string]lipgloss.Style
+)
+
+type review struct {
+	Summary  string          `json:"summary"`
+	Comments []reviewComment `json:"comments"`
+}
+
+type reviewComment struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Body     string `json:"body"`
+}
+
+// reviewCommand reviews a diff file, a diff on stdin, or a GitHub pull
+// request, and prints the model's findings per file and line.
+func reviewCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	repo := fs.String("repo", os.Getenv("GITHUB_REPOSITORY"), "repository as owner/name, to review a pull request by number")
+	model := fs.String("model", defaultModel, "model to review with")
+	jsonOutput := fs.Bool("json", false, "print the review as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: review [flags] [diff file | - | pull request number]")
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var (
+		diff string
+		tool agent.LocalTool
+	)
+
+	if number, err := strconv.Atoi(fs.Arg(0)); err == nil {
+		if *repo == "" {
+			return fmt.Errorf("-repo is required to review a pull request")
+		}
+
+		gh := &githubClient{
+			httpClient: b.httpClient,
+			token:      os.Getenv("GITHUB_TOKEN"),
+			repo:       *repo,
+		}
+
+		diff, err = pullDiff(ctx, gh, number)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s#%d: %v", *repo, number, err)
+		}
+
+		tool = gh.readFileTool()
+	} else {
+		diff, err = readDiff(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		tool = localReadFileTool(workspace)
+	}
+
+	if strings.TrimSpace(diff) == "" {
+		return fmt.Errorf("nothing to review, the diff is empty")
+	}
+
+	a, mcpClient, err := b.newAgent(ctx, tool)
+	if err != nil {
+		return err
+	}
+	defer mcpClient.Close()
+
+	a.Schema = reviewSchema
+	a.SchemaRepairs = cfg.Output.SchemaRepairs
+
+	r := newREPL(cfg, newSession(workspace, *model), a)
+	r.render = logEvent
+
+	result, err := r.runTask(ctx, reviewPrompt(diff, tool.Tool.Name))
+	if err != nil {
+		return err
+	}
+
+	var rev review
+	if err := json.Unmarshal([]byte(result.Answer), &rev); err != nil {
+		return fmt.Errorf("failed to parse review: %v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(rev)
+	}
+
+	printReview(rev)
+
+	return nil
+}
+
+func readDiff(path string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if path == "" || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read diff: %v", err)
+	}
+
+	return string(data), nil
+}
+
+// pullDiff rebuilds a unified diff from the patches of a pull request's
+// changed files.
+func pullDiff(ctx context.Context, gh *githubClient, number int) (string, error) {
+	files, err := gh.pullFiles(ctx, number)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n%s\n", file.Filename, file.Filename, file.Patch)
+	}
+
+	return sb.String(), nil
+}
+
+func reviewPrompt(diff, readTool string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Review this diff as an experienced maintainer. Report bugs, risky changes and missing error handling as issues, better approaches as suggestions, and style problems as nits. Comment on the line in the new version of the file that each finding is about. Use %s to read surrounding code when the diff isn't enough. Don't use the Python sandbox unless it helps. If there is nothing to report, return no comments.\n\n", readTool)
+	fmt.Fprintf(&sb, "```diff\n%s\n```\n", diff)
+
+	return sb.String()
+}
+
+var reviewSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"summary": map[string]any{"type": "string"},
+		"comments": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"file":     map[string]any{"type": "string"},
+					"line":     map[string]any{"type": "integer"},
+					"severity": map[string]any{"type": "string", "enum": []any{"issue", "suggestion", "nit"}},
+					"body":     map[string]any{"type": "string"},
+				},
+				"required":             []any{"file", "line", "severity", "body"},
+				"additionalProperties": false,
+			},
+		},
+	},
+	"required":             []any{"summary", "comments"},
+	"additionalProperties": false,
+}
+
+func printReview(rev review) {
+	printResultBox(rev.Summary)
+
+	comments := slices.Clone(rev.Comments)
+	slices.SortStableFunc(comments, func(a, b reviewComment) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+
+		return a.Line - b.Line
+	})
+
+	for i, comment := range comments {
+		if i == 0 || comments[i-1].File != comment.File {
+			print("\n%s", reviewFileStyle.Render(comment.File))
+		}
+
+		severity := reviewSeverityStyles[comment.Severity].Render(comment.Severity)
+		print("  %s %s %s", reviewLineStyle.Render(fmt.Sprintf("L%d", comment.Line)), severity, comment.Body)
+	}
+}
+
+// localReadFileTool lets the model read files and list directories under
+// root. Paths, including symlinks, can't escape it.
+func localReadFileTool(root string) agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("read_repo_file",
+			mcp.WithDescription("Read a file or list a directory in the repository being reviewed."),
+			mcp.WithString("path", mcp.Description("Path relative to the repository root. Empty for the root directory.")),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			path := strings.Trim(request.GetString("path", ""), "/")
+			if path == "" {
+				path = "."
+			}
+
+			dir, err := os.OpenRoot(root)
+			if err != nil {
+				return "", err
+			}
+			defer dir.Close()
+
+			fsys := dir.FS()
+
+			entries, err := fs.ReadDir(fsys, path)
+			if err == nil {
+				var sb strings.Builder
+				for _, entry := range entries {
+					kind := "file"
+					if entry.IsDir() {
+						kind = "dir"
+					}
+					fmt.Fprintf(&sb, "%s\t%s\n", kind, entry.Name())
+				}
+
+				return sb.String(), nil
+			}
+
+			data, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				return fmt.Sprintf("Failed to read %q: %v", path, err), nil
+			}
+
+			return string(data), nil
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// scheduleCommand manages the schedules from the config: list shows when
+// each runs next and run runs some now. Otherwise it runs them like daemon.
+func scheduleCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return daemonCommand(ctx, cfg, args)
+	}
+
+	if err := validateSchedules(cfg); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for _, s := range cfg.Schedules {
+			next, _ := s.next(time.Now())
+			print("%s  %s  next %s", sessionIDStyle.Render(s.Name), scheduleWhen(s), next.Format(time.DateTime))
+		}
+
+		return nil
+	case "run":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: schedule run <name...>")
+		}
+
+		var schedules []scheduleConfig
+		for _, name := range args[1:] {
+			i := slices.IndexFunc(cfg.Schedules, func(s scheduleConfig) bool { return s.Name == name })
+			if i < 0 {
+				return fmt.Errorf("no schedule named %q", name)
+			}
+			schedules = append(schedules, cfg.Schedules[i])
+		}
+
+		b, err := newBackend(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		workspace, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, s := range schedules {
+			if err := runSchedule(ctx, b, workspace, s); err != nil {
+				printWarning("Schedule %s failed: %v", s.Name, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d schedules failed", failed, len(schedules))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown schedule command %q", args[0])
+	}
+}
+
+func scheduleWhen(s scheduleConfig) string {
+	switch {
+	case s.Cron != "":
+		return "cron " + s.Cron
+	case s.Every != "":
+		return "every " + s.Every
+	default:
+		return "daily at " + s.At
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+type taskRequest struct {
+	Task      string `json:"task"`
+	Model     string `json:"model,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+type taskServer struct {
+	backend   *backend
+	workspace string
+	bus       eventBus
+
+	// drain is closed when the server stops taking new tasks.
+	drain chan struct{}
+
+	limiters keyLimiters
+	spend    keySpend
+}
+
+func serveCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	redisURL := fs.String("redis", "", "Redis URL for sharing events and session locks between instances")
+	fs.Parse(args)
+
+	if err := cfg.Serve.validate(); err != nil {
+		return err
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	b.metrics = newMetrics()
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var bus eventBus = newMemoryBus()
+	if *redisURL != "" {
+		bus, err = newRedisBus(*redisURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	s := &taskServer{
+		backend:   b,
+		workspace: workspace,
+		bus:       bus,
+		drain:     make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tasks", s.handleTask)
+	mux.HandleFunc("GET /sessions/{id}/events", s.handleEvents)
+	mux.HandleFunc("GET /usage", s.handleUsage)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+
+	// Cancelling baseCtx interrupts running tasks. Their sessions are kept
+	// as of the last finished turn.
+	baseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	httpServer := &http.Server{
+		Addr:        *addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	signals, stop := notifySignals()
+	defer stop()
+
+	shutdown := make(chan struct{})
+
+	go func() {
+		for sig := range signals {
+			switch {
+			case sig == syscall.SIGHUP:
+				reloadConfig(b, nil)
+			case s.draining():
+				log.Printf("Stopping")
+				cancel()
+			default:
+				log.Printf("Draining, finishing running tasks before stopping; signal again to stop now")
+				close(s.drain)
+
+				go func() {
+					httpServer.Shutdown(context.Background())
+					close(shutdown)
+				}()
+			}
+		}
+	}()
+
+	log.Printf("Listening on %s", *addr)
+
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	<-shutdown
+
+	return nil
+}
+
+func (s *taskServer) draining() bool {
+	select {
+	case <-s.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleTask runs a task and streams the agent's events back as server-sent
+// events, ending with a done event carrying the answer.
+func (s *taskServer) handleTask(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req taskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Task == "" {
+		http.Error(w, "task is required", http.StatusBadRequest)
+		return
+	}
+	if s.draining() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sess, err := s.session(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errSessionNotFound) {
+			status = http.StatusNotFound
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if key != nil {
+		if req.SessionID != "" && !s.owns(w, key, sess) {
+			return
+		}
+		if !key.allowsModel(sess.Model) {
+			http.Error(w, fmt.Sprintf("model %s isn't allowed for this key", sess.Model), http.StatusForbidden)
+			return
+		}
+		if !s.limiters.allow(key) {
+			http.Error(w, "too many tasks, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		sess.User = key.Name
+	}
+
+	ok, err = s.bus.acquire(r.Context(), sess.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "session is busy", http.StatusConflict)
+		return
+	}
+	defer s.bus.release(context.WithoutCancel(r.Context()), sess.ID)
+
+	a, mcpClient, err := s.backend.newAgent(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer mcpClient.Close()
+
+	// The task stops once it spends what was reserved for it of the key's
+	// quota, which tasks running at the same time can't use.
+	if key != nil && key.MaxCost > 0 {
+		reserved, release, err := s.spend.reserve(key, a.Budget.MaxCost)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if reserved == 0 {
+			http.Error(w, fmt.Sprintf("spend quota of $%.2f used up", key.MaxCost), http.StatusPaymentRequired)
+			return
+		}
+		defer release()
+
+		a.Budget.MaxCost = reserved
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(name string, data any) {
+		event, err := newSSEEvent(name, data)
+		if err != nil {
+			return
+		}
+
+		writeSSE(w, event)
+		flusher.Flush()
+
+		if err := s.bus.publish(r.Context(), sess.ID, event); err != nil {
+			log.Printf("Failed to publish event: %v", err)
+		}
+	}
+
+	send("session", map[string]any{"session_id": sess.ID})
+
+	runner := newREPL(s.backend.config(), sess, a)
+	if s.backend.memory != nil {
+		runner.memorize = s.backend.memorize
+	}
+	runner.render = func(event agent.Event) {
+		if name, data, ok := eventData(event); ok {
+			send(name, data)
+		}
+	}
+
+	finished := s.backend.metrics.taskStarted()
+	result, err := runner.runTask(r.Context(), req.Task)
+	finished(err)
+	if err != nil {
+		return
+	}
+
+	send("done", map[string]any{
+		"session_id": sess.ID,
+		"answer":     result.Answer,
+		"usage":      sess.Usage,
+	})
+}
+
+// handleEvents streams the events of tasks running in a session, on any
+// instance sharing the event bus, until the client disconnects.
+func (s *taskServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sess, err := findSession(r.PathValue("id"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errSessionNotFound) {
+			status = http.StatusNotFound
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if key != nil && !s.owns(w, key, sess) {
+		return
+	}
+
+	events, err := s.bus.subscribe(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-s.drain:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			writeSSE(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleUsage reports what the key of the request has spent in its period,
+// and its quota.
+func (s *taskServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if key == nil {
+		http.Error(w, "the server has no API keys", http.StatusNotFound)
+		return
+	}
+
+	spent, since, err := key.spent(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"name":     key.Name,
+		"spent":    spent,
+		"max_cost": key.MaxCost,
+		"since":    since,
+	})
+}
+
+// handleMetrics serves the metrics, which include what every key spent, so
+// they need a key like everything else.
+func (s *taskServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	s.backend.metrics.ServeHTTP(w, r)
+}
+
+// authenticate returns the API key of a request, which is nil if the server
+// has none, or responds with 401 if it doesn't have one.
+func (s *taskServer) authenticate(w http.ResponseWriter, r *http.Request) (*apiKeyConfig, bool) {
+	key, ok := s.backend.config().Serve.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "a valid API key is required", http.StatusUnauthorized)
+	}
+
+	return key, ok
+}
+
+// owns reports whether a session was started with key, and responds with 403
+// if not.
+func (s *taskServer) owns(w http.ResponseWriter, key *apiKeyConfig, sess *session) bool {
+	if sess.User != key.Name {
+		http.Error(w, "session belongs to another key", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (s *taskServer) session(req taskRequest) (*session, error) {
+	if req.SessionID == "" {
+		model := req.Model
+		if model == "" {
+			model = defaultModel
+		}
+
+		return newSession(s.workspace, model), nil
+	}
+
+	sess, err := findSession(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Model != "" {
+		sess.Model = req.Model
+	}
+
+	return sess, nil
+}
+
+func writeSSE(w http.ResponseWriter, event sseEvent) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, event.Data)
+}
+
+// eventData converts an agent event into an SSE event name and JSON payload.
+// Events that only matter in-process, like conversation snapshots, are
+// skipped.
+func eventData(event agent.Event) (string, any, bool) {
+	switch event := event.(type) {
+	case agent.TurnStarted:
+		return "turn_started", map[string]any{"turn": event.Turn}, true
+	case agent.AssistantText:
+		return "assistant_text", map[string]any{
+			"text":       event.Text,
+			"structured": event.Structured,
+		}, true
+	case agent.Reasoning:
+		return "reasoning", map[string]any{"text": event.Text}, true
+	case agent.TextDelta:
+		return "text_delta", map[string]any{
+			"text": event.Text,
+		}, true
+	case agent.ToolCallDelta:
+		return "tool_call_delta", map[string]any{
+			"index":     event.Index,
+			"id":        event.ID,
+			"name":      event.Name,
+			"arguments": event.Arguments,
+		}, true
+	case agent.ToolCallStarted:
+		return "tool_call_started", map[string]any{
+			"id":        event.ToolCall.ID,
+			"name":      event.ToolCall.Function.Name,
+			"arguments": event.Arguments,
+		}, true
+	case agent.ToolCallFinished:
+		data := map[string]any{
+			"id":          event.ToolCall.ID,
+			"name":        event.ToolCall.Function.Name,
+			"result":      event.Result,
+			"duration_ms": event.Duration.Milliseconds(),
+		}
+		if len(event.Images) > 0 {
+			data["images"] = event.Images
+		}
+		if event.Err != nil {
+			data["error"] = event.Err.Error()
+		}
+		if event.Prefetched {
+			data["prefetched"] = true
+		}
+
+		return "tool_call_finished", data, true
+	case agent.UsageUpdated:
+		return "usage", map[string]any{
+			"turn":  event.Turn,
+			"total": event.Total,
+		}, true
+	case agent.ModelRouted:
+		return "model_routed", map[string]any{"model": event.Model, "reason": event.Reason}, true
+	case agent.GuardrailTripped:
+		return "guardrail", map[string]any{
+			"guardrail":  event.Guardrail,
+			"where":      event.Where,
+			"match":      event.Match,
+			"blocked":    event.Blocked,
+			"overridden": event.Overridden,
+		}, true
+	case agent.Warning:
+		return "warning", map[string]any{"text": event.Text}, true
+	case agent.ServerLog:
+		return "server_log", map[string]any{
+			"server": event.Server,
+			"level":  event.Level,
+			"logger": event.Logger,
+			"text":   event.Text,
+		}, true
+	case agent.Error:
+		return "error", map[string]any{"error": event.Err.Error()}, true
+	}
+
+	return "", nil, false
+}
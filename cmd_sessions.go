@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/tree"
+)
+
+var sessionIDStyle lipgloss.Style
+
+func sessionsCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: sessions list|view [id]|export <id> [file.ipynb|file.html]|extract-script <id> [file]|explain [-model model] <id>|graph [-format tree|dot|mermaid] <id>")
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		sessions, err := listSessions(workspace)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range sessions {
+			print("%s  %s  %s  (%s)", sessionIDStyle.Render(s.ID), s.Updated.Format("2006-01-02 15:04"), s.title(), s.Usage)
+		}
+
+		return nil
+	case "view":
+		var id string
+		if len(args) > 1 {
+			id = args[1]
+		}
+
+		return viewSessions(workspace, id)
+	case "export":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: sessions export <id> [file.ipynb|file.html]")
+		}
+
+		s, err := findSession(args[1])
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 3 && slices.Contains([]string{".html", ".htm"}, strings.ToLower(filepath.Ext(args[2]))) {
+			return writeSessionHTML(cfg, s, args[2])
+		}
+
+		data, err := exportNotebook(s, cfg.Output.CodeTools)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 2 {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+
+		return os.WriteFile(args[2], data, 0o644)
+	case "extract-script":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: sessions extract-script <id> [file]")
+		}
+
+		s, err := findSession(args[1])
+		if err != nil {
+			return err
+		}
+
+		script, err := extractScript(s, cfg.Output.CodeTools)
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 2 {
+			_, err = os.Stdout.WriteString(script)
+			return err
+		}
+
+		return os.WriteFile(args[2], []byte(script), 0o755)
+	case "explain":
+		fs := flag.NewFlagSet("sessions explain", flag.ExitOnError)
+		model := fs.String("model", "", "model to write the postmortem with (defaults to the session's)")
+		fs.Parse(args[1:])
+
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: sessions explain [-model model] <id>")
+		}
+
+		s, err := findSession(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *model == "" {
+			*model = s.Model
+		}
+
+		b, err := newBackend(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		explanation, err := explainSession(ctx, b, s, *model)
+		if err != nil {
+			return fmt.Errorf("failed to explain session: %v", err)
+		}
+
+		printResultBox(explanation)
+		return nil
+	case "graph":
+		fs := flag.NewFlagSet("sessions graph", flag.ExitOnError)
+		format := fs.String("format", "tree", "how to draw the graph: tree, dot or mermaid")
+		fs.Parse(args[1:])
+
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: sessions graph [-format tree|dot|mermaid] <id>")
+		}
+
+		s, err := findSession(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		nodes := sessionCallGraph(s)
+
+		switch *format {
+		case "tree":
+			fmt.Println(callTree(s, nodes))
+		case "dot":
+			fmt.Print(callGraphDOT(nodes))
+		case "mermaid":
+			fmt.Print(callGraphMermaid(nodes))
+		default:
+			return fmt.Errorf("unknown graph format %q, expected tree, dot or mermaid", *format)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown sessions command %q", args[0])
+	}
+}
+
+func viewSessions(workspace, id string) error {
+	sessions, err := listSessions("")
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*session)
+	children := make(map[string][]*session)
+
+	for _, s := range sessions {
+		byID[s.ID] = s
+		children[s.ParentID] = append(children[s.ParentID], s)
+	}
+
+	var roots []*session
+
+	if id != "" {
+		s, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("session %s not found", id)
+		}
+
+		for s.ParentID != "" && byID[s.ParentID] != nil {
+			s = byID[s.ParentID]
+		}
+
+		roots = append(roots, s)
+	} else {
+		for _, s := range sessions {
+			if s.Workspace == workspace && (s.ParentID == "" || byID[s.ParentID] == nil) {
+				roots = append(roots, s)
+			}
+		}
+	}
+
+	for _, root := range roots {
+		t, _ := sessionTree(root, children)
+		fmt.Println(t)
+	}
+
+	return nil
+}
+
+func sessionTree(s *session, children map[string][]*session) (*tree.Tree, agent.Usage) {
+	t := tree.New()
+	total := s.Usage
+
+	for _, child := range children[s.ID] {
+		childTree, childTotal := sessionTree(child, children)
+		t.Child(childTree)
+		total = total.Plus(childTotal)
+	}
+
+	label := fmt.Sprintf("%s  %s  %s\nbranch: %s", sessionIDStyle.Render(s.ID), s.Updated.Format("2006-01-02 15:04"), s.title(), s.Usage)
+	if len(children[s.ID]) > 0 {
+		label += fmt.Sprintf("\nwith branches: %s", total)
+	}
+
+	return t.Root(label), total
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/charmbracelet/huh"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolsCommand lists the MCP server's tools, calls one of them or shows what
+// the server negotiated. Arguments are given as JSON, or asked for with a
+// form generated from the tool's input schema when they're left out.
+func toolsCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: tools list|server|call <name> [JSON arguments | -]")
+	}
+
+	switch args[0] {
+	case "server":
+		client, result, err := initializeMCP(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		print("Server:        %s %s", result.ServerInfo.Name, result.ServerInfo.Version)
+		print("Protocol:      %s", result.ProtocolVersion)
+		if requested := cfg.MCPInitialize.ProtocolVersion; requested != "" && requested != result.ProtocolVersion {
+			printWarning("The server answered with protocol %s rather than %s", result.ProtocolVersion, requested)
+		}
+		print("Capabilities:  %s", describeCapabilities(result.Capabilities))
+		if result.Instructions != "" {
+			print("Instructions:  %s", result.Instructions)
+		}
+
+		return nil
+	case "list":
+		client, tools, err := listTools(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		for _, tool := range tools {
+			print("%s", toolLabel(tool))
+		}
+
+		return nil
+	case "call":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: tools call <name> [JSON arguments | -]")
+		}
+
+		client, tools, err := listTools(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		var tool *mcp.Tool
+		for i := range tools {
+			if tools[i].Name == args[1] {
+				tool = &tools[i]
+				break
+			}
+		}
+		if tool == nil {
+			return fmt.Errorf("no tool named %q", args[1])
+		}
+
+		var arguments map[string]any
+		if len(args) == 3 {
+			arguments, err = parseToolArguments(args[2])
+		} else {
+			arguments, err = askToolArguments(ctx, *tool)
+		}
+		if err != nil || arguments == nil {
+			return err
+		}
+
+		return callAndPrint(ctx, cfg, client, tool.Name, arguments)
+	default:
+		return fmt.Errorf("unknown tools command %q", args[0])
+	}
+}
+
+// parseToolArguments parses arguments given as JSON, or read from stdin
+// when they're "-".
+func parseToolArguments(arg string) (map[string]any, error) {
+	data := []byte(arg)
+	if arg == "-" {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, fmt.Errorf("failed to read arguments: %v", err)
+		}
+	}
+
+	arguments := make(map[string]any)
+	if err := json.Unmarshal(data, &arguments); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments: %v", err)
+	}
+
+	return arguments, nil
+}
+
+// askToolArguments runs the form for a tool's arguments. It returns nil if
+// the user aborts it.
+func askToolArguments(ctx context.Context, tool mcp.Tool) (map[string]any, error) {
+	form, collect, err := toolForm(tool)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := form.RunWithContext(ctx); errors.Is(err, huh.ErrUserAborted) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return collect()
+}
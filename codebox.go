@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// codeToolConfig describes a tool whose calls carry code worth showing before
+// the tool runs.
+type codeToolConfig struct {
+	// Argument is the tool argument holding the code.
+	Argument string `json:"argument"`
+	// Language is the chroma language to highlight the code as. When empty,
+	// it is taken from the tool's language argument or detected from the
+	// code.
+	Language string `json:"language,omitempty"`
+}
+
+var defaultCodeTools = map[string]codeToolConfig{
+	"sandbox_run_code": {Argument: "code", Language: "python"},
+}
+
+// toolCode returns the code of a tool call and the language to highlight it
+// as, if the tool is configured as a code tool.
+func toolCode(tools map[string]codeToolConfig, name string, args map[string]any) (string, string, bool) {
+	tool, ok := tools[name]
+	if !ok {
+		return "", "", false
+	}
+
+	code, ok := args[tool.Argument].(string)
+	if !ok {
+		return "", "", false
+	}
+
+	language := tool.Language
+	if language == "" {
+		language, _ = args["language"].(string)
+	}
+	if language == "" || lexers.Get(language) == nil {
+		language = detectLanguage(code)
+	}
+
+	return code, language, true
+}
+
+// partialToolCode is toolCode for arguments that are still being streamed,
+// with the code decoded as far as it has arrived.
+func partialToolCode(tools map[string]codeToolConfig, name, arguments string) (string, string, bool) {
+	tool, ok := tools[name]
+	if !ok {
+		return "", "", false
+	}
+
+	code, ok := partialJSONString(arguments, tool.Argument)
+	if !ok {
+		return "", "", false
+	}
+
+	return toolCode(tools, name, map[string]any{tool.Argument: code})
+}
+
+// partialJSONString finds the string value of key in an incomplete JSON
+// object and decodes it up to its end or the end of data.
+func partialJSONString(data, key string) (string, bool) {
+	i := strings.Index(data, strconv.Quote(key))
+	if i < 0 {
+		return "", false
+	}
+
+	rest := strings.TrimLeft(data[i+len(strconv.Quote(key)):], " \t\r\n")
+	rest, ok := strings.CutPrefix(rest, ":")
+	if !ok {
+		return "", false
+	}
+	rest, ok = strings.CutPrefix(strings.TrimLeft(rest, " \t\r\n"), `"`)
+	if !ok {
+		return "", false
+	}
+
+	// Stop at the closing quote, or before an escape that is cut off.
+	end := len(rest)
+scan:
+	for j := 0; j < len(rest); j++ {
+		switch rest[j] {
+		case '"':
+			end = j
+			break scan
+		case '\\':
+			n := 2
+			if j+1 < len(rest) && rest[j+1] == 'u' {
+				n = 6
+			}
+			if j+n > len(rest) {
+				end = j
+				break scan
+			}
+			j += n - 1
+		}
+	}
+
+	var s string
+	if err := json.Unmarshal([]byte(`"`+rest[:end]+`"`), &s); err != nil {
+		return "", false
+	}
+
+	return s, true
+}
+
+func detectLanguage(code string) string {
+	if lexer := lexers.Analyse(code); lexer != nil {
+		return lexer.Config().Name
+	}
+
+	return "plaintext"
+}
+
+const (
+	maxArgumentLines     = 20
+	maxArgumentLineWidth = 200
+)
+
+// toolArguments formats tool call arguments as indented JSON. Unless full is
+// set, long lines and payloads are truncated.
+func toolArguments(args map[string]any, full bool) string {
+	data, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", args)
+	}
+
+	if full {
+		return string(data)
+	}
+
+	return shortenLines(string(data))
+}
+
+// shortenLines cuts text down to maxArgumentLines lines of at most
+// maxArgumentLineWidth characters.
+func shortenLines(text string) string {
+	lines := strings.Split(text, "\n")
+
+	var hidden int
+	if len(lines) > maxArgumentLines {
+		hidden = len(lines) - maxArgumentLines
+		lines = lines[:maxArgumentLines]
+	}
+
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > maxArgumentLineWidth {
+			lines[i] = string(runes[:maxArgumentLineWidth]) + "…"
+		}
+	}
+
+	if hidden > 0 {
+		lines = append(lines, fmt.Sprintf("… %d more lines, use -verbosity 2 to show all", hidden))
+	}
+
+	return strings.Join(lines, "\n")
+}
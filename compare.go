@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	compareTitleStyle lipgloss.Style
+	compareStatsStyle lipgloss.Style
+)
+
+type comparison struct {
+	model     string
+	answer    string
+	err       error
+	duration  time.Duration
+	usage     agent.Usage
+	turns     int
+	toolCalls int
+}
+
+// runCompare runs task through each model in parallel, each in its own
+// session with its own MCP connection, and prints the answers side by side.
+func runCompare(ctx context.Context, cfg *config, task string, models []string) error {
+	if task == "" {
+		return fmt.Errorf("-compare needs -task")
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	print("Query: %s", task)
+
+	results := make([]comparison, len(models))
+
+	var wg sync.WaitGroup
+
+	for i, model := range models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = compareModel(ctx, b, workspace, task, strings.TrimSpace(model))
+		}()
+	}
+
+	wg.Wait()
+
+	printComparison(results)
+
+	return nil
+}
+
+func compareModel(ctx context.Context, b *backend, workspace, task, model string) comparison {
+	return compareSession(ctx, b, newSession(workspace, model), task)
+}
+
+// compareSession runs task in sess with an agent of its own, counting what
+// it took.
+func compareSession(ctx context.Context, b *backend, sess *session, task string) comparison {
+	c := comparison{model: sess.Model}
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer mcpClient.Close()
+
+	r := newREPL(b.config(), sess, a)
+	r.render = func(event agent.Event) {
+		if _, ok := event.(agent.ToolCallFinished); ok {
+			c.toolCalls++
+		}
+	}
+
+	start := time.Now()
+
+	result, err := r.runTask(ctx, task)
+
+	c.duration = time.Since(start)
+	c.usage = sess.Usage
+	c.turns = len(sess.Turns)
+	c.err = err
+	if result != nil {
+		c.answer = result.Answer
+	}
+
+	return c
+}
+
+func printComparison(results []comparison) {
+	width, _ := terminalSize()
+
+	// Each column gets an equal share of the terminal, less the box's
+	// border and margin.
+	columnWidth := max(width/len(results)-4, 20)
+
+	var columns []string
+
+	for _, c := range results {
+		body := c.answer
+		if c.err != nil {
+			body = warningStyle.UnsetMarginLeft().Render(c.err.Error())
+		}
+
+		stats := fmt.Sprintf("%s · %d turns · %d tool calls\n%s", c.duration.Round(100*time.Millisecond), c.turns, c.toolCalls, c.usage)
+
+		content := lipgloss.JoinVertical(lipgloss.Left,
+			compareTitleStyle.Render(c.model),
+			compareStatsStyle.Render(stats),
+			"",
+			body,
+		)
+
+		columns = append(columns, resultBoxStyle.Width(columnWidth).Render(content))
+	}
+
+	fmt.Println(lipgloss.JoinHorizontal(lipgloss.Top, columns...))
+}
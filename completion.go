@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// subcommands are the commands main runs, for completion.
+var subcommands = []string{
+	"audit", "auth", "completion", "costs", "daemon", "doctor", "eval", "github", "mcp-server",
+	"memory", "play", "playground", "review", "schedule", "serve", "sessions", "tools",
+}
+
+// completionCommand prints a completion script for a shell. The scripts
+// call completion models and completion profiles for the values of -model
+// and -profile flags, so they are completed from the current config.
+func completionCommand(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: completion bash|zsh|fish")
+	}
+
+	name := filepath.Base(os.Args[0])
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(name))
+	case "zsh":
+		fmt.Print(zshCompletion(name))
+	case "fish":
+		fmt.Print(fishCompletion(name))
+	case "models":
+		// Completion must be quick, so only cached models are offered,
+		// however old.
+		s, err := openStore(cfg.Storage)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		models, _ := s.cachedModels(cfg.baseURL(), math.MaxInt64)
+		for _, id := range modelIDs(models) {
+			fmt.Println(id)
+		}
+	case "profiles":
+		for _, profile := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+			fmt.Println(profile)
+		}
+	default:
+		return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", args[0])
+	}
+
+	return nil
+}
+
+// completionFlag is a flag of the command line, and which values it takes.
+type completionFlag struct {
+	name  string
+	usage string
+	value string // "", "model", "profile" or "any"
+}
+
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+
+	newFlagSet(&config{}, &options{}, flag.ContinueOnError).VisitAll(func(f *flag.Flag) {
+		cf := completionFlag{name: f.Name, usage: f.Usage, value: "any"}
+
+		switch {
+		case isBoolFlag(f):
+			cf.value = ""
+		case f.Name == "profile":
+			cf.value = "profile"
+		case f.Name == "model" || strings.HasSuffix(f.Name, "-model"):
+			cf.value = "model"
+		}
+
+		flags = append(flags, cf)
+	})
+
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// flagNames returns the flags taking value, with a dash, separated by sep.
+func flagNames(flags []completionFlag, value, sep string) string {
+	var names []string
+	for _, f := range flags {
+		if f.value == value {
+			names = append(names, "-"+f.name)
+		}
+	}
+
+	return strings.Join(names, sep)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func bashCompletion(name string) string {
+	flags := completionFlags()
+	fn := "_" + nonIdentifier.ReplaceAllString(name, "_")
+
+	var allFlags []string
+	for _, f := range flags {
+		allFlags = append(allFlags, "-"+f.name)
+	}
+
+	return fmt.Sprintf(`%[1]s() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+
+	case $prev in
+	%[3]s)
+		COMPREPLY=($(compgen -W "$(%[2]s completion models 2>/dev/null)" -- "$cur"))
+		return
+		;;
+	%[4]s)
+		COMPREPLY=($(compgen -W "$(%[2]s completion profiles 2>/dev/null)" -- "$cur"))
+		return
+		;;
+	esac
+
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%[5]s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "%[6]s" -- "$cur"))
+	fi
+}
+
+complete -o default -F %[1]s %[2]s
+`, fn, name, flagNames(flags, "model", "|"), flagNames(flags, "profile", "|"), strings.Join(allFlags, " "), strings.Join(subcommands, " "))
+}
+
+func zshCompletion(name string) string {
+	flags := completionFlags()
+	fn := "_" + nonIdentifier.ReplaceAllString(name, "_")
+
+	var allFlags []string
+	for _, f := range flags {
+		allFlags = append(allFlags, "-"+f.name)
+	}
+
+	return fmt.Sprintf(`#compdef %[2]s
+
+%[1]s() {
+	case ${words[CURRENT-1]} in
+	%[3]s)
+		compadd -- ${(f)"$(%[2]s completion models 2>/dev/null)"}
+		return
+		;;
+	%[4]s)
+		compadd -- ${(f)"$(%[2]s completion profiles 2>/dev/null)"}
+		return
+		;;
+	esac
+
+	if [[ ${words[CURRENT]} == -* ]]; then
+		compadd -- %[5]s
+	else
+		compadd -- %[6]s
+		_files
+	fi
+}
+
+compdef %[1]s %[2]s
+`, fn, name, flagNames(flags, "model", "|"), flagNames(flags, "profile", "|"), strings.Join(allFlags, " "), strings.Join(subcommands, " "))
+}
+
+func fishCompletion(name string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "complete -c %s -n __fish_use_subcommand -f -a '%s'\n", name, strings.Join(subcommands, " "))
+
+	for _, f := range completionFlags() {
+		line := fmt.Sprintf("complete -c %s -o %s -d %s", name, f.name, fishQuote(f.usage))
+
+		switch f.value {
+		case "model":
+			line += fmt.Sprintf(" -x -a '(%s completion models 2>/dev/null)'", name)
+		case "profile":
+			line += fmt.Sprintf(" -x -a '(%s completion profiles 2>/dev/null)'", name)
+		case "any":
+			line += " -r"
+		}
+
+		sb.WriteString(line + "\n")
+	}
+
+	return sb.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// compositeToolConfig defines a tool the model calls with Parameters, JSON
+// schemas of its parameters, which is made as a call of Tool. String values
+// of Arguments are text/templates of the parameters, so fixed code can be
+// wrapped around them; other values are passed as they are.
+type compositeToolConfig struct {
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Required    []string       `json:"required,omitempty"`
+	Tool        string         `json:"tool"`
+	Arguments   map[string]any `json:"arguments"`
+}
+
+var compositeFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func compositeTools(configs map[string]compositeToolConfig) ([]agent.CompositeTool, error) {
+	var tools []agent.CompositeTool
+
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		tool, err := compositeTool(name, configs[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid composite tool %s: %v", name, err)
+		}
+		tools = append(tools, tool)
+	}
+
+	return tools, nil
+}
+
+func compositeTool(name string, cfg compositeToolConfig) (agent.CompositeTool, error) {
+	if cfg.Tool == "" {
+		return agent.CompositeTool{}, fmt.Errorf("no tool to call")
+	}
+	for _, required := range cfg.Required {
+		if _, ok := cfg.Parameters[required]; !ok {
+			return agent.CompositeTool{}, fmt.Errorf("required parameter %s isn't one of its parameters", required)
+		}
+	}
+
+	schema := map[string]any{"type": "object"}
+	if len(cfg.Parameters) > 0 {
+		schema["properties"] = cfg.Parameters
+	}
+	if len(cfg.Required) > 0 {
+		schema["required"] = cfg.Required
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return agent.CompositeTool{}, err
+	}
+
+	templates := make(map[string]*template.Template)
+	for argument, value := range cfg.Arguments {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		tmpl, err := template.New(argument).Option("missingkey=error").Funcs(compositeFuncs).Parse(text)
+		if err != nil {
+			return agent.CompositeTool{}, fmt.Errorf("failed to parse argument %s: %v", argument, err)
+		}
+		templates[argument] = tmpl
+	}
+
+	expand := func(args map[string]any) (map[string]any, error) {
+		// Parameters left out are empty rather than missing, so templates
+		// can test for them.
+		data := make(map[string]any)
+		for parameter := range cfg.Parameters {
+			data[parameter] = ""
+		}
+		maps.Copy(data, args)
+
+		expanded := maps.Clone(cfg.Arguments)
+		for argument, tmpl := range templates {
+			var sb strings.Builder
+			if err := tmpl.Execute(&sb, data); err != nil {
+				return nil, err
+			}
+			expanded[argument] = sb.String()
+		}
+
+		return expanded, nil
+	}
+
+	return agent.CompositeTool{
+		Tool:   mcp.NewToolWithRawSchema(name, cfg.Description, data),
+		Target: cfg.Tool,
+		Expand: expand,
+	}, nil
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+)
+
+type config struct {
+	Sampling      samplingConfig      `json:"sampling"`
+	ContentFilter contentFilterConfig `json:"content_filter"`
+	Output        outputConfig        `json:"output"`
+	Compaction    compactionConfig    `json:"compaction"`
+	Summarizer    summarizerConfig    `json:"summarizer"`
+	Prefetch      prefetchConfig      `json:"prefetch"`
+	Delegation    delegationConfig    `json:"delegation"`
+	Debug         debugConfig         `json:"debug"`
+	Network       networkConfig       `json:"network"`
+	Notify        notifyConfig        `json:"notify"`
+	Hooks         hooksConfig         `json:"hooks"`
+	Serve         serveConfig         `json:"serve"`
+	Environment   environmentConfig   `json:"environment"`
+	Storage       storageConfig       `json:"storage"`
+	Workdir       workdirConfig       `json:"workdir"`
+	Theme         themeConfig         `json:"theme"`
+	Attachments   attachmentConfig    `json:"attachments"`
+	Builtins      builtinsConfig      `json:"builtin_tools"`
+	Budget        budgetConfig        `json:"budget"`
+	Escalation    escalationConfig    `json:"escalation"`
+	ModelRouting  modelRoutingConfig  `json:"model_routing"`
+	Loops         loopConfig          `json:"loops"`
+	Batching      batchingConfig      `json:"batching"`
+	Knowledge     knowledgeConfig     `json:"knowledge"`
+	Embeddings    embeddingsConfig    `json:"embeddings"`
+	ToolSelection toolSelectionConfig `json:"tool_selection"`
+	Reasoning     reasoningConfig     `json:"reasoning"`
+	Routing       routingConfig       `json:"provider_routing"`
+	Memory        memoryConfig        `json:"memory"`
+	Verify        verifyConfig        `json:"verify"`
+
+	// Profile picks the credential profile, from Profiles, whose API and
+	// keychain entry are used.
+	Profile  string                   `json:"profile,omitempty"`
+	Profiles map[string]profileConfig `json:"profiles,omitempty"`
+
+	// SystemPrompt adds to the built-in system prompt, without being stored
+	// in sessions.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+
+	// Locale picks the language of the interface, and SystemPrompts are
+	// variants of SystemPrompt for locales, such as de or pt-BR.
+	Locale        string            `json:"locale,omitempty"`
+	SystemPrompts map[string]string `json:"system_prompts,omitempty"`
+
+	// PromptProfile picks the built-in system prompts new sessions start
+	// with from PromptProfiles, in place of the defaults.
+	PromptProfile  string              `json:"prompt_profile,omitempty"`
+	PromptProfiles map[string][]string `json:"prompt_profiles,omitempty"`
+
+	// Tasks are saved tasks, run by name with -preset, and Vars the values
+	// of their template variables, which -var adds to.
+	Tasks map[string]string `json:"tasks,omitempty"`
+	Vars  map[string]string `json:"vars,omitempty"`
+
+	MaxContinuations int  `json:"max_continuations"`
+	ArgumentRepairs  int  `json:"argument_repairs"`
+	Stream           bool `json:"stream,omitempty"`
+	StreamToolCalls  bool `json:"stream_tool_calls,omitempty"`
+
+	// FallbackModels are tried in order when a completion with the session's
+	// model fails.
+	FallbackModels []string `json:"fallback_models,omitempty"`
+
+	// ToolConcurrency is the most tool calls from one response running at
+	// once on a server.
+	ToolConcurrency int `json:"tool_concurrency"`
+
+	// ToolCacheTTL is how long the tools listed by the MCP server are reused
+	// for new sessions, as a duration. 0 lists them for every session.
+	ToolCacheTTL string `json:"tool_cache_ttl"`
+
+	// ModelCacheTTL is how long the models the provider lists are reused,
+	// as a duration, when sessions are stored in a database. 0 lists them
+	// at every start.
+	ModelCacheTTL string `json:"model_cache_ttl"`
+
+	// PreflightProbe checks that a model supports tool calls with a small
+	// completion before its first run, when the provider doesn't say.
+	PreflightProbe bool `json:"preflight_probe,omitempty"`
+
+	// MCPLogLevel is the least severe level of the MCP server's logs shown,
+	// or off.
+	MCPLogLevel string `json:"mcp_log_level,omitempty"`
+
+	// MCPInitialize is what the client tells the MCP server about itself
+	// when it connects.
+	MCPInitialize mcpInitializeConfig `json:"mcp_initialize"`
+
+	// Roots are the directories the MCP server is told it may work in. The
+	// working directory is used if there are none.
+	Roots []string `json:"roots,omitempty"`
+
+	// RateLimits limit how many completions and tool calls are made a minute.
+	RateLimits rateLimitConfig `json:"rate_limits,omitempty"`
+
+	// ToolOutputTokens is the most of a tool result sent to the model; the
+	// rest can be read with read_more.
+	ToolOutputTokens int `json:"tool_output_tokens"`
+
+	// ToolDescriptions replace or add to the descriptions of tools and their
+	// parameters, by tool name.
+	ToolDescriptions map[string]toolDescriptionConfig `json:"tool_descriptions,omitempty"`
+
+	// Passthrough has the provider call the MCP server, rather than the
+	// agent.
+	Passthrough passthroughConfig `json:"passthrough"`
+
+	// CompositeTools are tools made of calls of other tools, by name.
+	CompositeTools map[string]compositeToolConfig `json:"composite_tools,omitempty"`
+
+	// Subscriptions are resources of the servers whose changes interactive
+	// sessions follow.
+	Subscriptions []subscriptionConfig `json:"subscriptions,omitempty"`
+
+	// HistorySize is how many entered tasks and commands are remembered.
+	HistorySize int `json:"history_size"`
+
+	// Approve asks before every tool call, not only those tripping a
+	// tripwire.
+	Approve    bool             `json:"approve,omitempty"`
+	DryRun     bool             `json:"dry_run,omitempty"`
+	Tripwires  []tripwireConfig `json:"tripwires,omitempty"`
+	Guardrails guardrailsConfig `json:"guardrails"`
+	Sync       syncConfig       `json:"sync"`
+	Artifacts  artifactsConfig  `json:"artifacts"`
+	Git        gitConfig        `json:"git"`
+	Queue      queueConfig      `json:"queue"`
+	Redaction  redactionConfig  `json:"redaction"`
+	Encryption encryptionConfig `json:"encryption"`
+	Audit      auditConfig      `json:"audit"`
+
+	// Policy allows, asks about or denies tool calls, after the rules of
+	// PolicyFile, a YAML list of rules.
+	Policy     []policyRule `json:"policy,omitempty"`
+	PolicyFile string       `json:"policy_file,omitempty"`
+
+	// PolicyScript is a Starlark script inspecting, changing and rejecting
+	// tool calls and their results.
+	PolicyScript string `json:"policy_script,omitempty"`
+
+	Schedules []scheduleConfig `json:"schedules,omitempty"`
+}
+
+// debugConfig turns on logging of HTTP traffic. Bodies hold prompts, tool
+// output and sometimes keys, so only their sizes are logged unless Bodies is
+// set.
+type debugConfig struct {
+	Enabled bool   `json:"enabled"`
+	File    string `json:"file,omitempty"`
+	Bodies  bool   `json:"bodies,omitempty"`
+}
+
+type summarizerConfig struct {
+	Model     string `json:"model,omitempty"`
+	Threshold int    `json:"threshold"`
+}
+
+// prefetchConfig enables speculative calls to read-only tools. Tools lists
+// tools to treat as read-only besides those annotated so by their server.
+// Turns requests the next turn while such calls wait for approval.
+type prefetchConfig struct {
+	Model string   `json:"model,omitempty"`
+	Tools []string `json:"tools,omitempty"`
+	Turns bool     `json:"turns,omitempty"`
+}
+
+// delegationConfig offers the model the delegate_task tool, to run subtasks
+// in sub-agents with their own context, with the session's model or one of
+// Models.
+type delegationConfig struct {
+	Enabled bool     `json:"enabled,omitempty"`
+	Models  []string `json:"models,omitempty"`
+}
+
+// rateLimitConfig limits completions, calls to all tools together, and calls
+// to each tool in Tools, per minute. Zero is unlimited.
+type rateLimitConfig struct {
+	Completions int            `json:"completions,omitempty"`
+	ToolCalls   int            `json:"tool_calls,omitempty"`
+	Tools       map[string]int `json:"tools,omitempty"`
+}
+
+// limits returns the limiters of the config. They let one request through at
+// a time, spaced evenly, as bursts are what trip providers' own limits.
+func (c rateLimitConfig) limits() agent.RateLimits {
+	var limits agent.RateLimits
+	if c.Completions > 0 {
+		limits.Completions = agent.NewRateLimiter(c.Completions, 1)
+	}
+	if c.ToolCalls > 0 {
+		limits.ToolCalls = agent.NewRateLimiter(c.ToolCalls, 1)
+	}
+	for name, perMinute := range c.Tools {
+		if perMinute <= 0 {
+			continue
+		}
+		if limits.Tools == nil {
+			limits.Tools = make(map[string]*agent.RateLimiter)
+		}
+		limits.Tools[name] = agent.NewRateLimiter(perMinute, 1)
+	}
+
+	return limits
+}
+
+// setToolRate sets the rate limit of all tools, or of one tool if given as
+// name=limit.
+func (c *rateLimitConfig) setToolRate(value string) error {
+	name, limit, ok := strings.Cut(value, "=")
+	if !ok {
+		name, limit = "", value
+	}
+
+	perMinute, err := strconv.Atoi(limit)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit %q", value)
+	}
+
+	if name == "" {
+		c.ToolCalls = perMinute
+		return nil
+	}
+
+	if c.Tools == nil {
+		c.Tools = make(map[string]int)
+	}
+	c.Tools[name] = perMinute
+
+	return nil
+}
+
+// budgetConfig limits what a single task may spend. Zero is unlimited.
+// Deadline is how long a task may run, as a duration.
+type budgetConfig struct {
+	MaxCost   float64 `json:"max_cost,omitempty"`
+	MaxTokens int64   `json:"max_tokens,omitempty"`
+	MaxTurns  int     `json:"max_turns,omitempty"`
+	Deadline  string  `json:"deadline,omitempty"`
+}
+
+func (c budgetConfig) deadline() (time.Duration, error) {
+	if c.Deadline == "" {
+		return 0, nil
+	}
+
+	deadline, err := time.ParseDuration(c.Deadline)
+	if err != nil {
+		return 0, fmt.Errorf("invalid deadline: %v", err)
+	}
+
+	return deadline, nil
+}
+
+// escalationConfig switches a task to Model once Failures tool calls in a row
+// have failed, or the same call was made Repeats times in a row.
+type escalationConfig struct {
+	Model    string `json:"model,omitempty"`
+	Failures int    `json:"failures"`
+	Repeats  int    `json:"repeats"`
+}
+
+// modelRoutingConfig picks the model of each turn: the first of Rules that
+// matches, and Final for answers.
+type modelRoutingConfig struct {
+	Rules []modelRouteConfig `json:"rules,omitempty"`
+	Final string             `json:"final,omitempty"`
+}
+
+// modelRouteConfig matches turns after a task or tool results, and by the
+// estimated tokens of their request.
+type modelRouteConfig struct {
+	Model     string `json:"model"`
+	After     string `json:"after,omitempty"`
+	MinTokens int64  `json:"min_tokens,omitempty"`
+	MaxTokens int64  `json:"max_tokens,omitempty"`
+}
+
+func (m modelRoutingConfig) routing() agent.Routing {
+	routing := agent.Routing{Final: m.Final}
+	for _, rule := range m.Rules {
+		routing.Rules = append(routing.Rules, agent.RoutingRule{
+			Model:     rule.Model,
+			After:     rule.After,
+			MinTokens: rule.MinTokens,
+			MaxTokens: rule.MaxTokens,
+		})
+	}
+
+	return routing
+}
+
+// batchingConfig reminds the model to batch tool calls once it made one
+// per response Turns times in a row, at most Max times a task, with Prompt
+// instead of the built-in reminder if set. Zero is off.
+type batchingConfig struct {
+	Turns  int    `json:"turns"`
+	Max    int    `json:"max"`
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// loopConfig nudges a task that keeps making the same calls or giving empty
+// responses, and stops it if it carries on. Zero thresholds are off.
+type loopConfig struct {
+	Repeats int `json:"repeats"`
+	Cycles  int `json:"cycles"`
+	Empty   int `json:"empty"`
+	Nudges  int `json:"nudges"`
+}
+
+type compactionConfig struct {
+	Threshold  float64  `json:"threshold"`
+	KeepRecent int      `json:"keep_recent"`
+	Model      string   `json:"model,omitempty"`
+	PinTools   []string `json:"pin_tools,omitempty"`
+}
+
+type outputConfig struct {
+	Format        string `json:"format,omitempty"`
+	Template      string `json:"template,omitempty"`
+	Plain         bool   `json:"plain,omitempty"`
+	Quiet         bool   `json:"quiet,omitempty"`
+	NoTUI         bool   `json:"no_tui,omitempty"`
+	NoPager       bool   `json:"no_pager,omitempty"`
+	Verbosity     int    `json:"verbosity,omitempty"`
+	Images        string `json:"images,omitempty"`
+	JSONSchema    string `json:"json_schema,omitempty"`
+	SchemaRepairs int    `json:"schema_repairs,omitempty"`
+	Provenance    bool   `json:"provenance,omitempty"`
+	Copy          bool   `json:"copy,omitempty"`
+	Timings       bool   `json:"timings,omitempty"`
+	EventsFD      int    `json:"events_fd,omitempty"`
+
+	// CodeTools maps tool names to the argument holding code to show before
+	// the tool runs.
+	CodeTools map[string]codeToolConfig `json:"code_tools,omitempty"`
+
+	// Renderers map tool names and the MIME types of results to the
+	// renderer their results are shown with.
+	Renderers map[string]string `json:"renderers,omitempty"`
+}
+
+type toolDescriptionConfig struct {
+	Description      string            `json:"description,omitempty"`
+	Append           string            `json:"append,omitempty"`
+	Parameters       map[string]string `json:"parameters,omitempty"`
+	AppendParameters map[string]string `json:"append_parameters,omitempty"`
+}
+
+type contentFilterConfig struct {
+	RetryPrompt string `json:"retry_prompt,omitempty"`
+	Model       string `json:"model,omitempty"`
+	MaxRetries  int    `json:"max_retries,omitempty"`
+}
+
+type samplingConfig struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int64   `json:"max_tokens,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+}
+
+func appDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "mcp-experiment"), nil
+}
+
+func loadConfig() (*config, error) {
+	cfg := &config{
+		ContentFilter: contentFilterConfig{
+			MaxRetries: 1,
+		},
+		Output: outputConfig{
+			SchemaRepairs: 2,
+			CodeTools:     maps.Clone(defaultCodeTools),
+			Renderers:     maps.Clone(defaultRenderers),
+		},
+		Compaction: compactionConfig{
+			Threshold:  0.8,
+			KeepRecent: 6,
+		},
+		Summarizer: summarizerConfig{
+			Threshold: 4000,
+		},
+		Workdir: workdirConfig{
+			Retention: "168h",
+		},
+		Escalation: escalationConfig{
+			Failures: 3,
+			Repeats:  3,
+		},
+		Loops: loopConfig{
+			Repeats: 3,
+			Cycles:  3,
+			Empty:   1,
+			Nudges:  1,
+		},
+		Batching: batchingConfig{
+			Turns: 3,
+			Max:   2,
+		},
+		MaxContinuations: 3,
+		ArgumentRepairs:  2,
+		ToolConcurrency:  1,
+		ToolCacheTTL:     "5m",
+		ModelCacheTTL:    "1h",
+		ToolOutputTokens: 10_000,
+		HistorySize:      1000,
+		MCPLogLevel:      "warning",
+		Attachments: attachmentConfig{
+			MaxBytes: 100_000,
+		},
+		Queue: queueConfig{
+			Max: 10,
+		},
+		Artifacts: artifactsConfig{
+			Enabled: true,
+		},
+	}
+
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return cfg, nil
+}
+
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	for _, name := range samplingParams {
+		fs.Func(strings.ReplaceAll(name, "_", "-"), "sampling parameter "+name, func(value string) error {
+			return c.Sampling.set(name, value)
+		})
+	}
+
+	fs.StringVar(&c.Profile, "profile", c.Profile, "credential profile whose API and keychain entry to use (default \"default\")")
+
+	fs.StringVar(&c.ContentFilter.RetryPrompt, "content-filter-retry-prompt", c.ContentFilter.RetryPrompt, "message sent to the model when a response is blocked by a content filter")
+	fs.StringVar(&c.ContentFilter.Model, "content-filter-model", c.ContentFilter.Model, "model to retry with when a response is blocked by a content filter")
+	fs.IntVar(&c.ContentFilter.MaxRetries, "content-filter-retries", c.ContentFilter.MaxRetries, "maximum retries after a content filter block")
+
+	fs.BoolVar(&c.Builtins.ReadFile, "read-file-tool", c.Builtins.ReadFile, "offer the built-in read_file tool, reading the session's working directory")
+	fs.BoolVar(&c.Builtins.WriteFile, "write-file-tool", c.Builtins.WriteFile, "offer the built-in write_file tool, writing to the session's working directory")
+	fs.BoolVar(&c.Builtins.HTTPFetch, "http-fetch-tool", c.Builtins.HTTPFetch, "offer the built-in http_fetch tool, fetching URLs from this machine")
+	fs.BoolVar(&c.Builtins.ShellExec, "shell-exec-tool", c.Builtins.ShellExec, "offer the built-in shell_exec tool, running commands on this machine after asking")
+	fs.StringVar(&c.Builtins.WebSearch.Provider, "web-search", c.Builtins.WebSearch.Provider, "offer the built-in web_search tool, searching with searxng, brave or tavily")
+	fs.StringVar(&c.Builtins.WebSearch.URL, "web-search-url", c.Builtins.WebSearch.URL, "URL of the SearXNG instance, or of the search API")
+	fs.StringVar(&c.Sync.Dir, "sync", c.Sync.Dir, "upload this directory's files to the sandbox when the agent starts")
+	fs.BoolVar(&c.Sync.Pull, "sync-pull", c.Sync.Pull, "copy the files changed in the sandbox back into the -sync directory when the agent exits")
+	fs.BoolVar(&c.Artifacts.Enabled, "artifacts", c.Artifacts.Enabled, "collect files and data produced by tools into the session's artifacts directory")
+	fs.StringVar(&c.Git.Branch, "git-branch", c.Git.Branch, "commit the transcript and artifacts of every task to this git branch")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "show tool calls without running them, so the model describes its plan")
+	fs.BoolVar(&c.Approve, "approve", c.Approve, "ask before running every tool call, with the chance to edit it")
+	fs.Func("tripwire", "ask before running tool calls with arguments matching this regular expression (repeatable)", func(pattern string) error {
+		c.Tripwires = append(c.Tripwires, tripwireConfig{Pattern: pattern, Action: "approve"})
+		return nil
+	})
+	fs.Func("tripwire-abort", "abort the task instead of running tool calls with arguments matching this regular expression (repeatable)", func(pattern string) error {
+		c.Tripwires = append(c.Tripwires, tripwireConfig{Pattern: pattern, Action: "abort"})
+		return nil
+	})
+	fs.Func("guardrail", "withhold responses matching this regular expression, in their text or tool calls, unless allowed (repeatable)", func(pattern string) error {
+		c.Guardrails.Rules = append(c.Guardrails.Rules, guardrailConfig{Pattern: pattern, Action: "block"})
+		return nil
+	})
+	fs.Func("guardrail-flag", "warn about responses matching this regular expression, in their text or tool calls (repeatable)", func(pattern string) error {
+		c.Guardrails.Rules = append(c.Guardrails.Rules, guardrailConfig{Pattern: pattern, Action: "flag"})
+		return nil
+	})
+	fs.StringVar(&c.Guardrails.Classifier, "guardrail-classifier", c.Guardrails.Classifier, "model to ask whether each response is safe to show and run")
+	fs.BoolVar(&c.Redaction.Enabled, "redact", c.Redaction.Enabled, "scrub API keys, credentials, email addresses and the config's redaction rules from requests, output, sessions and logs")
+	fs.BoolVar(&c.Encryption.Enabled, "encrypt", c.Encryption.Enabled, "encrypt stored sessions, memories, history and knowledge indexes, with a key from the keychain or a passphrase")
+	fs.StringVar(&c.Encryption.Key, "encryption-key", c.Encryption.Key, "where the encryption key comes from: keychain or passphrase")
+	fs.StringVar(&c.Audit.File, "audit-log", c.Audit.File, "append a JSON line describing every tool call to this file")
+	fs.StringVar(&c.Audit.HMACKeyEnv, "audit-hmac-env", c.Audit.HMACKeyEnv, "environment variable holding a key to chain audit log records with HMACs")
+	fs.StringVar(&c.PolicyFile, "policy", c.PolicyFile, "YAML file of rules allowing, asking about or denying tool calls by tool name and arguments")
+	fs.StringVar(&c.PolicyScript, "policy-script", c.PolicyScript, "Starlark script defining pre_tool_call and post_tool_call functions to inspect, change or reject tool calls and their results")
+
+	fs.IntVar(&c.Attachments.MaxBytes, "file-limit", c.Attachments.MaxBytes, "largest file, in bytes, that can be attached to a task (0 for no limit)")
+	fs.StringVar(&c.Attachments.Truncate, "file-truncate", c.Attachments.Truncate, "attach the head, tail or both ends of files over -file-limit instead of refusing them")
+
+	fs.StringVar(&c.Knowledge.Dir, "knowledge", c.Knowledge.Dir, "directory of documents to embed and send the relevant parts of with every task")
+	fs.StringVar(&c.Knowledge.Model, "knowledge-model", c.Knowledge.Model, "embedding model for -knowledge (default "+defaultEmbeddingModel+")")
+	fs.StringVar(&c.Embeddings.Provider, "embeddings-provider", c.Embeddings.Provider, "where -knowledge and -select-tools embed texts: openai (an OpenAI-compatible endpoint, the chat provider's by default), ollama or command")
+	fs.StringVar(&c.Embeddings.BaseURL, "embeddings-url", c.Embeddings.BaseURL, "base URL of the embeddings endpoint, if not the chat provider's or Ollama's default")
+	fs.StringVar(&c.Embeddings.Model, "embeddings-model", c.Embeddings.Model, "embedding model, unless -knowledge-model or -select-tools-model is set")
+	fs.StringVar(&c.Embeddings.Reranker.BaseURL, "rerank-url", c.Embeddings.Reranker.BaseURL, "base URL of a rerank endpoint to reorder the memories, documents and tools found for each task")
+	fs.StringVar(&c.Embeddings.Reranker.Model, "rerank-model", c.Embeddings.Reranker.Model, "model of -rerank-url")
+	fs.IntVar(&c.Knowledge.Results, "knowledge-results", c.Knowledge.Results, "how many chunks of -knowledge documents to send with every task (default 5)")
+	fs.StringVar(&c.Passthrough.URL, "passthrough", c.Passthrough.URL, "have the provider call the MCP server at this public URL itself, through the Responses API, rather than calling its tools locally")
+	fs.IntVar(&c.ToolSelection.Results, "select-tools", c.ToolSelection.Results, "offer only this many tools, those closest to each task by embedding, when the servers offer more")
+	fs.StringVar(&c.ToolSelection.Model, "select-tools-model", c.ToolSelection.Model, "embedding model for -select-tools (default "+defaultEmbeddingModel+")")
+
+	fs.BoolVar(&c.Memory.Enabled, "memory", c.Memory.Enabled, "remember facts about you from every task and send the related ones with later tasks")
+	fs.StringVar(&c.Memory.Model, "memory-model", c.Memory.Model, "model to extract memories with (defaults to the session's)")
+	fs.BoolVar(&c.Verify.Enabled, "verify", c.Verify.Enabled, "have a judge model check every answer against the tool results and print its verdict")
+	fs.StringVar(&c.Verify.Model, "verify-model", c.Verify.Model, "model to check answers with (defaults to the session's)")
+
+	fs.Func("reasoning-effort", "how hard reasoning models think: "+strings.Join(reasoningEfforts, ", ")+" (provider default if empty)", c.Reasoning.setEffort)
+	fs.IntVar(&c.Reasoning.MaxTokens, "reasoning-tokens", c.Reasoning.MaxTokens, "most tokens reasoning models may think for (provider default if 0)")
+
+	fs.Func("provider-order", "comma-separated OpenRouter providers to try first, in order", func(value string) error {
+		c.Routing.Order = strings.Split(value, ",")
+		return nil
+	})
+	fs.Func("provider-only", "comma-separated OpenRouter providers to allow, refusing all others", func(value string) error {
+		c.Routing.Only = strings.Split(value, ",")
+		return nil
+	})
+	fs.Func("provider-ignore", "comma-separated OpenRouter providers never to use", func(value string) error {
+		c.Routing.Ignore = strings.Split(value, ",")
+		return nil
+	})
+	fs.BoolFunc("no-provider-fallbacks", "only use the providers in -provider-order", func(string) error {
+		c.Routing.AllowFallbacks = new(bool)
+		return nil
+	})
+	fs.BoolVar(&c.Routing.RequireParameters, "require-parameters", c.Routing.RequireParameters, "only use providers supporting every request parameter, including tools")
+	fs.BoolFunc("deny-data-collection", "only use providers that don't store or train on prompts", func(string) error {
+		c.Routing.DataCollection = "deny"
+		return nil
+	})
+	fs.Func("max-prompt-price", "most dollars per million prompt tokens a provider may charge", func(value string) error {
+		price, err := strconv.ParseFloat(value, 64)
+		c.Routing.maxPrice().Prompt = price
+		return err
+	})
+	fs.Func("max-completion-price", "most dollars per million completion tokens a provider may charge", func(value string) error {
+		price, err := strconv.ParseFloat(value, 64)
+		c.Routing.maxPrice().Completion = price
+		return err
+	})
+
+	fs.BoolVar(&c.Stream, "stream", c.Stream, "stream completions, showing code sent to code tools as it is written")
+	fs.BoolVar(&c.StreamToolCalls, "stream-tool-calls", c.StreamToolCalls, "with -stream, start tool calls that need no approval as soon as their arguments are complete")
+	fs.Func("fallback-models", "comma-separated models to retry a completion with, in order, when the model fails", func(value string) error {
+		c.FallbackModels = strings.Split(value, ",")
+		return nil
+	})
+	fs.IntVar(&c.MaxContinuations, "max-continuations", c.MaxContinuations, "how many times to ask the model to continue a response cut off by the token limit")
+	fs.Float64Var(&c.Budget.MaxCost, "budget-cost", c.Budget.MaxCost, "stop a task once it has cost more than this many dollars (0 for no limit)")
+	fs.Int64Var(&c.Budget.MaxTokens, "budget-tokens", c.Budget.MaxTokens, "stop a task once it has used more than this many tokens (0 for no limit)")
+	fs.IntVar(&c.Budget.MaxTurns, "budget-turns", c.Budget.MaxTurns, "stop a task after this many completions (0 for no limit)")
+	fs.StringVar(&c.Budget.Deadline, "deadline", c.Budget.Deadline, "stop a task that runs longer than this duration, asking the model to sum up what it has so far")
+	fs.StringVar(&c.Escalation.Model, "escalate-model", c.Escalation.Model, "model to switch a task to once it is stuck on failing or repeated tool calls")
+	fs.IntVar(&c.Escalation.Failures, "escalate-failures", c.Escalation.Failures, "failed tool calls in a row that switch a task to -escalate-model (0 disables)")
+	fs.StringVar(&c.ModelRouting.Final, "final-model", c.ModelRouting.Final, "model to write the answers of tasks, after other models made the tool calls")
+	fs.IntVar(&c.Escalation.Repeats, "escalate-repeats", c.Escalation.Repeats, "identical tool calls in a row that switch a task to -escalate-model (0 disables)")
+	fs.IntVar(&c.Loops.Repeats, "loop-repeats", c.Loops.Repeats, "identical tool calls with identical results in a row that count as a loop (0 disables)")
+	fs.IntVar(&c.Loops.Cycles, "loop-cycles", c.Loops.Cycles, "times two tool calls alternate with the same results before it counts as a loop (0 disables)")
+	fs.IntVar(&c.Loops.Empty, "loop-empty", c.Loops.Empty, "empty responses in a row that count as a stall (0 disables)")
+	fs.IntVar(&c.Loops.Nudges, "loop-nudges", c.Loops.Nudges, "how many times the model is told it is stuck before the task fails")
+	fs.IntVar(&c.Batching.Turns, "batching-hint-turns", c.Batching.Turns, "responses in a row with a single tool call after which the model is reminded to batch calls (0 disables)")
+	fs.IntVar(&c.ArgumentRepairs, "argument-repairs", c.ArgumentRepairs, "how many tool calls with malformed JSON arguments are sent back to the model before the task fails")
+
+	fs.Float64Var(&c.Compaction.Threshold, "compaction-threshold", c.Compaction.Threshold, "fraction of the context window at which older messages are summarized (0 disables)")
+	fs.StringVar(&c.Compaction.Model, "compaction-model", c.Compaction.Model, "model used to summarize older messages (defaults to the selected model)")
+
+	fs.StringVar(&c.Summarizer.Model, "summarizer-model", c.Summarizer.Model, "model used to summarize large tool outputs (disabled when empty)")
+	fs.IntVar(&c.ToolOutputTokens, "tool-output-tokens", c.ToolOutputTokens, "truncate tool outputs to about this many tokens, letting the model read the rest with read_more (0 for no limit)")
+	fs.IntVar(&c.Summarizer.Threshold, "summarizer-threshold", c.Summarizer.Threshold, "tool outputs longer than this many characters are summarized")
+	fs.BoolVar(&c.Delegation.Enabled, "delegate", c.Delegation.Enabled, "let the model hand subtasks to sub-agents with their own context through the delegate_task tool")
+	fs.Func("delegate-models", "comma-separated models the model may pick for sub-agents", func(value string) error {
+		c.Delegation.Models = strings.Split(value, ",")
+		return nil
+	})
+	fs.IntVar(&c.ToolConcurrency, "tool-concurrency", c.ToolConcurrency, "most tool calls from one response run in parallel on a server, adapting to its latency and errors (1 runs them in order)")
+	fs.StringVar(&c.MCPInitialize.ProtocolVersion, "mcp-protocol-version", c.MCPInitialize.ProtocolVersion, "MCP protocol version to ask the server for: "+strings.Join(mcp.ValidProtocolVersions, ", "))
+	fs.StringVar(&c.MCPLogLevel, "mcp-log-level", c.MCPLogLevel, "least severe level of the MCP server's logs to show: debug, info, notice, warning, error, critical, alert, emergency or off")
+	fs.Func("root", "directory the MCP server may work in, sent to it as a root (repeatable; the working directory if none)", func(dir string) error {
+		c.Roots = append(c.Roots, dir)
+		return nil
+	})
+	fs.StringVar(&c.ToolCacheTTL, "tool-cache-ttl", c.ToolCacheTTL, "reuse the MCP server's tool list for new sessions for this long (0 lists tools every time)")
+	fs.BoolVar(&c.PreflightProbe, "preflight-probe", c.PreflightProbe, "before using a model the provider doesn't say supports tool calls, check with a one-off completion offering a tool")
+	fs.StringVar(&c.ModelCacheTTL, "model-cache-ttl", c.ModelCacheTTL, "reuse the provider's model list for this long (0 lists models at every start)")
+	fs.IntVar(&c.RateLimits.Completions, "completion-rate", c.RateLimits.Completions, "most completions requested a minute, across all tasks (0 for no limit)")
+	fs.Func("tool-rate", "most tool calls a minute across all tasks, or of one tool as name=limit (repeatable)", c.RateLimits.setToolRate)
+	fs.StringVar(&c.Prefetch.Model, "prefetch-model", c.Prefetch.Model, "cheap model predicting read-only tool calls to make ahead of time (disabled when empty)")
+	fs.BoolVar(&c.Prefetch.Turns, "prefetch-turns", c.Prefetch.Turns, "make read-only tool calls and request the next turn while they wait for approval, discarding both if declined or edited")
+
+	fs.Func("env-context", "comma-separated environment details to tell the model about: "+strings.Join(environmentFields, ", ")+", all or none", c.Environment.set)
+
+	fs.StringVar(&c.Storage.Driver, "storage", c.Storage.Driver, "where sessions are stored: sqlite (default), postgres or file")
+	fs.StringVar(&c.Storage.DSN, "storage-dsn", c.Storage.DSN, "SQLite database path or Postgres connection string")
+
+	fs.StringVar(&c.Workdir.Retention, "workdir-retention", c.Workdir.Retention, "remove session working directories unused for this long (0 keeps them)")
+
+	fs.BoolVar(&c.Notify.Desktop, "notify", c.Notify.Desktop, "show a desktop notification when a task finishes or fails")
+	fs.StringVar(&c.Notify.Webhook, "notify-webhook", c.Notify.Webhook, "post a Slack-compatible message to this URL when a task finishes or fails")
+	fs.Func("hook", "run a shell command with the event as JSON on stdin, as event=command where event is one of "+strings.Join(hookEvents, ", ")+" (repeatable)", c.Hooks.add)
+
+	fs.StringVar(&c.Network.Proxy, "proxy", c.Network.Proxy, "proxy URL for all outbound connections (defaults to HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	fs.StringVar(&c.Network.CACert, "ca-cert", c.Network.CACert, "PEM file of CA certificates to trust besides the system's")
+	fs.StringVar(&c.Network.ClientCert, "client-cert", c.Network.ClientCert, "PEM client certificate for servers requiring mutual TLS")
+	fs.StringVar(&c.Network.ClientKey, "client-key", c.Network.ClientKey, "PEM private key of -client-cert")
+	fs.BoolVar(&c.Network.InsecureSkipVerify, "insecure-skip-verify", c.Network.InsecureSkipVerify, "don't verify server certificates (unsafe, for testing only)")
+
+	fs.BoolVar(&c.Debug.Enabled, "debug", c.Debug.Enabled, "log all HTTP traffic to the LLM API and MCP server")
+	fs.StringVar(&c.Debug.File, "debug-file", c.Debug.File, "write debug logs to this file instead of stderr")
+	fs.BoolVar(&c.Debug.Bodies, "debug-bodies", c.Debug.Bodies, "log request and response bodies with -debug, rather than only their sizes")
+
+	fs.StringVar(&c.Output.Format, "output", c.Output.Format, "output format: text, json (a record of the -task run) or gha (GitHub Actions annotations and step summary)")
+	fs.BoolVar(&c.Output.Quiet, "quiet", c.Output.Quiet, "print only the answer of -task, or the -output json record, without progress")
+	fs.IntVar(&c.Output.Verbosity, "verbosity", c.Output.Verbosity, "0 shows code run by code tools and the results of tools with a renderer, 1 also shows every tool call with its arguments and result, 2 doesn't truncate them")
+	fs.StringVar(&c.Output.Images, "images", c.Output.Images, "how to show images returned by tools: "+strings.Join(imageProtocols, ", "))
+	fs.BoolVar(&c.Output.NoTUI, "no-tui", c.Output.NoTUI, "print interactive sessions to the terminal instead of using the full screen interface")
+	fs.BoolVar(&c.Output.NoPager, "no-pager", c.Output.NoPager, "don't page output taller than the terminal through $PAGER")
+	fs.StringVar(&c.Theme.Name, "theme", c.Theme.Name, "color theme: auto (default), dark, light or basic")
+	fs.StringVar(&c.Theme.Border, "border", c.Theme.Border, "box border style: rounded (default), normal, thick, double, hidden or ascii")
+	fs.BoolFunc("minimal", "draw with ASCII and 16 colors, print instead of running full screen and ask questions line by line, for dumb terminals and old Windows consoles", func(value string) error {
+		minimal, err := strconv.ParseBool(value)
+		c.Theme.Minimal = &minimal
+		return err
+	})
+	fs.StringVar(&c.PromptProfile, "prompt-profile", c.PromptProfile, "prompt profile whose system prompts new sessions start with, from prompt_profiles in the config")
+	fs.StringVar(&c.Locale, "locale", c.Locale, "language of the interface and system prompt, such as de or pt-BR (defaults to $LANG's)")
+	fs.StringVar(&c.Theme.CodeStyle, "code-style", c.Theme.CodeStyle, "chroma style to highlight code with (defaults to the theme's)")
+	fs.BoolVar(&c.Output.Provenance, "provenance", c.Output.Provenance, "end answers with the tools and servers used to compute them")
+	fs.BoolVar(&c.Output.Copy, "copy", c.Output.Copy, "copy every answer to the clipboard")
+	fs.IntVar(&c.Output.EventsFD, "events-jsonl", c.Output.EventsFD, "write every event of the agent as a line of JSON to this file descriptor as it happens")
+	fs.BoolVar(&c.Output.Timings, "timings", c.Output.Timings, "show how long completions and tool calls took every turn, and a summary after every task")
+	fs.BoolVar(&c.Output.Plain, "plain", c.Output.Plain, "print answers as plain text instead of rendering Markdown")
+	fs.StringVar(&c.Output.Template, "template", c.Output.Template, "text/template file to render the answer, tool results and usage with after running -task")
+	fs.StringVar(&c.Output.JSONSchema, "json-schema", c.Output.JSONSchema, "JSON schema file the final answer must conform to")
+	fs.IntVar(&c.Output.SchemaRepairs, "json-schema-repairs", c.Output.SchemaRepairs, "maximum attempts to repair an answer that does not match the JSON schema")
+}
+
+var samplingParams = []string{"temperature", "max_tokens", "top_p", "seed"}
+
+func (s *samplingConfig) set(name, value string) error {
+	if value == "" || value == "default" {
+		switch name {
+		case "temperature":
+			s.Temperature = nil
+		case "max_tokens":
+			s.MaxTokens = nil
+		case "top_p":
+			s.TopP = nil
+		case "seed":
+			s.Seed = nil
+		default:
+			return fmt.Errorf("unknown sampling parameter %q", name)
+		}
+		return nil
+	}
+
+	switch name {
+	case "temperature", "top_p":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if name == "temperature" {
+			s.Temperature = &v
+		} else {
+			s.TopP = &v
+		}
+	case "max_tokens", "seed":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if name == "max_tokens" {
+			s.MaxTokens = &v
+		} else {
+			s.Seed = &v
+		}
+	default:
+		return fmt.Errorf("unknown sampling parameter %q", name)
+	}
+
+	return nil
+}
+
+func (s samplingConfig) String() string {
+	var parts []string
+
+	if s.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *s.Temperature))
+	}
+	if s.MaxTokens != nil {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", *s.MaxTokens))
+	}
+	if s.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *s.TopP))
+	}
+	if s.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *s.Seed))
+	}
+
+	if len(parts) == 0 {
+		return "(provider defaults)"
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (s samplingConfig) apply(params *openai.ChatCompletionNewParams) {
+	params.Temperature = param.Opt[float64]{}
+	params.MaxTokens = param.Opt[int64]{}
+	params.TopP = param.Opt[float64]{}
+	params.Seed = param.Opt[int64]{}
+
+	if s.Temperature != nil {
+		params.Temperature = openai.Float(*s.Temperature)
+	}
+	if s.MaxTokens != nil {
+		params.MaxTokens = openai.Int(*s.MaxTokens)
+	}
+	if s.TopP != nil {
+		params.TopP = openai.Float(*s.TopP)
+	}
+	if s.Seed != nil {
+		params.Seed = openai.Int(*s.Seed)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/openai/openai-go"
+)
+
+// contextMeter tracks how much of the model's context window the requests of
+// a session take up. Requests are counted by the agent's TokenCounter as
+// they are sent, scaled to the prompt tokens the provider reported for the
+// model's last request to correct for the counter's error.
+type contextMeter struct {
+	mu sync.Mutex
+
+	model  string
+	limit  int64
+	tokens int64
+
+	// estimate is the unscaled estimate of the last request, and scales
+	// the ratio of reported to estimated tokens for each model.
+	estimate int64
+	scales   map[string]float64
+
+	// price, if set, is what a prompt token of a model costs, to estimate
+	// what sending the context costs.
+	price func(model string) float64
+
+	// warnAt is the fraction of the context window past which the user is
+	// warned, once until it drops below again, ahead of compaction at
+	// compactAt, if enabled, or of requests failing.
+	compactAt float64
+	warnAt    float64
+	warned    bool
+}
+
+func newContextMeter(cfg *config) *contextMeter {
+	return &contextMeter{
+		scales:    make(map[string]float64),
+		compactAt: cfg.Compaction.Threshold,
+		warnAt:    0.8 * cmp.Or(cfg.Compaction.Threshold, 1),
+	}
+}
+
+// measure takes the estimated tokens of a request about to be sent.
+func (m *contextMeter) measure(params *openai.ChatCompletionNewParams, limit, estimate int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.model, m.limit, m.estimate = params.Model, limit, estimate
+	m.tokens = int64(float64(estimate) * cmp.Or(m.scales[params.Model], 1))
+}
+
+// observe takes the prompt tokens of completions as reported, and returns a
+// warning the first time the context fills past warnAt.
+func (m *contextMeter) observe(event agent.Event) string {
+	usage, ok := event.(agent.UsageUpdated)
+	if !ok {
+		return ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prompt := usage.Turn.Usage.PromptTokens; prompt > 0 && m.estimate > 0 && !usage.Turn.Fallback {
+		m.scales[m.model] = float64(prompt) / float64(m.estimate)
+		m.tokens = prompt
+	}
+
+	if m.limit == 0 {
+		return ""
+	}
+
+	full := float64(m.tokens) / float64(m.limit)
+	if full < m.warnAt {
+		m.warned = false
+		return ""
+	}
+	if m.warned {
+		return ""
+	}
+	m.warned = true
+
+	warning := fmt.Sprintf("The context is %.0f%% full, at about %s of %s tokens", 100*full, formatTokens(m.tokens), formatTokens(m.limit))
+	if m.compactAt > 0 {
+		return warning + fmt.Sprintf("; older messages will be compacted at %.0f%%.", 100*m.compactAt)
+	}
+
+	return warning + "; requests will fail once it is full. /undo, or start a new session."
+}
+
+// String describes how full the context is, or is empty before the first
+// request or for models of unknown context length.
+func (m *contextMeter) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.limit == 0 {
+		return ""
+	}
+
+	s := fmt.Sprintf("context %s/%s (%.0f%%", formatTokens(m.tokens), formatTokens(m.limit), 100*float64(m.tokens)/float64(m.limit))
+	if cost := m.requestCost(); cost > 0 {
+		s += fmt.Sprintf(", ~$%.4f a request", cost)
+	}
+
+	return s + ")"
+}
+
+// cost estimates what sending the context costs in dollars, or zero if the
+// price of the model isn't known.
+func (m *contextMeter) cost() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.requestCost()
+}
+
+func (m *contextMeter) requestCost() float64 {
+	if m.price == nil {
+		return 0
+	}
+
+	return m.price(m.model) * float64(m.tokens)
+}
+
+func formatTokens(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 10_000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
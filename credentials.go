@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/x/term"
+)
+
+// profileConfig is a named set of provider credentials. The API key is kept
+// in the OS keychain under the profile's name, and BaseURL is the
+// OpenAI-compatible API it is for.
+type profileConfig struct {
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+const defaultProfile = "default"
+
+// profile returns the name of the selected credential profile and its config.
+func (c *config) profile() (string, profileConfig) {
+	name := cmp.Or(c.Profile, defaultProfile)
+	return name, c.Profiles[name]
+}
+
+// baseURL returns the API of the selected profile.
+func (c *config) baseURL() string {
+	_, p := c.profile()
+	return cmp.Or(p.BaseURL, providerBaseURL)
+}
+
+// apiKey returns the key for the selected profile. OPENAI_API_KEY wins when
+// no profile was picked, so CI and existing setups keep working.
+func (c *config) apiKey() (string, error) {
+	if key, ok := os.LookupEnv("OPENAI_API_KEY"); ok && c.Profile == "" {
+		return key, nil
+	}
+
+	name, _ := c.profile()
+
+	key, err := credentialGet(name)
+	if errors.Is(err, errNoKeychainEntry) {
+		if key, ok := os.LookupEnv("OPENAI_API_KEY"); ok {
+			return key, nil
+		}
+		return "", fmt.Errorf("no API key for profile %q, set OPENAI_API_KEY or run auth login", name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the keychain: %v", err)
+	}
+
+	return key, nil
+}
+
+func authCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: auth login|logout [profile] | auth list")
+	}
+
+	name, _ := cfg.profile()
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	switch args[0] {
+	case "login":
+		key, err := readAPIKey(ctx, name)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("no API key given")
+		}
+
+		if err := credentialSet(name, key); err != nil {
+			return fmt.Errorf("failed to store the key: %v", err)
+		}
+
+		return nil
+	case "logout":
+		if err := credentialDelete(name); errors.Is(err, errNoKeychainEntry) {
+			return fmt.Errorf("no key stored for profile %q", name)
+		} else if err != nil {
+			return fmt.Errorf("failed to delete the key: %v", err)
+		}
+
+		return nil
+	case "list":
+		names := []string{defaultProfile, cfg.Profile}
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names[1:])
+		names = slices.Compact(slices.DeleteFunc(names, func(s string) bool { return s == "" }))
+
+		for _, name := range names {
+			status := "no key"
+			if _, err := credentialGet(name); err == nil {
+				status = "key stored"
+			}
+
+			print("%s  %s  %s", sessionIDStyle.Render(name), cmp.Or(cfg.Profiles[name].BaseURL, providerBaseURL), status)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown auth command %q", args[0])
+	}
+}
+
+// readAPIKey prompts for a key without echoing it, or reads it from stdin
+// when that isn't a terminal.
+func readAPIKey(ctx context.Context, profile string) (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if line == "" && err != nil {
+			return "", fmt.Errorf("failed to read the key: %v", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	var key string
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title(fmt.Sprintf("API key for profile %q", profile)).
+				EchoMode(huh.EchoModePassword).
+				Value(&key),
+		),
+	)
+	if err := form.RunWithContext(ctx); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(key), nil
+}
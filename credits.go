@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// credits is the account balance reported by OpenRouter, in dollars.
+type credits struct {
+	Total float64 `json:"total_credits"`
+	Used  float64 `json:"total_usage"`
+}
+
+func (c credits) remaining() float64 {
+	return c.Total - c.Used
+}
+
+func (c credits) String() string {
+	return fmt.Sprintf("$%.2f of $%.2f credits remaining", c.remaining(), c.Total)
+}
+
+func (b *backend) credits(ctx context.Context) (*credits, error) {
+	var res struct {
+		Data credits `json:"data"`
+	}
+
+	if err := b.openai.Get(ctx, "credits", nil, &res); err != nil {
+		return nil, fmt.Errorf("failed to fetch credits: %v", err)
+	}
+
+	return &res.Data, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmailSubject = `{{.Name}} report for {{.Time.Format "2006-01-02"}}`
+	defaultWebhookBody  = `{"text": {{json .Answer}}}`
+	defaultFileBody     = "## {{.Name}}, {{.Time.Format \"2006-01-02 15:04\"}}\n\n{{.Answer}}\n\n"
+	defaultNotifyTitle  = "{{.Name}} finished"
+)
+
+// deliveryConfig controls where the answer of a scheduled task is sent.
+// Subjects, bodies, titles and file paths are report templates.
+type deliveryConfig struct {
+	Email   *emailDelivery   `json:"email,omitempty"`
+	Webhook *webhookDelivery `json:"webhook,omitempty"`
+	File    *fileDelivery    `json:"file,omitempty"`
+	Notify  *notifyDelivery  `json:"notify,omitempty"`
+}
+
+type emailDelivery struct {
+	SMTPAddr    string   `json:"smtp_addr"`
+	Username    string   `json:"username,omitempty"`
+	PasswordEnv string   `json:"password_env,omitempty"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	Subject     string   `json:"subject,omitempty"`
+	Body        string   `json:"body,omitempty"`
+}
+
+type webhookDelivery struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// fileDelivery writes the report to a file, appending to it if Append is
+// set.
+type fileDelivery struct {
+	Path   string `json:"path"`
+	Body   string `json:"body,omitempty"`
+	Append bool   `json:"append,omitempty"`
+}
+
+// notifyDelivery shows the report as a desktop notification.
+type notifyDelivery struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+func (d deliveryConfig) validate() error {
+	if d.Email != nil {
+		if d.Email.SMTPAddr == "" || d.Email.From == "" || len(d.Email.To) == 0 {
+			return fmt.Errorf("email delivery needs smtp_addr, from and to")
+		}
+		if _, err := parseTemplate(d.Email.Subject, defaultEmailSubject); err != nil {
+			return err
+		}
+		if _, err := parseTemplate(d.Email.Body, "{{.Answer}}"); err != nil {
+			return err
+		}
+	}
+
+	if d.Webhook != nil {
+		if d.Webhook.URL == "" {
+			return fmt.Errorf("webhook delivery needs a url")
+		}
+		if _, err := parseTemplate(d.Webhook.Body, defaultWebhookBody); err != nil {
+			return err
+		}
+	}
+
+	if d.File != nil {
+		if d.File.Path == "" {
+			return fmt.Errorf("file delivery needs a path")
+		}
+		if _, err := parseTemplate(d.File.Path, ""); err != nil {
+			return err
+		}
+		if _, err := parseTemplate(d.File.Body, defaultFileBody); err != nil {
+			return err
+		}
+	}
+
+	if d.Notify != nil {
+		if _, err := parseTemplate(d.Notify.Title, defaultNotifyTitle); err != nil {
+			return err
+		}
+		if _, err := parseTemplate(d.Notify.Body, "{{.Answer}}"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d deliveryConfig) deliver(ctx context.Context, httpClient *http.Client, r report) error {
+	var errs []string
+
+	if d.Email != nil {
+		if err := d.Email.send(r); err != nil {
+			errs = append(errs, fmt.Sprintf("email: %v", err))
+		}
+	}
+
+	if d.Webhook != nil {
+		if err := d.Webhook.send(ctx, httpClient, r); err != nil {
+			errs = append(errs, fmt.Sprintf("webhook: %v", err))
+		}
+	}
+
+	if d.File != nil {
+		if err := d.File.write(r); err != nil {
+			errs = append(errs, fmt.Sprintf("file: %v", err))
+		}
+	}
+
+	if d.Notify != nil {
+		if err := d.Notify.show(r); err != nil {
+			errs = append(errs, fmt.Sprintf("notification: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to deliver report: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (e *emailDelivery) send(r report) error {
+	subject, err := renderTemplate(e.Subject, defaultEmailSubject, r)
+	if err != nil {
+		return err
+	}
+
+	body, err := renderTemplate(e.Body, "{{.Answer}}", r)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", r.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.SMTPAddr)
+		if err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", e.Username, os.Getenv(e.PasswordEnv), host)
+	}
+
+	return smtp.SendMail(e.SMTPAddr, auth, e.From, e.To, msg.Bytes())
+}
+
+func (w *webhookDelivery) send(ctx context.Context, httpClient *http.Client, r report) error {
+	body, err := renderTemplate(w.Body, defaultWebhookBody, r)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, httpClient, w.URL, w.Headers, body)
+}
+
+// postJSON posts body to url. Header values may reference environment
+// variables.
+func postJSON(ctx context.Context, httpClient *http.Client, url string, headers map[string]string, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func (f *fileDelivery) write(r report) error {
+	path, err := renderTemplate(f.Path, "", r)
+	if err != nil {
+		return err
+	}
+
+	body, err := renderTemplate(f.Body, defaultFileBody, r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if f.Append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(body); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (n *notifyDelivery) show(r report) error {
+	title, err := renderTemplate(n.Title, defaultNotifyTitle, r)
+	if err != nil {
+		return err
+	}
+
+	body, err := renderTemplate(n.Body, "{{.Answer}}", r)
+	if err != nil {
+		return err
+	}
+
+	return desktopNotify(title, body)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aymanbagabas/go-udiff"
+)
+
+// editArguments are the names file-editing tools give the text they replace
+// and its replacement, and patchArguments those of patches.
+var (
+	editArguments = [][2]string{
+		{"old_string", "new_string"},
+		{"old_str", "new_str"},
+		{"old_text", "new_text"},
+		{"oldText", "newText"},
+		{"old_content", "new_content"},
+		{"old", "new"},
+		{"search", "replace"},
+		{"original", "updated"},
+	}
+	patchArguments = []string{"patch", "diff", "unified_diff"}
+	pathArguments  = []string{"path", "file_path", "filePath", "file", "filename"}
+)
+
+// toolDiff returns the change the arguments of a tool call make to a file as
+// a unified diff, if they hold a patch, old and new text, or a list of edits
+// of old and new text.
+func toolDiff(args map[string]any) (string, bool) {
+	for _, key := range patchArguments {
+		if patch, ok := args[key].(string); ok && strings.Contains(patch, "@@") {
+			return patch, true
+		}
+	}
+
+	path := "file"
+	for _, key := range pathArguments {
+		if value, ok := args[key].(string); ok && value != "" {
+			path = value
+			break
+		}
+	}
+
+	edits := []any{args}
+	if list, ok := args["edits"].([]any); ok {
+		edits = list
+	}
+
+	var diffs []string
+	for _, edit := range edits {
+		edit, ok := edit.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if before, after, ok := editChange(edit); ok {
+			if diff := udiff.Unified("a/"+path, "b/"+path, withNewline(before), withNewline(after)); diff != "" {
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+
+	return strings.Join(diffs, ""), len(diffs) > 0
+}
+
+// editChange returns the old and new text of an edit.
+func editChange(edit map[string]any) (string, string, bool) {
+	for _, keys := range editArguments {
+		before, ok := edit[keys[0]].(string)
+		if !ok {
+			continue
+		}
+		after, ok := edit[keys[1]].(string)
+		if !ok {
+			continue
+		}
+
+		return before, after, true
+	}
+
+	return "", "", false
+}
+
+// withNewline ends text with a newline, so a diff doesn't note that it has
+// none for fragments of files.
+func withNewline(text string) string {
+	if text == "" || strings.HasSuffix(text, "\n") {
+		return text
+	}
+
+	return text + "\n"
+}
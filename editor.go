@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// editorCommand returns the user's editor from $VISUAL or $EDITOR, split
+// into the command and its arguments.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(env)); len(editor) > 0 {
+			return editor
+		}
+	}
+
+	return []string{"vi"}
+}
+
+// editFile returns a command opening path in the user's editor, connected to
+// the terminal.
+func editFile(path string) *exec.Cmd {
+	editor := editorCommand()
+
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+// languageExtension returns the file extension for code in a chroma
+// language, so editors highlight it.
+func languageExtension(language string) string {
+	if lexer := lexers.Get(language); lexer != nil {
+		for _, pattern := range lexer.Config().Filenames {
+			if ext := strings.TrimPrefix(pattern, "*"); strings.HasPrefix(ext, ".") && !strings.ContainsAny(ext, "*?[") {
+				return ext
+			}
+		}
+	}
+
+	return ".txt"
+}
+
+// editText opens text in the user's editor and returns it once saved. ext
+// is the file extension, for syntax highlighting.
+func editText(text, ext string) (string, error) {
+	f, err := os.CreateTemp("", "mcp-experiment-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	if err := editFile(f.Name()).Run(); err != nil {
+		return "", fmt.Errorf("failed to run editor: %v", err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(normalizeNewlines(string(data))), nil
+}
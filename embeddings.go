@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// embeddingsConfig is where knowledge and tool descriptions are embedded,
+// apart from the chat provider: the chat provider itself by default, another
+// OpenAI-compatible endpoint at BaseURL, Ollama, or a local command, such as
+// one running an ONNX model. Model is used unless a feature sets its own.
+// Reranker, if set, reorders what the embeddings found.
+type embeddingsConfig struct {
+	Provider  string         `json:"provider,omitempty"`
+	BaseURL   string         `json:"base_url,omitempty"`
+	APIKeyEnv string         `json:"api_key_env,omitempty"`
+	Model     string         `json:"model,omitempty"`
+	Command   string         `json:"command,omitempty"`
+	Reranker  rerankerConfig `json:"reranker"`
+}
+
+// rerankerConfig points at a rerank endpoint, as served by Cohere, Jina,
+// vLLM or llama.cpp. Candidates is how many of the closest embeddings it
+// reorders, by default four times as many as are kept.
+type rerankerConfig struct {
+	BaseURL    string `json:"base_url,omitempty"`
+	APIKeyEnv  string `json:"api_key_env,omitempty"`
+	Model      string `json:"model,omitempty"`
+	Candidates int    `json:"candidates,omitempty"`
+}
+
+var embeddingProviders = []string{"openai", "ollama", "command"}
+
+const defaultOllamaURL = "http://localhost:11434"
+
+func (c embeddingsConfig) validate() error {
+	switch c.Provider {
+	case "", "openai", "ollama":
+	case "command":
+		if c.Command == "" {
+			return fmt.Errorf("the command embeddings provider needs a command")
+		}
+	default:
+		return fmt.Errorf("unknown embeddings provider %q, expected one of %s", c.Provider, strings.Join(embeddingProviders, ", "))
+	}
+
+	return nil
+}
+
+// embedder turns texts into vectors. Its key identifies where the vectors
+// come from, so indexes made by different models aren't mixed up.
+type embedder interface {
+	embed(ctx context.Context, texts []string) ([][]float64, error)
+	key() string
+}
+
+// newEmbedder returns the embedder of cfg, with model if a feature sets its
+// own. The chat provider's client is used unless another endpoint is set.
+func newEmbedder(cfg embeddingsConfig, model string, httpClient *http.Client, chat openai.Client) embedder {
+	model = cmp.Or(model, cfg.Model)
+
+	switch cfg.Provider {
+	case "ollama":
+		return &ollamaEmbedder{
+			http:    httpClient,
+			baseURL: strings.TrimSuffix(cmp.Or(cfg.BaseURL, defaultOllamaURL), "/"),
+			model:   cmp.Or(model, "nomic-embed-text"),
+		}
+	case "command":
+		return &commandEmbedder{command: cfg.Command, model: model}
+	}
+
+	e := &openaiEmbedder{client: chat, model: cmp.Or(model, defaultEmbeddingModel)}
+	if cfg.BaseURL != "" {
+		e.baseURL = cfg.BaseURL
+		e.client = openai.NewClient(
+			option.WithBaseURL(cfg.BaseURL),
+			option.WithAPIKey(os.Getenv(cfg.APIKeyEnv)),
+			option.WithHTTPClient(httpClient),
+		)
+	}
+
+	return e
+}
+
+type openaiEmbedder struct {
+	client  openai.Client
+	baseURL string
+	model   string
+}
+
+func (e *openaiEmbedder) key() string {
+	// Indexes made with the chat provider predate other providers.
+	if e.baseURL == "" {
+		return e.model
+	}
+
+	return e.baseURL + " " + e.model
+}
+
+func (e *openaiEmbedder) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	resp, err := e.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: e.model,
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %v", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	vectors := make([][]float64, len(texts))
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || int(embedding.Index) >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+
+	return vectors, nil
+}
+
+// embedRequest and embedResponse are Ollama's /api/embed, which commands
+// speak too.
+type embedRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+type embedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+func (r embedResponse) vectors(texts []string) ([][]float64, error) {
+	if len(r.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(r.Embeddings))
+	}
+
+	return r.Embeddings, nil
+}
+
+type ollamaEmbedder struct {
+	http    *http.Client
+	baseURL string
+	model   string
+}
+
+func (e *ollamaEmbedder) key() string {
+	return "ollama " + e.model
+}
+
+func (e *ollamaEmbedder) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var resp embedResponse
+	if err := requestJSON(ctx, e.http, e.baseURL+"/api/embed", "", embedRequest{Model: e.model, Input: texts}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %v", err)
+	}
+
+	return resp.vectors(texts)
+}
+
+// commandEmbedder runs a command for every batch of texts, with the request
+// of Ollama's /api/embed on stdin, expecting its response on stdout.
+type commandEmbedder struct {
+	command string
+	model   string
+}
+
+func (e *commandEmbedder) key() string {
+	return "command " + e.command + " " + e.model
+}
+
+func (e *commandEmbedder) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	input, err := json.Marshal(embedRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp embedResponse
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse embeddings: %v", err)
+	}
+
+	return resp.vectors(texts)
+}
+
+// reranker reorders documents by how relevant a model finds them to a query.
+type reranker struct {
+	http       *http.Client
+	baseURL    string
+	apiKey     string
+	model      string
+	candidates int
+}
+
+func newReranker(cfg rerankerConfig, httpClient *http.Client) *reranker {
+	if cfg.BaseURL == "" {
+		return nil
+	}
+
+	return &reranker{
+		http:       httpClient,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		apiKey:     os.Getenv(cfg.APIKeyEnv),
+		model:      cfg.Model,
+		candidates: cfg.Candidates,
+	}
+}
+
+// pool is how many candidates to find for n results to be kept, more than n
+// if they are reranked.
+func (r *reranker) pool(n int) int {
+	if r == nil {
+		return n
+	}
+
+	return max(cmp.Or(r.candidates, 4*n), n)
+}
+
+// rerank returns the indexes of the n documents most relevant to query,
+// most relevant first. If reranking fails, the documents keep their order.
+func (r *reranker) rerank(ctx context.Context, query string, documents []string, n int) []int {
+	order := make([]int, len(documents))
+	for i := range order {
+		order[i] = i
+	}
+	n = min(n, len(documents))
+
+	if r == nil || len(documents) <= 1 {
+		return order[:n]
+	}
+
+	request := map[string]any{
+		"query":     query,
+		"documents": documents,
+		"top_n":     n,
+	}
+	if r.model != "" {
+		request["model"] = r.model
+	}
+
+	var resp struct {
+		Results []struct {
+			Index int     `json:"index"`
+			Score float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := requestJSON(ctx, r.http, r.baseURL+"/rerank", r.apiKey, request, &resp); err != nil {
+		log.Printf("Failed to rerank, keeping the order of the embeddings: %v", err)
+		return order[:n]
+	}
+
+	var ranked []int
+	for _, result := range resp.Results {
+		if result.Index >= 0 && result.Index < len(documents) && !slices.Contains(ranked, result.Index) {
+			ranked = append(ranked, result.Index)
+		}
+	}
+	if len(ranked) == 0 {
+		return order[:n]
+	}
+
+	return ranked[:min(n, len(ranked))]
+}
+
+// requestJSON posts body to url as JSON and decodes the response into out.
+func requestJSON(ctx context.Context, client *http.Client, url, apiKey string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/x/term"
+)
+
+// encryptionConfig encrypts sessions, memories, the history and knowledge
+// indexes at rest with AES-256-GCM. Key is where the key comes from: keychain,
+// a random key kept in the OS keychain, or passphrase, derived from
+// $MCP_EXPERIMENT_PASSPHRASE or asked for. With a passphrase, API keys are
+// kept encrypted in the config directory rather than in the keychain.
+type encryptionConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Key     string `json:"key,omitempty"`
+}
+
+const (
+	encryptedPrefix    = "enc:v1:"
+	encryptionKeyEntry = "@storage-encryption-key"
+	encryptionFile     = "encryption.json"
+	keychainCheckFile  = "encryption-keychain.check"
+	passphraseEnv      = "MCP_EXPERIMENT_PASSPHRASE"
+	pbkdf2Iterations   = 600_000
+
+	// encryptionCheck is encrypted with a passphrase's key to tell whether
+	// a passphrase is the right one.
+	encryptionCheck = "mcp-experiment"
+)
+
+var (
+	// storageCipher encrypts what is stored, or is nil if encryption is off.
+	storageCipher cipher.AEAD
+
+	// encryptCredentials keeps API keys in an encrypted file.
+	encryptCredentials bool
+
+	errEncrypted = errors.New("it is encrypted, enable encryption to read it")
+)
+
+func applyEncryption(ctx context.Context, cfg encryptionConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var (
+		key []byte
+		err error
+	)
+
+	switch cfg.Key {
+	case "", "keychain":
+		key, err = keychainEncryptionKey()
+	case "passphrase":
+		key, err = passphraseEncryptionKey(ctx)
+		encryptCredentials = true
+	default:
+		return fmt.Errorf("unknown encryption key %q, expected keychain or passphrase", cfg.Key)
+	}
+	if err != nil {
+		return err
+	}
+
+	storageCipher, err = newStorageCipher(key)
+
+	return err
+}
+
+func newStorageCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// keychainEncryptionKey returns the key kept in the keychain, creating it the
+// first time. The key must outlive a reboot, so keyrings that forget it
+// aren't used. Once data was encrypted, a check sealed with the key is kept
+// in the config directory, so a key gone from the keychain is an error
+// rather than replaced by a new one that can't read that data.
+func keychainEncryptionKey() ([]byte, error) {
+	if !keychainPersistent() {
+		return nil, fmt.Errorf("no keychain here keeps keys across reboots, so everything encrypted could be lost: set -encryption-key passphrase, or on Linux install secret-tool")
+	}
+
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+	checkPath := filepath.Join(dir, keychainCheckFile)
+
+	check, err := os.ReadFile(checkPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var key []byte
+
+	encoded, err := keychainGet(encryptionKeyEntry)
+	switch {
+	case err == nil:
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("the encryption key in the keychain is invalid")
+		}
+	case !errors.Is(err, errNoKeychainEntry):
+		return nil, fmt.Errorf("failed to read the keychain: %v", err)
+	case len(check) > 0:
+		return nil, fmt.Errorf("the encryption key is gone from the keychain, so what was encrypted with it can't be read: restore it, or move %s and the encrypted data away to start over", checkPath)
+	default:
+		key = make([]byte, 32)
+		rand.Read(key)
+
+		if err := keychainSet(encryptionKeyEntry, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("failed to store the encryption key: %v", err)
+		}
+	}
+
+	aead, err := newStorageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(check) > 0 {
+		if plain, err := unseal(aead, check); err != nil || string(plain) != encryptionCheck {
+			return nil, fmt.Errorf("the encryption key in the keychain isn't the one the data was encrypted with")
+		}
+		return key, nil
+	}
+
+	// Keys created before the check was kept get one too.
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(checkPath, seal(aead, []byte(encryptionCheck)), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save the encryption check: %v", err)
+	}
+
+	return key, nil
+}
+
+// passphraseParams are what is needed to derive the key of a passphrase and
+// check it, kept in encryption.json.
+type passphraseParams struct {
+	Salt  []byte `json:"salt"`
+	Check string `json:"check"`
+}
+
+// passphraseEncryptionKey derives the key from the passphrase, which is set
+// the first time.
+func passphraseEncryptionKey(ctx context.Context) ([]byte, error) {
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, encryptionFile)
+
+	var params passphraseParams
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		params.Salt = make([]byte, 16)
+		rand.Read(params.Salt)
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	passphrase, err := readPassphrase(ctx, params.Check == "")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, passphrase, params.Salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newStorageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Check != "" {
+		if check, err := unseal(aead, []byte(params.Check)); err != nil || string(check) != encryptionCheck {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		return key, nil
+	}
+
+	params.Check = string(seal(aead, []byte(encryptionCheck)))
+
+	data, err = json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save the passphrase salt: %v", err)
+	}
+
+	return key, nil
+}
+
+// readPassphrase reads the passphrase from the environment or asks for it,
+// twice if it is new.
+func readPassphrase(ctx context.Context, confirm bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("encryption needs a passphrase, set %s", passphraseEnv)
+	}
+
+	var passphrase, again string
+
+	fields := []huh.Field{
+		huh.NewInput().
+			Title("Passphrase").
+			EchoMode(huh.EchoModePassword).
+			Value(&passphrase),
+	}
+	if confirm {
+		fields = append(fields, huh.NewInput().
+			Title("Passphrase again").
+			EchoMode(huh.EchoModePassword).
+			Value(&again).
+			Validate(func(s string) error {
+				if s != passphrase {
+					return fmt.Errorf("the passphrases differ")
+				}
+				return nil
+			}))
+	}
+
+	if err := newForm(huh.NewGroup(fields...)).RunWithContext(ctx); err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("no passphrase given")
+	}
+
+	return passphrase, nil
+}
+
+// encrypt encrypts data if encryption is enabled.
+func encrypt(data []byte) []byte {
+	if storageCipher == nil {
+		return data
+	}
+
+	return seal(storageCipher, data)
+}
+
+// decrypt decrypts data if it is encrypted, so what was stored before
+// encryption was enabled can still be read.
+func decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedPrefix)) {
+		return data, nil
+	}
+	if storageCipher == nil {
+		return nil, errEncrypted
+	}
+
+	return unseal(storageCipher, data)
+}
+
+func seal(aead cipher.AEAD, data []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	sealed := aead.Seal(nonce, nonce, data, nil)
+
+	return base64.StdEncoding.AppendEncode([]byte(encryptedPrefix), sealed)
+}
+
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimPrefix(data, []byte(encryptedPrefix))))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt: malformed data")
+	}
+
+	data, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: wrong key or corrupted data")
+	}
+
+	return data, nil
+}
+
+const credentialsFile = "credentials.enc"
+
+// credentialGet, credentialSet and credentialDelete keep API keys in the
+// keychain, or in an encrypted file when encryption uses a passphrase.
+func credentialGet(profile string) (string, error) {
+	if !encryptCredentials {
+		return keychainGet(profile)
+	}
+
+	keys, err := loadCredentials()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := keys[profile]
+	if !ok {
+		return "", errNoKeychainEntry
+	}
+
+	return key, nil
+}
+
+func credentialSet(profile, key string) error {
+	if !encryptCredentials {
+		return keychainSet(profile, key)
+	}
+
+	keys, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	keys[profile] = key
+
+	return saveCredentials(keys)
+}
+
+func credentialDelete(profile string) error {
+	if !encryptCredentials {
+		return keychainDelete(profile)
+	}
+
+	keys, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[profile]; !ok {
+		return errNoKeychainEntry
+	}
+	delete(keys, profile)
+
+	return saveCredentials(keys)
+}
+
+func loadCredentials() (map[string]string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(dir, credentialsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = decrypt(data); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", credentialsFile, err)
+	}
+
+	return keys, nil
+}
+
+func saveCredentials(keys map[string]string) error {
+	dir, err := appDir()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, credentialsFile), encrypt(data), 0o600)
+}
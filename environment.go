@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+type environmentConfig struct {
+	Date     bool `json:"date"`
+	Timezone bool `json:"timezone"`
+	OS       bool `json:"os"`
+	Workdir  bool `json:"workdir"`
+}
+
+var environmentFields = []string{"date", "timezone", "os", "workdir"}
+
+func (e *environmentConfig) set(value string) error {
+	*e = environmentConfig{}
+
+	for _, field := range strings.Split(value, ",") {
+		switch strings.TrimSpace(field) {
+		case "", "none":
+		case "all":
+			*e = environmentConfig{Date: true, Timezone: true, OS: true, Workdir: true}
+		case "date":
+			e.Date = true
+		case "timezone":
+			e.Timezone = true
+		case "os":
+			e.OS = true
+		case "workdir":
+			e.Workdir = true
+		default:
+			return fmt.Errorf("unknown environment field %q, expected one of %s", field, strings.Join(environmentFields, ", "))
+		}
+	}
+
+	return nil
+}
+
+// environmentMessage describes the local environment so the model doesn't
+// need a tool call for things like today's date.
+func environmentMessage(e environmentConfig) (openai.ChatCompletionMessageParamUnion, bool) {
+	var lines []string
+	now := time.Now()
+
+	if e.Date {
+		lines = append(lines, "Current date and time: "+now.Format("Monday, 2 January 2006 15:04"))
+	}
+	if e.Timezone {
+		// The offset keeps its minutes, as in UTC+05:30 or UTC+05:45.
+		name, _ := now.Zone()
+		lines = append(lines, fmt.Sprintf("Timezone: %s (%s, UTC%s)", now.Location(), name, now.Format("-07:00")))
+	}
+	if e.OS {
+		lines = append(lines, fmt.Sprintf("Operating system: %s/%s", runtime.GOOS, runtime.GOARCH))
+	}
+	if e.Workdir {
+		if wd, err := os.Getwd(); err == nil {
+			lines = append(lines, "Working directory: "+wd)
+		}
+	}
+
+	if len(lines) == 0 {
+		return openai.ChatCompletionMessageParamUnion{}, false
+	}
+
+	return openai.SystemMessage("Environment of the user:\n" + strings.Join(lines, "\n")), true
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionLockTTL bounds how long a crashed instance can keep a session busy.
+// Instances still running a task renew their locks well before they expire.
+const sessionLockTTL = time.Hour
+
+type sseEvent struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newSSEEvent(name string, data any) (sseEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return sseEvent{}, err
+	}
+
+	return sseEvent{Name: name, Data: payload}, nil
+}
+
+// eventBus carries a session's events to every subscriber, and makes sure
+// only one task runs in a session at a time. The memory bus only spans one
+// process; the Redis bus lets any instance behind a load balancer stream any
+// session.
+type eventBus interface {
+	publish(ctx context.Context, sessionID string, event sseEvent) error
+	subscribe(ctx context.Context, sessionID string) (<-chan sseEvent, error)
+	acquire(ctx context.Context, sessionID string) (bool, error)
+	release(ctx context.Context, sessionID string) error
+}
+
+type memoryBus struct {
+	mu     sync.Mutex
+	active map[string]bool
+	subs   map[string]map[chan sseEvent]bool
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{
+		active: make(map[string]bool),
+		subs:   make(map[string]map[chan sseEvent]bool),
+	}
+}
+
+func (b *memoryBus) publish(ctx context.Context, sessionID string, event sseEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[sessionID] {
+		// Slow subscribers miss events rather than holding up the agent.
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBus) subscribe(ctx context.Context, sessionID string) (<-chan sseEvent, error) {
+	ch := make(chan sseEvent, 64)
+
+	b.mu.Lock()
+	if b.subs[sessionID] == nil {
+		b.subs[sessionID] = make(map[chan sseEvent]bool)
+	}
+	b.subs[sessionID][ch] = true
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subs[sessionID], ch)
+		if len(b.subs[sessionID]) == 0 {
+			delete(b.subs, sessionID)
+		}
+		b.mu.Unlock()
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (b *memoryBus) acquire(ctx context.Context, sessionID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.active[sessionID] {
+		return false, nil
+	}
+
+	b.active[sessionID] = true
+	return true, nil
+}
+
+func (b *memoryBus) release(ctx context.Context, sessionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.active, sessionID)
+	return nil
+}
+
+type redisBus struct {
+	client *redis.Client
+
+	mu    sync.Mutex
+	locks map[string]redisLock
+}
+
+// redisLock is a session lock held by this instance. Token tells it apart
+// from a lock another instance took after this one expired, which must be
+// left alone.
+type redisLock struct {
+	token string
+	stop  context.CancelFunc
+}
+
+var (
+	renewLockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func newRedisBus(url string) (*redisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+
+	return &redisBus{client: redis.NewClient(opts), locks: make(map[string]redisLock)}, nil
+}
+
+func (b *redisBus) channel(sessionID string) string {
+	return "mcp-experiment:events:" + sessionID
+}
+
+func (b *redisBus) lockKey(sessionID string) string {
+	return "mcp-experiment:lock:" + sessionID
+}
+
+func (b *redisBus) publish(ctx context.Context, sessionID string, event sseEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return b.client.Publish(ctx, b.channel(sessionID), payload).Err()
+}
+
+func (b *redisBus) subscribe(ctx context.Context, sessionID string) (<-chan sseEvent, error) {
+	pubsub := b.client.Subscribe(ctx, b.channel(sessionID))
+
+	// Wait for the subscription to be confirmed so no events are missed.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	ch := make(chan sseEvent, 64)
+
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var event sseEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+
+				select {
+				case ch <- event:
+				default:
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (b *redisBus) acquire(ctx context.Context, sessionID string) (bool, error) {
+	token := uuid.NewString()
+
+	ok, err := b.client.SetNX(ctx, b.lockKey(sessionID), token, sessionLockTTL).Result()
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	renewCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	go b.renew(renewCtx, sessionID, token)
+
+	b.mu.Lock()
+	b.locks[sessionID] = redisLock{token: token, stop: stop}
+	b.mu.Unlock()
+
+	return true, nil
+}
+
+// renew extends a session lock while its task runs, so tasks running longer
+// than sessionLockTTL keep it.
+func (b *redisBus) renew(ctx context.Context, sessionID, token string) {
+	ticker := time.NewTicker(sessionLockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := renewLockScript.Run(ctx, b.client, []string{b.lockKey(sessionID)}, token, sessionLockTTL.Milliseconds()).Int()
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("Failed to renew the lock of session %s: %v", sessionID, err)
+		case err == nil && renewed == 0:
+			log.Printf("Lost the lock of session %s", sessionID)
+			return
+		}
+	}
+}
+
+// release gives up a session lock, unless it expired and another instance
+// holds it now.
+func (b *redisBus) release(ctx context.Context, sessionID string) error {
+	b.mu.Lock()
+	lock, ok := b.locks[sessionID]
+	delete(b.locks, sessionID)
+	b.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	lock.stop()
+
+	return releaseLockScript.Run(ctx, b.client, []string{b.lockKey(sessionID)}, lock.token).Err()
+}
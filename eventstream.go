@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// eventStream writes the agent's events as they happen, one JSON object per
+// line, to a file descriptor given with -events-jsonl, for tools following a
+// run without parsing what's shown on the terminal. Events are named and
+// shaped like those of serve.
+type eventStream struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	failed bool
+}
+
+type eventLine struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// openEventStream opens the stream to fd, returning nil without one.
+func openEventStream(fd int) (*eventStream, error) {
+	if fd <= 0 {
+		return nil, nil
+	}
+
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("failed to open the event stream: file descriptor %d isn't open", fd)
+	}
+
+	return &eventStream{enc: json.NewEncoder(f)}, nil
+}
+
+func (s *eventStream) observe(event agent.Event) {
+	name, data, ok := eventData(event)
+	if !ok {
+		return
+	}
+
+	if len(redactions) > 0 {
+		if encoded, err := json.Marshal(data); err == nil {
+			var decoded any
+			if json.Unmarshal(encoded, &decoded) == nil {
+				data = redactValue(decoded)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A reader that went away shouldn't stop the run.
+	if s.failed {
+		return
+	}
+	if err := s.enc.Encode(eventLine{Event: name, Time: time.Now(), Data: data}); err != nil {
+		log.Printf("Failed to write the event stream: %v", err)
+		s.failed = true
+	}
+}
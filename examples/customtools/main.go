@@ -0,0 +1,102 @@
+// Command customtools gives the agent tools from two sources: an MCP server
+// built in-process with mcp-go, and a local tool implemented as a plain Go
+// function. The model sees them side by side.
+//
+// It runs offline with a scripted provider from agenttest.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/agent/agenttest"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func main() {
+	ctx := context.Background()
+
+	client, err := inventoryServer(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start inventory server: %v", err)
+	}
+	defer client.Close()
+
+	clock := agent.LocalTool{
+		Tool: mcp.NewTool("current_time",
+			mcp.WithDescription("Get the current time in UTC."),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			return time.Now().UTC().Format(time.RFC3339), nil
+		},
+	}
+
+	a := agent.New(
+		agent.WithToolSource(client),
+		agent.WithLocalTools(clock),
+		agent.WithProvider(agenttest.NewProvider(
+			agenttest.CallTools(
+				agenttest.ToolCall{Name: "stock_level", Arguments: map[string]any{"sku": "WIDGET-1"}},
+				agenttest.ToolCall{Name: "current_time", Arguments: map[string]any{}},
+			),
+			agenttest.Reply("There are 42 WIDGET-1 in stock as of now."),
+		)),
+	)
+
+	result, err := a.Run(ctx, "How many WIDGET-1 do we have right now?")
+	if err != nil {
+		log.Fatalf("Failed to run agent: %v", err)
+	}
+
+	for _, tool := range a.Tools() {
+		fmt.Println("tool:", tool.Function.Name)
+	}
+	for _, event := range result.Events {
+		if finished, ok := event.(agent.ToolCallFinished); ok {
+			fmt.Printf("%s -> %s\n", finished.ToolCall.Function.Name, finished.Result)
+		}
+	}
+	fmt.Println(result.Answer)
+}
+
+// inventoryServer starts an MCP server in-process and returns a client
+// connected to it. A real server would be reached over HTTP or stdio instead.
+func inventoryServer(ctx context.Context) (*mcpclient.Client, error) {
+	stock := map[string]int{"WIDGET-1": 42, "GADGET-7": 0}
+
+	srv := server.NewMCPServer("inventory", "1.0.0")
+	srv.AddTool(
+		mcp.NewTool("stock_level",
+			mcp.WithDescription("Get the number of items in stock for a SKU."),
+			mcp.WithString("sku", mcp.Required()),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			sku := strings.ToUpper(request.GetString("sku", ""))
+
+			n, ok := stock[sku]
+			if !ok {
+				return mcp.NewToolResultError("unknown SKU " + sku), nil
+			}
+
+			return mcp.NewToolResultText(fmt.Sprint(n)), nil
+		},
+	)
+
+	client, err := mcpclient.NewInProcessClient(srv)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// run runs the example and returns what it printed.
+func run(t *testing.T) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+
+	main()
+	w.Close()
+
+	return string(<-output)
+}
+
+func TestCustomTools(t *testing.T) {
+	output := run(t)
+
+	for _, want := range []string{
+		"tool: stock_level\n",
+		"tool: current_time\n",
+		"stock_level -> 42\n",
+		"current_time -> ",
+		"There are 42 WIDGET-1 in stock as of now.\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, output)
+		}
+	}
+}
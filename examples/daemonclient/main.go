@@ -0,0 +1,85 @@
+// Command daemonclient runs a task on a running `mcp-experiment serve` over
+// HTTP and follows its server-sent events until the answer arrives. Run the
+// server first:
+//
+//	mcp-experiment serve -addr 127.0.0.1:8080
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", "http://127.0.0.1:8080", "address of the server")
+	task := flag.String("task", "What is the 100th prime?", "task to run")
+	session := flag.String("session", "", "session to continue")
+	flag.Parse()
+
+	body, err := json.Marshal(map[string]string{
+		"task":       *task,
+		"session_id": *session,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resp, err := http.Post(*addr+"/tasks", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Failed to send task: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Server returned %s", resp.Status)
+	}
+
+	var event string
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		var payload map[string]any
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			log.Fatalf("Failed to decode %s event: %v", event, err)
+		}
+
+		switch event {
+		case "session":
+			fmt.Println("session", payload["session_id"])
+		case "tool_call_started":
+			fmt.Println("calling", payload["name"])
+		case "warning":
+			fmt.Println("warning:", payload["text"])
+		case "error":
+			log.Fatalf("Task failed: %v", payload["error"])
+		case "done":
+			fmt.Println(payload["answer"])
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read events: %v", err)
+	}
+	log.Fatal("The server closed the stream before the task was done")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/agent/agenttest"
+)
+
+// run runs the example and returns what it printed.
+func run(t *testing.T) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+
+	main()
+	w.Close()
+
+	return string(<-output)
+}
+
+// serveTask speaks the protocol of POST /tasks of mcp-experiment serve for
+// the events the example handles, running the task with agenttest.
+func serveTask(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Task string `json:"task"`
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/tasks" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		client, err := agenttest.NewClient(r.Context(), agenttest.Tool{
+			Name:        "sandbox_run_code",
+			Description: "Run Python code and return its output.",
+			Params:      []string{"code"},
+			Handler: func(args map[string]any) (string, error) {
+				return "541", nil
+			},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer client.Close()
+
+		a := agent.New(
+			agent.WithToolSource(client),
+			agent.WithProvider(agenttest.NewProvider(
+				agenttest.CallTools(agenttest.ToolCall{Name: "sandbox_run_code", Arguments: map[string]any{"code": "print(nth_prime(100))"}}),
+				agenttest.Finish("The 100th prime ", "length"),
+				agenttest.Reply("is 541."),
+			)),
+		)
+
+		events, err := a.Stream(r.Context(), req.Task)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(name string, data any) {
+			payload, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+			w.(http.Flusher).Flush()
+		}
+
+		send("session", map[string]any{"session_id": "test-session"})
+
+		for event := range events {
+			switch event := event.(type) {
+			case agent.TurnStarted:
+				send("turn_started", map[string]any{"turn": event.Turn})
+			case agent.ToolCallStarted:
+				send("tool_call_started", map[string]any{"id": event.ToolCall.ID, "name": event.ToolCall.Function.Name, "arguments": event.Arguments})
+			case agent.Warning:
+				send("warning", map[string]any{"text": event.Text})
+			case agent.Done:
+				if event.Err != nil {
+					send("error", map[string]any{"error": event.Err.Error()})
+					return
+				}
+				send("done", map[string]any{"session_id": "test-session", "answer": event.Result.Answer})
+			}
+		}
+	}
+}
+
+func TestDaemonClient(t *testing.T) {
+	server := httptest.NewServer(serveTask(t))
+	defer server.Close()
+
+	args := os.Args
+	os.Args = []string{args[0], "-addr", server.URL}
+	defer func() { os.Args = args }()
+
+	output := run(t)
+
+	want := "session test-session\n" +
+		"calling sandbox_run_code\n" +
+		"warning: The response hit the token limit, asking the model to continue (1/3)...\n" +
+		"The 100th prime is 541.\n"
+	if output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
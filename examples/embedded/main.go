@@ -0,0 +1,77 @@
+// Command embedded shows the agent inside another program's own UI: it
+// streams a task's events and draws them as a simple log, with the answer
+// written out as it arrives.
+//
+// It runs offline against the mock MCP server and a scripted provider from
+// agenttest. The scripted provider doesn't stream, so the answer arrives in
+// one piece; a real provider sends it token by token.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/agent/agenttest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	client, err := agenttest.NewClient(ctx, agenttest.Tool{
+		Name:        "sandbox_run_code",
+		Description: "Run Python code and return its output.",
+		Params:      []string{"code"},
+		Handler: func(args map[string]any) (string, error) {
+			return "2.718281828459045", nil
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to start mock MCP server: %v", err)
+	}
+	defer client.Close()
+
+	a := agent.New(
+		agent.WithToolSource(client),
+		agent.WithProvider(agenttest.NewProvider(
+			agenttest.CallTools(agenttest.ToolCall{Name: "sandbox_run_code", Arguments: map[string]any{"code": "import math; print(math.e)"}}),
+			agenttest.Reply("e is approximately 2.71828."),
+		)),
+		agent.WithBudget(agent.Budget{MaxTurns: 10}),
+	)
+
+	events, err := a.Stream(ctx, "What is e to 5 decimal places?")
+	if err != nil {
+		log.Fatalf("Failed to start task: %v", err)
+	}
+
+	start := time.Now()
+	streamed := false
+
+	for event := range events {
+		switch event := event.(type) {
+		case agent.TextDelta:
+			fmt.Print(event.Text)
+			streamed = true
+		case agent.AssistantText:
+			if !streamed {
+				fmt.Print(event.Text)
+			}
+			fmt.Println()
+			streamed = false
+		case agent.ToolCallStarted:
+			fmt.Printf("[%s] calling %s %v\n", time.Since(start).Round(time.Millisecond), event.ToolCall.Function.Name, event.Arguments)
+		case agent.ToolCallFinished:
+			fmt.Printf("[%s] %s returned %q\n", time.Since(start).Round(time.Millisecond), event.ToolCall.Function.Name, event.Result)
+		case agent.Warning:
+			fmt.Println("warning:", event.Text)
+		case agent.Done:
+			if event.Err != nil {
+				log.Fatalf("Task failed: %v", event.Err)
+			}
+			fmt.Printf("[%s] done, %s\n", time.Since(start).Round(time.Millisecond), a.Usage)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// run runs the example and returns what it printed.
+func run(t *testing.T) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+
+	main()
+	w.Close()
+
+	return string(<-output)
+}
+
+func TestEmbedded(t *testing.T) {
+	output := run(t)
+
+	for _, want := range []string{
+		"calling sandbox_run_code map[code:import math; print(math.e)]",
+		`sandbox_run_code returned "2.718281828459045"`,
+		"e is approximately 2.71828.\n",
+		"done, ",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, output)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Command headless runs one task without any UI and prints the answer and
+// usage as JSON, the way a script or CI job would use the agent.
+//
+// It runs against the mock MCP server and a scripted provider from
+// agenttest, so it works offline. Set OPENAI_API_KEY (and OPENAI_BASE_URL
+// for OpenRouter and the like) and -mcp to run it for real.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/cedws/mcp-experiment/agent/agenttest"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+)
+
+func main() {
+	task := flag.String("task", "What is the 100th prime?", "task to run")
+	model := flag.String("model", "google/gemini-2.5-flash", "model to use")
+	mcpURL := flag.String("mcp", "", "URL of a streamable HTTP MCP server (the mock server if empty)")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	var opts []agent.Option
+
+	if *mcpURL == "" {
+		client, err := agenttest.NewClient(ctx, sandbox)
+		if err != nil {
+			log.Fatalf("Failed to start mock MCP server: %v", err)
+		}
+		defer client.Close()
+
+		opts = append(opts,
+			agent.WithToolSource(client),
+			agent.WithProvider(agenttest.NewProvider(
+				agenttest.CallTools(agenttest.ToolCall{Name: "sandbox_run_code", Arguments: map[string]any{"code": "print(nth_prime(100))"}}),
+				agenttest.Reply("The 100th prime is 541."),
+			)),
+		)
+	} else {
+		client, err := mcpclient.NewStreamableHttpClient(*mcpURL)
+		if err != nil {
+			log.Fatalf("Failed to create MCP client: %v", err)
+		}
+		defer client.Close()
+
+		if err := client.Start(ctx); err != nil {
+			log.Fatalf("Failed to start MCP client: %v", err)
+		}
+
+		opts = append(opts, agent.WithToolSource(client))
+	}
+
+	a := agent.New(append(opts, agent.WithModel(*model))...)
+
+	result, err := a.Run(ctx, *task)
+	if err != nil {
+		log.Fatalf("Failed to run agent: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]any{
+		"answer": result.Answer,
+		"usage":  a.Usage,
+	})
+}
+
+var sandbox = agenttest.Tool{
+	Name:        "sandbox_run_code",
+	Description: "Run Python code and return its output.",
+	Params:      []string{"code"},
+	Handler: func(args map[string]any) (string, error) {
+		return "541", nil
+	},
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+// run runs the example and returns what it printed.
+func run(t *testing.T) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+
+	main()
+	w.Close()
+
+	return string(<-output)
+}
+
+func TestHeadless(t *testing.T) {
+	args := os.Args
+	os.Args = args[:1]
+	defer func() { os.Args = args }()
+
+	var output struct {
+		Answer string `json:"answer"`
+		Usage  struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(run(t)), &output); err != nil {
+		t.Fatalf("output isn't JSON: %v", err)
+	}
+
+	if want := "The 100th prime is 541."; output.Answer != want {
+		t.Errorf("answer = %q, want %q", output.Answer, want)
+	}
+	if output.Usage.PromptTokens != 20 || output.Usage.CompletionTokens != 10 {
+		t.Errorf("usage = %+v, want two turns of it", output.Usage)
+	}
+}
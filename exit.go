@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/huh"
+)
+
+// Exit codes, so scripts can tell why a run failed. 2 is left to the flag
+// package for invalid flags.
+const (
+	exitFailure       = 1
+	exitLLM           = 3
+	exitMCPConnection = 4
+	exitTool          = 5
+	exitBudget        = 6
+	exitLoop          = 7
+	exitAborted       = 130
+)
+
+var (
+	errMCPConnection = errors.New("failed to connect to the MCP server")
+	errFetchModels   = errors.New("failed to fetch models")
+)
+
+// classifyError returns the class of the error a run failed with, and the
+// code to exit with.
+func classifyError(err error) (string, int) {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, huh.ErrUserAborted), errors.Is(err, agent.ErrTripwire), errors.Is(err, agent.ErrGuardrail):
+		return "aborted", exitAborted
+	case errors.Is(err, agent.ErrBudgetExceeded):
+		return "budget_exceeded", exitBudget
+	case errors.Is(err, agent.ErrLoop):
+		return "loop", exitLoop
+	case errors.Is(err, errMCPConnection), errors.Is(err, agent.ErrServerUnreachable):
+		return "mcp_connection", exitMCPConnection
+	case errors.Is(err, agent.ErrCompletion), errors.Is(err, errFetchModels):
+		return "llm_api", exitLLM
+	case errors.Is(err, agent.ErrUnsupportedModel):
+		return "unsupported_model", exitLLM
+	case errors.Is(err, agent.ErrToolCall):
+		return "tool", exitTool
+	default:
+		return "error", exitFailure
+	}
+}
+
+// fatal reports err on stderr, as JSON with -output json, and exits with the
+// code of its class.
+func fatal(cfg *config, err error) {
+	class, code := classifyError(err)
+
+	if cfg.Output.Format == "json" {
+		json.NewEncoder(os.Stderr).Encode(map[string]any{
+			"error": map[string]any{
+				"class":     class,
+				"message":   err.Error(),
+				"exit_code": code,
+			},
+		})
+	} else {
+		fmt.Fprintln(os.Stderr, tr("Error (%s): %v", class, err))
+	}
+
+	os.Exit(code)
+}
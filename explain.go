@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+const explainPrompt = "Below is the transcript of an AI agent's run, with its tool calls and their results. " +
+	"Write a short postmortem in Markdown for someone who didn't watch it: what the agent was asked, what it tried, " +
+	"what failed and why, and what the final approach and answer were. Be concrete and brief; don't restate every step."
+
+// maxExplainResult is how much of each tool result goes into the transcript.
+const maxExplainResult = 2000
+
+// explainSession asks a model to narrate a session's run as a postmortem.
+func explainSession(ctx context.Context, b *backend, s *session, model string) (string, error) {
+	narrator := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(model),
+	)
+
+	result, err := narrator.Run(ctx, explainPrompt+"\n\n"+sessionTranscript(s))
+	if err != nil {
+		return "", err
+	}
+
+	return result.Answer, nil
+}
+
+// sessionTranscript writes a session's messages as plain text, with long
+// tool results cut short.
+func sessionTranscript(s *session) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Model: %s\nUsage: %s\nTurns: %d\n", s.Model, s.Usage, len(s.Turns))
+
+	for _, message := range s.Messages {
+		switch {
+		case message.OfUser != nil:
+			fmt.Fprintf(&sb, "\n## User\n%s\n", message.OfUser.Content.OfString.Value)
+		case message.OfAssistant != nil:
+			if content := message.OfAssistant.Content.OfString.Value; content != "" {
+				fmt.Fprintf(&sb, "\n## Assistant\n%s\n", content)
+			}
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				fmt.Fprintf(&sb, "\n## Tool call %s\n%s\n", toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+		case message.OfTool != nil:
+			result := message.OfTool.Content.OfString.Value
+			if len(result) > maxExplainResult {
+				result = result[:cutAt(result, maxExplainResult)] + fmt.Sprintf("\n[… %d more characters]", len(result)-maxExplainResult)
+			}
+			fmt.Fprintf(&sb, "\n## Tool result\n%s\n", result)
+		}
+	}
+
+	for i, turn := range s.Turns {
+		if turn.Fallback {
+			fmt.Fprintf(&sb, "\nNote: completion %d failed and was made by the fallback model %s.\n", i+1, turn.Model)
+		}
+		if turn.FinishReason != "" && turn.FinishReason != "stop" && turn.FinishReason != "tool_calls" {
+			fmt.Fprintf(&sb, "\nNote: completion %d finished with %q.\n", i+1, turn.FinishReason)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// htmlPage is what a session is exported to HTML as.
+type htmlPage struct {
+	Title    string
+	Session  *session
+	Usage    []htmlUsage
+	Calls    int
+	Entries  []htmlEntry
+	CSS      template.CSS
+	Exported time.Time
+}
+
+// htmlUsage is the usage of one model in a session.
+type htmlUsage struct {
+	Model       string
+	Completions int
+	Usage       string
+}
+
+// htmlEntry is a task, an answer, or a tool call with its arguments and
+// result.
+type htmlEntry struct {
+	Kind      string
+	Content   template.HTML
+	Tool      string
+	Arguments template.HTML
+	Result    template.HTML
+	Lines     int
+	Duration  time.Duration
+	Error     string
+	Failed    bool
+}
+
+var htmlMarkdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// exportHTML turns a session into a standalone HTML page: tasks, answers
+// rendered from Markdown, tool calls with highlighted code or arguments and
+// collapsible results, and usage by model. Secrets are redacted if enabled.
+func exportHTML(s *session, codeTools map[string]codeToolConfig, codeStyle string) ([]byte, error) {
+	style := styles.Get(cmp.Or(codeStyle, "github"))
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(false))
+
+	var css bytes.Buffer
+	if err := formatter.WriteCSS(&css, style); err != nil {
+		return nil, err
+	}
+
+	highlight := func(code, language string) template.HTML {
+		lexer := cmp.Or(lexers.Get(language), lexers.Fallback)
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+		if err != nil {
+			return template.HTML("<pre>" + template.HTMLEscapeString(code) + "</pre>")
+		}
+
+		var buf bytes.Buffer
+		if err := formatter.Format(&buf, style, iterator); err != nil {
+			return template.HTML("<pre>" + template.HTMLEscapeString(code) + "</pre>")
+		}
+
+		return template.HTML(buf.String())
+	}
+
+	results := make(map[string]string)
+	for _, message := range s.Messages {
+		if message.OfTool != nil {
+			results[message.OfTool.ToolCallID] = message.OfTool.Content.OfString.Value
+		}
+	}
+
+	// Summarized tool outputs are exported in full.
+	for id, output := range s.RawOutputs {
+		results[id] = output
+	}
+
+	page := htmlPage{
+		Title:    s.title(),
+		Session:  s,
+		Usage:    usageByModel(s.Turns),
+		CSS:      template.CSS(css.String()),
+		Exported: time.Now(),
+	}
+
+	for _, message := range s.Messages {
+		switch {
+		case message.OfUser != nil:
+			page.Entries = append(page.Entries, htmlEntry{
+				Kind:    "task",
+				Content: template.HTML(template.HTMLEscapeString(redact(message.OfUser.Content.OfString.Value))),
+			})
+		case message.OfAssistant != nil:
+			if content := message.OfAssistant.Content.OfString.Value; strings.TrimSpace(content) != "" {
+				var buf bytes.Buffer
+				if err := htmlMarkdown.Convert([]byte(redact(content)), &buf); err != nil {
+					return nil, fmt.Errorf("failed to render answer: %v", err)
+				}
+
+				page.Entries = append(page.Entries, htmlEntry{
+					Kind:    "answer",
+					Content: template.HTML(buf.String()),
+				})
+			}
+
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				page.Calls++
+
+				entry := htmlEntry{
+					Kind: "call",
+					Tool: toolCall.Function.Name,
+				}
+
+				arguments := redact(toolCall.Function.Arguments)
+
+				var args map[string]any
+				if err := json.Unmarshal([]byte(arguments), &args); err == nil {
+					if code, language, ok := toolCode(codeTools, toolCall.Function.Name, args); ok {
+						entry.Arguments = highlight(code, language)
+					} else if pretty, err := json.MarshalIndent(args, "", "  "); err == nil {
+						entry.Arguments = highlight(string(pretty), "json")
+					}
+				}
+				if entry.Arguments == "" {
+					entry.Arguments = highlight(arguments, "text")
+				}
+
+				if result := redact(results[toolCall.ID]); result != "" {
+					entry.Lines = strings.Count(strings.TrimRight(result, "\n"), "\n") + 1
+
+					if detectMIMEType(result) == "application/json" {
+						var buf bytes.Buffer
+						if json.Indent(&buf, []byte(strings.TrimSpace(result)), "", "  ") == nil {
+							result = buf.String()
+						}
+						entry.Result = highlight(result, "json")
+					} else {
+						entry.Result = highlight(result, "text")
+					}
+				}
+
+				if stats, ok := s.ToolCalls[toolCall.ID]; ok {
+					entry.Duration = time.Duration(stats.DurationMS) * time.Millisecond
+					entry.Error = redact(stats.Error)
+					entry.Failed = stats.Failed
+				}
+
+				page.Entries = append(page.Entries, entry)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSessionHTML exports a session to HTML at path.
+func writeSessionHTML(cfg *config, s *session, path string) error {
+	data, err := exportHTML(s, cfg.Output.CodeTools, cfg.Theme.CodeStyle)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// usageByModel adds up the usage of turns by the model that made them, most
+// used first.
+func usageByModel(turns []agent.Turn) []htmlUsage {
+	var (
+		models []string
+		counts = make(map[string]int)
+		usage  = make(map[string]agent.Usage)
+	)
+
+	for _, turn := range turns {
+		if _, ok := usage[turn.Model]; !ok {
+			models = append(models, turn.Model)
+		}
+
+		counts[turn.Model]++
+
+		total := usage[turn.Model]
+		total.PromptTokens += turn.Usage.PromptTokens
+		total.CompletionTokens += turn.Usage.CompletionTokens
+		total.Cost += turn.Usage.Cost
+		usage[turn.Model] = total
+	}
+
+	slices.SortStableFunc(models, func(a, b string) int {
+		return counts[b] - counts[a]
+	})
+
+	rows := make([]htmlUsage, len(models))
+	for i, model := range models {
+		rows[i] = htmlUsage{
+			Model:       model,
+			Completions: counts[model],
+			Usage:       usage[model].String(),
+		}
+	}
+
+	return rows
+}
+
+var htmlTemplate = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 56rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; line-height: 1.5; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1.5rem; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.25rem 1rem; }
+dt { color: #656d76; }
+dd { margin: 0; }
+table { border-collapse: collapse; margin: 1rem 0; }
+th, td { border: 1px solid #d0d7de; padding: 0.25rem 0.75rem; text-align: left; }
+pre { padding: 0.75rem; overflow-x: auto; border-radius: 6px; font-size: 0.85rem; white-space: pre-wrap; word-break: break-word; }
+.task { background: #ddf4ff; border-radius: 6px; padding: 0.75rem 1rem; margin: 1.5rem 0 1rem; white-space: pre-wrap; }
+.answer { margin: 1rem 0; }
+.answer pre { background: #f6f8fa; }
+details.call { border: 1px solid #d0d7de; border-radius: 6px; padding: 0.5rem 1rem; margin: 0.75rem 0; }
+details.call.failed { border-color: #cf222e; }
+summary { cursor: pointer; }
+summary code { font-weight: 600; }
+.meta { color: #656d76; font-size: 0.85rem; }
+.error { color: #cf222e; }
+{{.CSS}}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<dl>
+<dt>Session</dt><dd>{{.Session.ID}}</dd>
+<dt>Model</dt><dd>{{.Session.Model}}</dd>
+<dt>Started</dt><dd>{{.Session.Created.Format "2006-01-02 15:04"}}</dd>
+<dt>Updated</dt><dd>{{.Session.Updated.Format "2006-01-02 15:04"}}</dd>
+<dt>Completions</dt><dd>{{len .Session.Turns}}</dd>
+<dt>Tool calls</dt><dd>{{.Calls}}</dd>
+<dt>Usage</dt><dd>{{.Session.Usage}}</dd>
+</dl>
+{{- if gt (len .Usage) 1}}
+<table>
+<tr><th>Model</th><th>Completions</th><th>Usage</th></tr>
+{{- range .Usage}}
+<tr><td>{{.Model}}</td><td>{{.Completions}}</td><td>{{.Usage}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Session.Runs}}
+<details>
+<summary class="meta">Runs</summary>
+<table>
+<tr><th>Started</th><th>Model</th><th>Sampling</th><th>Prompts</th><th>Tools</th><th>Config</th><th>Binary</th></tr>
+{{- range .Session.Runs}}
+<tr><td>{{.Time.Format "2006-01-02 15:04"}}</td><td>{{.Model}}</td><td>{{.Sampling}}</td><td>{{with .PromptProfile}}{{.}} {{end}}<code>{{.PromptHash}}</code></td><td><code>{{.ToolsHash}}</code></td><td><code>{{.ConfigHash}}</code></td><td>{{.BinaryVersion}}</td></tr>
+{{- end}}
+</table>
+</details>
+{{- end}}
+</header>
+<main>
+{{- range .Entries}}
+{{- if eq .Kind "task"}}
+<div class="task">{{.Content}}</div>
+{{- else if eq .Kind "answer"}}
+<div class="answer">{{.Content}}</div>
+{{- else}}
+<details class="call{{if .Failed}} failed{{end}}">
+<summary><code>{{.Tool}}</code>{{if .Duration}} <span class="meta">{{.Duration}}</span>{{end}}{{if .Failed}} <span class="error">failed</span>{{end}}</summary>
+{{.Arguments}}
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+{{- end}}
+{{- if .Result}}
+<details>
+<summary class="meta">Result, {{.Lines}} line{{if ne .Lines 1}}s{{end}}</summary>
+{{.Result}}
+</details>
+{{- end}}
+</details>
+{{- end}}
+{{- end}}
+</main>
+<footer class="meta">
+<p>Exported {{.Exported.Format "2006-01-02 15:04"}}</p>
+</footer>
+</body>
+</html>
+`))
@@ -0,0 +1,24 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package main
+
+import "os"
+
+// Without file locks, only one process at a time can safely use the same
+// files.
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func lockFileShared(f *os.File) error {
+	return nil
+}
+
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile takes an exclusive lock on f, waiting for other processes to
+// release theirs.
+func lockFile(f *os.File) error {
+	return lockFileEx(f, lockfileExclusiveLock)
+}
+
+// lockFileShared takes a lock on f that other processes can share, but that
+// keeps exclusive locks out.
+func lockFileShared(f *os.File) error {
+	return lockFileEx(f, 0)
+}
+
+// tryLockFile takes an exclusive lock on f if no other process holds one,
+// reporting whether it did.
+func tryLockFile(f *os.File) (bool, error) {
+	err := lockFileEx(f, lockfileExclusiveLock|lockfileFailImmediately)
+	if err == errorLockViolation {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	var overlapped syscall.Overlapped
+	if ok, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped))); ok == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// lockFileEx locks the first byte of f, which stands for the whole file.
+func lockFileEx(f *os.File, flags uintptr) error {
+	var overlapped syscall.Overlapped
+	if ok, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped))); ok == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// runGHA runs a single task non-interactively, reporting progress as GitHub
+// Actions workflow commands and the answer as a step summary.
+func runGHA(ctx context.Context, cfg *config, a *agent.Agent, sess *session, task string) error {
+	if task == "" {
+		return fmt.Errorf("-task is required with -output gha")
+	}
+
+	r := newREPL(cfg, sess, a)
+	r.render = renderGHAEvent
+	r.notify = notifier(cfg)
+
+	result, err := r.runTask(ctx, task)
+	if err != nil {
+		return err
+	}
+
+	return writeStepSummary(result, sess)
+}
+
+func renderGHAEvent(event agent.Event) {
+	switch event := event.(type) {
+	case agent.AssistantText:
+		ghaCommand("notice", "Answer", event.Text)
+	case agent.ToolCallStarted:
+		fmt.Printf("::group::%s\n", ghaEscape(event.ToolCall.Function.Name))
+		if code, ok := event.Arguments["code"].(string); ok {
+			fmt.Println(code)
+		} else {
+			fmt.Println(event.ToolCall.Function.Arguments)
+		}
+		fmt.Println("::endgroup::")
+	case agent.ToolCallFinished:
+		if event.Err != nil {
+			ghaCommand("error", "Tool "+event.ToolCall.Function.Name, event.Err.Error())
+		}
+	case agent.Warning:
+		ghaCommand("warning", "", event.Text)
+	case agent.Error:
+		ghaCommand("error", "Agent", event.Err.Error())
+	}
+}
+
+func ghaCommand(name, title, message string) {
+	if title != "" {
+		fmt.Printf("::%s title=%s::%s\n", name, ghaEscapeProperty(title), ghaEscape(message))
+	} else {
+		fmt.Printf("::%s::%s\n", name, ghaEscape(message))
+	}
+}
+
+func ghaEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func ghaEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// writeStepSummary appends the answer to the file named by
+// GITHUB_STEP_SUMMARY. It does nothing outside of GitHub Actions.
+func writeStepSummary(result *agent.Result, sess *session) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open step summary: %v", err)
+	}
+	defer f.Close()
+
+	answer := result.Answer
+	if slices.ContainsFunc(result.Events, isStructuredAnswer) {
+		answer = "```json\n" + answer + "\n```"
+	}
+
+	fmt.Fprintf(f, "## Answer\n\n%s\n\n", answer)
+	fmt.Fprintf(f, "<sub>Model: `%s` · %s · session `%s`</sub>\n", sess.Model, sess.Usage, sess.ID)
+
+	return nil
+}
+
+func isStructuredAnswer(event agent.Event) bool {
+	text, ok := event.(agent.AssistantText)
+	return ok && text.Structured
+}
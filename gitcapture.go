@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// gitConfig commits the transcript and artifacts of every task to Branch of
+// the repository in the working directory, under Path, so they can be
+// reviewed like any other change. The commit message is written by Model, the
+// session's model if empty.
+type gitConfig struct {
+	Branch string `json:"branch,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+const commitMessagePrompt = "Below is the transcript of an AI agent's task, whose transcript and output files are being committed to git. " +
+	"Write the commit message: a subject line of at most 72 characters in the imperative mood summarizing what was done, " +
+	"a blank line, and a few lines on what was asked, what the agent found or produced and anything left unresolved. " +
+	"Reply with the message only, without Markdown fences."
+
+// commitMessage asks a model to write the message of the commit capturing a
+// task.
+func (b *backend) commitMessage(ctx context.Context, model, task string, result *agent.Result) (string, error) {
+	writer := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(model),
+	)
+
+	answer, err := writer.Run(ctx, commitMessagePrompt+"\n\n"+verificationTranscript(task, result))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(answer.Answer), nil
+}
+
+// commitResults commits the transcript of the session and its artifacts to
+// the configured branch, without touching the working tree or the index.
+func (r *repl) commitResults(ctx context.Context, task string, result *agent.Result) error {
+	git := r.cfg.Git
+
+	transcript, err := exportHTML(r.sess, r.cfg.Output.CodeTools, r.cfg.Theme.CodeStyle)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(cmp.Or(git.Path, "agent-runs"), r.sess.ID)
+	files := map[string][]byte{
+		path.Join(dir, "transcript.html"): []byte(redact(string(transcript))),
+	}
+
+	artifacts, err := r.committedArtifacts()
+	if err != nil {
+		return err
+	}
+	for name, data := range artifacts {
+		files[path.Join(dir, "artifacts", name)] = data
+	}
+
+	subject := strings.TrimSpace(strings.SplitN(task, "\n", 2)[0])
+	if runes := []rune(subject); len(runes) > 60 {
+		subject = string(runes[:60]) + "…"
+	}
+	message := "Agent run: " + subject
+
+	if r.commitMessage != nil {
+		written, err := r.commitMessage(ctx, cmp.Or(git.Model, r.agent.Model), task, result)
+		if err != nil {
+			r.warn("Failed to write the commit message: %v", err)
+		} else if written != "" {
+			message = written
+		}
+	}
+	message = redact(message) + "\n\nSession: " + r.sess.ID + "\n"
+
+	commit, err := commitToBranch(ctx, r.sess.Workspace, git.Branch, files, message)
+	if err != nil {
+		return err
+	}
+	if commit == "" {
+		return nil
+	}
+
+	r.print("%s", statusStyle.Render(tr("Committed the results to %s (%s)", git.Branch, commit[:min(len(commit), 12)])))
+
+	return nil
+}
+
+// secretFilePattern matches the names of files that commonly hold
+// credentials.
+var secretFilePattern = regexp.MustCompile(`(?i)^(\.env(\..*)?|\.netrc|\.npmrc|\.pypirc|id_(rsa|dsa|ecdsa|ed25519)|credentials(\..*)?|.*\.(pem|key|p12|pfx|kdbx))$`)
+
+// committedArtifacts returns the artifacts of the session worth committing, by
+// name: data returned by tools, and files found in the scratch directory or
+// the workspace, so nothing else on the machine ends up in git history. Text
+// is redacted, and files named like they hold credentials are left out.
+func (r *repl) committedArtifacts() (map[string][]byte, error) {
+	dir, err := artifactsDir(r.sess)
+	if err != nil {
+		return nil, err
+	}
+	workdir, err := r.sess.workdir()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readArtifactIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for _, a := range index {
+		if a.Path != "" && !inDirs(a.Path, workdir, r.sess.Workspace) {
+			continue
+		}
+		if secretFilePattern.MatchString(a.File) || (a.Path != "" && secretFilePattern.MatchString(filepath.Base(a.Path))) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, a.File))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if utf8.Valid(data) {
+			data = []byte(redact(string(data)))
+		}
+		files[a.File] = data
+	}
+
+	return files, nil
+}
+
+// commitToBranch commits files, by path from the root of the repository in
+// dir, on top of branch, or HEAD if it doesn't exist yet. It uses an index of
+// its own, so the working tree and the index are left alone. It returns the
+// commit, or nothing if the files were already committed as they are.
+func commitToBranch(ctx context.Context, dir, branch string, files map[string][]byte, message string) (string, error) {
+	tmp, err := os.MkdirTemp("", "mcp-experiment-git-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	git := func(stdin []byte, args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(tmp, "index"))
+		if stdin != nil {
+			cmd.Stdin = bytes.NewReader(stdin)
+		}
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	ref := "refs/heads/" + branch
+	if _, err := git(nil, "check-ref-format", ref); err != nil {
+		return "", fmt.Errorf("invalid branch name %q", branch)
+	}
+
+	old, _ := git(nil, "rev-parse", "--verify", "--quiet", ref)
+	parent := old
+	if parent == "" {
+		parent, _ = git(nil, "rev-parse", "--verify", "--quiet", "HEAD")
+	}
+
+	if parent != "" {
+		_, err = git(nil, "read-tree", parent)
+	} else {
+		_, err = git(nil, "read-tree", "--empty")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for name, data := range files {
+		object, err := git(data, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		if _, err := git(nil, "update-index", "--add", "--cacheinfo", "100644,"+object+","+name); err != nil {
+			return "", err
+		}
+	}
+
+	tree, err := git(nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"commit-tree", tree}
+	if parent != "" {
+		if parentTree, _ := git(nil, "rev-parse", parent+"^{tree}"); parentTree == tree && old != "" {
+			return "", nil
+		}
+		args = append(args, "-p", parent)
+	}
+
+	commit, err := git([]byte(message), append(args, "-F", "-")...)
+	if err != nil {
+		return "", err
+	}
+
+	update := []string{"update-ref", "-m", "mcp-experiment: commit results", ref, commit}
+	if old != "" {
+		update = append(update, old)
+	}
+	if _, err := git(nil, update...); err != nil {
+		return "", err
+	}
+
+	return commit, nil
+}
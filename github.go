@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+type githubClient struct {
+	httpClient *http.Client
+	token      string
+	repo       string
+}
+
+type githubIssue struct {
+	Number      int           `json:"number"`
+	Title       string        `json:"title"`
+	Body        string        `json:"body"`
+	State       string        `json:"state"`
+	User        githubUser    `json:"user"`
+	Labels      []githubLabel `json:"labels"`
+	PullRequest *struct{}     `json:"pull_request,omitempty"`
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubLabel struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type githubComment struct {
+	User githubUser `json:"user"`
+	Body string     `json:"body"`
+}
+
+type githubFile struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+	Patch    string `json:"patch"`
+}
+
+type githubContent struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+func (c *githubClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, githubAPIURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *githubClient) issue(ctx context.Context, number int) (*githubIssue, error) {
+	var issue githubIssue
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d", c.repo, number), nil, &issue); err != nil {
+		return nil, err
+	}
+
+	return &issue, nil
+}
+
+func (c *githubClient) comments(ctx context.Context, number int) ([]githubComment, error) {
+	var comments []githubComment
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", c.repo, number), nil, &comments)
+
+	return comments, err
+}
+
+func (c *githubClient) pullFiles(ctx context.Context, number int) ([]githubFile, error) {
+	var files []githubFile
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/pulls/%d/files?per_page=100", c.repo, number), nil, &files)
+
+	return files, err
+}
+
+func (c *githubClient) labels(ctx context.Context) ([]githubLabel, error) {
+	var labels []githubLabel
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/labels?per_page=100", c.repo), nil, &labels)
+
+	return labels, err
+}
+
+func (c *githubClient) contents(ctx context.Context, path string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/contents/%s", c.repo, (&url.URL{Path: path}).EscapedPath()), nil, &raw)
+
+	return raw, err
+}
+
+func (c *githubClient) comment(ctx context.Context, number int, body string) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", c.repo, number), map[string]string{"body": body}, nil)
+}
+
+func (c *githubClient) addLabels(ctx context.Context, number int, labels []string) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/labels", c.repo, number), map[string][]string{"labels": labels}, nil)
+}
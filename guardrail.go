@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// guardrailsConfig checks responses before they are shown or their tool calls
+// run: with regular expressions, and a classifier model if set. Matches are
+// flagged, or blocked unless the user allows them.
+type guardrailsConfig struct {
+	Rules            []guardrailConfig `json:"rules,omitempty"`
+	Classifier       string            `json:"classifier,omitempty"`
+	ClassifierAction string            `json:"classifier_action,omitempty"`
+}
+
+type guardrailConfig struct {
+	Name    string `json:"name,omitempty"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action,omitempty"`
+}
+
+func (g guardrailsConfig) compile() (agent.Guardrails, error) {
+	classifierAction, err := guardrailAction(g.ClassifierAction)
+	if err != nil {
+		return agent.Guardrails{}, err
+	}
+
+	guardrails := agent.Guardrails{
+		Classifier:       g.Classifier,
+		ClassifierAction: classifierAction,
+	}
+
+	for _, cfg := range g.Rules {
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return agent.Guardrails{}, fmt.Errorf("invalid guardrail %q: %v", cfg.Pattern, err)
+		}
+
+		action, err := guardrailAction(cfg.Action)
+		if err != nil {
+			return agent.Guardrails{}, err
+		}
+
+		guardrails.Rules = append(guardrails.Rules, agent.Guardrail{Name: cfg.Name, Pattern: pattern, Action: action})
+	}
+
+	return guardrails, nil
+}
+
+func guardrailAction(action string) (agent.GuardrailAction, error) {
+	switch action := agent.GuardrailAction(cmp.Or(action, string(agent.GuardrailBlock))); action {
+	case agent.GuardrailBlock, agent.GuardrailFlag:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown guardrail action %q, expected block or flag", action)
+	}
+}
+
+// overrideGuardrail asks the user whether a response a guardrail blocked may
+// be shown and its tool calls run anyway.
+func (r *repl) overrideGuardrail(ctx context.Context, tripped agent.GuardrailTripped) (bool, error) {
+	q := approvalQuestion{
+		title:  tr("Allow the response anyway?"),
+		reason: tr("Guardrail: %s", tripped.String()),
+		fixed:  true,
+	}
+
+	var (
+		allowed bool
+		err     error
+	)
+
+	done := make(chan struct{})
+	ask := func() {
+		defer close(done)
+		_, allowed, err = r.askApproval(ctx, q)
+	}
+
+	select {
+	case r.approvals <- ask:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	<-done
+
+	return allowed, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const historyFile = "history.jsonl"
+
+// history is the tasks and commands entered interactively, oldest first. It
+// is stored as one JSON string per line, as tasks can span several lines,
+// each encrypted if encryption is enabled.
+type history struct {
+	path    string
+	size    int
+	entries []string
+}
+
+// loadHistory reads the history, keeping at most size entries. A size of
+// zero disables history.
+func loadHistory(size int) (*history, error) {
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &history{path: filepath.Join(dir, historyFile), size: size}
+	if size <= 0 {
+		return h, nil
+	}
+
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		line, err := decrypt(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+
+		var entry string
+		if err := json.Unmarshal(line, &entry); err == nil {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(h.entries) > size {
+		h.entries = h.entries[len(h.entries)-size:]
+	}
+
+	return h, nil
+}
+
+// add appends an entry, unless it repeats the last one. The file is
+// rewritten when it grows past twice the size, to drop old entries.
+func (h *history) add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if h.size <= 0 || entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return nil
+	}
+
+	h.entries = append(h.entries, entry)
+
+	if len(h.entries) > 2*h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+		return h.write()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := historyLine(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(line)
+	return err
+}
+
+func (h *history) write() error {
+	var data []byte
+
+	for _, entry := range h.entries {
+		line, err := historyLine(entry)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+	}
+
+	return os.WriteFile(h.path, data, 0o600)
+}
+
+// historyLine encodes an entry as a line of the file, encrypted if
+// encryption is enabled.
+func historyLine(entry string) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(encrypt(data), '\n'), nil
+}
+
+// search returns the index of the newest entry before index containing
+// query, or -1.
+func (h *history) search(query string, before int) int {
+	for i := min(before, len(h.entries)) - 1; i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// hooksConfig lists shell commands run at points of a run, which are given
+// the event as JSON on stdin. A pre-tool-call hook that fails blocks the
+// call, and what it printed is the reason the model is given.
+type hooksConfig struct {
+	PreToolCall  []string `json:"pre_tool_call,omitempty"`
+	PostToolCall []string `json:"post_tool_call,omitempty"`
+	OnComplete   []string `json:"on_complete,omitempty"`
+	OnError      []string `json:"on_error,omitempty"`
+
+	// Timeout is how long a hook may run, 30s by default. Pre-tool-call
+	// hooks that time out block the call.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+var hookEvents = []string{"pre-tool-call", "post-tool-call", "on-complete", "on-error"}
+
+const defaultHookTimeout = 30 * time.Second
+
+// add adds a hook given as event=command.
+func (h *hooksConfig) add(spec string) error {
+	event, command, ok := strings.Cut(spec, "=")
+	if !ok || command == "" {
+		return fmt.Errorf("expected event=command")
+	}
+
+	switch event {
+	case "pre-tool-call":
+		h.PreToolCall = append(h.PreToolCall, command)
+	case "post-tool-call":
+		h.PostToolCall = append(h.PostToolCall, command)
+	case "on-complete":
+		h.OnComplete = append(h.OnComplete, command)
+	case "on-error":
+		h.OnError = append(h.OnError, command)
+	default:
+		return fmt.Errorf("unknown hook %q, expected one of %s", event, strings.Join(hookEvents, ", "))
+	}
+
+	return nil
+}
+
+func (h hooksConfig) validate() error {
+	if h.Timeout == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(h.Timeout); err != nil {
+		return fmt.Errorf("invalid hook timeout: %v", err)
+	}
+
+	return nil
+}
+
+func (h hooksConfig) timeout() time.Duration {
+	timeout, err := time.ParseDuration(h.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultHookTimeout
+	}
+
+	return timeout
+}
+
+// hookEvent is what hooks get on stdin. Arguments, results and answers are
+// redacted if enabled.
+type hookEvent struct {
+	Event string `json:"event"`
+
+	Tool       string         `json:"tool,omitempty"`
+	CallID     string         `json:"call_id,omitempty"`
+	Server     string         `json:"server,omitempty"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Result     string         `json:"result,omitempty"`
+	Failed     bool           `json:"failed,omitempty"`
+	DurationMS int64          `json:"duration_ms,omitempty"`
+	Approval   string         `json:"approval,omitempty"`
+
+	Task       string       `json:"task,omitempty"`
+	Answer     string       `json:"answer,omitempty"`
+	Usage      *agent.Usage `json:"usage,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	ErrorClass string       `json:"error_class,omitempty"`
+}
+
+// setToolCallHooks has the agent run the policy script and then the
+// pre-tool-call hooks before tool calls, and the script after them.
+func setToolCallHooks(a *agent.Agent, cfg *config) error {
+	var script *policyScript
+	if cfg.PolicyScript != "" {
+		var err error
+		if script, err = loadPolicyScript(cfg.PolicyScript); err != nil {
+			return err
+		}
+	}
+
+	hooks := cfg.Hooks
+	if (script != nil && script.pre != nil) || len(hooks.PreToolCall) > 0 {
+		a.PreToolCall = func(ctx context.Context, call agent.ToolCallStarted) (map[string]any, string, error) {
+			var rewritten map[string]any
+
+			if script != nil && script.pre != nil {
+				args, reason, err := script.preToolCall(ctx, call)
+				if err != nil || reason != "" {
+					return nil, reason, err
+				}
+				if args != nil {
+					rewritten, call.Arguments = args, args
+				}
+			}
+
+			reason, err := hooks.preToolCall(ctx, call)
+			return rewritten, reason, err
+		}
+	}
+
+	if script != nil && script.post != nil {
+		a.PostToolCall = script.postToolCall
+	}
+
+	return nil
+}
+
+// preToolCall runs the pre-tool-call hooks, returning why the call is
+// blocked if one of them fails.
+func (h hooksConfig) preToolCall(ctx context.Context, call agent.ToolCallStarted) (string, error) {
+	arguments, _ := redactValue(call.Arguments).(map[string]any)
+
+	event := hookEvent{
+		Event:     "pre-tool-call",
+		Tool:      call.ToolCall.Function.Name,
+		CallID:    call.ToolCall.ID,
+		Arguments: arguments,
+	}
+
+	for _, command := range h.PreToolCall {
+		if output, err := h.run(ctx, command, event); err != nil {
+			return cmp.Or(output, fmt.Sprintf("the hook %q failed: %v", command, err)), nil
+		}
+	}
+
+	return "", nil
+}
+
+// hookQueue runs the post-tool-call hooks of an agent's calls in the
+// background, one call after another in order, so slow hooks hold up neither
+// the agent nor other observers.
+type hookQueue struct {
+	hooks hooksConfig
+
+	mu      sync.Mutex
+	events  []hookEvent
+	running bool
+}
+
+// pendingHooks counts the post-tool-call hooks queued or running, for the
+// process to wait for before it exits.
+var pendingHooks sync.WaitGroup
+
+func newHookQueue(hooks hooksConfig) *hookQueue {
+	return &hookQueue{hooks: hooks}
+}
+
+// observe queues the post-tool-call hooks after every tool call.
+func (q *hookQueue) observe(e agent.Event) {
+	finished, ok := e.(agent.ToolCallFinished)
+	if !ok || len(q.hooks.PostToolCall) == 0 {
+		return
+	}
+
+	pendingHooks.Add(1)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.events = append(q.events, postToolCallEvent(finished))
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+}
+
+func (q *hookQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.events) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		event := q.events[0]
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		q.hooks.runAll(context.Background(), q.hooks.PostToolCall, event)
+		pendingHooks.Done()
+	}
+}
+
+// postToolCallEvent copies what post-tool-call hooks are given of a call.
+func postToolCallEvent(finished agent.ToolCallFinished) hookEvent {
+	arguments, _ := redactValue(finished.Arguments).(map[string]any)
+
+	event := hookEvent{
+		Event:      "post-tool-call",
+		Tool:       finished.ToolCall.Function.Name,
+		CallID:     finished.ToolCall.ID,
+		Server:     finished.Server,
+		Arguments:  arguments,
+		Result:     redact(finished.Result),
+		Failed:     finished.Failed,
+		DurationMS: finished.Duration.Milliseconds(),
+		Approval:   string(finished.Approval),
+	}
+	if finished.Err != nil {
+		event.Error = redact(finished.Err.Error())
+	}
+
+	return event
+}
+
+// taskFinished runs the on-complete or on-error hooks after a task.
+func (h hooksConfig) taskFinished(ctx context.Context, task string, result *agent.Result, err error) {
+	event := hookEvent{
+		Event: "on-complete",
+		Task:  redact(task),
+	}
+	commands := h.OnComplete
+
+	if err != nil {
+		event.Event = "on-error"
+		event.Error = redact(err.Error())
+		event.ErrorClass, _ = classifyError(err)
+		commands = h.OnError
+	}
+	if result != nil {
+		event.Answer = redact(result.Answer)
+
+		for _, e := range result.Events {
+			if updated, ok := e.(agent.UsageUpdated); ok {
+				event.Usage = &updated.Total
+			}
+		}
+	}
+
+	h.runAll(ctx, commands, event)
+}
+
+func (h hooksConfig) runAll(ctx context.Context, commands []string, event hookEvent) {
+	for _, command := range commands {
+		output, err := h.run(ctx, command, event)
+		if err != nil && output != "" {
+			log.Printf("The %s hook %q failed: %v: %s", event.Event, command, err, output)
+		} else if err != nil {
+			log.Printf("The %s hook %q failed: %v", event.Event, command, err)
+		}
+	}
+}
+
+// run runs a hook with the event on stdin, returning what it printed.
+func (h hooksConfig) run(ctx context.Context, command string, event hookEvent) (string, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Env = append(cmd.Environ(), "HOOK_EVENT="+event.Event)
+
+	output, err := cmd.CombinedOutput()
+
+	return strings.TrimSpace(string(output)), err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// networkConfig sets how outbound connections are made. Proxy overrides the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, CACert adds a
+// PEM bundle to the system's trusted CAs, and ClientCert and ClientKey are a
+// PEM certificate and key for servers requiring mutual TLS.
+type networkConfig struct {
+	Proxy              string `json:"proxy,omitempty"`
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// newHTTPClient returns the client used for all outbound traffic: LLM, MCP,
+// built-in tools and deliveries.
+func newHTTPClient(cfg *config) (*http.Client, error) {
+	base, err := newTransport(cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.RoundTripper(base)
+
+	if cfg.Debug.Enabled {
+		w := io.Writer(os.Stderr)
+
+		if cfg.Debug.File != "" {
+			f, err := os.OpenFile(cfg.Debug.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open debug log: %v", err)
+			}
+			w = f
+		}
+
+		transport = &tracingTransport{base: transport, w: w, bodies: cfg.Debug.Bodies}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTransport(cfg networkConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", cfg.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, errors.New("a client certificate needs both a certificate and a key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// tracingTransport logs every request and response, and the size of their
+// bodies, or with bodies the bodies themselves, run through the redaction
+// rules. Response bodies are logged as they are read so streamed responses
+// (SSE) are traced without being buffered.
+type tracingTransport struct {
+	base   http.RoundTripper
+	bodies bool
+
+	mu sync.Mutex
+	w  io.Writer
+	n  int
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.n++
+	id := t.n
+	t.mu.Unlock()
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if t.bodies {
+		t.logf(id, "--> %s %s\n%s\n%s", req.Method, req.URL, formatHeaders(req.Header), body)
+	} else {
+		t.logf(id, "--> %s %s\n%s\n[%d bytes]", req.Method, req.URL, formatHeaders(req.Header), len(body))
+	}
+
+	start := time.Now()
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.logf(id, "<-- error after %s: %v", time.Since(start), err)
+		return nil, err
+	}
+
+	t.logf(id, "<-- %s (%s)\n%s", resp.Status, time.Since(start), formatHeaders(resp.Header))
+
+	resp.Body = &tracingBody{ReadCloser: resp.Body, t: t, id: id}
+
+	return resp, nil
+}
+
+func (t *tracingTransport) logf(id int, format string, a ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintf(t.w, "[debug #%d %s] %s\n", id, time.Now().Format(time.TimeOnly), redact(fmt.Sprintf(format, a...)))
+}
+
+type tracingBody struct {
+	io.ReadCloser
+	t  *tracingTransport
+	id int
+
+	// read counts the bytes read, logged on close without bodies.
+	read   int
+	logged bool
+}
+
+func (b *tracingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += n
+	if n > 0 && b.t.bodies {
+		b.t.logf(b.id, "<-- body\n%s", p[:n])
+	}
+
+	return n, err
+}
+
+func (b *tracingBody) Close() error {
+	if !b.t.bodies && !b.logged {
+		b.logged = true
+		b.t.logf(b.id, "<-- body [%d bytes]", b.read)
+	}
+
+	return b.ReadCloser.Close()
+}
+
+func formatHeaders(header http.Header) string {
+	var b strings.Builder
+
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		value := strings.Join(header[name], ", ")
+
+		for _, redacted := range redactedHeaders {
+			if strings.EqualFold(name, redacted) {
+				value = "[REDACTED]"
+			}
+		}
+
+		fmt.Fprintf(&b, "%s: %s\n", name, value)
+	}
+
+	return b.String()
+}
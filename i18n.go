@@ -0,0 +1,172 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Catalogs translate what is shown to the user, keyed by the English text,
+// which is shown where a catalog has no translation. Catalogs in the locales
+// directory of the app directory add to and override the bundled ones.
+//
+//go:embed locales/*.json
+var bundledCatalogs embed.FS
+
+var (
+	locale  = "en"
+	catalog map[string]string
+)
+
+// applyLocale loads the catalog of a locale, such as de or pt-BR, or of the
+// locale of the environment if empty.
+func applyLocale(name string) error {
+	explicit := name != ""
+	if !explicit {
+		name = environmentLocale()
+	}
+
+	name = normalizeLocale(name)
+	if name == "" || name == "en" {
+		locale, catalog = "en", nil
+		return nil
+	}
+
+	messages := make(map[string]string)
+	found := false
+
+	// A region falls back to its language, de-AT to de.
+	candidates := []string{name}
+	if language, _, ok := strings.Cut(name, "-"); ok {
+		candidates = []string{language, name}
+	}
+
+	for _, candidate := range candidates {
+		ok, err := loadCatalog(candidate, messages)
+		if err != nil {
+			return err
+		}
+		found = found || ok
+	}
+
+	if !found {
+		if explicit {
+			return fmt.Errorf("no catalog for locale %q, expected one of %s", name, strings.Join(bundledLocales(), ", "))
+		}
+		locale, catalog = "en", nil
+		return nil
+	}
+
+	locale, catalog = name, messages
+
+	return nil
+}
+
+// loadCatalog adds the bundled and user catalogs of a locale to messages,
+// reporting whether there were any.
+func loadCatalog(name string, messages map[string]string) (bool, error) {
+	found := false
+
+	data, err := bundledCatalogs.ReadFile(path.Join("locales", name+".json"))
+	if err == nil {
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return false, fmt.Errorf("failed to parse bundled catalog %s: %v", name, err)
+		}
+		found = true
+	}
+
+	dir, err := appDir()
+	if err != nil {
+		return found, nil
+	}
+
+	file := filepath.Join(dir, "locales", name+".json")
+
+	data, err = os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return found, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+
+	return true, nil
+}
+
+func bundledLocales() []string {
+	locales := []string{"en"}
+
+	entries, _ := fs.ReadDir(bundledCatalogs, "locales")
+	for _, entry := range entries {
+		locales = append(locales, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	slices.Sort(locales)
+
+	return locales
+}
+
+// environmentLocale returns the locale of messages set in the environment,
+// the POSIX way.
+func environmentLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// normalizeLocale turns POSIX locales such as pt_BR.UTF-8 into tags like
+// pt-BR. C and POSIX are English.
+func normalizeLocale(name string) string {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	if name == "C" || name == "POSIX" {
+		return "en"
+	}
+
+	language, region, ok := strings.Cut(strings.ReplaceAll(name, "_", "-"), "-")
+	if !ok {
+		return strings.ToLower(language)
+	}
+
+	return strings.ToLower(language) + "-" + strings.ToUpper(region)
+}
+
+// tr translates a message, formatting it with a like fmt.Sprintf if given.
+func tr(message string, a ...any) string {
+	if translated, ok := catalog[message]; ok && translated != "" {
+		message = translated
+	}
+	if len(a) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf(message, a...)
+}
+
+// localizedSystemPrompt returns the system prompt of the locale, from
+// SystemPrompts, or SystemPrompt if it has none.
+func (c *config) localizedSystemPrompt() string {
+	if prompt, ok := c.SystemPrompts[locale]; ok {
+		return prompt
+	}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		if prompt, ok := c.SystemPrompts[language]; ok {
+			return prompt
+		}
+	}
+
+	return c.SystemPrompt
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/x/term"
+)
+
+var imageProtocols = []string{"auto", "kitty", "iterm", "sixel", "file"}
+
+// imageProtocol picks how to show images. auto looks at the environment to
+// find a terminal that can display them inline, and falls back to writing
+// them to files.
+func imageProtocol(setting string) string {
+	if setting != "" && setting != "auto" {
+		return setting
+	}
+
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		return "file"
+	}
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", os.Getenv("TERM") == "xterm-kitty", os.Getenv("TERM_PROGRAM") == "ghostty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app", os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "iterm"
+	case strings.Contains(os.Getenv("TERM"), "sixel"), os.Getenv("TERM_PROGRAM") == "mlterm", os.Getenv("TERM") == "foot":
+		return "sixel"
+	}
+
+	return "file"
+}
+
+func printImage(img agent.Image, protocol string) {
+	var err error
+
+	switch imageProtocol(protocol) {
+	case "kitty":
+		err = writeKittyImage(os.Stdout, img)
+	case "iterm":
+		err = writeITermImage(os.Stdout, img)
+	case "sixel":
+		err = writeSixelImage(os.Stdout, img)
+	default:
+		err = fmt.Errorf("inline images not supported")
+	}
+
+	if err == nil {
+		fmt.Println()
+		return
+	}
+
+	path, err := saveImage(img)
+	if err != nil {
+		printWarning("Failed to save image: %v", err)
+		return
+	}
+
+	print("%s", statusStyle.Render("Image saved to "+path))
+}
+
+func saveImage(img agent.Image) (string, error) {
+	ext := ".img"
+	if exts, _ := mime.ExtensionsByType(img.MIMEType); len(exts) > 0 {
+		ext = exts[len(exts)-1]
+	}
+
+	f, err := os.CreateTemp("", "mcp-experiment-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(img.Data); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// writeKittyImage sends a PNG in chunks using the kitty graphics protocol.
+func writeKittyImage(w io.Writer, img agent.Image) error {
+	data := img.Data
+
+	if img.MIMEType != "image/png" {
+		decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, decoded); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	for first := true; len(encoded) > 0; first = false {
+		chunk := encoded[:min(len(encoded), 4096)]
+		encoded = encoded[len(chunk):]
+
+		more := 0
+		if len(encoded) > 0 {
+			more = 1
+		}
+
+		control := fmt.Sprintf("m=%d", more)
+		if first {
+			control = "a=T,f=100," + control
+		}
+
+		if _, err := fmt.Fprintf(w, "\x1b_G%s;%s\x1b\\", control, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeITermImage shows an image using iTerm2's inline image escape sequence.
+func writeITermImage(w io.Writer, img agent.Image) error {
+	_, err := fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d:%s\a", len(img.Data), base64.StdEncoding.EncodeToString(img.Data))
+	return err
+}
+
+// writeSixelImage quantizes an image to a 256 colour palette and writes it as
+// sixels, six rows of pixels at a time.
+func writeSixelImage(w io.Writer, img agent.Image) error {
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return err
+	}
+
+	bounds := decoded.Bounds()
+	paletted := image.NewPaletted(bounds, palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, bounds, decoded, bounds.Min)
+
+	var sb strings.Builder
+	sb.WriteString("\x1bPq")
+	fmt.Fprintf(&sb, "\"1;1;%d;%d", bounds.Dx(), bounds.Dy())
+
+	for i, c := range paletted.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 6 {
+		used := make(map[uint8]bool)
+		for dy := 0; dy < 6 && y+dy < bounds.Max.Y; dy++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				used[paletted.ColorIndexAt(x, y+dy)] = true
+			}
+		}
+
+		for index := range used {
+			fmt.Fprintf(&sb, "#%d", index)
+
+			var (
+				last byte
+				run  int
+			)
+			for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+				var sixel byte
+				if x < bounds.Max.X {
+					sixel = '?'
+					for dy := 0; dy < 6 && y+dy < bounds.Max.Y; dy++ {
+						if paletted.ColorIndexAt(x, y+dy) == index {
+							sixel += 1 << dy
+						}
+					}
+				}
+
+				// Runs of the same sixel are written once with a repeat count.
+				if sixel == last {
+					run++
+					continue
+				}
+				writeSixelRun(&sb, last, run)
+				last, run = sixel, 1
+			}
+
+			// Return to the start of the band for the next colour.
+			sb.WriteByte('$')
+		}
+
+		// Move to the next band.
+		sb.WriteByte('-')
+	}
+
+	sb.WriteString("\x1b\\")
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+func writeSixelRun(sb *strings.Builder, sixel byte, run int) {
+	switch {
+	case run == 0:
+	case run > 3:
+		fmt.Fprintf(sb, "!%d%c", run, sixel)
+	default:
+		sb.WriteString(strings.Repeat(string(sixel), run))
+	}
+}
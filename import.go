@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// importSession reads a conversation held by another tool into a new
+// session, to continue it with this agent: a JSON array of OpenAI chat
+// messages, an object with one under "messages", or a Claude Code session,
+// one JSON record a line. The agent's system prompt comes first, before any
+// the conversation had.
+func importSession(path, workspace, model string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []openai.ChatCompletionMessageParamUnion
+
+	switch data = bytes.TrimSpace(data); {
+	case bytes.HasPrefix(data, []byte("[")):
+		messages, err = importOpenAIMessages(data)
+	case isMessagesObject(data):
+		var transcript struct {
+			Messages json.RawMessage `json:"messages"`
+		}
+		json.Unmarshal(data, &transcript)
+		messages, err = importOpenAIMessages(transcript.Messages)
+	default:
+		messages, err = importClaudeCode(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %v", path, err)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("failed to import %s: it has no messages", path)
+	}
+
+	sess := newSession(workspace, model)
+	sess.Messages = append(sess.Messages, answerToolCalls(messages)...)
+
+	return sess, nil
+}
+
+// isMessagesObject reports whether data is a single JSON object with
+// messages, rather than the first of many lines.
+func isMessagesObject(data []byte) bool {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return false
+	}
+
+	_, ok := object["messages"]
+	return ok
+}
+
+func importOpenAIMessages(data []byte) ([]openai.ChatCompletionMessageParamUnion, error) {
+	var messages []openai.ChatCompletionMessageParamUnion
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+
+	for i, message := range messages {
+		if message.GetRole() == nil {
+			return nil, fmt.Errorf("message %d has no role this agent knows", i+1)
+		}
+	}
+
+	return messages, nil
+}
+
+// claudeCodeRecord is a line of a Claude Code session. Only user and
+// assistant records are part of the conversation.
+type claudeCodeRecord struct {
+	Type        string `json:"type"`
+	IsSidechain bool   `json:"isSidechain"`
+	IsMeta      bool   `json:"isMeta"`
+	Message     struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"message"`
+}
+
+// claudeCodeBlock is a block of the content of a Claude Code message.
+type claudeCodeBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+}
+
+// importClaudeCode turns a Claude Code session into chat messages. Its
+// assistant messages are split over several records, which are joined, and
+// tool results are sent by the user, which become tool messages. Records of
+// subagents and those the user didn't write, such as command output, are
+// left out.
+func importClaudeCode(data []byte) ([]openai.ChatCompletionMessageParamUnion, error) {
+	var (
+		messages  []openai.ChatCompletionMessageParamUnion
+		assistant *openai.ChatCompletionAssistantMessageParam
+	)
+
+	flush := func() {
+		if assistant != nil {
+			messages = append(messages, openai.ChatCompletionMessageParamUnion{OfAssistant: assistant})
+			assistant = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		var record claudeCodeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if (record.Type != "user" && record.Type != "assistant") || record.IsSidechain || record.IsMeta {
+			continue
+		}
+
+		var blocks []claudeCodeBlock
+
+		var text string
+		if json.Unmarshal(record.Message.Content, &text) == nil {
+			blocks = []claudeCodeBlock{{Type: "text", Text: text}}
+		} else if err := json.Unmarshal(record.Message.Content, &blocks); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if record.Type == "assistant" {
+			if assistant == nil {
+				assistant = &openai.ChatCompletionAssistantMessageParam{}
+			}
+
+			for _, block := range blocks {
+				switch block.Type {
+				case "text":
+					assistant.Content.OfString = openai.String(joinText(assistant.Content.OfString.Value, block.Text))
+				case "tool_use":
+					assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+						ID: block.ID,
+						Function: openai.ChatCompletionMessageToolCallFunctionParam{
+							Name:      block.Name,
+							Arguments: string(rawOr(block.Input, "{}")),
+						},
+					})
+				}
+			}
+			continue
+		}
+
+		flush()
+
+		var parts []string
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				parts = append(parts, block.Text)
+			case "tool_result":
+				messages = append(messages, openai.ToolMessage(toolResultText(block.Content), block.ToolUseID))
+			}
+		}
+		if len(parts) > 0 {
+			messages = append(messages, openai.UserMessage(strings.Join(parts, "\n\n")))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return messages, nil
+}
+
+// toolResultText returns the text of a tool result, which is a string or a
+// list of blocks.
+func toolResultText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+
+	var blocks []claudeCodeBlock
+	json.Unmarshal(content, &blocks)
+
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+func joinText(text, more string) string {
+	if text == "" {
+		return more
+	}
+
+	return text + "\n\n" + more
+}
+
+func rawOr(raw json.RawMessage, fallback string) json.RawMessage {
+	if len(raw) == 0 || string(raw) == "null" {
+		return json.RawMessage(fallback)
+	}
+
+	return raw
+}
+
+// answerToolCalls adds a result to tool calls that have none, such as those
+// of a conversation that was interrupted, as providers reject them.
+func answerToolCalls(messages []openai.ChatCompletionMessageParamUnion) []openai.ChatCompletionMessageParamUnion {
+	answered := make(map[string]bool)
+	for _, message := range messages {
+		if message.OfTool != nil {
+			answered[message.OfTool.ToolCallID] = true
+		}
+	}
+
+	var result []openai.ChatCompletionMessageParamUnion
+	for _, message := range messages {
+		result = append(result, message)
+
+		if message.OfAssistant == nil {
+			continue
+		}
+		for _, toolCall := range message.OfAssistant.ToolCalls {
+			if !answered[toolCall.ID] {
+				result = append(result, openai.ToolMessage("No result was recorded for this call.", toolCall.ID))
+			}
+		}
+	}
+
+	return result
+}
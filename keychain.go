@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainService names the entries in the OS keychain holding API keys, one
+// per credential profile.
+const keychainService = "mcp-experiment"
+
+var errNoKeychainEntry = errors.New("no key in the keychain")
+
+// runKeychainTool runs a keychain command line tool with stdin, returning its
+// trimmed output. Errors include what the tool printed.
+func runKeychainTool(stdin string, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
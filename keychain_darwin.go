@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// The macOS keychain is used through the security tool.
+
+func keychainGet(profile string) (string, error) {
+	key, err := runKeychainTool("", "security", "find-generic-password", "-s", keychainService, "-a", profile, "-w")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+			return "", errNoKeychainEntry
+		}
+		return "", err
+	}
+
+	return key, nil
+}
+
+func keychainPersistent() bool {
+	return true
+}
+
+func keychainSet(profile, key string) error {
+	// -w must come last to be prompted for, keeping the key out of the
+	// process list; the prompt asks twice.
+	_, err := runKeychainTool(key+"\n"+key+"\n", "security", "add-generic-password", "-U", "-s", keychainService, "-a", profile, "-w")
+	return err
+}
+
+func keychainDelete(profile string) error {
+	_, err := runKeychainTool("", "security", "delete-generic-password", "-s", keychainService, "-a", profile)
+	return err
+}
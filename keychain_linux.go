@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// On Linux, keys are kept by the Secret Service (GNOME Keyring, KWallet)
+// through secret-tool, or else in the kernel's user keyring through keyctl,
+// which forgets them on reboot.
+
+func useSecretTool() bool {
+	_, err := exec.LookPath("secret-tool")
+	return err == nil
+}
+
+// keychainPersistent reports whether keys outlive a reboot, which they don't
+// in the kernel keyring.
+func keychainPersistent() bool {
+	return useSecretTool()
+}
+
+func keyctlDescription(profile string) string {
+	return keychainService + ":" + profile
+}
+
+func keychainGet(profile string) (string, error) {
+	if useSecretTool() {
+		// secret-tool exits with 1 and prints nothing when there's no
+		// entry.
+		key, err := runKeychainTool("", "secret-tool", "lookup", "service", keychainService, "profile", profile)
+		if key == "" {
+			return "", errNoKeychainEntry
+		}
+		return key, err
+	}
+
+	id, err := runKeychainTool("", "keyctl", "search", "@u", "user", keyctlDescription(profile))
+	if err != nil {
+		return "", errNoKeychainEntry
+	}
+
+	return runKeychainTool("", "keyctl", "pipe", strings.TrimSpace(id))
+}
+
+func keychainSet(profile, key string) error {
+	if useSecretTool() {
+		_, err := runKeychainTool(key, "secret-tool", "store", "--label", keychainService+" API key ("+profile+")", "service", keychainService, "profile", profile)
+		return err
+	}
+
+	_, err := runKeychainTool(key, "keyctl", "padd", "user", keyctlDescription(profile), "@u")
+	return err
+}
+
+func keychainDelete(profile string) error {
+	if useSecretTool() {
+		_, err := runKeychainTool("", "secret-tool", "clear", "service", keychainService, "profile", profile)
+		return err
+	}
+
+	id, err := runKeychainTool("", "keyctl", "search", "@u", "user", keyctlDescription(profile))
+	if err != nil {
+		return errNoKeychainEntry
+	}
+
+	_, err = runKeychainTool("", "keyctl", "unlink", strings.TrimSpace(id), "@u")
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "errors"
+
+var errNoKeychain = errors.New("no supported keychain on this platform, set OPENAI_API_KEY instead")
+
+func keychainGet(profile string) (string, error) {
+	return "", errNoKeychainEntry
+}
+
+func keychainPersistent() bool {
+	return false
+}
+
+func keychainSet(profile, key string) error {
+	return errNoKeychain
+}
+
+func keychainDelete(profile string) error {
+	return errNoKeychain
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// knowledgeConfig points the agent at a directory of documents. They are
+// split into chunks and embedded, and the chunks closest to each task are
+// sent with it.
+type knowledgeConfig struct {
+	Dir     string `json:"dir,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Results int    `json:"results,omitempty"`
+}
+
+const (
+	defaultEmbeddingModel = "openai/text-embedding-3-small"
+
+	knowledgeChunkSize = 2000
+	knowledgeMaxFile   = 1 << 20
+	embeddingBatch     = 64
+)
+
+type knowledgeChunk struct {
+	Path   string    `json:"path"`
+	Text   string    `json:"text"`
+	Vector []float64 `json:"vector"`
+}
+
+type knowledgeFile struct {
+	Hash   string           `json:"hash"`
+	Chunks []knowledgeChunk `json:"chunks"`
+}
+
+// knowledgeBase is the embedded index of a directory, kept in the app
+// directory so only files that changed are embedded again.
+type knowledgeBase struct {
+	embedder embedder
+	reranker *reranker
+	results  int
+
+	files map[string]knowledgeFile
+}
+
+// openKnowledge loads the index of cfg.Dir and brings it up to date.
+func openKnowledge(ctx context.Context, cfg knowledgeConfig, embedder embedder, reranker *reranker) (*knowledgeBase, error) {
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &knowledgeBase{
+		embedder: embedder,
+		reranker: reranker,
+		results:  cmp.Or(cfg.Results, 5),
+	}
+
+	indexPath, err := knowledgeIndexPath(dir, embedder.key())
+	if err != nil {
+		return nil, err
+	}
+
+	var index map[string]knowledgeFile
+	if data, err := os.ReadFile(indexPath); err == nil {
+		if data, err = decrypt(data); err != nil {
+			return nil, fmt.Errorf("failed to read index %s: %v", indexPath, err)
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse index %s: %v", indexPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	k.files, err = k.update(ctx, dir, index)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(k.files)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(indexPath, encrypt(data), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save index: %v", err)
+	}
+
+	return k, nil
+}
+
+func knowledgeIndexPath(dir, model string) (string, error) {
+	appDir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(dir + "\x00" + model))
+
+	return filepath.Join(appDir, "knowledge", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// update walks dir and returns the index of its text files, reusing the
+// chunks of files that haven't changed and embedding the rest.
+func (k *knowledgeBase) update(ctx context.Context, dir string, index map[string]knowledgeFile) (map[string]knowledgeFile, error) {
+	files := make(map[string]knowledgeFile)
+
+	var pending []knowledgeChunk
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() > knowledgeMaxFile {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(data) || slices.Contains(data, 0) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+
+		if file, ok := index[rel]; ok && file.Hash == hash {
+			files[rel] = file
+			return nil
+		}
+
+		files[rel] = knowledgeFile{Hash: hash}
+		for _, text := range chunkText(string(data), knowledgeChunkSize) {
+			pending = append(pending, knowledgeChunk{Path: rel, Text: text})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+
+	for batch := range slices.Chunk(pending, embeddingBatch) {
+		texts := make([]string, len(batch))
+		for i, chunk := range batch {
+			texts[i] = chunk.Path + "\n\n" + chunk.Text
+		}
+
+		vectors, err := k.embed(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, chunk := range batch {
+			chunk.Vector = vectors[i]
+
+			file := files[chunk.Path]
+			file.Chunks = append(file.Chunks, chunk)
+			files[chunk.Path] = file
+		}
+	}
+
+	return files, nil
+}
+
+func (k *knowledgeBase) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return k.embedder.embed(ctx, texts)
+}
+
+// retrieve returns the chunks closest to task, formatted to be sent with it.
+func (k *knowledgeBase) retrieve(ctx context.Context, task string) (string, error) {
+	vectors, err := k.embed(ctx, []string{task})
+	if err != nil {
+		return "", err
+	}
+
+	type match struct {
+		chunk knowledgeChunk
+		score float64
+	}
+
+	var matches []match
+	for _, file := range k.files {
+		for _, chunk := range file.Chunks {
+			matches = append(matches, match{chunk, cosine(vectors[0], chunk.Vector)})
+		}
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	matches = matches[:min(len(matches), k.reranker.pool(k.results))]
+
+	texts := make([]string, len(matches))
+	for i, m := range matches {
+		texts[i] = m.chunk.Text
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Excerpts from the user's documents that may be relevant to the next task. Cite the file when you use them.\n")
+	for _, i := range k.reranker.rerank(ctx, task, texts, k.results) {
+		fmt.Fprintf(&sb, "\n<document path=%q>\n%s\n</document>\n", matches[i].chunk.Path, matches[i].chunk.Text)
+	}
+
+	return sb.String(), nil
+}
+
+// chunkText splits text into chunks of about size bytes, on paragraph
+// boundaries where it can.
+func chunkText(text string, size int) []string {
+	var (
+		chunks  []string
+		current strings.Builder
+	)
+
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+
+	for _, paragraph := range strings.SplitAfter(text, "\n\n") {
+		if current.Len()+len(paragraph) > size {
+			flush()
+		}
+
+		for len(paragraph) > size {
+			cut := size
+			for cut > 0 && !utf8.RuneStart(paragraph[cut]) {
+				cut--
+			}
+
+			current.WriteString(paragraph[:cut])
+			flush()
+			paragraph = paragraph[cut:]
+		}
+
+		current.WriteString(paragraph)
+	}
+	flush()
+
+	return chunks
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -3,287 +3,1094 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/chroma/v2/quick"
+	"github.com/cedws/mcp-experiment/agent"
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/mark3labs/mcp-go/client"
+	"github.com/charmbracelet/x/term"
 	mcpclient "github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 )
 
-const defaultModel = "google/gemini-2.5-flash"
+const (
+	defaultModel    = "google/gemini-2.5-flash"
+	providerBaseURL = "https://openrouter.ai/api/v1"
+)
+
+var systemPrompts = []string{
+	"To be a fast and efficient agent, batch tool calls together.",
+	"Do everything using a Python sandbox. Don't use built-in tool calling, use the Python sandbox.",
+	"Don't try to calculate yourself or retrieve results from memory. You compute everything.",
+	"Output the result and ONLY the result.",
+}
 
-var systemMessages = []openai.ChatCompletionMessageParamUnion{
-	openai.SystemMessage("To be a fast and efficient agent, batch tool calls together."),
-	openai.SystemMessage("Do everything using a Python sandbox. Don't use built-in tool calling, use the Python sandbox."),
-	openai.SystemMessage("Don't try to calculate yourself or retrieve results from memory. You compute everything."),
-	openai.SystemMessage("Output the result and ONLY the result."),
+// systemMessages returns the built-in system prompts that new sessions
+// start with, in the language of the locale.
+func systemMessages() []openai.ChatCompletionMessageParamUnion {
+	return promptMessages(startPrompts)
+}
+
+func promptMessages(prompts []string) []openai.ChatCompletionMessageParamUnion {
+	var messages []openai.ChatCompletionMessageParamUnion
+	for _, prompt := range prompts {
+		messages = append(messages, openai.SystemMessage(tr(prompt)))
+	}
+
+	return messages
 }
 
 func print(s string, a ...any) {
-	fmt.Printf(s+"\n", a...)
+	fmt.Fprintf(terminalOut, s+"\n", a...)
 }
 
+// Styles are built from the theme by applyTheme.
 var (
-	codeBoxStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("62")).
-			Padding(1, 2).
-			MarginLeft(2)
-
-	resultBoxStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("42")).
-			Padding(1, 2).
-			MarginLeft(2)
+	codeBoxStyle      lipgloss.Style
+	resultBoxStyle    lipgloss.Style
+	reasoningBoxStyle lipgloss.Style
+	warningStyle      lipgloss.Style
+	toolNameStyle     lipgloss.Style
+	statusStyle       lipgloss.Style
+	serverLogStyle    lipgloss.Style
 )
 
-func printCodeBox(content, language string) {
+// currentTheme is the theme applied to the styles.
+var currentTheme theme
+
+func codeBox(content, language string) string {
+	if currentTheme.codeStyle == "" {
+		return renderBox(codeBoxStyle, content)
+	}
+
 	var buf strings.Builder
-	if err := quick.Highlight(&buf, content, language, "terminal256", "monokai"); err != nil {
+	formatter := "terminal256"
+	if minimalOutput {
+		formatter = "terminal16"
+	}
+
+	if err := quick.Highlight(&buf, content, language, formatter, currentTheme.codeStyle); err != nil {
 		buf.WriteString(content)
 	}
 
-	styledBox := codeBoxStyle.
-		BorderTop(true).
-		BorderTopForeground(lipgloss.Color("62")).
-		Render(buf.String())
+	return renderBox(codeBoxStyle, buf.String())
+}
+
+func resultBox(content string) string {
+	return renderBox(resultBoxStyle, renderMarkdown(content))
+}
 
-	fmt.Println(styledBox)
+func printCodeBox(content, language string) {
+	fmt.Println(codeBox(content, language))
 }
 
 func printResultBox(content string) {
-	fmt.Println(resultBoxStyle.Render(content))
+	fmt.Println(resultBox(content))
+}
+
+func printWarning(s string, a ...any) {
+	fmt.Println(warningStyle.Render(fmt.Sprintf(s, a...)))
+}
+
+func (r *repl) renderEvent(event agent.Event) {
+	switch event.(type) {
+	case agent.TextDelta, agent.ToolCallDelta:
+	default:
+		r.clearPreview()
+	}
+
+	switch event := event.(type) {
+	case agent.AssistantText:
+		if event.Structured {
+			r.printLong(codeBox(event.Text, "json"))
+		} else {
+			r.printLong(resultBox(event.Text))
+		}
+	case agent.Reasoning:
+		r.lastReasoning = event.Text
+		r.printLong(reasoningBox(event.Text, false))
+	case agent.ToolCallDelta:
+		r.previewToolCall(event)
+	case agent.ToolCallStarted:
+		name := event.ToolCall.Function.Name
+		verbosity := r.cfg.Output.Verbosity
+
+		if verbosity > 0 {
+			r.print("%s", toolNameStyle.Render(name))
+		}
+
+		if code, language, ok := toolCode(r.cfg.Output.CodeTools, name, event.Arguments); ok {
+			r.printLong(codeBox(code, language))
+		} else if verbosity > 0 {
+			r.printLong(codeBox(toolArguments(event.Arguments, verbosity > 1), "json"))
+		}
+	case agent.ToolCallFinished:
+		if output, ok := renderToolOutput(r.cfg.Output, event); ok {
+			r.printLong(output)
+		}
+		for _, img := range event.Images {
+			printImage(img, r.cfg.Output.Images)
+		}
+	case agent.TurnFinished:
+		if r.timings != nil && r.timings.finished != nil {
+			r.print("%s", statusStyle.Render(r.timings.finished.String()))
+		}
+	case agent.ModelRouted:
+		r.print("%s", statusStyle.Render(tr("Using %s (%s)", event.Model, event.Reason)))
+	case agent.GuardrailTripped:
+		switch {
+		case event.Blocked:
+			r.warn("%s", tr("Blocked by a guardrail: %s", event.String()))
+		case event.Overridden:
+			r.print("%s", statusStyle.Render(tr("Allowed despite a guardrail: %s", event.String())))
+		default:
+			r.warn("%s", tr("Guardrail: %s", event.String()))
+		}
+	case agent.Warning:
+		r.warn("%s", event.Text)
+	case agent.ServerLog:
+		fmt.Fprintln(r.out, serverLogStyle.Render(redact(serverLogText(event))))
+	}
+}
+
+// serverLogText describes a log message of the MCP server in a line.
+func serverLogText(log agent.ServerLog) string {
+	if log.Logger != "" {
+		return fmt.Sprintf("[%s] %s: %s", log.Level, log.Logger, log.Text)
+	}
+
+	return fmt.Sprintf("[%s] %s", log.Level, log.Text)
+}
+
+// previewToolCall redraws the code of a tool call while it streams in, so it
+// can be interrupted early. It needs a terminal, to erase the last preview.
+func (r *repl) previewToolCall(event agent.ToolCallDelta) {
+	if r.out != io.Writer(os.Stdout) || !term.IsTerminal(os.Stdout.Fd()) {
+		return
+	}
+
+	code, language, ok := partialToolCode(r.cfg.Output.CodeTools, event.Name, event.Arguments)
+	if !ok {
+		return
+	}
+
+	// Only the end of the code is shown, so the box fits on the screen and
+	// can be erased.
+	_, height := terminalSize()
+	if lines := strings.Split(code, "\n"); len(lines) > height-6 {
+		code = strings.Join(lines[len(lines)-(height-6):], "\n")
+	}
+
+	box := codeBox(code, language)
+
+	r.clearPreview()
+	fmt.Fprintln(r.out, redact(box))
+	r.previewLines = strings.Count(box, "\n") + 1
+}
+
+func (r *repl) clearPreview() {
+	if r.previewLines > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dF\x1b[J", r.previewLines)
+		r.previewLines = 0
+	}
+}
+
+// options are the command line flags that aren't part of the config.
+type options struct {
+	task        string
+	model       string
+	record      string
+	replay      string
+	importFile  string
+	resumeLast  bool
+	exportHTML  string
+	compare     string
+	ab          string
+	abRuns      int
+	abJudge     string
+	samples     int
+	vote        string
+	voteModel   string
+	batch       string
+	batchOutput string
+	parallel    int
+	watch       []string
+	watchURIs   []string
+	debounce    time.Duration
+	preset      string
+	edit        bool
+	paste       bool
+	files       []string
+
+	// templated is set when the task is meant to be a template, with -var
+	// or -preset, so errors expanding it are fatal.
+	templated bool
+
+	// args are the arguments after the flags, starting with the subcommand.
+	args []string
+}
+
+// parseArgs loads the config and applies command line flags on top. It is
+// also used to reload the config of long-running commands.
+func parseArgs(args []string, errorHandling flag.ErrorHandling) (*config, *options, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := &options{}
+
+	fs := newFlagSet(cfg, opts, errorHandling)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	opts.args = fs.Args()
+
+	return cfg, opts, nil
+}
+
+// newFlagSet returns the flags, which set cfg and opts.
+func newFlagSet(cfg *config, opts *options, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	cfg.registerFlags(fs)
+	fs.StringVar(&opts.task, "task", "", "run this task in a new session instead of prompting for one")
+	fs.StringVar(&opts.preset, "preset", "", "run the task of this name from the tasks section of the config, like -task")
+	fs.Func("var", "set a task template variable, as name=value (repeatable)", func(value string) error {
+		name, value, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected name=value")
+		}
+		if cfg.Vars == nil {
+			cfg.Vars = make(map[string]string)
+		}
+		cfg.Vars[name] = value
+		opts.templated = true
+		return nil
+	})
+	fs.StringVar(&opts.model, "model", defaultModel, "model to use with -task")
+	fs.StringVar(&opts.record, "record", "", "record completions and tool results to this cassette file")
+	fs.StringVar(&opts.compare, "compare", "", "comma-separated models to run -task through in parallel and compare")
+	fs.StringVar(&opts.ab, "ab", "", "two comma-separated prompt profiles to run -task under and compare")
+	fs.IntVar(&opts.abRuns, "ab-runs", 1, "how many times -ab runs -task under each prompt profile")
+	fs.StringVar(&opts.abJudge, "ab-judge", "", "model to score the answers of -ab with (defaults to -model)")
+	fs.IntVar(&opts.samples, "samples", 0, "run -task this many times in parallel and print the answer most runs agree on")
+	fs.StringVar(&opts.vote, "vote", "majority", "how -samples picks the answer: majority, or judge to have a model pick the best")
+	fs.StringVar(&opts.voteModel, "vote-model", "", "model to pick the answer with -vote judge (defaults to -model)")
+	fs.StringVar(&opts.batch, "batch", "", "run every task in this JSONL file, with lines like {\"id\": \"...\", \"task\": \"...\", \"model\": \"...\"}")
+	fs.StringVar(&opts.batchOutput, "batch-output", "", "JSONL file to write -batch results to (defaults to stdout)")
+	fs.IntVar(&opts.parallel, "parallel", 1, "how many -batch tasks to run at once")
+	fs.Func("watch", "run -task again whenever files under this path change (repeatable)", func(path string) error {
+		opts.watch = append(opts.watch, path)
+		return nil
+	})
+	fs.Func("watch-resource", "run -task again whenever this resource of the servers changes (repeatable)", func(uri string) error {
+		opts.watchURIs = append(opts.watchURIs, uri)
+		return nil
+	})
+	fs.DurationVar(&opts.debounce, "watch-debounce", 500*time.Millisecond, "how long files must stop changing before -watch runs the task again")
+	fs.Func("file", "attach this file to the task (repeatable)", func(path string) error {
+		opts.files = append(opts.files, path)
+		return nil
+	})
+	fs.BoolVar(&opts.paste, "paste", false, "take the task from the clipboard, after -task if given")
+	fs.BoolVar(&opts.edit, "edit", false, "write the task in $EDITOR, starting from -task, and run it like -task")
+	fs.StringVar(&opts.importFile, "import", "", "continue a conversation exported from another tool, as OpenAI chat messages JSON or a Claude Code session")
+	fs.BoolVar(&opts.resumeLast, "resume-last", false, "resume the last task in this directory that was cut short, from its last checkpoint")
+	fs.StringVar(&opts.replay, "replay", "", "replay a cassette file with -task instead of calling the LLM and MCP server")
+	fs.StringVar(&opts.exportHTML, "export-html", "", "write the session to this file as a standalone HTML page when the run ends")
+
+	return fs
 }
 
 func main() {
 	ctx := context.Background()
 
-	mcpClient, err := client.NewStreamableHttpClient("http://127.0.0.1:5555/mcp")
+	cfg, opts, err := parseArgs(os.Args[1:], flag.ExitOnError)
 	if err != nil {
-		log.Fatalf("Failed to create MCP client: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
-	defer mcpClient.Close()
 
-	if err := mcpClient.Start(ctx); err != nil {
-		log.Fatalf("Failed to start MCP client: %v", err)
+	if err := applyLocale(cfg.Locale); err != nil {
+		log.Fatal(err)
 	}
 
-	toolsResult := toolList(ctx, mcpClient)
-	toolsSchema := convertToolsSchema(toolsResult)
+	// The doctor checks the config itself, so it runs even if it's invalid,
+	// and completion must not fail or prompt for a passphrase.
+	if len(opts.args) > 0 && (opts.args[0] == "doctor" || opts.args[0] == "completion") {
+		if opts.args[0] == "doctor" {
+			err = doctorCommand(ctx, cfg, opts.args[1:])
+		} else {
+			err = completionCommand(cfg, opts.args[1:])
+		}
+		if err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
 
-	apiKey, ok := os.LookupEnv("OPENAI_API_KEY")
-	if !ok {
-		log.Fatal("OPENAI_API_KEY environment variable not set")
+	if err := validateConfig(cfg); err != nil {
+		log.Fatal(err)
 	}
 
-	openaiClient := openai.NewClient(
-		option.WithBaseURL("https://openrouter.ai/api/v1"),
-		option.WithAPIKey(apiKey),
-	)
+	if err := applyTheme(cfg.Theme); err != nil {
+		log.Fatal(err)
+	}
+	if err := applyRedaction(cfg.Redaction); err != nil {
+		log.Fatal(err)
+	}
+	if err := applyPromptProfile(cfg); err != nil {
+		log.Fatal(err)
+	}
 
-	models, err := fetchModels(ctx, openaiClient)
-	if err != nil {
-		log.Fatalf("Failed to fetch models: %v", err)
+	if !cfg.Output.Plain {
+		markdown, err = newMarkdownRenderer()
+		if err != nil {
+			log.Fatalf("Failed to create Markdown renderer: %v", err)
+		}
+	}
+
+	if err := applyEncryption(ctx, cfg.Encryption); err != nil {
+		log.Fatalf("Failed to set up encryption: %v", err)
 	}
 
-	question, model, err := showForm(ctx, models)
+	store, err = openStore(cfg.Storage)
 	if err != nil {
-		log.Fatalf("Failed to show form: %v", err)
+		log.Fatalf("Failed to open session storage: %v", err)
+	}
+	defer store.Close()
+
+	// Post-tool-call hooks run in the background, and may still be running.
+	defer pendingHooks.Wait()
+
+	// Commands that only read sessions, like listing them, leave their
+	// working directories alone.
+	if len(opts.args) == 0 || slices.Contains(sessionCommands, opts.args[0]) {
+		if err := cleanWorkdirs(cfg.Workdir); err != nil {
+			log.Fatalf("Failed to clean working directories: %v", err)
+		}
+	}
+
+	if len(opts.args) > 0 {
+		var err error
+
+		switch opts.args[0] {
+		case "sessions":
+			err = sessionsCommand(ctx, cfg, opts.args[1:])
+		case "daemon":
+			err = daemonCommand(ctx, cfg, opts.args[1:])
+		case "schedule":
+			err = scheduleCommand(ctx, cfg, opts.args[1:])
+		case "play":
+			err = playCommand(ctx, cfg, opts.args[1:])
+		case "eval":
+			err = evalCommand(ctx, cfg, opts.args[1:])
+		case "github":
+			err = githubCommand(ctx, cfg, opts.args[1:])
+		case "playground":
+			err = playgroundCommand(ctx, cfg, opts.args[1:])
+		case "tools":
+			err = toolsCommand(ctx, cfg, opts.args[1:])
+		case "review":
+			err = reviewCommand(ctx, cfg, opts.args[1:])
+		case "mcp-server":
+			err = mcpServerCommand(ctx, cfg, opts.args[1:])
+		case "serve":
+			err = serveCommand(ctx, cfg, opts.args[1:])
+		case "memory":
+			err = memoryCommand(ctx, cfg, opts.args[1:])
+		case "auth":
+			err = authCommand(ctx, cfg, opts.args[1:])
+		case "costs":
+			err = costsCommand(ctx, cfg, opts.args[1:])
+		case "audit":
+			err = auditCommand(ctx, cfg, opts.args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", opts.args[0])
+		}
+
+		if err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
+
+	// Interrupting a run cancels it, so it exits as aborted.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.resumeLast && (opts.task != "" || opts.preset != "") {
+		log.Fatal("-resume-last can't be combined with a task")
+	}
+
+	if opts.preset != "" {
+		task, ok := cfg.Tasks[opts.preset]
+		if !ok {
+			log.Fatalf("No task named %q in the config", opts.preset)
+		}
+		if opts.task != "" {
+			log.Fatal("-preset can't be combined with -task")
+		}
+
+		opts.task = task
+		opts.templated = true
 	}
 
-	print("Query: %s", question)
+	if opts.paste {
+		text, err := pasteFromClipboard()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	params := openai.ChatCompletionNewParams{
-		Tools:    toolsSchema,
-		Model:    model,
-		Messages: append(systemMessages, openai.UserMessage(question)),
+		opts.task = strings.TrimSpace(opts.task + "\n\n" + text)
+		if opts.task == "" {
+			log.Fatal("The clipboard is empty")
+		}
 	}
 
-	for {
-		completion, err := openaiClient.Chat.Completions.New(ctx, params)
+	if opts.edit {
+		opts.task, err = editText(opts.task, ".md")
 		if err != nil {
-			log.Fatalf("Failed to create chat completion: %v", err)
+			log.Fatalf("Failed to edit task: %v", err)
+		}
+		if opts.task == "" {
+			log.Fatal("The task is empty")
 		}
+	}
 
-		if completion.Choices[0].Message.Content != "" {
-			printResultBox(completion.Choices[0].Message.Content)
+	// Watched tasks are expanded for every run, as the files they include
+	// change.
+	if len(opts.watch) == 0 && len(opts.watchURIs) == 0 {
+		opts.task, err = expandTask(opts.task, cfg.Vars, opts.templated)
+		if err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		toolCalls := completion.Choices[0].Message.ToolCalls
-		if len(toolCalls) == 0 {
-			break
+	var attachments []*attachment
+	for _, path := range opts.files {
+		a, err := readAttachment(path, cfg.Attachments)
+		if err != nil {
+			log.Fatalf("Failed to attach file: %v", err)
 		}
+		attachments = append(attachments, a)
+	}
 
-		params.Messages = append(
-			params.Messages,
-			completion.Choices[0].Message.ToParam(),
-		)
+	if opts.compare != "" {
+		if err := runCompare(ctx, cfg, withAttachments(opts.task, attachments), strings.Split(opts.compare, ",")); err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
+
+	if opts.ab != "" {
+		if err := runAB(ctx, cfg, withAttachments(opts.task, attachments), opts.model, strings.Split(opts.ab, ","), opts.abRuns, opts.abJudge); err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
 
-		for _, toolCall := range toolCalls {
-			result, err := callTool(ctx, mcpClient, toolCall)
+	if opts.samples > 1 {
+		if err := runSamples(ctx, cfg, withAttachments(opts.task, attachments), opts.model, opts.samples, opts.vote, opts.voteModel); err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
+
+	if len(opts.watch) > 0 || len(opts.watchURIs) > 0 {
+		if err := runWatch(ctx, cfg, opts.watch, opts.watchURIs, opts.debounce, opts.task, opts.templated, opts.files, opts.model); err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
+
+	if opts.batch != "" {
+		output := io.Writer(os.Stdout)
+		if opts.batchOutput != "" {
+			f, err := os.Create(opts.batchOutput)
 			if err != nil {
-				log.Fatalf("Failed to call tool: %v", err)
+				log.Fatalf("Failed to create batch output: %v", err)
 			}
+			defer f.Close()
+			output = f
+		}
 
-			params.Messages = append(
-				params.Messages,
-				openai.ToolMessage(result, toolCall.ID),
-			)
+		if err := runBatch(ctx, cfg, opts.batch, opts.parallel, output, attachments, opts.model, opts.templated); err != nil {
+			fatal(cfg, err)
 		}
+		return
 	}
-}
 
-func showForm(ctx context.Context, models []string) (string, string, error) {
 	var (
-		question string
-		model    = defaultModel
+		a         *agent.Agent
+		b         *backend
+		models    []modelInfo
+		mcpClient *mcpclient.Client
+		synced    *workspaceSync
 	)
+	defer func() {
+		synced.pull(ctx)
+		if mcpClient != nil {
+			mcpClient.Close()
+		}
+	}()
 
-	form := huh.NewForm(
-		huh.NewGroup(
-			huh.NewInput().
-				Title("Enter a task").
-				Value(&question),
-			huh.NewSelect[string]().
-				Title("Select a model").
-				Value(&model).
-				Height(10).
-				Options(huh.NewOptions(models...)...),
-		),
-	)
+	if opts.replay != "" {
+		if opts.task == "" {
+			log.Fatal("-replay needs -task")
+		}
 
-	if err := form.RunWithContext(ctx); err != nil {
-		log.Fatalf("Failed to run input: %v", err)
+		a, err = replayAgent(ctx, cfg, opts.replay)
+		if err != nil {
+			fatal(cfg, err)
+		}
+	} else {
+		b, err = newBackend(ctx, cfg)
+		if err != nil {
+			fatal(cfg, err)
+		}
+
+		if opts.record != "" {
+			b.cassette = agent.NewCassette(opts.record)
+		}
+
+		models = b.models
 	}
 
-	return question, model, nil
-}
+	// Scripted runs connect to the MCP server right away, and fail if it
+	// can't be reached. Interactive sessions start the agent once their
+	// first task is known, so the form doesn't wait for the server, and go
+	// on without its tools if it is down.
+	scripted := cfg.Output.Template != "" || cfg.Output.Format == "gha" || cfg.Output.Format == "json" || cfg.Output.Quiet
 
-func fetchModels(ctx context.Context, openaiClient openai.Client) (res []string, err error) {
-	models := openaiClient.Models.ListAutoPaging(ctx)
+	setUpAgent := func(start func() (*agent.Agent, *mcpclient.Client, error)) {
+		if b == nil {
+			return
+		}
 
-	for {
-		res = append(res, models.Current().ID)
+		a, mcpClient, err = start()
+		if err != nil {
+			fatal(cfg, err)
+		}
 
-		switch {
-		case models.Next():
-			continue
-		case models.Err() != nil:
-			return nil, models.Err()
-		default:
-			return res, nil
+		// Without the MCP server, there is no sandbox to sync with.
+		if cfg.Sync.Dir != "" && len(a.Servers()) == 0 && !scripted {
+			printWarning("Not syncing %s without the MCP server", cfg.Sync.Dir)
+			return
+		}
+
+		synced, err = startSync(ctx, cfg.Sync, a)
+		if err != nil {
+			fatal(cfg, err)
 		}
 	}
-}
 
-func convertToolsSchema(tools *mcp.ListToolsResult) []openai.ChatCompletionToolParam {
-	var openaiTools []openai.ChatCompletionToolParam
+	if scripted {
+		setUpAgent(func() (*agent.Agent, *mcpclient.Client, error) {
+			return b.newAgent(ctx)
+		})
+	}
 
-	for _, tool := range tools.Tools {
-		schema := map[string]any{
-			"type": "object",
+	workspace, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Failed to get working directory: %v", err)
+	}
+
+	taskSession := newSession(workspace, opts.model)
+	if opts.importFile != "" {
+		taskSession, err = importSession(opts.importFile, workspace, opts.model)
+		if err != nil {
+			log.Fatalf("Failed to import conversation: %v", err)
 		}
+	}
 
-		if len(tool.InputSchema.Properties) > 0 {
-			schema["properties"] = tool.InputSchema.Properties
-		} else {
-			schema["properties"] = map[string]any{}
+	if cfg.Output.Template != "" {
+		if err := runTemplate(ctx, cfg, a, taskSession, withAttachments(opts.task, attachments)); err != nil {
+			fatal(cfg, fmt.Errorf("failed to run agent: %w", err))
+		}
+		return
+	}
+
+	if cfg.Output.Format == "gha" {
+		if err := runGHA(ctx, cfg, a, taskSession, withAttachments(opts.task, attachments)); err != nil {
+			fatal(cfg, fmt.Errorf("failed to run agent: %w", err))
+		}
+		return
+	}
+
+	if cfg.Output.Format == "json" || cfg.Output.Quiet {
+		if err := runScripted(ctx, cfg, b, a, taskSession, withAttachments(opts.task, attachments)); err != nil {
+			fatal(cfg, fmt.Errorf("failed to run agent: %w", err))
+		}
+		return
+	}
+
+	history, err := loadHistory(cfg.HistorySize)
+	if err != nil {
+		log.Fatalf("Failed to load history: %v", err)
+	}
+
+	newInteractiveREPL := func(sess *session) *repl {
+		r := newREPL(cfg, sess, a)
+		r.history = history
+		r.attachments = attachments
+		r.notify = notifier(cfg)
+		a.Approve = r.approve
+		a.OverrideGuardrail = r.overrideGuardrail
+		for _, subscription := range cfg.Subscriptions {
+			if err := r.subscribe(ctx, subscription.URI, subscription.Task); err != nil {
+				printWarning("Failed to subscribe: %v", err)
+			}
+		}
+		if b != nil {
+			r.credits = b.credits
+			r.verify = b.verify
+			r.commitMessage = b.commitMessage
+			r.context.price = b.promptPrice
+			if b.memory != nil {
+				r.memorize = b.memorize
+			}
+		}
+
+		return r
+	}
+
+	if b != nil {
+		if credits, err := b.credits(ctx); err == nil {
+			print("%s", statusStyle.Render(credits.String()))
 		}
+	}
 
-		if len(tool.InputSchema.Required) > 0 {
-			schema["required"] = tool.InputSchema.Required
+	// A run that failed is still worth sharing.
+	exportSession := func(r *repl) {
+		if opts.exportHTML == "" {
+			return
 		}
 
-		openaiTool := openai.ChatCompletionToolParam{
-			Function: openai.FunctionDefinitionParam{
-				Name:        tool.Name,
-				Description: openai.String(tool.Description),
-				Parameters:  openai.FunctionParameters(schema),
-			},
+		if err := writeSessionHTML(cfg, r.sess, opts.exportHTML); err != nil {
+			log.Printf("Failed to export session: %v", err)
+			return
 		}
+		print("%s", tr("Session exported to %s", opts.exportHTML))
+	}
+
+	if opts.task != "" {
+		setUpAgent(func() (*agent.Agent, *mcpclient.Client, error) {
+			return b.startAgent(ctx, taskSession.Model)
+		})
 
-		openaiTools = append(openaiTools, openaiTool)
+		r := newInteractiveREPL(taskSession)
+		err := r.run(ctx, opts.task)
+		exportSession(r)
+		if err != nil {
+			fatal(cfg, err)
+		}
+		return
 	}
 
-	return openaiTools
+	var (
+		sess     *session
+		question string
+	)
+
+	switch {
+	case opts.resumeLast:
+		sess, err = lastInterruptedSession(workspace)
+		if err != nil {
+			log.Fatalf("Failed to resume: %v", err)
+		}
+		question = "/resume"
+	case opts.importFile != "":
+		sess = taskSession
+	default:
+		sess, err = chooseSession(ctx, workspace)
+		if err != nil {
+			log.Fatalf("Failed to choose session: %v", err)
+		}
+	}
+
+	if question == "" {
+		defaultSessionModel := defaultModel
+		if sess != nil {
+			defaultSessionModel = sess.Model
+		}
+
+		var sessionModel string
+		question, sessionModel, err = showForm(ctx, modelIDs(models), defaultSessionModel)
+		if err != nil {
+			log.Fatalf("Failed to show form: %v", err)
+		}
+
+		if sess == nil {
+			sess = newSession(workspace, sessionModel)
+		}
+		sess.Model = sessionModel
+	}
+
+	setUpAgent(func() (*agent.Agent, *mcpclient.Client, error) {
+		return b.startAgent(ctx, sess.Model)
+	})
+
+	r := newInteractiveREPL(sess)
+
+	if cfg.Output.NoTUI || minimalOutput || !term.IsTerminal(os.Stdout.Fd()) {
+		err := r.run(ctx, question)
+		exportSession(r)
+		if err != nil {
+			fatal(cfg, err)
+		}
+		return
+	}
+
+	if err := runTUI(ctx, r, question); err != nil {
+		log.Fatalf("Failed to run TUI: %v", err)
+	}
+
+	print("%s", tr("Session %s saved", r.sess.ID))
+	exportSession(r)
 }
 
-func toolList(ctx context.Context, mcpClient *mcpclient.Client) *mcp.ListToolsResult {
-	initRequest := mcp.InitializeRequest{
-		Request: mcp.Request{
-			Method: "initialize",
-		},
-		Params: mcp.InitializeParams{
-			ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
-			Capabilities: mcp.ClientCapabilities{
-				Experimental: map[string]any{},
-			},
-			ClientInfo: mcp.Implementation{
-				Name:    "mcp-client",
-				Version: "1.0.0",
-			},
+// validateConfig checks the settings that are only used later on, so that
+// mistakes are reported on startup.
+func validateConfig(cfg *config) error {
+	if err := cfg.MCPInitialize.validate(); err != nil {
+		return err
+	}
+	if _, err := cfg.Budget.deadline(); err != nil {
+		return err
+	}
+	if err := cfg.Embeddings.validate(); err != nil {
+		return err
+	}
+	switch cfg.Output.Format {
+	case "", "text", "json", "gha":
+	default:
+		return fmt.Errorf("unknown output format %q", cfg.Output.Format)
+	}
+	if cfg.Attachments.Truncate != "" && !slices.Contains(truncateModes, cfg.Attachments.Truncate) {
+		return fmt.Errorf("unknown truncation mode %q, expected one of %s", cfg.Attachments.Truncate, strings.Join(truncateModes, ", "))
+	}
+	if !slices.Contains(imageProtocols, cfg.Output.Images) && cfg.Output.Images != "" {
+		return fmt.Errorf("unknown image protocol %q, expected one of %s", cfg.Output.Images, strings.Join(imageProtocols, ", "))
+	}
+	if cfg.Output.Format != "" && cfg.Output.Format != "text" && cfg.Output.Template != "" {
+		return fmt.Errorf("-template can't be combined with -output %s", cfg.Output.Format)
+	}
+
+	if err := validateRenderers(cfg.Output.Renderers); err != nil {
+		return err
+	}
+	if _, err := compositeTools(cfg.CompositeTools); err != nil {
+		return err
+	}
+	for _, rule := range cfg.ModelRouting.Rules {
+		if rule.Model == "" {
+			return fmt.Errorf("model routing rules need a model")
+		}
+		if rule.After != "" && rule.After != "task" && rule.After != "tools" {
+			return fmt.Errorf("unknown model routing condition after %q, expected task or tools", rule.After)
+		}
+	}
+	for _, subscription := range cfg.Subscriptions {
+		if subscription.URI == "" {
+			return fmt.Errorf("subscriptions need a uri")
+		}
+	}
+
+	return cfg.Hooks.validate()
+}
+
+// OpenRouter only reports the cost of a completion when asked to.
+var usageAccounting = option.WithJSONSet("usage.include", true)
+
+func configureAgent(a *agent.Agent, cfg *config, models []modelInfo) error {
+	a.ContentFilter = agent.ContentFilterPolicy{
+		RetryPrompt: cfg.ContentFilter.RetryPrompt,
+		Model:       cfg.ContentFilter.Model,
+		MaxRetries:  cfg.ContentFilter.MaxRetries,
+	}
+	a.MaxContinuations = cfg.MaxContinuations
+	a.FallbackModels = cfg.FallbackModels
+	a.ArgumentRepairs = cfg.ArgumentRepairs
+	deadline, err := cfg.Budget.deadline()
+	if err != nil {
+		return err
+	}
+	a.Budget = agent.Budget{
+		MaxCost:   cfg.Budget.MaxCost,
+		MaxTokens: cfg.Budget.MaxTokens,
+		MaxTurns:  cfg.Budget.MaxTurns,
+		Deadline:  deadline,
+	}
+	a.Escalation = agent.Escalation{
+		Model:    cfg.Escalation.Model,
+		Failures: cfg.Escalation.Failures,
+		Repeats:  cfg.Escalation.Repeats,
+	}
+	a.Routing = cfg.ModelRouting.routing()
+	a.Loops = agent.LoopDetection{
+		Repeats: cfg.Loops.Repeats,
+		Cycles:  cfg.Loops.Cycles,
+		Empty:   cfg.Loops.Empty,
+		Nudges:  cfg.Loops.Nudges,
+	}
+	a.Batching = agent.BatchingHint{
+		Turns:  cfg.Batching.Turns,
+		Max:    cfg.Batching.Max,
+		Prompt: cfg.Batching.Prompt,
+	}
+	a.Streaming = cfg.Stream
+	a.StartToolCalls = cfg.StreamToolCalls
+	a.Provenance = cfg.Output.Provenance
+	a.Compaction = agent.Compaction{
+		Threshold:  cfg.Compaction.Threshold,
+		KeepRecent: cfg.Compaction.KeepRecent,
+		Model:      cfg.Compaction.Model,
+		PinTools:   cfg.Compaction.PinTools,
+	}
+	a.Summarizer = agent.Summarizer{
+		Model:     cfg.Summarizer.Model,
+		Threshold: cfg.Summarizer.Threshold,
+	}
+	a.Truncation = agent.Truncation{MaxTokens: cfg.ToolOutputTokens}
+	a.ToolDescriptions = make(map[string]agent.ToolDescription)
+	for name, description := range cfg.ToolDescriptions {
+		a.ToolDescriptions[name] = agent.ToolDescription(description)
+	}
+
+	a.Concurrency = agent.Concurrency{Max: cfg.ToolConcurrency}
+	a.Prefetch = agent.Prefetch{
+		Model: cfg.Prefetch.Model,
+		Tools: cfg.Prefetch.Tools,
+		Turns: cfg.Prefetch.Turns,
+	}
+	if cfg.MCPLogLevel != "off" {
+		level := mcp.LoggingLevel(cfg.MCPLogLevel)
+		if !slices.Contains(agent.LogLevels, level) {
+			return fmt.Errorf("unknown MCP log level %q", cfg.MCPLogLevel)
+		}
+		a.LogLevel = level
+	}
+	a.ContextLength = func(model string) int64 {
+		return findModel(models, model).ContextLength
+	}
+	a.Tokens = agent.TokenEstimator{
+		Tokenizer: func(model string) string {
+			return findModel(models, model).Tokenizer
 		},
 	}
 
-	if _, err := mcpClient.Initialize(ctx, initRequest); err != nil {
-		log.Fatalf("Failed to initialize MCP client: %v", err)
+	tripwires, err := compileTripwires(cfg.Tripwires)
+	if err != nil {
+		return err
 	}
+	a.Tripwires = tripwires
 
-	toolsResult, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+	guardrails, err := cfg.Guardrails.compile()
 	if err != nil {
-		log.Fatalf("Failed to list tools: %v", err)
+		return err
 	}
-	if len(toolsResult.Tools) == 0 {
-		log.Fatal("No tools available from MCP server")
+	a.Guardrails = guardrails
+
+	rules, err := compilePolicy(cfg.PolicyFile, cfg.Policy)
+	if err != nil {
+		return err
 	}
+	a.ToolRules = rules
+	a.ApproveAll = cfg.Approve
+	a.DryRun = cfg.DryRun
 
-	return toolsResult
-}
+	if err := setToolCallHooks(a, cfg); err != nil {
+		return err
+	}
 
-func callTool(ctx context.Context, mcpClient *mcpclient.Client, toolCall openai.ChatCompletionMessageToolCall) (string, error) {
-	var args map[string]any
+	if cfg.Output.JSONSchema != "" {
+		schema, err := loadSchema(cfg.Output.JSONSchema)
+		if err != nil {
+			return err
+		}
 
-	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-		return "", fmt.Errorf("failed to unmarshal tool arguments: %v", err)
+		a.Schema = schema
+		a.SchemaRepairs = cfg.Output.SchemaRepairs
 	}
 
-	switch toolCall.Function.Name {
-	case "sandbox_run_code":
-		printCodeBox(args["code"].(string), "python")
+	return nil
+}
+
+func loadSchema(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
-	mcpToolRequest := mcp.CallToolRequest{
-		Request: mcp.Request{
-			Method: "tools/call",
-		},
-		Params: mcp.CallToolParams{
-			Name:      toolCall.Function.Name,
-			Arguments: args,
-		},
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %v", path, err)
 	}
 
-	toolResult, err := mcpClient.CallTool(ctx, mcpToolRequest)
+	return schema, nil
+}
+
+func chooseSession(ctx context.Context, workspace string) (*session, error) {
+	sessions, err := listSessions(workspace)
 	if err != nil {
-		return "", fmt.Errorf("failed to call tool: %v", err)
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
 	}
 
-	var resultText string
+	options := []huh.Option[*session]{huh.NewOption[*session]("Start a new session", nil)}
+	for _, s := range sessions {
+		label := fmt.Sprintf("%s  %s", s.Updated.Format("2006-01-02 15:04"), s.title())
+		options = append(options, huh.NewOption(label, s))
+	}
 
-	if len(toolResult.Content) > 0 {
-		if textContent, ok := mcp.AsTextContent(toolResult.Content[0]); ok {
-			resultText = textContent.Text
-		} else {
-			resultText = fmt.Sprintf("%v", toolResult.Content[0])
+	var chosen *session
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewSelect[*session]().
+				Title(fmt.Sprintf("Resume a session in %s?", workspace)).
+				Value(&chosen).
+				Height(10).
+				Options(options...),
+		),
+	)
+
+	if err := form.RunWithContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return chosen, nil
+}
+
+func showForm(ctx context.Context, models []string, defaultModel string) (string, string, error) {
+	var (
+		question string
+		model    = defaultModel
+	)
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewText().
+				Title(tr("Enter a task")).
+				Description(tr("ctrl+e to write it in $EDITOR")).
+				Lines(3).
+				Editor(editorCommand()...).
+				Value(&question),
+			huh.NewSelect[string]().
+				Title(tr("Select a model")).
+				Value(&model).
+				Height(10).
+				Options(huh.NewOptions(models...)...),
+		),
+	)
+
+	if err := form.RunWithContext(ctx); err != nil {
+		log.Fatalf("Failed to run input: %v", err)
+	}
+
+	return question, model, nil
+}
+
+type modelInfo struct {
+	ID            string
+	ContextLength int64
+
+	// Tokenizer is the family of the model's tokenizer, such as GPT, Claude
+	// or Llama3, and PromptPrice what a prompt token costs in dollars, as
+	// OpenRouter reports them.
+	Tokenizer   string
+	PromptPrice float64
+
+	// SupportedParameters are the request parameters OpenRouter says the
+	// model supports, such as tools. Nil means it didn't say.
+	SupportedParameters []string
+}
+
+// supportsTools reports whether the model supports tool calls, and whether
+// that is known.
+func (m modelInfo) supportsTools() (supported, known bool) {
+	if m.SupportedParameters == nil {
+		return false, false
+	}
+
+	return slices.Contains(m.SupportedParameters, "tools"), true
+}
+
+func fetchModels(ctx context.Context, openaiClient openai.Client) (res []modelInfo, err error) {
+	models := openaiClient.Models.ListAutoPaging(ctx)
+
+	for models.Next() {
+		model := models.Current()
+		info := modelInfo{ID: model.ID}
+
+		// OpenRouter reports the context window alongside the standard fields.
+		if contextLength, ok := model.JSON.ExtraFields["context_length"]; ok {
+			info.ContextLength, _ = strconv.ParseInt(contextLength.Raw(), 10, 64)
+		}
+		if architecture, ok := model.JSON.ExtraFields["architecture"]; ok {
+			var fields struct {
+				Tokenizer string `json:"tokenizer"`
+			}
+			if json.Unmarshal([]byte(architecture.Raw()), &fields) == nil {
+				info.Tokenizer = fields.Tokenizer
+			}
+		}
+		if pricing, ok := model.JSON.ExtraFields["pricing"]; ok {
+			var fields struct {
+				Prompt string `json:"prompt"`
+			}
+			if json.Unmarshal([]byte(pricing.Raw()), &fields) == nil {
+				info.PromptPrice, _ = strconv.ParseFloat(fields.Prompt, 64)
+			}
+		}
+		if parameters, ok := model.JSON.ExtraFields["supported_parameters"]; ok {
+			json.Unmarshal([]byte(parameters.Raw()), &info.SupportedParameters)
+		}
+
+		res = append(res, info)
+	}
+
+	if err := models.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// findModel returns what is known about model, which is nothing for models
+// the provider didn't list.
+func findModel(models []modelInfo, model string) modelInfo {
+	for _, info := range models {
+		if info.ID == model {
+			return info
 		}
 	}
 
-	return resultText, nil
+	return modelInfo{}
+}
+
+func modelIDs(models []modelInfo) []string {
+	ids := make([]string, len(models))
+	for i, model := range models {
+		ids[i] = model.ID
+	}
+
+	return ids
 }
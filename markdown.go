@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+)
+
+// markdown renders assistant responses in the terminal. It is nil with
+// -plain, in which case responses are printed as they are.
+var markdown *glamour.TermRenderer
+
+func newMarkdownRenderer() (*glamour.TermRenderer, error) {
+	width, _ := terminalSize()
+
+	// Leave room for the result box's border, padding and margin.
+	return glamour.NewTermRenderer(
+		glamour.WithStandardStyle(currentTheme.markdown),
+		glamour.WithWordWrap(max(width-8, 20)),
+	)
+}
+
+func renderMarkdown(content string) string {
+	if markdown == nil {
+		return content
+	}
+
+	rendered, err := markdown.Render(content)
+	if err != nil {
+		return content
+	}
+
+	return strings.Trim(rendered, "\n")
+}
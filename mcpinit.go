@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// mcpInitializeConfig is what the client tells the MCP server about itself
+// in the initialize request: the protocol version it asks for, its name and
+// version, and experimental capabilities. Empty fields keep the defaults.
+type mcpInitializeConfig struct {
+	ProtocolVersion string         `json:"protocol_version,omitempty"`
+	ClientName      string         `json:"client_name,omitempty"`
+	ClientVersion   string         `json:"client_version,omitempty"`
+	Experimental    map[string]any `json:"experimental,omitempty"`
+}
+
+func (c mcpInitializeConfig) options() agent.InitOptions {
+	return agent.InitOptions{
+		ProtocolVersion: c.ProtocolVersion,
+		ClientInfo:      mcp.Implementation{Name: c.ClientName, Version: c.ClientVersion},
+		Experimental:    c.Experimental,
+	}
+}
+
+func (c mcpInitializeConfig) validate() error {
+	if c.ProtocolVersion != "" && !slices.Contains(mcp.ValidProtocolVersions, c.ProtocolVersion) {
+		return fmt.Errorf("unknown MCP protocol version %q, expected one of %s", c.ProtocolVersion, strings.Join(mcp.ValidProtocolVersions, ", "))
+	}
+
+	return nil
+}
+
+// describeCapabilities lists what a server said it can do, such as
+// "tools (list changes), resources (subscribe), logging".
+func describeCapabilities(c mcp.ServerCapabilities) string {
+	var described []string
+
+	withFeatures := func(name string, features ...string) {
+		features = slices.DeleteFunc(features, func(f string) bool { return f == "" })
+		if len(features) > 0 {
+			name += " (" + strings.Join(features, ", ") + ")"
+		}
+		described = append(described, name)
+	}
+	feature := func(set bool, name string) string {
+		if set {
+			return name
+		}
+		return ""
+	}
+
+	if c.Tools != nil {
+		withFeatures("tools", feature(c.Tools.ListChanged, "list changes"))
+	}
+	if c.Resources != nil {
+		withFeatures("resources", feature(c.Resources.Subscribe, "subscribe"), feature(c.Resources.ListChanged, "list changes"))
+	}
+	if c.Prompts != nil {
+		withFeatures("prompts", feature(c.Prompts.ListChanged, "list changes"))
+	}
+	if c.Logging != nil {
+		withFeatures("logging")
+	}
+	if len(c.Experimental) > 0 {
+		withFeatures("experimental", slices.Sorted(maps.Keys(c.Experimental))...)
+	}
+
+	if len(described) == 0 {
+		return "none"
+	}
+
+	return strings.Join(described, ", ")
+}
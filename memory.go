@@ -0,0 +1,306 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// memoryConfig enables long-term memory: facts and preferences about the
+// user are extracted from every task with Model, or the session's model, and
+// up to Limit of those most related to a task are sent with it.
+type memoryConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+const memoryPrompt = "You maintain the long-term memory of an AI assistant. Below are what it already remembers about the user and their latest exchange with it. " +
+	"List new facts about the user, their preferences, setup or ongoing projects that would help in future, unrelated conversations. " +
+	"Only include what the user stated or clearly implied, not what the assistant worked out; skip anything already remembered, temporary or specific to this task. " +
+	"Write each as a short, self-contained sentence. Most exchanges have nothing worth remembering."
+
+var memorySchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"memories": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		},
+	},
+	"required":             []any{"memories"},
+	"additionalProperties": false,
+}
+
+type memory struct {
+	ID      int64
+	Text    string
+	Session string
+	Created time.Time
+}
+
+// memoryStore keeps memories in SQLite in the app directory.
+type memoryStore struct {
+	db *sql.DB
+}
+
+func openMemory() (*memoryStore, error) {
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "memory.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS memories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		text TEXT NOT NULL,
+		session TEXT NOT NULL,
+		created BIGINT NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create memories table: %v", err)
+	}
+
+	return &memoryStore{db: db}, nil
+}
+
+func (m *memoryStore) Close() error {
+	return m.db.Close()
+}
+
+func (m *memoryStore) list() ([]memory, error) {
+	rows, err := m.db.Query(`SELECT id, text, session, created FROM memories ORDER BY created DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memories []memory
+
+	for rows.Next() {
+		var (
+			mem     memory
+			created int64
+		)
+		if err := rows.Scan(&mem.ID, &mem.Text, &mem.Session, &created); err != nil {
+			return nil, err
+		}
+		mem.Created = time.Unix(0, created)
+
+		text, err := decrypt([]byte(mem.Text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read memory %d: %v", mem.ID, err)
+		}
+		mem.Text = string(text)
+
+		memories = append(memories, mem)
+	}
+
+	return memories, rows.Err()
+}
+
+func (m *memoryStore) add(session string, texts []string) error {
+	for _, text := range texts {
+		if _, err := m.db.Exec(`INSERT INTO memories (text, session, created) VALUES (?, ?, ?)`, string(encrypt([]byte(text))), session, time.Now().UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forget deletes a memory, reporting whether it existed.
+func (m *memoryStore) forget(id int64) (bool, error) {
+	result, err := m.db.Exec(`DELETE FROM memories WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (m *memoryStore) forgetAll() error {
+	_, err := m.db.Exec(`DELETE FROM memories`)
+	return err
+}
+
+// relevant returns up to limit memories, those sharing the most words with
+// task first and the most recent among equals.
+func (m *memoryStore) relevant(task string, limit int) ([]memory, error) {
+	memories, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+
+	taskWords := words(task)
+
+	score := func(mem memory) int {
+		n := 0
+		for word := range words(mem.Text) {
+			if taskWords[word] {
+				n++
+			}
+		}
+		return n
+	}
+
+	slices.SortStableFunc(memories, func(a, b memory) int {
+		return cmp.Compare(score(b), score(a))
+	})
+
+	return memories[:min(len(memories), limit)], nil
+}
+
+// retrieve formats the memories relevant to task to be sent with it, as
+// reordered by reranker if set.
+func (m *memoryStore) retrieve(ctx context.Context, task string, limit int, reranker *reranker) (string, error) {
+	memories, err := m.relevant(task, reranker.pool(limit))
+	if err != nil || len(memories) == 0 {
+		return "", err
+	}
+
+	texts := make([]string, len(memories))
+	for i, mem := range memories {
+		texts[i] = mem.Text
+	}
+
+	var sb strings.Builder
+	sb.WriteString("What you remember about the user from previous conversations:\n")
+	for _, i := range reranker.rerank(ctx, task, texts, limit) {
+		sb.WriteString("- " + memories[i].Text + "\n")
+	}
+
+	return sb.String(), nil
+}
+
+// words returns the lowercased words of s longer than three letters, which
+// leaves out most words that say nothing about a memory.
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(word)) > 3 {
+			set[word] = true
+		}
+	}
+
+	return set
+}
+
+// memorize extracts memories from the latest exchange of a session and
+// stores them.
+func (b *backend) memorize(ctx context.Context, sess *session, task, answer string) error {
+	existing, err := b.memory.list()
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(memoryPrompt + "\n\n## Already remembered\n")
+	for _, mem := range existing {
+		sb.WriteString("- " + mem.Text + "\n")
+	}
+	fmt.Fprintf(&sb, "\n## User\n%s\n\n## Assistant\n%s\n", task, answer)
+
+	extractor := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(cmp.Or(b.config().Memory.Model, sess.Model)),
+	)
+	extractor.Schema = memorySchema
+
+	result, err := extractor.Run(ctx, sb.String())
+	if err != nil {
+		return err
+	}
+
+	var extracted struct {
+		Memories []string `json:"memories"`
+	}
+	if err := json.Unmarshal([]byte(result.Answer), &extracted); err != nil {
+		return fmt.Errorf("failed to parse memories: %v", err)
+	}
+
+	var texts []string
+	for _, text := range extracted.Memories {
+		if text = strings.TrimSpace(text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+
+	return b.memory.add(sess.ID, texts)
+}
+
+func memoryCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: memory list|forget <id...>|forget all")
+	}
+
+	m, err := openMemory()
+	if err != nil {
+		return fmt.Errorf("failed to open memory: %v", err)
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "list":
+		memories, err := m.list()
+		if err != nil {
+			return err
+		}
+
+		for _, mem := range memories {
+			print("%s  %s  %s", sessionIDStyle.Render(strconv.FormatInt(mem.ID, 10)), mem.Created.Format("2006-01-02 15:04"), mem.Text)
+		}
+
+		return nil
+	case "forget":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: memory forget <id...>|all")
+		}
+
+		if len(args) == 2 && args[1] == "all" {
+			return m.forgetAll()
+		}
+
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid memory ID %q", arg)
+			}
+
+			ok, err := m.forget(id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("no memory with ID %d", id)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown memory command %q", args[0])
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metrics collects counters and histograms from agents' events and serves
+// them in the Prometheus text format.
+type metrics struct {
+	mu sync.Mutex
+
+	// Series are keyed by name, then by their rendered labels.
+	counters   map[string]map[string]float64
+	gauges     map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+
+	help map[string]string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		counters:   make(map[string]map[string]float64),
+		gauges:     make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+		help: map[string]string{
+			"mcp_experiment_completions_total":           "Completions, by model and finish reason.",
+			"mcp_experiment_completion_duration_seconds": "Time taken by completions, by model.",
+			"mcp_experiment_tokens_total":                "Tokens used, by model and type.",
+			"mcp_experiment_cost_dollars_total":          "Cost reported by the provider, by model.",
+			"mcp_experiment_tool_calls_total":            "Tool calls, by tool and status.",
+			"mcp_experiment_tool_call_duration_seconds":  "Time taken by tool calls that ran, by tool.",
+			"mcp_experiment_tasks_total":                 "Finished tasks, by status.",
+			"mcp_experiment_tasks_running":               "Tasks running now.",
+			"mcp_experiment_errors_total":                "Failed tasks, by error class.",
+		},
+	}
+}
+
+// labels renders label pairs, given as name, value, name, value...
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (m *metrics) add(name, labels string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][labels] += value
+}
+
+func (m *metrics) gauge(name, labels string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][labels] += delta
+}
+
+func (m *metrics) observe(name, labels string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.histograms[name] == nil {
+		m.histograms[name] = make(map[string]*histogram)
+	}
+
+	h, ok := m.histograms[name][labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.histograms[name][labels] = h
+	}
+
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// observeEvent records the completions and tool calls of an agent. It is
+// given to agents as an observer.
+func (m *metrics) observeEvent(event agent.Event) {
+	switch event := event.(type) {
+	case agent.UsageUpdated:
+		turn := event.Turn
+
+		m.add("mcp_experiment_completions_total", labels("model", turn.Model, "finish_reason", turn.FinishReason), 1)
+		m.add("mcp_experiment_tokens_total", labels("model", turn.Model, "type", "prompt"), float64(turn.Usage.PromptTokens))
+		m.add("mcp_experiment_tokens_total", labels("model", turn.Model, "type", "completion"), float64(turn.Usage.CompletionTokens))
+		m.add("mcp_experiment_cost_dollars_total", labels("model", turn.Model), turn.Usage.Cost)
+		if turn.Duration > 0 {
+			m.observe("mcp_experiment_completion_duration_seconds", labels("model", turn.Model), turn.Duration.Seconds())
+		}
+	case agent.ToolCallFinished:
+		name := event.ToolCall.Function.Name
+
+		status := "ok"
+		switch {
+		case event.Approval == agent.ApprovalDeclined || event.Approval == agent.ApprovalDenied:
+			status = string(event.Approval)
+		case event.Err != nil:
+			status = "error"
+		}
+
+		m.add("mcp_experiment_tool_calls_total", labels("tool", name, "status", status), 1)
+		if status == "ok" || status == "error" {
+			m.observe("mcp_experiment_tool_call_duration_seconds", labels("tool", name), event.Duration.Seconds())
+		}
+	}
+}
+
+// taskStarted counts a running task, and returns a function to call with
+// how it ended.
+func (m *metrics) taskStarted() func(err error) {
+	m.gauge("mcp_experiment_tasks_running", "", 1)
+
+	return func(err error) {
+		m.gauge("mcp_experiment_tasks_running", "", -1)
+
+		if err != nil {
+			class, _ := classifyError(err)
+			m.add("mcp_experiment_tasks_total", labels("status", "error"), 1)
+			m.add("mcp_experiment_errors_total", labels("class", class), 1)
+			return
+		}
+		m.add("mcp_experiment_tasks_total", labels("status", "ok"), 1)
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(m.counters)) {
+		m.writeHeader(w, name, "counter")
+		for _, l := range slices.Sorted(maps.Keys(m.counters[name])) {
+			fmt.Fprintf(w, "%s%s %s\n", name, braces(l), formatFloat(m.counters[name][l]))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.gauges)) {
+		m.writeHeader(w, name, "gauge")
+		for _, l := range slices.Sorted(maps.Keys(m.gauges[name])) {
+			fmt.Fprintf(w, "%s%s %s\n", name, braces(l), formatFloat(m.gauges[name][l]))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.histograms)) {
+		m.writeHeader(w, name, "histogram")
+		for _, l := range slices.Sorted(maps.Keys(m.histograms[name])) {
+			h := m.histograms[name][l]
+
+			for i, bound := range latencyBuckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, braces(joinLabels(l, labels("le", formatFloat(bound)))), h.counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, braces(joinLabels(l, labels("le", "+Inf"))), h.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(l), formatFloat(h.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, braces(l), h.count)
+		}
+	}
+}
+
+func (m *metrics) writeHeader(w io.Writer, name, kind string) {
+	if help, ok := m.help[name]; ok {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
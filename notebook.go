@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// notebook is a Jupyter notebook in nbformat 4.
+type notebook struct {
+	Cells         []notebookCell `json:"cells"`
+	Metadata      map[string]any `json:"metadata"`
+	NBFormat      int            `json:"nbformat"`
+	NBFormatMinor int            `json:"nbformat_minor"`
+}
+
+// notebookCell is a Markdown or code cell. Code cells need an execution
+// count and outputs, even if empty, while Markdown cells can't have them.
+type notebookCell struct {
+	CellType       string            `json:"cell_type"`
+	Metadata       map[string]any    `json:"metadata"`
+	Source         []string          `json:"source"`
+	ExecutionCount *int              `json:"execution_count,omitempty"`
+	Outputs        *[]notebookOutput `json:"outputs,omitempty"`
+}
+
+type notebookOutput struct {
+	OutputType string   `json:"output_type"`
+	Name       string   `json:"name"`
+	Text       []string `json:"text"`
+}
+
+// exportNotebook turns a session into a notebook: user tasks and assistant
+// commentary become Markdown cells, and calls to code tools become code
+// cells with the tool's result as their output.
+func exportNotebook(s *session, codeTools map[string]codeToolConfig) ([]byte, error) {
+	results := make(map[string]string)
+	for _, message := range s.Messages {
+		if message.OfTool != nil {
+			results[message.OfTool.ToolCallID] = message.OfTool.Content.OfString.Value
+		}
+	}
+
+	// Summarized tool outputs are exported in full.
+	for id, output := range s.RawOutputs {
+		results[id] = output
+	}
+
+	nb := notebook{
+		Metadata: map[string]any{
+			"kernelspec": map[string]any{
+				"name":         "python3",
+				"display_name": "Python 3",
+				"language":     "python",
+			},
+			"language_info": map[string]any{"name": "python"},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 4,
+	}
+
+	var executed int
+
+	for _, message := range s.Messages {
+		switch {
+		case message.OfUser != nil:
+			nb.Cells = append(nb.Cells, markdownCell("**Task:** "+message.OfUser.Content.OfString.Value))
+		case message.OfAssistant != nil:
+			if content := message.OfAssistant.Content.OfString.Value; strings.TrimSpace(content) != "" {
+				nb.Cells = append(nb.Cells, markdownCell(content))
+			}
+
+			for _, toolCall := range message.OfAssistant.ToolCalls {
+				var args map[string]any
+				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+					continue
+				}
+
+				code, _, ok := toolCode(codeTools, toolCall.Function.Name, args)
+				if !ok {
+					continue
+				}
+
+				executed++
+				count := executed
+
+				outputs := []notebookOutput{}
+				if result := results[toolCall.ID]; result != "" {
+					outputs = append(outputs, notebookOutput{
+						OutputType: "stream",
+						Name:       "stdout",
+						Text:       notebookLines(result),
+					})
+				}
+
+				nb.Cells = append(nb.Cells, notebookCell{
+					CellType:       "code",
+					Metadata:       map[string]any{"tool": toolCall.Function.Name},
+					Source:         notebookLines(code),
+					ExecutionCount: &count,
+					Outputs:        &outputs,
+				})
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+func markdownCell(text string) notebookCell {
+	return notebookCell{
+		CellType: "markdown",
+		Metadata: map[string]any{},
+		Source:   notebookLines(text),
+	}
+}
+
+// notebookLines splits text into lines keeping their line endings, as
+// notebooks store multi-line strings.
+func notebookLines(text string) []string {
+	return strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// notifyConfig announces when a task finishes or fails, with a desktop
+// notification and/or a Slack-compatible webhook, for tasks left running.
+type notifyConfig struct {
+	Desktop bool   `json:"desktop,omitempty"`
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// notifier returns the function announcing finished tasks and running the
+// on-complete and on-error hooks, or nil if there is nothing to do.
+func notifier(cfg *config) func(ctx context.Context, task string, result *agent.Result, err error) {
+	n, hooks := cfg.Notify, cfg.Hooks
+	if !n.Desktop && n.Webhook == "" && len(hooks.OnComplete) == 0 && len(hooks.OnError) == 0 {
+		return nil
+	}
+
+	httpClient, clientErr := newHTTPClient(cfg)
+
+	return func(ctx context.Context, task string, result *agent.Result, err error) {
+		hooks.taskFinished(ctx, task, result, err)
+
+		// Interrupted tasks were stopped by someone watching.
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		title, text := "Task finished", ""
+		if err != nil {
+			title, text = "Task failed", err.Error()
+		} else if result != nil {
+			text = result.Answer
+		}
+
+		if n.Desktop {
+			if err := desktopNotify(title, text); err != nil {
+				log.Printf("Failed to show notification: %v", err)
+			}
+		}
+
+		if n.Webhook != "" {
+			if clientErr != nil {
+				log.Printf("Failed to send notification: %v", clientErr)
+				return
+			}
+
+			body, _ := json.Marshal(map[string]string{
+				"text": fmt.Sprintf("*%s:* %s\n\n%s", title, firstLine(task), text),
+			})
+			if err := postJSON(ctx, httpClient, n.Webhook, nil, string(body)); err != nil {
+				log.Printf("Failed to send notification: %v", err)
+			}
+		}
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// notificationLimit is the most characters of a body shown in a desktop
+// notification, which only has room for a line or two.
+const notificationLimit = 200
+
+// desktopNotify shows a desktop notification with osascript on macOS,
+// PowerShell on Windows and notify-send elsewhere.
+func desktopNotify(title, body string) error {
+	body = strings.Join(strings.Fields(body), " ")
+	if runes := []rune(body); len(runes) > notificationLimit {
+		body = string(runes[:notificationLimit-1]) + "…"
+	}
+
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:NOTIFY_BODY)) | Out-Null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('mcp-experiment').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		cmd.Env = append(cmd.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_BODY="+body)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=mcp-experiment", title, body)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
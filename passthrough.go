@@ -0,0 +1,38 @@
+package main
+
+import (
+	"cmp"
+	"os"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// passthroughConfig has the model's provider call the MCP server itself,
+// through the Responses API of the profile's API, rather than the agent
+// calling its tools. URL is where the provider reaches the server, so it must
+// be public. Header values may refer to environment variables, like
+// $MCP_TOKEN.
+type passthroughConfig struct {
+	URL          string            `json:"url,omitempty"`
+	Label        string            `json:"label,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	AllowedTools []string          `json:"allowed_tools,omitempty"`
+}
+
+func (p passthroughConfig) enabled() bool {
+	return p.URL != ""
+}
+
+func (p passthroughConfig) server() agent.RemoteMCPServer {
+	headers := make(map[string]string)
+	for name, value := range p.Headers {
+		headers[name] = os.ExpandEnv(value)
+	}
+
+	return agent.RemoteMCPServer{
+		Label:        cmp.Or(p.Label, "mcp"),
+		URL:          p.URL,
+		Headers:      headers,
+		AllowedTools: p.AllowedTools,
+	}
+}
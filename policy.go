@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"gopkg.in/yaml.v3"
+)
+
+// policyRule allows, asks about or denies calls to the tools matching Tool,
+// a glob, whose arguments meet every condition in When.
+type policyRule struct {
+	Tool   string            `json:"tool" yaml:"tool"`
+	When   []policyCondition `json:"when,omitempty" yaml:"when"`
+	Action string            `json:"action" yaml:"action"`
+	Reason string            `json:"reason,omitempty" yaml:"reason"`
+}
+
+// policyCondition holds when an argument matches the regular expression
+// Matches, or is a path outside all of Outside, or both if both are set.
+type policyCondition struct {
+	Argument string   `json:"argument" yaml:"argument"`
+	Matches  string   `json:"matches,omitempty" yaml:"matches"`
+	Outside  []string `json:"outside,omitempty" yaml:"outside"`
+}
+
+// compilePolicy returns the rules of the policy file, if any, followed by
+// those of the config.
+func compilePolicy(file string, rules []policyRule) ([]agent.ToolRule, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %v", err)
+		}
+
+		var fileRules []policyRule
+		if err := yaml.Unmarshal(data, &fileRules); err != nil {
+			return nil, fmt.Errorf("failed to parse policy %s: %v", file, err)
+		}
+
+		rules = append(fileRules, rules...)
+	}
+
+	var compiled []agent.ToolRule
+
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Tool, ""); err != nil || rule.Tool == "" {
+			return nil, fmt.Errorf("invalid tool pattern %q in policy", rule.Tool)
+		}
+
+		action := agent.RuleAction(rule.Action)
+		switch action {
+		case agent.RuleAllow, agent.RuleAsk, agent.RuleDeny:
+		default:
+			return nil, fmt.Errorf("unknown policy action %q for %s, expected allow, ask or deny", rule.Action, rule.Tool)
+		}
+
+		toolRule := agent.ToolRule{Tool: rule.Tool, Action: action, Reason: rule.Reason}
+
+		for _, condition := range rule.When {
+			if condition.Argument == "" || condition.Matches == "" && len(condition.Outside) == 0 {
+				return nil, fmt.Errorf("policy conditions for %s need an argument, and matches or outside", rule.Tool)
+			}
+
+			c := agent.Condition{Argument: condition.Argument, Outside: condition.Outside}
+			if condition.Matches != "" {
+				pattern, err := regexp.Compile(condition.Matches)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q in policy: %v", condition.Matches, err)
+				}
+				c.Pattern = pattern
+			}
+
+			toolRule.When = append(toolRule.When, c)
+		}
+
+		compiled = append(compiled, toolRule)
+	}
+
+	return compiled, nil
+}
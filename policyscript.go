@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/cedws/mcp-experiment/agent"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// policyScript is a Starlark script inspecting tool calls and their results.
+// It may define either of:
+//
+//	def pre_tool_call(call): ...
+//	def post_tool_call(call, result): ...
+//
+// where call is a dict of the tool, call ID and arguments. pre_tool_call
+// returns None to make the call as it is, a dict of other arguments to make
+// it with, or a string to reject it with that reason. post_tool_call returns
+// None to keep the result, or a string to give the model instead.
+type policyScript struct {
+	name string
+	pre  starlark.Callable
+	post starlark.Callable
+}
+
+// maxScriptSteps keeps a script stuck in a loop from stopping the run.
+const maxScriptSteps = 10_000_000
+
+func loadPolicyScript(path string) (*policyScript, error) {
+	s := &policyScript{name: filepath.Base(path)}
+
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, s.thread(), path, nil, starlark.StringDict{
+		"json": starlarkjson.Module,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy script: %v", err)
+	}
+
+	var ok bool
+	if fn := globals["pre_tool_call"]; fn != nil {
+		if s.pre, ok = fn.(starlark.Callable); !ok {
+			return nil, fmt.Errorf("pre_tool_call in %s isn't a function", s.name)
+		}
+	}
+	if fn := globals["post_tool_call"]; fn != nil {
+		if s.post, ok = fn.(starlark.Callable); !ok {
+			return nil, fmt.Errorf("post_tool_call in %s isn't a function", s.name)
+		}
+	}
+	if s.pre == nil && s.post == nil {
+		return nil, fmt.Errorf("%s defines neither pre_tool_call nor post_tool_call", s.name)
+	}
+
+	return s, nil
+}
+
+// thread returns a thread to call the script's functions in, whose print
+// logs. Frozen after loading, the functions can run in several at once.
+func (s *policyScript) thread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: s.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("%s: %s", s.name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+
+	return thread
+}
+
+func (s *policyScript) preToolCall(ctx context.Context, call agent.ToolCallStarted) (map[string]any, string, error) {
+	result, err := s.call(ctx, s.pre, map[string]any{
+		"tool":      call.ToolCall.Function.Name,
+		"id":        call.ToolCall.ID,
+		"arguments": call.Arguments,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch result := result.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return nil, result, nil
+	case map[string]any:
+		return result, "", nil
+	default:
+		return nil, "", fmt.Errorf("pre_tool_call in %s returned %T, expected None, a dict of arguments or a reason to reject the call", s.name, result)
+	}
+}
+
+func (s *policyScript) postToolCall(ctx context.Context, call agent.ToolCallFinished) (string, error) {
+	result, err := s.call(ctx, s.post, map[string]any{
+		"tool":      call.ToolCall.Function.Name,
+		"id":        call.ToolCall.ID,
+		"server":    call.Server,
+		"arguments": call.Arguments,
+		"failed":    call.Failed,
+	}, call.Result)
+	if err != nil {
+		return "", err
+	}
+
+	switch result := result.(type) {
+	case nil:
+		return call.Result, nil
+	case string:
+		return result, nil
+	default:
+		return "", fmt.Errorf("post_tool_call in %s returned %T, expected None or a result", s.name, result)
+	}
+}
+
+// call calls fn with args, passed and returned as JSON values, stopping it if
+// ctx is cancelled.
+func (s *policyScript) call(ctx context.Context, fn starlark.Callable, args ...any) (any, error) {
+	thread := s.thread()
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	decode := starlarkjson.Module.Members["decode"]
+	encode := starlarkjson.Module.Members["encode"]
+
+	values := make(starlark.Tuple, len(args))
+	for i, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i], err = starlark.Call(thread, decode, starlark.Tuple{starlark.String(data)}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := starlark.Call(thread, fn, values, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", fn.Name(), err)
+	}
+
+	data, err := starlark.Call(thread, encode, starlark.Tuple{result}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s returned a value that isn't JSON: %v", fn.Name(), err)
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(data.(starlark.String)), &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
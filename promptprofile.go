@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// defaultPromptProfile names the built-in system prompts, unless the config
+// has a profile of that name.
+const defaultPromptProfile = "default"
+
+// startPrompts are the system prompts new sessions start with, set by
+// applyPromptProfile.
+var startPrompts = systemPrompts
+
+func applyPromptProfile(cfg *config) error {
+	prompts, err := profilePrompts(cfg, cfg.PromptProfile)
+	if err != nil {
+		return err
+	}
+	startPrompts = prompts
+
+	return nil
+}
+
+// profilePrompts returns the system prompts of a prompt profile.
+func profilePrompts(cfg *config, name string) ([]string, error) {
+	if prompts, ok := cfg.PromptProfiles[name]; ok {
+		return prompts, nil
+	}
+	if name == "" || name == defaultPromptProfile {
+		return systemPrompts, nil
+	}
+
+	names := append(slices.Sorted(maps.Keys(cfg.PromptProfiles)), defaultPromptProfile)
+
+	return nil, fmt.Errorf("unknown prompt profile %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// matchPromptProfile names the profile whose system prompts a conversation
+// starts with, if any.
+func matchPromptProfile(cfg *config, prompts []string) string {
+	translated := func(profile []string) []string {
+		var out []string
+		for _, prompt := range profile {
+			out = append(out, tr(prompt))
+		}
+		return out
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.PromptProfiles)) {
+		if slices.Equal(translated(cfg.PromptProfiles[name]), prompts) {
+			return name
+		}
+	}
+	if _, ok := cfg.PromptProfiles[defaultPromptProfile]; !ok && slices.Equal(translated(systemPrompts), prompts) {
+		return defaultPromptProfile
+	}
+
+	return ""
+}
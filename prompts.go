@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/charmbracelet/huh"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+// promptArgument is an argument of a prompt or a variable of a resource
+// template, to be filled in.
+type promptArgument struct {
+	name        string
+	description string
+	required    bool
+}
+
+// cmdPrompt fills in the arguments of a prompt of the servers, offering their
+// completions, and leaves its text as the next task to edit.
+func (r *repl) cmdPrompt(ctx context.Context, args []string) error {
+	prompts, err := r.agent.Prompts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(prompts) == 0 {
+		r.print("%s", tr("The servers offer no prompts"))
+		return nil
+	}
+
+	var names, descriptions []string
+	for _, prompt := range prompts {
+		names = append(names, prompt.Name)
+		descriptions = append(descriptions, prompt.Description)
+	}
+
+	i, err := r.pick(ctx, tr("Prompt"), names, descriptions, args)
+	if i < 0 || err != nil {
+		return err
+	}
+	prompt := prompts[i]
+
+	var arguments []promptArgument
+	for _, argument := range prompt.Arguments {
+		arguments = append(arguments, promptArgument{argument.Name, argument.Description, argument.Required})
+	}
+
+	values, err := r.askArguments(ctx, prompt.Name, arguments, args[min(len(args), 1):], func(name, value string) ([]string, error) {
+		return r.agent.CompletePrompt(ctx, prompt, name, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	result, err := r.agent.GetPrompt(ctx, prompt, values)
+	if err != nil {
+		return err
+	}
+
+	var parts []string
+	for _, message := range result.Messages {
+		if content, ok := message.Content.(mcp.TextContent); ok {
+			parts = append(parts, content.Text)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("prompt %s has no text", prompt.Name)
+	}
+
+	r.draft = strings.Join(parts, "\n\n")
+
+	return nil
+}
+
+// cmdResource fills in the variables of a resource template of the servers,
+// offering their completions, and attaches the resource to the next task.
+func (r *repl) cmdResource(ctx context.Context, args []string) error {
+	templates, err := r.agent.ResourceTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		r.print("%s", tr("The servers offer no resource templates"))
+		return nil
+	}
+
+	var names, descriptions []string
+	for _, template := range templates {
+		names = append(names, template.Name)
+		descriptions = append(descriptions, template.Description)
+	}
+
+	i, err := r.pick(ctx, tr("Resource"), names, descriptions, args)
+	if i < 0 || err != nil {
+		return err
+	}
+	template := templates[i]
+
+	var variables []promptArgument
+	for _, name := range template.URITemplate.Varnames() {
+		variables = append(variables, promptArgument{name: name, required: true})
+	}
+
+	values, err := r.askArguments(ctx, template.Name, variables, args[min(len(args), 1):], func(name, value string) ([]string, error) {
+		return r.agent.CompleteResource(ctx, template, name, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	expand := uritemplate.Values{}
+	for name, value := range values {
+		expand.Set(name, uritemplate.String(value))
+	}
+
+	uri, err := template.URITemplate.Expand(expand)
+	if err != nil {
+		return fmt.Errorf("failed to expand %s: %v", template.URITemplate.Raw(), err)
+	}
+
+	contents, err := r.agent.ReadResource(ctx, template, uri)
+	if err != nil {
+		return err
+	}
+
+	var text []string
+	for _, content := range contents {
+		if content, ok := content.(mcp.TextResourceContents); ok {
+			text = append(text, content.Text)
+		}
+	}
+	if len(text) == 0 {
+		return fmt.Errorf("%s has no text", uri)
+	}
+
+	a, err := newAttachment(uri, []byte(strings.Join(text, "\n")), r.cfg.Attachments)
+	if err != nil {
+		return err
+	}
+	r.attachments = append(r.attachments, a)
+
+	r.print("%s", tr("Attached %s to the next task", uri))
+
+	return nil
+}
+
+// pick returns the index of the name given first in args, or asks which one.
+// In the TUI, where there are no forms, it lists them and returns -1.
+func (r *repl) pick(ctx context.Context, title string, names, descriptions []string, args []string) (int, error) {
+	if len(args) > 0 {
+		for i, name := range names {
+			if name == args[0] {
+				return i, nil
+			}
+		}
+		return -1, errors.New(tr("nothing is named %s", args[0]))
+	}
+
+	if r.out != terminalOut {
+		for i, name := range names {
+			r.print("  %s  %s", name, firstLine(descriptions[i]))
+		}
+		return -1, nil
+	}
+
+	var options []huh.Option[int]
+	for i, name := range names {
+		label := name
+		if descriptions[i] != "" {
+			label += " — " + firstLine(descriptions[i])
+		}
+		options = append(options, huh.NewOption(label, i))
+	}
+
+	var i int
+	form := newForm(
+		huh.NewGroup(
+			huh.NewSelect[int]().
+				Title(title).
+				Options(options...).
+				Value(&i),
+		),
+	)
+
+	return i, form.RunWithContext(ctx)
+}
+
+// askArguments returns the values of arguments, from the name=value args and
+// from a form asking for the rest, which offers the completions of complete
+// as they are typed. In the TUI, the required ones must be given as args.
+func (r *repl) askArguments(ctx context.Context, title string, arguments []promptArgument, args []string, complete func(name, value string) ([]string, error)) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, errors.New(tr("expected name=value, got %s", arg))
+		}
+		values[name] = value
+	}
+
+	var missing []promptArgument
+	for _, argument := range arguments {
+		if _, ok := values[argument.name]; !ok {
+			missing = append(missing, argument)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	if r.out != terminalOut {
+		for _, argument := range missing {
+			if argument.required {
+				return nil, errors.New(tr("%s needs %s, give it as %s=value", title, argument.name, argument.name))
+			}
+		}
+		return values, nil
+	}
+
+	// Servers without completions fail, so they are only asked once.
+	var unsupported atomic.Bool
+
+	inputs := make([]string, len(missing))
+	fields := make([]huh.Field, len(missing))
+
+	for i, argument := range missing {
+		value := &inputs[i]
+
+		input := huh.NewInput().
+			Title(argument.name).
+			Description(argument.description).
+			Value(value).
+			SuggestionsFunc(func() []string {
+				if unsupported.Load() {
+					return nil
+				}
+				suggestions, err := complete(argument.name, *value)
+				if err != nil {
+					unsupported.Store(true)
+				}
+				return suggestions
+			}, value)
+
+		if argument.required {
+			input = input.Validate(func(s string) error {
+				if s == "" {
+					return errors.New(tr("%s is required", argument.name))
+				}
+				return nil
+			})
+		}
+
+		fields[i] = input
+	}
+
+	if err := newForm(huh.NewGroup(fields...).Title(title)).RunWithContext(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, argument := range missing {
+		if inputs[i] != "" {
+			values[argument.name] = inputs[i]
+		}
+	}
+
+	return values, nil
+}
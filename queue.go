@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// queueConfig limits the tasks waiting with /queue. With Enter, tasks
+// entered in the TUI while another runs are queued without the command.
+type queueConfig struct {
+	Max   int  `json:"max,omitempty"`
+	Enter bool `json:"enter,omitempty"`
+}
+
+// enqueue adds a task to run once those before it are done.
+func (r *repl) enqueue(task string) error {
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+
+	if r.cfg.Queue.Max > 0 && len(r.queue) >= r.cfg.Queue.Max {
+		return fmt.Errorf("the queue is full, with %d tasks waiting", len(r.queue))
+	}
+	r.queue = append(r.queue, task)
+
+	return nil
+}
+
+// dequeue takes the next task waiting, if any.
+func (r *repl) dequeue() (string, bool) {
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+
+	if len(r.queue) == 0 {
+		return "", false
+	}
+
+	task := r.queue[0]
+	r.queue = r.queue[1:]
+
+	return task, true
+}
+
+func (r *repl) queued() []string {
+	r.queueMu.Lock()
+	defer r.queueMu.Unlock()
+
+	return append([]string(nil), r.queue...)
+}
+
+// cmdQueue queues a task to run after the current one, with the context it
+// leaves, lists the tasks waiting, or drops them with clear.
+func (r *repl) cmdQueue(ctx context.Context, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "clear":
+		r.queueMu.Lock()
+		r.queue = nil
+		r.queueMu.Unlock()
+
+		r.print("%s", tr("Queue cleared"))
+	case len(args) > 0:
+		task := strings.Join(args, " ")
+		if err := r.enqueue(task); err != nil {
+			return err
+		}
+
+		r.print("%s", statusStyle.Render(tr("Queued: %s (%d waiting)", task, len(r.queued()))))
+	default:
+		queued := r.queued()
+		if len(queued) == 0 {
+			r.print("%s", tr("Nothing is queued"))
+			return nil
+		}
+
+		r.print("%s", tr("Queued tasks:"))
+		for i, task := range queued {
+			r.print("  %d. %s", i+1, task)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// reasoningConfig asks reasoning models to think for longer or shorter, with
+// an effort or a token budget. Their reasoning is shown dimmed before the
+// answer, cut to reasoningPreviewLines unless expanded with /reasoning.
+type reasoningConfig struct {
+	Effort    string `json:"effort,omitempty"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+}
+
+var reasoningEfforts = []string{"minimal", "low", "medium", "high"}
+
+const reasoningPreviewLines = 6
+
+func (c *reasoningConfig) setEffort(value string) error {
+	if value != "" && !slices.Contains(reasoningEfforts, value) {
+		return fmt.Errorf("unknown reasoning effort %q, expected one of %s", value, strings.Join(reasoningEfforts, ", "))
+	}
+
+	c.Effort = value
+	return nil
+}
+
+// apply sets OpenRouter's reasoning parameter, which it translates for each
+// provider.
+func (c reasoningConfig) apply(params *openai.ChatCompletionNewParams) {
+	reasoning := make(map[string]any)
+
+	if c.Effort != "" {
+		reasoning["effort"] = c.Effort
+	}
+	if c.MaxTokens > 0 {
+		reasoning["max_tokens"] = c.MaxTokens
+	}
+
+	if len(reasoning) > 0 {
+		params.SetExtraFields(map[string]any{"reasoning": reasoning})
+	}
+}
+
+// reasoningBox renders reasoning dimmed, with only its first lines unless
+// full is set.
+func reasoningBox(text string, full bool) string {
+	text = strings.TrimSpace(text)
+
+	lines := strings.Split(text, "\n")
+	if !full && len(lines) > reasoningPreviewLines {
+		text = strings.Join(lines[:reasoningPreviewLines], "\n") +
+			fmt.Sprintf("\n… %d more lines, /reasoning to show all", len(lines)-reasoningPreviewLines)
+	}
+
+	return renderBox(reasoningBoxStyle, text)
+}
+
+func (r *repl) cmdReasoning(ctx context.Context, args []string) error {
+	if r.lastReasoning == "" {
+		r.print("No reasoning yet")
+		return nil
+	}
+
+	r.printLong(reasoningBox(r.lastReasoning, true))
+	return nil
+}
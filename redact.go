@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/openai/openai-go"
+)
+
+// redactionConfig scrubs secrets from what is sent to the provider, shown on
+// screen, and saved in sessions, reports and debug logs. The built-in rules
+// apply unless Builtin is false, followed by Rules.
+type redactionConfig struct {
+	Enabled bool            `json:"enabled,omitempty"`
+	Builtin *bool           `json:"builtin,omitempty"`
+	Rules   []redactionRule `json:"rules,omitempty"`
+}
+
+// redactionRule replaces matches of Pattern with Replacement, which may refer
+// to groups as in regexp.Expand, or with [REDACTED:name] if empty.
+type redactionRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+var builtinRedactionRules = []redactionRule{
+	{Name: "private-key", Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{Name: "api-key", Pattern: `\bsk-(?:ant-|or-|proj-)?[A-Za-z0-9_-]{20,}`},
+	{Name: "aws-access-key", Pattern: `\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`},
+	{Name: "aws-secret-key", Pattern: `(?i)(aws_secret_access_key\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`, Replacement: "${1}[REDACTED:aws-secret-key]"},
+	{Name: "github-token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`},
+	{Name: "slack-token", Pattern: `\bxox[abprs]-[A-Za-z0-9-]{10,}`},
+	{Name: "bearer-token", Pattern: `(?i)(bearer\s+)[A-Za-z0-9._~+/-]{20,}=*`, Replacement: "${1}[REDACTED:bearer-token]"},
+	{Name: "email", Pattern: `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`},
+}
+
+type compiledRedaction struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// redactions are the rules applied by redact, set by applyRedaction.
+var redactions []compiledRedaction
+
+func applyRedaction(cfg redactionConfig) error {
+	redactions = nil
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var rules []redactionRule
+	if cfg.Builtin == nil || *cfg.Builtin {
+		rules = append(rules, builtinRedactionRules...)
+	}
+	rules = append(rules, cfg.Rules...)
+
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction rule %q: %v", rule.Name, err)
+		}
+
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = "[REDACTED:" + rule.Name + "]"
+		}
+
+		redactions = append(redactions, compiledRedaction{pattern: pattern, replacement: replacement})
+	}
+
+	return nil
+}
+
+// redact scrubs s with the redaction rules, if enabled.
+func redact(s string) string {
+	for _, r := range redactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+
+	return s
+}
+
+// redactValue returns a scrubbed copy of a decoded JSON value. Data URLs,
+// such as images, are left alone.
+func redactValue(value any) any {
+	switch value := value.(type) {
+	case string:
+		if strings.HasPrefix(value, "data:") {
+			return value
+		}
+		return redact(value)
+	case map[string]any:
+		redacted := make(map[string]any, len(value))
+		for k, v := range value {
+			redacted[k] = redactValue(v)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(value))
+		for i, v := range value {
+			redacted[i] = redactValue(v)
+		}
+		return redacted
+	}
+
+	return value
+}
+
+// redactMessages returns a scrubbed copy of messages. They are round-tripped
+// through JSON so every kind of content is covered; if that fails, they are
+// returned as they are rather than lose the conversation.
+func redactMessages(messages []openai.ChatCompletionMessageParamUnion) []openai.ChatCompletionMessageParamUnion {
+	if len(redactions) == 0 {
+		return messages
+	}
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return messages
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return messages
+	}
+
+	data, err = json.Marshal(redactValue(decoded))
+	if err != nil {
+		return messages
+	}
+
+	var redacted []openai.ChatCompletionMessageParamUnion
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return messages
+	}
+
+	return redacted
+}
+
+// redactingProvider scrubs the messages of every request before passing it
+// on, so secrets never reach the provider, whichever part of the agent makes
+// the request.
+type redactingProvider struct {
+	agent.StreamingProvider
+}
+
+func (p redactingProvider) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	params.Messages = redactMessages(params.Messages)
+	return p.StreamingProvider.Complete(ctx, params)
+}
+
+func (p redactingProvider) Stream(ctx context.Context, params openai.ChatCompletionNewParams, onChunk func(openai.ChatCompletionChunk)) (*openai.ChatCompletion, error) {
+	params.Messages = redactMessages(params.Messages)
+	return p.StreamingProvider.Stream(ctx, params, onChunk)
+}
+
+// redactingCompleter is a redactingProvider for providers that don't stream.
+type redactingCompleter struct {
+	agent.Provider
+}
+
+func (p redactingCompleter) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	params.Messages = redactMessages(params.Messages)
+	return p.Provider.Complete(ctx, params)
+}
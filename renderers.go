@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"golang.org/x/net/html"
+)
+
+// outputRenderer formats a tool result for the terminal. full is unset when
+// long results should be cut short.
+type outputRenderer func(output string, full bool) (string, error)
+
+// outputRenderers are the renderers that output.renderers can pick, by name.
+var outputRenderers = map[string]outputRenderer{
+	"text":     renderTextOutput,
+	"json":     renderJSONOutput,
+	"csv":      renderCSVOutput,
+	"html":     renderHTMLOutput,
+	"markdown": renderMarkdownOutput,
+	"none":     func(string, bool) (string, error) { return "", nil },
+}
+
+// defaultRenderers pick a renderer for the output of the tools that run code,
+// and for results by their content type.
+var defaultRenderers = map[string]string{
+	"sandbox_run_code":          "text",
+	"application/json":          "json",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "csv",
+	"text/html":                 "html",
+}
+
+const (
+	// maxTableRows and maxCellWidth limit tables unless -verbosity 2.
+	maxTableRows = 20
+	maxCellWidth = 40
+)
+
+// validateRenderers checks that renderers, keyed by tool name or MIME type,
+// name known renderers.
+func validateRenderers(renderers map[string]string) error {
+	for key, name := range renderers {
+		if _, ok := outputRenderers[name]; !ok {
+			return fmt.Errorf("unknown renderer %q for %s", name, key)
+		}
+	}
+
+	return nil
+}
+
+// renderToolOutput formats the result of a tool call with the renderer
+// configured for the tool, or at verbosity 1 and up for the type of its
+// content, and returns false if it isn't to be shown.
+func renderToolOutput(cfg outputConfig, finished agent.ToolCallFinished) (string, bool) {
+	output := finished.Result
+	if output == "" {
+		return "", false
+	}
+
+	name, ok := cfg.Renderers[finished.ToolCall.Function.Name]
+	if !ok {
+		if cfg.Verbosity == 0 {
+			return "", false
+		}
+		name = cmp.Or(cfg.Renderers[detectMIMEType(output)], "text")
+	}
+
+	// Tables are more readable as tables than as text.
+	if name == "text" {
+		if _, ok := tableDelimiter(output); ok {
+			name = "csv"
+		}
+	}
+
+	render, ok := outputRenderers[name]
+	if !ok {
+		render = renderTextOutput
+	}
+
+	rendered, err := render(output, cfg.Verbosity > 1)
+	if err != nil {
+		// Content that doesn't parse as its type is shown as it is.
+		rendered, _ = renderTextOutput(output, cfg.Verbosity > 1)
+	}
+
+	return rendered, rendered != ""
+}
+
+var (
+	csvHeaderPattern = regexp.MustCompile(`^[^,\n]+(,[^,\n]+)+$`)
+	tsvHeaderPattern = regexp.MustCompile(`^[^\t\n]+(\t[^\t\n]*)+$`)
+)
+
+// detectMIMEType guesses the type of a tool result from its content. Results
+// are text, so this only tells JSON, CSV, TSV and HTML from plain text.
+func detectMIMEType(output string) string {
+	trimmed := strings.TrimSpace(output)
+
+	switch {
+	case (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)):
+		return "application/json"
+	case strings.HasPrefix(http.DetectContentType([]byte(trimmed)), "text/html"):
+		return "text/html"
+	}
+
+	switch delimiter, _ := tableDelimiter(trimmed); delimiter {
+	case ',':
+		return "text/csv"
+	case '\t':
+		return "text/tab-separated-values"
+	}
+
+	return "text/plain"
+}
+
+// tableDelimiter reports whether text looks like a table of comma or tab
+// separated values, and which: at least two rows, a header of plain fields,
+// and as many fields in every row.
+func tableDelimiter(text string) (rune, bool) {
+	text = strings.TrimSpace(text)
+
+	header, _, ok := strings.Cut(text, "\n")
+	if !ok {
+		return 0, false
+	}
+	header = strings.TrimRight(header, "\r")
+
+	var delimiter rune
+	switch {
+	case tsvHeaderPattern.MatchString(header):
+		delimiter = '\t'
+	case csvHeaderPattern.MatchString(header):
+		delimiter = ','
+	default:
+		return 0, false
+	}
+
+	records, err := readTable(text, delimiter)
+	if err != nil || len(records) < 2 {
+		return 0, false
+	}
+
+	return delimiter, true
+}
+
+func readTable(text string, delimiter rune) ([][]string, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimSpace(text)))
+	r.Comma = delimiter
+	// Tabs would count as leading space.
+	r.TrimLeadingSpace = delimiter != '\t'
+	r.LazyQuotes = delimiter == '\t'
+
+	return r.ReadAll()
+}
+
+func renderTextOutput(output string, full bool) (string, error) {
+	if !full {
+		output = shortenLines(output)
+	}
+
+	return renderBox(codeBoxStyle, strings.TrimRight(output, "\n")), nil
+}
+
+func renderJSONOutput(output string, full bool) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(strings.TrimSpace(output)), "", "  "); err != nil {
+		return "", err
+	}
+
+	pretty := buf.String()
+	if !full {
+		pretty = shortenLines(pretty)
+	}
+
+	return codeBox(pretty, "json"), nil
+}
+
+// renderCSVOutput renders comma or tab separated values as a table, with the
+// first row as the header. Unless full, long cells are cut and only the first
+// maxTableRows rows shown.
+func renderCSVOutput(output string, full bool) (string, error) {
+	delimiter, ok := tableDelimiter(output)
+	if !ok {
+		delimiter = ','
+	}
+
+	records, err := readTable(output, delimiter)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	rows := records[1:]
+
+	var hidden int
+	if !full && len(rows) > maxTableRows {
+		hidden = len(rows) - maxTableRows
+		rows = rows[:maxTableRows]
+	}
+
+	if !full {
+		for _, row := range append([][]string{records[0]}, rows...) {
+			for i, cell := range row {
+				if runes := []rune(cell); len(runes) > maxCellWidth {
+					row[i] = string(runes[:maxCellWidth-1]) + "…"
+				}
+			}
+		}
+	}
+
+	// Numbers are aligned right, so their digits line up.
+	numeric := make([]bool, len(records[0]))
+	for col := range numeric {
+		numeric[col] = len(rows) > 0 && !slices.ContainsFunc(rows, func(row []string) bool {
+			_, err := strconv.ParseFloat(row[col], 64)
+			return err != nil
+		})
+	}
+
+	t := table.New().
+		Border(codeBoxStyle.GetBorderStyle()).
+		BorderStyle(statusStyle.UnsetMarginLeft()).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			style := lipgloss.NewStyle().Padding(0, 1)
+			if row == table.HeaderRow {
+				return style.Bold(true)
+			}
+			if numeric[col] {
+				style = style.Align(lipgloss.Right)
+			}
+
+			return style
+		}).
+		Headers(records[0]...).
+		Rows(rows...)
+
+	rendered := t.String()
+	if hidden > 0 {
+		rendered += "\n" + fmt.Sprintf("… %d more rows of %d, use -verbosity 2 to show all", hidden, len(records)-1)
+	}
+
+	return lipgloss.NewStyle().MarginLeft(2).Render(rendered), nil
+}
+
+func renderHTMLOutput(output string, full bool) (string, error) {
+	text, err := htmlText(output)
+	if err != nil {
+		return "", err
+	}
+
+	return renderTextOutput(text, full)
+}
+
+func renderMarkdownOutput(output string, full bool) (string, error) {
+	if !full {
+		output = shortenLines(output)
+	}
+
+	return renderMarkdown(output), nil
+}
+
+// htmlText extracts the text of an HTML document, leaving out scripts and
+// styles, with a line for each block.
+func htmlText(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style" || n.Data == "head"):
+			return
+		case n.Type == html.TextNode:
+			if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString(text)
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+
+		if n.Type == html.ElementNode && htmlBlocks[n.Data] && sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	walk(root)
+
+	return strings.TrimSpace(sb.String()), nil
+}
+
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true, "section": true, "article": true,
+	"table": true, "ul": true, "ol": true, "title": true,
+}
@@ -0,0 +1,1119 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/huh"
+	"github.com/openai/openai-go"
+)
+
+type repl struct {
+	cfg    *config
+	sess   *session
+	agent  *agent.Agent
+	render func(agent.Event)
+
+	// out is where the REPL writes, stdout unless it runs in the TUI.
+	out io.Writer
+
+	// credits, if set, fetches the remaining provider credits, which are
+	// shown after every task.
+	credits func(ctx context.Context) (*credits, error)
+
+	// memorize, if set, extracts what is worth remembering about the user
+	// from a finished task.
+	memorize func(ctx context.Context, sess *session, task, answer string) error
+
+	// notify, if set, announces every task that finishes or fails.
+	notify func(ctx context.Context, task string, result *agent.Result, err error)
+
+	// verify, if set, has a judge model check every answer with -verify,
+	// and verdict is the verdict on the last.
+	verify  func(ctx context.Context, model, task string, result *agent.Result) (*verdict, error)
+	verdict *verdict
+
+	// commitMessage, if set, has a model write the message of the commit
+	// capturing a task with -git-branch.
+	commitMessage func(ctx context.Context, model, task string, result *agent.Result) (string, error)
+
+	// serverLogs queues the MCP server's logs to be rendered with the
+	// events of the task running, or the next one.
+	serverLogs chan agent.ServerLog
+
+	// lastCost is the cost of the last task, used to estimate the next.
+	lastCost float64
+
+	// history, if set, records the tasks and commands entered, and draft is
+	// a past one picked with /history to edit in the next prompt.
+	history *history
+	draft   string
+
+	// lastResult is the result of the last task, for /copy, and
+	// lastReasoning the last reasoning shown, for /reasoning.
+	lastResult    *agent.Result
+	lastReasoning string
+
+	// attachments are files to send with the next task.
+	attachments []*attachment
+
+	// queue holds the tasks queued with /queue, to run in order once the
+	// current one is done. The TUI adds to it while tasks run.
+	queueMu sync.Mutex
+	queue   []string
+
+	// subscriptions maps the resources subscribed to to the task to run
+	// when they change, or "" to note the change in the next task. changed
+	// holds those that changed since the last task, and followUps the tasks
+	// to run for them.
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]string
+	changed         map[string]bool
+	followUps       chan string
+
+	// timings, if set, breaks down the time taken by the current task.
+	timings *timings
+
+	// context tracks how full the model's context window is.
+	context *contextMeter
+
+	// askApproval asks the user whether a tool call may run, with huh unless
+	// the REPL runs in the TUI. It returns the text of the call to run,
+	// which the user may have edited.
+	askApproval func(ctx context.Context, q approvalQuestion) (string, bool, error)
+
+	// approvals runs approval prompts in the goroutine rendering events, so
+	// they come after the tool call they're about.
+	approvals chan func()
+
+	// previewLines is the height of the streamed tool call preview on
+	// screen, which is erased before anything else is printed.
+	previewLines int
+}
+
+func newREPL(cfg *config, sess *session, a *agent.Agent) *repl {
+	r := &repl{
+		cfg:   cfg,
+		sess:  sess,
+		agent: a,
+		out:   terminalOut,
+
+		askApproval: askApproval,
+		approvals:   make(chan func()),
+		context:     newContextMeter(cfg),
+
+		subscriptions: make(map[string]string),
+		changed:       make(map[string]bool),
+		followUps:     make(chan string, 16),
+	}
+	r.render = r.renderEvent
+
+	if cfg.Output.Timings {
+		r.timings = &timings{}
+	}
+
+	// Usage is attributed to the profile the session started with.
+	if sess.Profile == "" {
+		sess.Profile, _ = cfg.profile()
+	}
+
+	a.Model = sess.Model
+	a.Messages = sess.Messages
+	a.Usage = sess.Usage
+	a.Turns = sess.Turns
+	a.RawOutputs = sess.RawOutputs
+	a.Pinned = sess.Pinned
+
+	sess.Runs = append(sess.Runs, newRunSnapshot(cfg, a))
+
+	a.Prepare = r.prepare
+	a.ResourceUpdated = r.resourceUpdated
+
+	if debugLog := a.ServerLogs; debugLog != nil {
+		r.serverLogs = make(chan agent.ServerLog, 64)
+		a.ServerLogs = func(log agent.ServerLog) {
+			debugLog(log)
+
+			// Logs that don't fit are dropped rather than blocking the
+			// client.
+			select {
+			case r.serverLogs <- log:
+			default:
+			}
+		}
+	}
+
+	return r
+}
+
+// runTask runs the agent in the background while rendering its events, and
+// returns once all events have been handled.
+func (r *repl) runTask(ctx context.Context, task string) (*agent.Result, error) {
+	if notes := r.resourceNotes(); notes != "" {
+		task = notes + "\n\n" + task
+	}
+	if len(r.attachments) > 0 {
+		task = withAttachments(task, r.attachments)
+		r.attachments = nil
+	}
+
+	return r.runAgent(ctx, task, func(ctx context.Context) (*agent.Result, error) {
+		return r.agent.Run(ctx, task)
+	})
+}
+
+// runAgent runs the agent loop with run, like runTask, for task.
+func (r *repl) runAgent(ctx context.Context, task string, run func(ctx context.Context) (*agent.Result, error)) (*agent.Result, error) {
+	release, err := r.sess.useWorkdir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock working directory: %v", err)
+	}
+	defer release()
+
+	workdir, err := r.sess.workdir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create working directory: %v", err)
+	}
+	ctx = withWorkdir(ctx, workdir)
+
+	var artifacts *artifactCollector
+	if r.cfg.Artifacts.Enabled {
+		artifacts = newArtifactCollector(r.sess, workdir)
+	}
+
+	events := make(chan agent.Event)
+	r.agent.Events = events
+
+	if r.timings != nil {
+		r.timings.reset()
+	}
+	r.verdict = nil
+
+	var result *agent.Result
+
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		result, err = run(ctx)
+		close(events)
+		errc <- err
+	}()
+
+	for events != nil {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				break
+			}
+
+			if r.timings != nil {
+				r.timings.observe(event)
+			}
+			// The tools are listed by the first turn, and the agent waits
+			// on the next event before changing the conversation.
+			if started, ok := event.(agent.TurnStarted); ok && started.Turn == 1 {
+				r.stampRun()
+			}
+			r.render(event)
+
+			if warning := r.context.observe(event); warning != "" {
+				r.warn("%s", warning)
+			}
+			if finished, ok := event.(agent.ToolCallFinished); ok {
+				r.sess.recordToolCall(finished)
+				if artifacts != nil {
+					if err := artifacts.observe(finished); err != nil {
+						r.warn("Failed to collect artifacts: %v", err)
+					}
+				}
+			}
+			// Completions are paid for even if the turn doesn't finish,
+			// such as when the budget runs out, so they are saved as made.
+			if updated, ok := event.(agent.UsageUpdated); ok {
+				r.sess.Usage = updated.Total
+				r.sess.Turns = append(r.sess.Turns, updated.Turn)
+				r.saveSession()
+			}
+			if checkpoint, ok := event.(agent.Checkpoint); ok {
+				r.sess.Checkpoint = &checkpoint
+				r.saveSession()
+			}
+			if finished, ok := event.(agent.TurnFinished); ok {
+				r.sess.Messages = finished.Messages
+				r.sess.Usage = finished.Usage
+				r.sess.Turns = finished.Turns
+				r.sess.RawOutputs = finished.RawOutputs
+				r.saveSession()
+			}
+		case approve := <-r.approvals:
+			approve()
+		case log := <-r.serverLogs:
+			r.render(log)
+		}
+	}
+
+	err = <-errc
+
+	// A task that was interrupted can be resumed from where it stopped.
+	if r.sess.Checkpoint != nil && ctx.Err() == nil {
+		r.sess.Checkpoint = nil
+		r.saveSession()
+	}
+
+	r.printArtifacts(artifacts)
+
+	if r.notify != nil {
+		r.notify(context.WithoutCancel(ctx), task, result, err)
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	if r.memorize != nil && result.Answer != "" {
+		if err := r.memorize(ctx, r.sess, task, result.Answer); err != nil {
+			r.warn("Failed to update memory: %v", err)
+		}
+	}
+
+	if r.cfg.Verify.Enabled && r.verify != nil && result.Answer != "" {
+		v, err := r.verify(ctx, cmp.Or(r.cfg.Verify.Model, r.agent.Model), task, result)
+		if err != nil {
+			r.warn("Failed to verify the answer: %v", err)
+		}
+		r.verdict = v
+	}
+
+	if r.cfg.Git.Branch != "" {
+		if err := r.commitResults(ctx, task, result); err != nil {
+			r.warn("Failed to commit the results: %v", err)
+		}
+	}
+
+	r.lastResult = result
+	if r.cfg.Output.Copy && result.Answer != "" {
+		if err := copyToClipboard(result.Answer); err != nil {
+			r.warn("%v", err)
+		}
+	}
+
+	return result, nil
+}
+
+type replCommand struct {
+	usage string
+	help  string
+	run   func(r *repl, ctx context.Context, args []string) error
+}
+
+var replCommands map[string]replCommand
+
+func init() {
+	replCommands = map[string]replCommand{
+		"/copy": {
+			usage: "/copy [code]",
+			help:  "copy the last answer, or its last code block, to the clipboard",
+			run:   (*repl).cmdCopy,
+		},
+		"/attach": {
+			usage: "/attach [file...]",
+			help:  "send files with the next task, or list those waiting",
+			run:   (*repl).cmdAttach,
+		},
+		"/branch": {
+			usage: "/branch",
+			help:  "continue in a new session branched off this one",
+			run:   (*repl).cmdBranch,
+		},
+		"/undo": {
+			usage: "/undo [turns]",
+			help:  "roll back the last turns of the conversation",
+			run:   (*repl).cmdUndo,
+		},
+		"/queue": {
+			usage: "/queue [task | clear]",
+			help:  "queue a task to run after the current one, or list or clear those waiting",
+			run:   (*repl).cmdQueue,
+		},
+		"/resume": {
+			usage: "/resume",
+			help:  "resume the task that was interrupted, from where it stopped",
+			run:   (*repl).cmdResume,
+		},
+		"/retry": {
+			usage: "/retry [model]",
+			help:  "run the last turn again, with another model if given",
+			run:   (*repl).cmdRetry,
+		},
+		"/reasoning": {
+			usage: "/reasoning",
+			help:  "show the model's last reasoning in full",
+			run:   (*repl).cmdReasoning,
+		},
+		"/history": {
+			usage: "/history [search]",
+			help:  "pick a past task or command to run again",
+			run:   (*repl).cmdHistory,
+		},
+		"/pin": {
+			usage: "/pin [result|answer|task]",
+			help:  "keep the last tool result, answer or task through compaction",
+			run:   (*repl).cmdPin,
+		},
+		"/unpin": {
+			usage: "/unpin",
+			help:  "let compaction summarize everything pinned",
+			run:   (*repl).cmdUnpin,
+		},
+		"/prompt": {
+			usage: "/prompt [name] [argument=value...]",
+			help:  "fill in a prompt of the servers, with their completions, to edit as the next task",
+			run:   (*repl).cmdPrompt,
+		},
+		"/resource": {
+			usage: "/resource [template] [variable=value...]",
+			help:  "read a resource of the servers' templates and attach it to the next task",
+			run:   (*repl).cmdResource,
+		},
+		"/subscribe": {
+			usage: "/subscribe [uri [task]]",
+			help:  "follow changes to a resource of the servers, running the task when it changes, or list those followed",
+			run:   (*repl).cmdSubscribe,
+		},
+		"/unsubscribe": {
+			usage: "/unsubscribe uri",
+			help:  "stop following changes to a resource",
+			run:   (*repl).cmdUnsubscribe,
+		},
+		"/help": {
+			usage: "/help",
+			help:  "list the commands",
+			run:   (*repl).cmdHelp,
+		},
+		"/set": {
+			usage: "/set [temperature|max_tokens|top_p|seed] [value|default]",
+			help:  "change a sampling parameter for the rest of the session",
+			run:   (*repl).cmdSet,
+		},
+	}
+}
+
+func (r *repl) run(ctx context.Context, input string) error {
+	for {
+		input = strings.TrimSpace(input)
+		if err := r.remember(input); err != nil {
+			r.warn("%s", tr("Failed to save history: %v", err))
+		}
+
+		switch {
+		case strings.HasPrefix(input, "/"):
+			if err := r.command(ctx, input); err != nil {
+				r.print("%s", tr("Error: %v", err))
+			}
+		case input != "":
+			r.print("%s", tr("Query: %s", input))
+
+			r.checkCredits(ctx)
+
+			before := r.agent.Usage.Cost
+			if _, err := r.runTask(ctx, input); err != nil {
+				return fmt.Errorf("failed to run agent: %w", err)
+			}
+			r.lastCost = r.agent.Usage.Cost - before
+
+			r.printStatus(ctx)
+		}
+
+		if queued, ok := r.dequeue(); ok {
+			input = queued
+			continue
+		}
+
+		next, err := r.nextInput(ctx)
+		if err != nil || strings.TrimSpace(next) == "" {
+			return nil
+		}
+
+		input = next
+	}
+}
+
+// approvalQuestion asks whether a tool call may run. text is the code of a
+// code tool, or else the arguments as JSON, which can be edited before the
+// call runs as long as they still pass validate.
+type approvalQuestion struct {
+	title    string
+	reason   string
+	text     string
+	language string
+	validate func(text string) error
+
+	// diff, if set, returns the change the call makes to a file with text
+	// as its code or arguments, as a unified diff, or nothing.
+	diff func(text string) string
+
+	// fixed questions are only allowed or blocked, without editing.
+	fixed bool
+}
+
+// approve asks the user whether a tool call may run, and lets them edit its
+// code or arguments first.
+func (r *repl) approve(ctx context.Context, call agent.ToolCallStarted, reason string) (map[string]any, bool, error) {
+	name := call.ToolCall.Function.Name
+
+	q := approvalQuestion{
+		title:    tr("Run %s?", name),
+		text:     toolArguments(call.Arguments, true),
+		reason:   reason,
+		language: "json",
+	}
+
+	tool := r.cfg.Output.CodeTools[name]
+	if code, language, ok := toolCode(r.cfg.Output.CodeTools, name, call.Arguments); ok {
+		q.text, q.language = code, language
+	}
+
+	args := func(text string) (map[string]any, error) {
+		if text == q.text {
+			return call.Arguments, nil
+		}
+
+		if q.language != "json" {
+			args := maps.Clone(call.Arguments)
+			args[tool.Argument] = text
+
+			return args, nil
+		}
+
+		var args map[string]any
+		if err := json.Unmarshal([]byte(text), &args); err != nil {
+			return nil, fmt.Errorf("arguments must be a JSON object: %v", err)
+		}
+
+		return args, nil
+	}
+	q.validate = func(text string) error {
+		_, err := args(text)
+		return err
+	}
+	if _, ok := toolDiff(call.Arguments); ok {
+		q.diff = func(text string) string {
+			args, err := args(text)
+			if err != nil {
+				return ""
+			}
+
+			diff, _ := toolDiff(args)
+			return diff
+		}
+	}
+
+	var (
+		text     string
+		approved bool
+		err      error
+	)
+
+	done := make(chan struct{})
+	ask := func() {
+		defer close(done)
+		text, approved, err = r.askApproval(ctx, q)
+	}
+
+	select {
+	case r.approvals <- ask:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	<-done
+
+	if err != nil || !approved {
+		return nil, false, err
+	}
+
+	edited, err := args(text)
+	return edited, err == nil, err
+}
+
+// askApproval asks whether a tool call may run, opening it in the user's
+// editor as many times as they like before they decide.
+func askApproval(ctx context.Context, q approvalQuestion) (string, bool, error) {
+	text := q.text
+
+	if q.diff != nil {
+		printCodeBox(q.diff(text), "diff")
+	}
+
+	for {
+		choice := "run"
+
+		options := []huh.Option[string]{
+			huh.NewOption(tr("Run"), "run"),
+			huh.NewOption(tr("Edit in $EDITOR"), "edit"),
+			huh.NewOption(tr("Decline"), "decline"),
+		}
+		if q.fixed {
+			options = []huh.Option[string]{
+				huh.NewOption(tr("Allow"), "run"),
+				huh.NewOption(tr("Block"), "decline"),
+			}
+		}
+
+		form := newForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Title(q.title).
+					Description(q.reason).
+					Options(options...).
+					Value(&choice),
+			),
+		)
+
+		if err := form.RunWithContext(ctx); err != nil {
+			return "", false, err
+		}
+
+		switch choice {
+		case "run":
+			return text, true, nil
+		case "decline":
+			return "", false, nil
+		}
+
+		edited, err := editText(text, languageExtension(q.language))
+		if err == nil {
+			err = q.validate(edited)
+		}
+		if err != nil {
+			printWarning("Discarded the edit: %v", err)
+			continue
+		}
+
+		text = edited
+		if diff := q.diff; diff != nil && diff(text) != "" {
+			printCodeBox(diff(text), "diff")
+		} else {
+			printCodeBox(text, q.language)
+		}
+	}
+}
+
+// checkCredits warns when the last task cost more than the credits remaining,
+// as the next one likely will too, or when sending the context alone would.
+func (r *repl) checkCredits(ctx context.Context) {
+	request := r.context.cost()
+	if r.credits == nil || (r.lastCost == 0 && request == 0) {
+		return
+	}
+
+	credits, err := r.credits(ctx)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case r.lastCost > credits.remaining():
+		r.warn("The last task cost $%.4f, more than the $%.4f of credits remaining", r.lastCost, credits.remaining())
+	case request > credits.remaining():
+		r.warn("Sending the context costs about $%.4f, more than the $%.4f of credits remaining", request, credits.remaining())
+	}
+}
+
+func (r *repl) printStatus(ctx context.Context) {
+	status := tr("Session usage: %s", r.agent.Usage.String())
+
+	if r.credits != nil {
+		if credits, err := r.credits(ctx); err == nil {
+			status += ", " + credits.String()
+		}
+	}
+	if meter := r.context.String(); meter != "" {
+		status += ", " + meter
+	}
+
+	r.printVerdict()
+	r.print("%s", statusStyle.Render(status))
+	r.printTimings()
+}
+
+// printVerdict prints the verdict on the last answer, with -verify.
+func (r *repl) printVerdict() {
+	if r.verdict != nil {
+		r.print("%s", r.verdict)
+	}
+}
+
+// printTimings prints the timings summary of the last task, with -timings.
+func (r *repl) printTimings() {
+	if r.timings != nil {
+		r.print("%s", statusStyle.Render(r.timings.summary()))
+	}
+}
+
+func (r *repl) print(s string, a ...any) {
+	fmt.Fprintln(r.out, redact(fmt.Sprintf(s, a...)))
+}
+
+// printLong prints output that may not fit on the terminal, paging it if
+// needed.
+func (r *repl) printLong(s string) {
+	writePaged(r.out, redact(s)+"\n", r.cfg.Output.NoPager)
+}
+
+func (r *repl) warn(s string, a ...any) {
+	fmt.Fprintln(r.out, warningStyle.Render(redact(fmt.Sprintf(s, a...))))
+}
+
+func (r *repl) command(ctx context.Context, input string) error {
+	fields := strings.Fields(input)
+
+	cmd, ok := replCommands[fields[0]]
+	if !ok {
+		return errors.New(tr("unknown command %s, see /help", fields[0]))
+	}
+
+	return cmd.run(r, ctx, fields[1:])
+}
+
+func (r *repl) cmdHelp(ctx context.Context, args []string) error {
+	width := 0
+	for _, cmd := range replCommands {
+		width = max(width, len(cmd.usage))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(replCommands)) {
+		r.print("  %-*s  %s", width, replCommands[name].usage, tr(replCommands[name].help))
+	}
+
+	return nil
+}
+
+// cmdCopy copies the last answer, or with code, its last code block, to the
+// clipboard.
+func (r *repl) cmdCopy(ctx context.Context, args []string) error {
+	if r.lastResult == nil {
+		return fmt.Errorf("nothing to copy yet")
+	}
+
+	switch {
+	case len(args) == 0:
+		if err := copyToClipboard(r.lastResult.Answer); err != nil {
+			return err
+		}
+		r.print("%s", tr("Copied the answer"))
+	case len(args) == 1 && args[0] == "code":
+		code, ok := lastCodeBlock(r.lastResult, r.cfg.Output.CodeTools)
+		if !ok {
+			return fmt.Errorf("the last answer has no code")
+		}
+		if err := copyToClipboard(code); err != nil {
+			return err
+		}
+		r.print("%s", tr("Copied the code"))
+	default:
+		return fmt.Errorf("usage: %s", replCommands["/copy"].usage)
+	}
+
+	return nil
+}
+
+// cmdAttach reads files to send with the next task, or lists those waiting
+// to be sent.
+func (r *repl) cmdAttach(ctx context.Context, args []string) error {
+	for _, path := range args {
+		a, err := readAttachment(path, r.cfg.Attachments)
+		if err != nil {
+			return err
+		}
+
+		r.attachments = append(r.attachments, a)
+	}
+
+	if len(r.attachments) == 0 {
+		r.print("%s", tr("No files attached"))
+		return nil
+	}
+
+	r.print("%s", tr("Attached to the next task:"))
+	for _, a := range r.attachments {
+		size := fmt.Sprintf("%d bytes", a.size)
+		if a.truncated {
+			size += ", truncated"
+		}
+		r.print("  %s (%s)", a.path, size)
+	}
+
+	return nil
+}
+
+// remember adds input to the history, except for /history itself.
+func (r *repl) remember(input string) error {
+	if r.history == nil || strings.HasPrefix(input, "/history") {
+		return nil
+	}
+
+	return r.history.add(input)
+}
+
+// cmdHistory picks a past task or command, filtered by the search, to edit
+// and run again. In the TUI, where up, down and ctrl+r recall them, it
+// lists the most recent instead.
+func (r *repl) cmdHistory(ctx context.Context, args []string) error {
+	if r.history == nil || len(r.history.entries) == 0 {
+		r.print("%s", tr("No history yet"))
+		return nil
+	}
+
+	query := strings.Join(args, " ")
+
+	var matches []string
+	for i := len(r.history.entries); ; {
+		if i = r.history.search(query, i); i < 0 {
+			break
+		}
+		matches = append(matches, r.history.entries[i])
+	}
+
+	if len(matches) == 0 {
+		r.print("%s", tr("Nothing in the history matches %q", query))
+		return nil
+	}
+
+	if r.out != terminalOut {
+		for _, entry := range matches[:min(len(matches), 20)] {
+			r.print("  %s", strings.ReplaceAll(entry, "\n", " "))
+		}
+		return nil
+	}
+
+	var options []huh.Option[string]
+	for _, entry := range matches {
+		options = append(options, huh.NewOption(strings.ReplaceAll(entry, "\n", " "), entry))
+	}
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title(tr("History (/ to search)")).
+				Height(12).
+				Options(options...).
+				Value(&r.draft),
+		),
+	)
+
+	return form.RunWithContext(ctx)
+}
+
+func (r *repl) cmdSet(ctx context.Context, args []string) error {
+	switch len(args) {
+	case 0:
+		r.print("Sampling: %s", r.cfg.Sampling)
+		return nil
+	case 1:
+		return fmt.Errorf("usage: %s", replCommands["/set"].usage)
+	default:
+		return r.cfg.Sampling.set(args[0], args[1])
+	}
+}
+
+func (r *repl) cmdBranch(ctx context.Context, args []string) error {
+	r.save()
+
+	parent := r.sess
+	child := parent.fork()
+
+	if err := forkWorkdir(parent, child); err != nil {
+		return fmt.Errorf("failed to copy working directory: %v", err)
+	}
+
+	r.sess = child
+	r.sess.Runs = append(r.sess.Runs, newRunSnapshot(r.cfg, r.agent))
+	r.agent.Usage = r.sess.Usage
+	r.agent.Turns = r.sess.Turns
+	r.save()
+
+	r.print("%s", tr("Branched session %s from %s", r.sess.ID, parent.ID))
+
+	return nil
+}
+
+// cmdPin pins the last tool result, answer or task, so compaction keeps it
+// verbatim rather than summarizing it.
+func (r *repl) cmdPin(ctx context.Context, args []string) error {
+	what := "result"
+	if len(args) > 0 {
+		what = args[0]
+	}
+
+	// text returns the text of a message of the kind to pin.
+	var text func(message openai.ChatCompletionMessageParamUnion) (string, bool)
+	switch {
+	case len(args) > 1:
+	case what == "result":
+		text = func(message openai.ChatCompletionMessageParamUnion) (string, bool) {
+			if message.OfTool == nil {
+				return "", false
+			}
+			return message.OfTool.Content.OfString.Value, true
+		}
+	case what == "answer":
+		text = func(message openai.ChatCompletionMessageParamUnion) (string, bool) {
+			if message.OfAssistant == nil {
+				return "", false
+			}
+			content := message.OfAssistant.Content.OfString.Value
+			return content, content != ""
+		}
+	case what == "task":
+		text = func(message openai.ChatCompletionMessageParamUnion) (string, bool) {
+			if message.OfUser == nil {
+				return "", false
+			}
+			return message.OfUser.Content.OfString.Value, true
+		}
+	}
+	if text == nil {
+		return fmt.Errorf("usage: %s", replCommands["/pin"].usage)
+	}
+
+	for _, message := range slices.Backward(r.agent.Messages) {
+		content, ok := text(message)
+		if !ok {
+			continue
+		}
+
+		r.agent.Pin(message)
+		r.save()
+		r.print("%s", tr("Pinned %s", shortenLines(firstLine(content))))
+
+		return nil
+	}
+
+	return fmt.Errorf("no %s to pin", what)
+}
+
+func (r *repl) cmdUnpin(ctx context.Context, args []string) error {
+	if len(r.agent.Pinned) == 0 {
+		return fmt.Errorf("nothing is pinned")
+	}
+
+	pinned := len(r.agent.Pinned)
+	r.agent.Pinned = nil
+	r.save()
+	r.print("%s", tr("Unpinned %d messages", pinned))
+
+	return nil
+}
+
+// cmdUndo rolls back the last turns of the conversation: each assistant
+// message with the results of its tool calls, and the task it answered once
+// nothing of the answer is left. Usage isn't rolled back, and neither is
+// anything the tools changed.
+func (r *repl) cmdUndo(ctx context.Context, args []string) error {
+	turns := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("usage: %s", replCommands["/undo"].usage)
+		}
+		turns = n
+	}
+
+	before := len(r.agent.Messages)
+	undone := 0
+
+	for ; undone < turns && r.rollbackTurn(); undone++ {
+		messages := r.agent.Messages
+		for len(messages) > 0 && messages[len(messages)-1].OfUser != nil {
+			messages = messages[:len(messages)-1]
+		}
+		r.agent.Messages = messages
+	}
+
+	if undone == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+
+	r.save()
+	r.print("Undid %s, removing %s", plural(undone, "turn"), plural(before-len(r.agent.Messages), "message"))
+
+	return nil
+}
+
+// cmdRetry rolls back the last turn, unless the last task failed before the
+// model answered, and runs it again, with model if given, which the session
+// then keeps using.
+func (r *repl) cmdRetry(ctx context.Context, args []string) error {
+	messages := r.agent.Messages
+	if len(messages) == 0 {
+		return fmt.Errorf("nothing to retry")
+	}
+
+	if messages[len(messages)-1].OfUser == nil && !r.rollbackTurn() {
+		return fmt.Errorf("nothing to retry")
+	}
+
+	if len(args) > 0 {
+		r.agent.Model = args[0]
+	}
+	r.save()
+
+	r.print("%s", tr("Retrying with %s", r.agent.Model))
+
+	task := agentTask(r.agent.Messages)
+
+	before := r.agent.Usage.Cost
+	if _, err := r.runAgent(ctx, task, r.agent.Continue); err != nil {
+		return fmt.Errorf("failed to run agent: %w", err)
+	}
+	r.lastCost = r.agent.Usage.Cost - before
+
+	r.printStatus(ctx)
+
+	return nil
+}
+
+func (r *repl) cmdResume(ctx context.Context, args []string) error {
+	checkpoint := r.sess.Checkpoint
+	if checkpoint == nil {
+		return fmt.Errorf("no interrupted task to resume")
+	}
+
+	task := agentTask(checkpoint.Messages)
+	r.print("%s", tr("Resuming: %s", task))
+
+	before := r.agent.Usage.Cost
+	if _, err := r.runAgent(ctx, task, func(ctx context.Context) (*agent.Result, error) {
+		return r.agent.Resume(ctx, *checkpoint)
+	}); err != nil {
+		return fmt.Errorf("failed to run agent: %w", err)
+	}
+	r.lastCost = r.agent.Usage.Cost - before
+
+	r.printStatus(ctx)
+
+	return nil
+}
+
+// rollbackTurn removes the last assistant message from the conversation,
+// with the results of its tool calls. It reports whether there was one.
+func (r *repl) rollbackTurn() bool {
+	messages := r.agent.Messages
+
+	i := len(messages) - 1
+	for i >= 0 && messages[i].OfAssistant == nil {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+
+	for _, m := range messages[i:] {
+		if tool := m.OfTool; tool != nil {
+			delete(r.agent.RawOutputs, tool.ToolCallID)
+		}
+	}
+	r.agent.Messages = messages[:i]
+
+	return true
+}
+
+// agentTask returns the text of the last task in a conversation.
+func agentTask(messages []openai.ChatCompletionMessageParamUnion) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if user := messages[i].OfUser; user != nil {
+			return user.Content.OfString.Value
+		}
+	}
+
+	return ""
+}
+
+// save copies the agent's state into the session and saves it. It must not be
+// called while the agent is running.
+func (r *repl) save() {
+	r.sess.Messages = r.agent.Messages
+	r.sess.Usage = r.agent.Usage
+	r.sess.Turns = r.agent.Turns
+	r.sess.RawOutputs = r.agent.RawOutputs
+	r.sess.Pinned = r.agent.Pinned
+	r.saveSession()
+}
+
+func (r *repl) saveSession() {
+	r.sess.Model = r.agent.Model
+
+	if err := r.sess.save(); err != nil {
+		log.Printf("Failed to save session: %v", err)
+	}
+}
+
+func (r *repl) prepare(params *openai.ChatCompletionNewParams) {
+	prepareRequest(r.cfg, params)
+
+	var limit int64
+	if r.agent.ContextLength != nil {
+		limit = r.agent.ContextLength(params.Model)
+	}
+	r.context.measure(params, limit, r.agent.CountRequestTokens(params))
+}
+
+// prepareRequest adds settings and context that only apply to the next
+// request rather than being stored in the conversation.
+func prepareRequest(cfg *config, params *openai.ChatCompletionNewParams) {
+	cfg.Sampling.apply(params)
+	cfg.Reasoning.apply(params)
+
+	var extra []openai.ChatCompletionMessageParamUnion
+	if prompt := cfg.localizedSystemPrompt(); prompt != "" {
+		extra = append(extra, openai.SystemMessage(prompt))
+	}
+	if message, ok := environmentMessage(cfg.Environment); ok {
+		extra = append(extra, message)
+	}
+	if message, ok := cfg.Sync.message(); ok {
+		extra = append(extra, message)
+	}
+
+	if len(extra) > 0 {
+		start := 0
+		for start < len(params.Messages) && params.Messages[start].OfSystem != nil {
+			start++
+		}
+
+		params.Messages = slices.Insert(params.Messages, start, extra...)
+	}
+}
+
+func askFollowUp(ctx context.Context, draft string) (string, error) {
+	input := draft
+
+	form := newForm(
+		huh.NewGroup(
+			huh.NewText().
+				Title(tr("Follow up (empty to exit, /help for commands)")).
+				Description(tr("ctrl+e to write it in $EDITOR")).
+				Lines(3).
+				Editor(editorCommand()...).
+				Value(&input),
+		),
+	)
+
+	if err := form.RunWithContext(ctx); err != nil {
+		return "", err
+	}
+
+	return input, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// report is the data available to output and delivery templates.
+type report struct {
+	Name      string
+	Task      string
+	Answer    string
+	Model     string
+	Time      time.Time
+	Usage     agent.Usage
+	Turns     []agent.Turn
+	ToolCalls []toolCallReport
+	SessionID string
+	Run       *runSnapshot
+}
+
+type toolCallReport struct {
+	Name      string
+	Arguments map[string]any
+	Result    string
+	Error     string
+	Duration  time.Duration
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// newReport describes a finished run. Usage and turns cover the whole
+// session, tool calls only this run.
+func newReport(name, task string, result *agent.Result, sess *session) report {
+	r := report{
+		Name:      name,
+		Task:      task,
+		Answer:    redact(result.Answer),
+		Model:     sess.Model,
+		Time:      time.Now(),
+		Usage:     sess.Usage,
+		Turns:     sess.Turns,
+		SessionID: sess.ID,
+	}
+	if n := len(sess.Runs); n > 0 {
+		r.Run = &sess.Runs[n-1]
+	}
+
+	for _, event := range result.Events {
+		finished, ok := event.(agent.ToolCallFinished)
+		if !ok {
+			continue
+		}
+
+		call := toolCallReport{
+			Name:     finished.ToolCall.Function.Name,
+			Result:   redact(finished.Result),
+			Duration: finished.Duration,
+		}
+		if args, ok := redactValue(finished.Arguments).(map[string]any); ok {
+			call.Arguments = args
+		}
+		if finished.Err != nil {
+			call.Error = redact(finished.Err.Error())
+		}
+
+		r.ToolCalls = append(r.ToolCalls, call)
+	}
+
+	return r
+}
+
+// runTemplate runs a single task and prints a report rendered with the
+// template in path. Progress is logged to stderr, so only the report is
+// written to stdout.
+func runTemplate(ctx context.Context, cfg *config, a *agent.Agent, sess *session, task string) error {
+	if task == "" {
+		return fmt.Errorf("-task is required with -template")
+	}
+
+	text, err := os.ReadFile(cfg.Output.Template)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := parseTemplate(string(text), "")
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	r := newREPL(cfg, sess, a)
+	r.render = progressLogger(cfg)
+	r.notify = notifier(cfg)
+
+	result, err := r.runTask(ctx, task)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(os.Stdout, newReport("", task, result, sess))
+}
+
+// runScripted runs a single task for shell pipelines, printing only the
+// answer, or with -output json a record of the run, to stdout. b is nil when
+// replaying, which leaves answers unverified.
+func runScripted(ctx context.Context, cfg *config, b *backend, a *agent.Agent, sess *session, task string) error {
+	if task == "" {
+		return fmt.Errorf("-task is required with -quiet or -output json")
+	}
+
+	r := newREPL(cfg, sess, a)
+	r.out = os.Stderr
+	r.render = progressLogger(cfg)
+	r.notify = notifier(cfg)
+	if b != nil {
+		r.verify = b.verify
+		r.commitMessage = b.commitMessage
+	}
+
+	start := time.Now()
+	result, err := r.runTask(ctx, task)
+
+	if cfg.Output.Format != "json" {
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(redact(result.Answer))
+		return nil
+	}
+
+	if result == nil {
+		result = &agent.Result{}
+	}
+
+	record := newRunRecord(newReport("", task, result, sess), start)
+	if err != nil {
+		record.setError(err)
+	}
+	if r.timings != nil {
+		record.Timings = r.timings.record()
+	}
+	record.Verdict = r.verdict
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(record); encErr != nil {
+		return encErr
+	}
+
+	return err
+}
+
+// progressLogger returns how non-interactive runs report progress: logged to
+// stderr, or not at all with -quiet.
+func progressLogger(cfg *config) func(agent.Event) {
+	if cfg.Output.Quiet {
+		return func(agent.Event) {}
+	}
+
+	return logEvent
+}
+
+// runRecord is the -output json record of a run.
+type runRecord struct {
+	Task       string           `json:"task"`
+	Answer     string           `json:"answer"`
+	Error      string           `json:"error,omitempty"`
+	ErrorClass string           `json:"error_class,omitempty"`
+	Model      string           `json:"model"`
+	SessionID  string           `json:"session_id"`
+	ToolCalls  []toolCallRecord `json:"tool_calls"`
+	Usage      agent.Usage      `json:"usage"`
+	Started    time.Time        `json:"started"`
+	DurationMS int64            `json:"duration_ms"`
+	Timings    *timingsRecord   `json:"timings,omitempty"`
+	Verdict    *verdict         `json:"verdict,omitempty"`
+	Run        *runSnapshot     `json:"run,omitempty"`
+}
+
+func (r *runRecord) setError(err error) {
+	r.Error = err.Error()
+	r.ErrorClass, _ = classifyError(err)
+}
+
+type toolCallRecord struct {
+	Name       string         `json:"name"`
+	Arguments  map[string]any `json:"arguments"`
+	Result     string         `json:"result"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+func newRunRecord(r report, start time.Time) runRecord {
+	record := runRecord{
+		Task:       r.Task,
+		Answer:     r.Answer,
+		Model:      r.Model,
+		SessionID:  r.SessionID,
+		ToolCalls:  []toolCallRecord{},
+		Usage:      r.Usage,
+		Started:    start,
+		DurationMS: time.Since(start).Milliseconds(),
+		Run:        r.Run,
+	}
+
+	for _, call := range r.ToolCalls {
+		record.ToolCalls = append(record.ToolCalls, toolCallRecord{
+			Name:       call.Name,
+			Arguments:  call.Arguments,
+			Result:     call.Result,
+			Error:      call.Error,
+			DurationMS: call.Duration.Milliseconds(),
+		})
+	}
+
+	return record
+}
+
+func parseTemplate(text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	return template.New("").Funcs(templateFuncs).Parse(text)
+}
+
+func renderTemplate(text, fallback string, r report) (string, error) {
+	tmpl, err := parseTemplate(text, fallback)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, r); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
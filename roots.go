@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// mcpRoots returns the directories of cfg.Roots as MCP roots, or the working
+// directory if there are none.
+func mcpRoots(cfg *config) ([]mcp.Root, error) {
+	dirs := cfg.Roots
+	if len(dirs) == 0 {
+		workspace, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		dirs = []string{workspace}
+	}
+
+	roots := make([]mcp.Root, len(dirs))
+	for i, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		roots[i] = mcp.Root{
+			URI:  "file://" + filepath.ToSlash(abs),
+			Name: filepath.Base(abs),
+		}
+	}
+
+	return roots, nil
+}
+
+// rootsTransport answers the roots/list requests the MCP server sends in its
+// event streams, which the MCP client can't, by posting the roots back. The
+// requests are taken out of the streams before the client reads them.
+type rootsTransport struct {
+	base  http.RoundTripper
+	roots []mcp.Root
+}
+
+func (t *rootsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, err
+	}
+
+	// The server names the session in its response to initialize.
+	header := req.Header.Clone()
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" && header.Get("Mcp-Session-Id") == "" {
+		header.Set("Mcp-Session-Id", session)
+	}
+
+	r, w := io.Pipe()
+	go t.filter(req.Context(), req.URL.String(), header, resp.Body, w)
+
+	resp.Body = &filteredBody{PipeReader: r, body: resp.Body}
+
+	return resp, nil
+}
+
+// filter copies the events of body to w, except roots/list requests, which it
+// answers to url with header.
+func (t *rootsTransport) filter(ctx context.Context, url string, header http.Header, body io.Reader, w *io.PipeWriter) {
+	var (
+		scanner = bufio.NewScanner(body)
+		event   bytes.Buffer
+		data    bytes.Buffer
+	)
+	scanner.Buffer(nil, 16<<20)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		event.Write(line)
+		event.WriteByte('\n')
+
+		if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data.Write(bytes.TrimPrefix(value, []byte(" ")))
+		}
+		if len(line) > 0 {
+			continue
+		}
+
+		if id, ok := rootsRequest(data.Bytes()); ok {
+			go t.reply(ctx, url, header, id)
+		} else if _, err := w.Write(event.Bytes()); err != nil {
+			return
+		}
+
+		event.Reset()
+		data.Reset()
+	}
+
+	if _, err := w.Write(event.Bytes()); err != nil {
+		return
+	}
+	w.CloseWithError(scanner.Err())
+}
+
+// rootsRequest returns the ID of data if it is a roots/list request.
+func rootsRequest(data []byte) (json.RawMessage, bool) {
+	var message struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if json.Unmarshal(data, &message) != nil || message.Method != "roots/list" || message.ID == nil {
+		return nil, false
+	}
+
+	return message.ID, true
+}
+
+// reply posts the roots to the server in response to request id.
+func (t *rootsTransport) reply(ctx context.Context, url string, header http.Header, id json.RawMessage) {
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      id,
+		"result":  mcp.ListRootsResult{Roots: t.roots},
+	})
+	if err != nil {
+		return
+	}
+
+	post, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	post.Header = header.Clone()
+	post.Header.Set("Content-Type", "application/json")
+	post.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := t.base.RoundTrip(post)
+	if err != nil {
+		log.Printf("Failed to send roots to the MCP server: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// filteredBody closes the response body along with the pipe the filtered
+// events are read from.
+type filteredBody struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+func (b *filteredBody) Close() error {
+	b.PipeReader.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/openai/openai-go/option"
+)
+
+// routingConfig is OpenRouter's provider routing, sent as is with every
+// completion to pin inference to some providers, for example for data
+// governance. Prices are in dollars per million tokens.
+type routingConfig struct {
+	Order             []string         `json:"order,omitempty"`
+	Only              []string         `json:"only,omitempty"`
+	Ignore            []string         `json:"ignore,omitempty"`
+	AllowFallbacks    *bool            `json:"allow_fallbacks,omitempty"`
+	RequireParameters bool             `json:"require_parameters,omitempty"`
+	DataCollection    string           `json:"data_collection,omitempty"`
+	MaxPrice          *routingMaxPrice `json:"max_price,omitempty"`
+}
+
+type routingMaxPrice struct {
+	Prompt     float64 `json:"prompt,omitempty"`
+	Completion float64 `json:"completion,omitempty"`
+}
+
+func (r routingConfig) empty() bool {
+	return len(r.Order) == 0 && len(r.Only) == 0 && len(r.Ignore) == 0 && r.AllowFallbacks == nil &&
+		!r.RequireParameters && r.DataCollection == "" && r.MaxPrice == nil
+}
+
+// requestOptions are sent with every completion.
+func (r routingConfig) requestOptions() []option.RequestOption {
+	opts := []option.RequestOption{usageAccounting}
+	if !r.empty() {
+		opts = append(opts, option.WithJSONSet("provider", r))
+	}
+
+	return opts
+}
+
+func (r *routingConfig) maxPrice() *routingMaxPrice {
+	if r.MaxPrice == nil {
+		r.MaxPrice = &routingMaxPrice{}
+	}
+
+	return r.MaxPrice
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+const votePrompt = "You are picking the best of several answers an AI agent gave to the same task, each from a separate attempt. " +
+	"Choose the answer most likely to be correct, list every answer that is equivalent to it, meaning the same result however it is worded, " +
+	"and briefly say why. Refer to answers by their numbers."
+
+var voteSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"choice":   map[string]any{"type": "integer"},
+		"agreeing": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+		"reason":   map[string]any{"type": "string"},
+	},
+	"required":             []any{"choice", "agreeing", "reason"},
+	"additionalProperties": false,
+}
+
+// sampleOutcome is the answer picked from the samples of a task, and how
+// many of the samples that succeeded agree with it.
+type sampleOutcome struct {
+	answer    string
+	agreeing  int
+	succeeded int
+	distinct  int
+	reason    string
+}
+
+// runSamples runs task n times in parallel, each in its own session with its
+// own MCP connection, and prints the answer most of them agree on, or the
+// one a judge model picks, with how many agree.
+func runSamples(ctx context.Context, cfg *config, task, model string, n int, vote, voteModel string) error {
+	if task == "" {
+		return fmt.Errorf("-samples needs -task")
+	}
+	if vote != "majority" && vote != "judge" {
+		return fmt.Errorf("unknown -vote %q, expected majority or judge", vote)
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	samples := make([]comparison, n)
+
+	var wg sync.WaitGroup
+
+	for i := range samples {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples[i] = compareModel(ctx, b, workspace, task, model)
+		}()
+	}
+
+	wg.Wait()
+
+	var usage agent.Usage
+	for _, s := range samples {
+		usage = usage.Plus(s.usage)
+	}
+
+	outcome := majorityVote(samples)
+	if outcome.succeeded == 0 {
+		return samples[0].err
+	}
+
+	if vote == "judge" && outcome.distinct > 1 {
+		judged, err := judgeSamples(ctx, b, cmp.Or(voteModel, model), task, samples)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, warningStyle.Render(fmt.Sprintf("Failed to have the answers judged, falling back to a majority vote: %v", err)))
+		} else {
+			judged.distinct = outcome.distinct
+			outcome = judged
+		}
+	}
+
+	if cfg.Output.Format == "json" {
+		return writeSamplesRecord(task, model, samples, outcome, usage)
+	}
+
+	fmt.Println(redact(outcome.answer))
+
+	status := fmt.Sprintf("Agreement: %d of %d samples (%.0f%%) · %s",
+		outcome.agreeing, outcome.succeeded, 100*float64(outcome.agreeing)/float64(outcome.succeeded), plural(outcome.distinct, "distinct answer"))
+	if failed := n - outcome.succeeded; failed > 0 {
+		status += fmt.Sprintf(" · %d failed", failed)
+	}
+	print("%s", statusStyle.Render(status))
+	if outcome.reason != "" {
+		print("%s", statusStyle.Render("Judge: "+outcome.reason))
+	}
+	print("%s", statusStyle.Render("Usage: "+usage.String()))
+
+	return nil
+}
+
+// majorityVote picks the answer given by the most samples, ignoring case,
+// spacing and a trailing full stop. Ties go to the earliest sample.
+func majorityVote(samples []comparison) sampleOutcome {
+	var (
+		outcome sampleOutcome
+		keys    []string
+		votes   = make(map[string]int)
+		first   = make(map[string]string)
+	)
+
+	for _, s := range samples {
+		if s.err != nil {
+			continue
+		}
+		outcome.succeeded++
+
+		key := normalizeAnswer(s.answer)
+		if _, ok := votes[key]; !ok {
+			keys = append(keys, key)
+			first[key] = s.answer
+		}
+		votes[key]++
+	}
+
+	outcome.distinct = len(keys)
+
+	for _, key := range keys {
+		if votes[key] > outcome.agreeing {
+			outcome.answer, outcome.agreeing = first[key], votes[key]
+		}
+	}
+
+	return outcome
+}
+
+func normalizeAnswer(answer string) string {
+	return strings.TrimSuffix(strings.Join(strings.Fields(strings.ToLower(answer)), " "), ".")
+}
+
+// judgeSamples has model pick the best of the answers of the samples that
+// succeeded, and say which of the others are equivalent.
+func judgeSamples(ctx context.Context, b *backend, model, task string, samples []comparison) (sampleOutcome, error) {
+	var answers []string
+	for _, s := range samples {
+		if s.err == nil {
+			answers = append(answers, s.answer)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n\n## Task\n%s\n", votePrompt, task)
+	for i, answer := range answers {
+		fmt.Fprintf(&sb, "\n## Answer %d\n%s\n", i+1, answer)
+	}
+
+	judge := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(model),
+	)
+	judge.Schema = voteSchema
+
+	result, err := judge.Run(ctx, sb.String())
+	if err != nil {
+		return sampleOutcome{}, err
+	}
+
+	var choice struct {
+		Choice   int    `json:"choice"`
+		Agreeing []int  `json:"agreeing"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(result.Answer), &choice); err != nil {
+		return sampleOutcome{}, fmt.Errorf("failed to parse the judge's choice: %v", err)
+	}
+	if choice.Choice < 1 || choice.Choice > len(answers) {
+		return sampleOutcome{}, fmt.Errorf("the judge chose answer %d of %d", choice.Choice, len(answers))
+	}
+
+	agreeing := []int{choice.Choice}
+	for _, i := range choice.Agreeing {
+		if i >= 1 && i <= len(answers) && !slices.Contains(agreeing, i) {
+			agreeing = append(agreeing, i)
+		}
+	}
+
+	return sampleOutcome{
+		answer:    answers[choice.Choice-1],
+		agreeing:  len(agreeing),
+		succeeded: len(answers),
+		reason:    choice.Reason,
+	}, nil
+}
+
+// samplesRecord is the -output json record of -samples.
+type samplesRecord struct {
+	Task      string         `json:"task"`
+	Answer    string         `json:"answer"`
+	Model     string         `json:"model"`
+	Agreement float64        `json:"agreement"`
+	Reason    string         `json:"reason,omitempty"`
+	Samples   []sampleRecord `json:"samples"`
+	Usage     agent.Usage    `json:"usage"`
+}
+
+type sampleRecord struct {
+	Answer     string      `json:"answer"`
+	Error      string      `json:"error,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	Usage      agent.Usage `json:"usage"`
+}
+
+func writeSamplesRecord(task, model string, samples []comparison, outcome sampleOutcome, usage agent.Usage) error {
+	record := samplesRecord{
+		Task:      task,
+		Answer:    redact(outcome.answer),
+		Model:     model,
+		Agreement: float64(outcome.agreeing) / float64(outcome.succeeded),
+		Reason:    outcome.reason,
+		Usage:     usage,
+	}
+
+	for _, s := range samples {
+		r := sampleRecord{
+			Answer:     redact(s.answer),
+			DurationMS: s.duration.Milliseconds(),
+			Usage:      s.usage,
+		}
+		if s.err != nil {
+			r.Error = s.err.Error()
+		}
+
+		record.Samples = append(record.Samples, r)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(record)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// scheduleConfig describes a task run by the daemon, either daily at a fixed
+// local time, at a fixed interval or when a cron expression is due.
+type scheduleConfig struct {
+	Name    string         `json:"name"`
+	Task    string         `json:"task"`
+	Model   string         `json:"model,omitempty"`
+	At      string         `json:"at,omitempty"`
+	Every   string         `json:"every,omitempty"`
+	Cron    string         `json:"cron,omitempty"`
+	Deliver deliveryConfig `json:"deliver"`
+}
+
+func (s scheduleConfig) validate() error {
+	if s.Name == "" || s.Task == "" {
+		return fmt.Errorf("schedules need a name and a task")
+	}
+	set := 0
+	for _, when := range []string{s.At, s.Every, s.Cron} {
+		if when != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("schedule %s: exactly one of at, every and cron must be set", s.Name)
+	}
+
+	if _, err := s.next(time.Now()); err != nil {
+		return fmt.Errorf("schedule %s: %v", s.Name, err)
+	}
+
+	return s.Deliver.validate()
+}
+
+// next returns the first time the schedule is due after the given time.
+func (s scheduleConfig) next(after time.Time) (time.Time, error) {
+	if s.Cron != "" {
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		next := c.next(after)
+		if next.IsZero() {
+			return time.Time{}, fmt.Errorf("cron expression %q is never due", s.Cron)
+		}
+
+		return next, nil
+	}
+
+	if s.Every != "" {
+		every, err := time.ParseDuration(s.Every)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if every < time.Minute {
+			return time.Time{}, fmt.Errorf("interval %s is shorter than a minute", every)
+		}
+
+		return after.Add(every), nil
+	}
+
+	at, err := time.Parse("15:04", s.At)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM", s.At)
+	}
+
+	next := time.Date(after.Year(), after.Month(), after.Day(), at.Hour(), at.Minute(), 0, 0, after.Location())
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next, nil
+}
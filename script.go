@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var scriptShebangs = map[string]string{
+	"python":     "#!/usr/bin/env python3",
+	"python3":    "#!/usr/bin/env python3",
+	"bash":       "#!/usr/bin/env bash",
+	"sh":         "#!/bin/sh",
+	"javascript": "#!/usr/bin/env node",
+	"ruby":       "#!/usr/bin/env ruby",
+}
+
+// extractScript concatenates the code sent to code tools in a session into a
+// single script, with each task and step as a comment.
+func extractScript(s *session, codeTools map[string]codeToolConfig) (string, error) {
+	var (
+		body     strings.Builder
+		language string
+		task     string
+		step     int
+	)
+
+	for _, message := range s.Messages {
+		if message.OfUser != nil {
+			task = message.OfUser.Content.OfString.Value
+			continue
+		}
+		if message.OfAssistant == nil {
+			continue
+		}
+
+		for _, toolCall := range message.OfAssistant.ToolCalls {
+			var args map[string]any
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+				continue
+			}
+
+			code, lang, ok := toolCode(codeTools, toolCall.Function.Name, args)
+			if !ok {
+				continue
+			}
+
+			lang = strings.ToLower(lang)
+			if language == "" {
+				language = lang
+			} else if lang != language {
+				return "", fmt.Errorf("session mixes %s and %s code, which can't be run as one script", language, lang)
+			}
+
+			if task != "" {
+				fmt.Fprintf(&body, "\n%s\n", scriptComment(language, "Task: "+task))
+				task = ""
+			}
+
+			step++
+			fmt.Fprintf(&body, "\n%s\n%s\n", scriptComment(language, fmt.Sprintf("Step %d (%s)", step, toolCall.Function.Name)), strings.TrimRight(normalizeNewlines(code), "\n"))
+		}
+	}
+
+	if step == 0 {
+		return "", fmt.Errorf("session %s didn't run any code", s.ID)
+	}
+
+	var sb strings.Builder
+	if shebang, ok := scriptShebangs[language]; ok {
+		sb.WriteString(shebang + "\n")
+	}
+	sb.WriteString(scriptComment(language, fmt.Sprintf("Extracted from session %s (%s) by mcp-experiment.", s.ID, s.Model)) + "\n")
+	sb.WriteString(body.String())
+
+	return sb.String(), nil
+}
+
+func scriptComment(language, text string) string {
+	prefix := "# "
+	switch language {
+	case "javascript", "typescript", "go", "c", "c++", "java", "rust":
+		prefix = "// "
+	}
+
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// serveConfig configures the HTTP server. With Keys, every request needs one
+// of them, and what each key may do is limited.
+type serveConfig struct {
+	Keys []apiKeyConfig `json:"keys,omitempty"`
+}
+
+// apiKeyConfig is an API key of the HTTP server, stored as the SHA-256 of the
+// key. Name identifies who uses it in sessions and costs. Models are globs of
+// the models it may use, any if empty; TasksPerMinute limits how often it may
+// start tasks; and MaxCost is the most it may spend in Period, a duration like
+// 30d or 12h.
+type apiKeyConfig struct {
+	Name           string   `json:"name"`
+	KeySHA256      string   `json:"key_sha256"`
+	Models         []string `json:"models,omitempty"`
+	TasksPerMinute int      `json:"tasks_per_minute,omitempty"`
+	MaxCost        float64  `json:"max_cost,omitempty"`
+	Period         string   `json:"period,omitempty"`
+}
+
+const defaultQuotaPeriod = "30d"
+
+func (c serveConfig) validate() error {
+	names := make(map[string]bool)
+
+	for _, key := range c.Keys {
+		if key.Name == "" {
+			return fmt.Errorf("API keys need a name")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("API key name %q is used twice", key.Name)
+		}
+		names[key.Name] = true
+
+		if hash, err := hex.DecodeString(key.KeySHA256); err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("key_sha256 of API key %q isn't a hex SHA-256", key.Name)
+		}
+		for _, pattern := range key.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid model pattern %q of API key %q", pattern, key.Name)
+			}
+		}
+		if _, err := parseSince(cmp.Or(key.Period, defaultQuotaPeriod), time.Now()); err != nil {
+			return fmt.Errorf("invalid period of API key %q: %v", key.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// authenticate returns the key of the bearer token of r. It is false if the
+// server has keys and r has none of them.
+func (c serveConfig) authenticate(r *http.Request) (*apiKeyConfig, bool) {
+	if len(c.Keys) == 0 {
+		return nil, true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, false
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for i, key := range c.Keys {
+		want, _ := hex.DecodeString(key.KeySHA256)
+		if subtle.ConstantTimeCompare(hash[:], want) == 1 {
+			return &c.Keys[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// allowsModel reports whether the key may use model.
+func (k *apiKeyConfig) allowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(k.Models, func(pattern string) bool {
+		ok, _ := path.Match(pattern, model)
+		return ok
+	})
+}
+
+// spent returns what the key has spent in its period, from the sessions it
+// ran, and when the period started.
+func (k *apiKeyConfig) spent(now time.Time) (float64, time.Time, error) {
+	start, err := parseSince(cmp.Or(k.Period, defaultQuotaPeriod), now)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	records, err := store.usage(start)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var cost float64
+	for _, record := range records {
+		if record.User == k.Name {
+			cost += record.Turn.Usage.Cost
+		}
+	}
+
+	return cost, start, nil
+}
+
+// keySpend holds what the running tasks of each API key may still spend, so
+// tasks running at once can't together spend more than its quota.
+type keySpend struct {
+	mu       sync.Mutex
+	reserved map[string]float64
+}
+
+// reserve sets aside what a task of key may spend: what is left of its quota,
+// less what running tasks may spend, and at most limit if it is positive. It
+// returns zero if nothing is left. release gives back what wasn't spent, as
+// by then it is recorded with the session.
+func (s *keySpend) reserve(key *apiKeyConfig, limit float64) (amount float64, release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spent, _, err := key.spent(time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	amount = key.MaxCost - spent - s.reserved[key.Name]
+	if amount <= 0 {
+		return 0, nil, nil
+	}
+	if limit > 0 {
+		amount = min(amount, limit)
+	}
+
+	if s.reserved == nil {
+		s.reserved = make(map[string]float64)
+	}
+	s.reserved[key.Name] += amount
+
+	return amount, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.reserved[key.Name] -= amount
+	}, nil
+}
+
+// keyLimiters hold the task rate limiters of the API keys, which outlive
+// config reloads unless the rate changes.
+type keyLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*agent.RateLimiter
+}
+
+func (l *keyLimiters) allow(key *apiKeyConfig) bool {
+	if key.TasksPerMinute <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := fmt.Sprintf("%s/%d", key.Name, key.TasksPerMinute)
+	limiter, ok := l.limiters[id]
+	if !ok {
+		if l.limiters == nil {
+			l.limiters = make(map[string]*agent.RateLimiter)
+		}
+		limiter = agent.NewRateLimiter(key.TasksPerMinute, key.TasksPerMinute)
+		l.limiters[id] = limiter
+	}
+
+	return limiter.Allow()
+}
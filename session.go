@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/google/uuid"
+	"github.com/openai/openai-go"
+)
+
+type session struct {
+	ID        string                                   `json:"id"`
+	ParentID  string                                   `json:"parent_id,omitempty"`
+	Workspace string                                   `json:"workspace"`
+	Model     string                                   `json:"model"`
+	Profile   string                                   `json:"profile,omitempty"`
+	User      string                                   `json:"user,omitempty"`
+	Created   time.Time                                `json:"created"`
+	Updated   time.Time                                `json:"updated"`
+	Usage     agent.Usage                              `json:"usage"`
+	Turns     []agent.Turn                             `json:"turns,omitempty"`
+	Runs      []runSnapshot                            `json:"runs,omitempty"`
+	Messages  []openai.ChatCompletionMessageParamUnion `json:"messages"`
+
+	// RawOutputs holds full tool outputs that were summarized before
+	// entering the context, keyed by tool call ID.
+	RawOutputs map[string]string `json:"raw_outputs,omitempty"`
+
+	// ToolCalls holds how long tool calls took and whether they failed,
+	// keyed by tool call ID.
+	ToolCalls map[string]toolCallStats `json:"tool_calls,omitempty"`
+
+	// Pinned holds the agent.PinKey of messages kept through compaction.
+	Pinned map[string]bool `json:"pinned,omitempty"`
+
+	// Checkpoint is the state of the task running, saved as it goes, and
+	// kept if the task was cut short so it can be resumed.
+	Checkpoint *agent.Checkpoint `json:"checkpoint,omitempty"`
+}
+
+func newSession(workspace, model string) *session {
+	now := time.Now()
+
+	return &session{
+		ID:        uuid.NewString(),
+		Workspace: workspace,
+		Model:     model,
+		Created:   now,
+		Updated:   now,
+		Messages:  systemMessages(),
+	}
+}
+
+// fork starts a new branch from the current state of the conversation. Usage
+// is tracked per branch, so the child starts from zero.
+func (s *session) fork() *session {
+	child := newSession(s.Workspace, s.Model)
+	child.ParentID = s.ID
+	child.User = s.User
+	child.Messages = slices.Clone(s.Messages)
+	child.RawOutputs = maps.Clone(s.RawOutputs)
+	child.Pinned = maps.Clone(s.Pinned)
+	child.ToolCalls = maps.Clone(s.ToolCalls)
+
+	return child
+}
+
+// save stores the session, with secrets redacted if enabled. The session in
+// memory keeps them.
+func (s *session) save() error {
+	s.Updated = time.Now()
+
+	if len(redactions) == 0 {
+		return store.save(s)
+	}
+
+	redacted := *s
+	redacted.Messages = redactMessages(s.Messages)
+	redacted.RawOutputs = redactStrings(s.RawOutputs)
+	if s.Checkpoint != nil {
+		checkpoint := *s.Checkpoint
+		checkpoint.Messages = redactMessages(checkpoint.Messages)
+		checkpoint.RawOutputs = redactStrings(checkpoint.RawOutputs)
+		checkpoint.Results = redactStrings(checkpoint.Results)
+		redacted.Checkpoint = &checkpoint
+	}
+
+	return store.save(&redacted)
+}
+
+func redactStrings(values map[string]string) map[string]string {
+	redacted := make(map[string]string, len(values))
+	for k, v := range values {
+		redacted[k] = redact(v)
+	}
+
+	return redacted
+}
+
+func findSession(id string) (*session, error) {
+	if err := uuid.Validate(id); err != nil {
+		return nil, fmt.Errorf("invalid session id %q", id)
+	}
+
+	return store.load(id)
+}
+
+// listSessions returns the sessions in workspace, or all sessions if it is
+// empty, most recently updated first.
+func listSessions(workspace string) ([]*session, error) {
+	return store.list(workspace)
+}
+
+// lastInterruptedSession returns the most recently updated session in
+// workspace with a task that was cut short.
+func lastInterruptedSession(workspace string) (*session, error) {
+	sessions, err := listSessions(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sessions {
+		if s.Checkpoint != nil {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no interrupted task to resume in %s", workspace)
+}
+
+func (s *session) title() string {
+	for _, message := range s.Messages {
+		if message.OfUser == nil {
+			continue
+		}
+
+		title := strings.Join(strings.Fields(message.OfUser.Content.OfString.Value), " ")
+		if runes := []rune(title); len(runes) > 60 {
+			title = string(runes[:57]) + "..."
+		}
+
+		return title
+	}
+
+	return "(empty)"
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals relays SIGHUP, SIGINT and SIGTERM. Long-running commands
+// reload their config on SIGHUP, drain on the first SIGINT or SIGTERM, and
+// stop immediately on the second.
+func notifySignals() (<-chan os.Signal, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	return signals, func() { signal.Stop(signals) }
+}
+
+// reloadConfig reads the config file and command line flags again. The
+// current config is kept if the new one is invalid.
+func reloadConfig(b *backend, validate func(*config) error) (*config, bool) {
+	cfg, _, err := parseArgs(os.Args[1:], flag.ContinueOnError)
+	if err == nil && validate != nil {
+		err = validate(cfg)
+	}
+	if err != nil {
+		log.Printf("Failed to reload config, keeping the current one: %v", err)
+		return nil, false
+	}
+
+	b.reload(cfg)
+	log.Printf("Reloaded config")
+
+	return cfg, true
+}
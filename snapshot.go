@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// runSnapshot records what a session was run with, so old transcripts can be
+// interpreted and reproduced. PromptHash covers the system prompts the model
+// was given and ToolsHash the schemas of the tools offered, so runs can be
+// told apart when either changed without the config changing.
+type runSnapshot struct {
+	Time          time.Time          `json:"time"`
+	Model         string             `json:"model"`
+	Provider      string             `json:"provider"`
+	Servers       []agent.ServerInfo `json:"servers"`
+	Sampling      samplingConfig     `json:"sampling"`
+	PromptProfile string             `json:"prompt_profile,omitempty"`
+	PromptHash    string             `json:"prompt_hash"`
+	ToolsHash     string             `json:"tools_hash,omitempty"`
+	ConfigHash    string             `json:"config_hash"`
+	BinaryVersion string             `json:"binary_version"`
+}
+
+func newRunSnapshot(cfg *config, a *agent.Agent) runSnapshot {
+	var prompts []string
+	for _, message := range a.Messages {
+		if message.OfSystem == nil {
+			break
+		}
+		prompts = append(prompts, message.OfSystem.Content.OfString.Value)
+	}
+
+	snapshot := runSnapshot{
+		Time:          time.Now(),
+		Model:         a.Model,
+		Provider:      cfg.baseURL(),
+		Servers:       a.Servers(),
+		Sampling:      cfg.Sampling,
+		PromptProfile: matchPromptProfile(cfg, prompts),
+		PromptHash:    hashJSON(append(prompts, cfg.localizedSystemPrompt())),
+		ConfigHash:    cfg.hash(),
+		BinaryVersion: binaryVersion(),
+	}
+	if tools := a.Tools(); len(tools) > 0 {
+		snapshot.ToolsHash = hashJSON(tools)
+	}
+
+	return snapshot
+}
+
+// sameRun reports whether two snapshots were taken of the same setup.
+func sameRun(a, b runSnapshot) bool {
+	a.Time, b.Time = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+// stampRun records what the task about to run is run with, unless it's what
+// the session last ran with. A snapshot no completion was made under since it
+// was taken, such as one taken before the tools were listed, is replaced.
+func (r *repl) stampRun() {
+	snapshot := newRunSnapshot(r.cfg, r.agent)
+	runs := r.sess.Runs
+
+	if n := len(runs); n > 0 {
+		if sameRun(runs[n-1], snapshot) {
+			return
+		}
+
+		turns := r.sess.Turns
+		if len(turns) == 0 || turns[len(turns)-1].Time.Before(runs[n-1].Time) {
+			runs = runs[:n-1]
+		}
+	}
+
+	r.sess.Runs = append(runs, snapshot)
+}
+
+func (c *config) hash() string {
+	return hashJSON(c)
+}
+
+func hashJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func binaryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version += " " + setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				version += "-dirty"
+			}
+		}
+	}
+
+	return version
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+)
+
+// healthCheckTimeout bounds the check of the provider before the first task.
+const healthCheckTimeout = 15 * time.Second
+
+// startAgent connects a new agent to the MCP server once the first task of
+// an interactive session is known, so the form doesn't wait for it, and
+// checks the provider with model meanwhile. A server that can't be reached
+// doesn't fail: the agent chats without its tools instead, with a warning.
+// The caller must close the returned client.
+func (b *backend) startAgent(ctx context.Context, model string) (*agent.Agent, *mcpclient.Client, error) {
+	var (
+		a           *agent.Agent
+		mcpClient   *mcpclient.Client
+		err         error
+		warning     string
+		providerErr error
+	)
+
+	withSpinner(ctx, tr("Checking the provider and connecting to the MCP server..."), func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			warning, providerErr = b.checkProvider(ctx, model)
+		}()
+		go func() {
+			defer wg.Done()
+			a, mcpClient, err = b.newAgent(ctx)
+		}()
+		wg.Wait()
+	})
+
+	if providerErr != nil {
+		if err == nil {
+			mcpClient.Close()
+		}
+		return nil, nil, providerErr
+	}
+	if warning != "" {
+		printWarning("%s", warning)
+	}
+
+	if errors.Is(err, errMCPConnection) || errors.Is(err, agent.ErrServerUnreachable) {
+		printWarning("The MCP server at %s can't be reached, chatting without its tools: %v", mcpServerURL, err)
+		return b.newOfflineAgent()
+	}
+
+	return a, mcpClient, err
+}
+
+// checkProvider makes sure the provider can be reached, listing its models
+// again if they came from the cache, and warns if it doesn't offer model.
+func (b *backend) checkProvider(ctx context.Context, model string) (string, error) {
+	if b.modelsCached {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+
+		if _, err := fetchModels(ctx, b.openai); err != nil {
+			return "", fmt.Errorf("%w: %v", errFetchModels, err)
+		}
+	}
+
+	if len(b.models) > 0 && findModel(b.models, model).ID == "" {
+		return fmt.Sprintf("%s isn't offered by the provider, pick another with -model", model), nil
+	}
+
+	return "", nil
+}
+
+// withSpinner runs fn, showing title beside a spinner meanwhile if the
+// output is a terminal.
+func withSpinner(ctx context.Context, title string, fn func()) {
+	if minimalOutput || !term.IsTerminal(os.Stdout.Fd()) {
+		fn()
+		return
+	}
+
+	p := tea.NewProgram(spinnerModel{
+		spinner: spinner.New(spinner.WithSpinner(spinner.Dot)),
+		title:   title,
+	}, tea.WithContext(ctx), tea.WithInput(nil))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+		p.Send(spinnerDone{})
+	}()
+
+	// The spinner only stops early if ctx is done, which fn sees too.
+	p.Run()
+	<-done
+}
+
+type spinnerDone struct{}
+
+type spinnerModel struct {
+	spinner spinner.Model
+	title   string
+	done    bool
+}
+
+func (m spinnerModel) Init() tea.Cmd {
+	return m.spinner.Tick
+}
+
+func (m spinnerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(spinnerDone); ok {
+		m.done = true
+		return m, tea.Quit
+	}
+
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+
+	return m, cmd
+}
+
+func (m spinnerModel) View() string {
+	if m.done {
+		return ""
+	}
+
+	return statusStyle.Render(m.spinner.View() + m.title)
+}
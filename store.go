@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// sessionStore persists sessions, including their usage, and caches the
+// models providers list. The file store keeps one JSON file per session and
+// caches nothing; the SQL stores index usage and let several instances share
+// sessions.
+type sessionStore interface {
+	save(s *session) error
+	load(id string) (*session, error)
+	list(workspace string) ([]*session, error)
+
+	// usage returns the completions made since a time, oldest first.
+	usage(since time.Time) ([]usageRecord, error)
+
+	// cachedModels returns the models provider listed within maxAge, and
+	// cacheModels keeps them.
+	cachedModels(provider string, maxAge time.Duration) ([]modelInfo, bool)
+	cacheModels(provider string, models []modelInfo) error
+
+	Close() error
+}
+
+// usageRecord is a completion made in a session.
+type usageRecord struct {
+	Session string
+	Profile string
+	User    string
+	Turn    agent.Turn
+}
+
+// sessionUsage returns the completions of sessions since a time, oldest
+// first.
+func sessionUsage(sessions []*session, since time.Time) []usageRecord {
+	var records []usageRecord
+
+	for _, s := range sessions {
+		for _, turn := range s.Turns {
+			if turn.Time.Before(since) {
+				continue
+			}
+
+			records = append(records, usageRecord{
+				Session: s.ID,
+				Profile: s.Profile,
+				User:    s.User,
+				Turn:    turn,
+			})
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b usageRecord) int {
+		return a.Turn.Time.Compare(b.Turn.Time)
+	})
+
+	return records
+}
+
+type storageConfig struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn,omitempty"`
+}
+
+var store sessionStore
+
+var errSessionNotFound = errors.New("session not found")
+
+func openStore(cfg storageConfig) (sessionStore, error) {
+	dir, err := appDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files := &fileStore{dir: filepath.Join(dir, "sessions")}
+
+	switch cfg.Driver {
+	case "file":
+		return files, nil
+	case "", "sqlite":
+		dsn := cfg.DSN
+		if dsn == "" {
+			dsn = filepath.Join(dir, "sessions.db")
+		}
+
+		_, err := os.Stat(dsn)
+		created := errors.Is(err, os.ErrNotExist)
+
+		if err := os.MkdirAll(filepath.Dir(dsn), 0o700); err != nil {
+			return nil, err
+		}
+
+		s, err := openSQLStore("sqlite", "file:"+dsn+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+		if err != nil {
+			return nil, err
+		}
+
+		// Bring sessions saved before SQLite became the default along.
+		if created {
+			if err := importSessions(files, s); err != nil {
+				s.Close()
+				return nil, fmt.Errorf("failed to import sessions: %v", err)
+			}
+		}
+
+		return s, nil
+	case "postgres":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("the postgres storage driver needs a dsn")
+		}
+
+		return openSQLStore("pgx", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+func importSessions(from, to sessionStore) error {
+	sessions, err := from.list("")
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if err := to.save(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (f *fileStore) save(s *session) error {
+	if err := os.MkdirAll(f.dir, 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Sessions are saved as tasks run, so being killed while writing one
+	// mustn't leave it cut in half.
+	path := filepath.Join(f.dir, s.ID+".json")
+	if err := os.WriteFile(path+".tmp", encrypt(data), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (f *fileStore) load(id string) (*session, error) {
+	s, err := loadSessionFile(filepath.Join(f.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errSessionNotFound
+	}
+
+	return s, err
+}
+
+func (f *fileStore) list(workspace string) ([]*session, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*session
+
+	for _, path := range paths {
+		s, err := loadSessionFile(path)
+		if err != nil {
+			continue
+		}
+
+		if workspace == "" || s.Workspace == workspace {
+			sessions = append(sessions, s)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b *session) int {
+		return b.Updated.Compare(a.Updated)
+	})
+
+	return sessions, nil
+}
+
+func (f *fileStore) usage(since time.Time) ([]usageRecord, error) {
+	sessions, err := f.list("")
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionUsage(sessions, since), nil
+}
+
+func (f *fileStore) cachedModels(string, time.Duration) ([]modelInfo, bool) {
+	return nil, false
+}
+
+func (f *fileStore) cacheModels(string, []modelInfo) error {
+	return nil
+}
+
+func (f *fileStore) Close() error {
+	return nil
+}
+
+func loadSessionFile(path string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decrypt(data); err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %v", path, err)
+	}
+
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %v", path, err)
+	}
+
+	return &s, nil
+}
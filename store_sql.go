@@ -0,0 +1,346 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore keeps each session as a JSON document, with the columns needed to
+// list them alongside. It works with SQLite and Postgres.
+type sqlStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// sqlMigrations bring the schema up to date, each once, in order. Existing
+// databases from before migrations were recorded start from the first, so
+// every migration must work on a database that already has sessions.
+var sqlMigrations = []func(s *sqlStore, tx *sql.Tx) error{
+	execMigration(
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			parent_id TEXT NOT NULL,
+			workspace TEXT NOT NULL,
+			updated BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_workspace ON sessions (workspace, updated)`,
+	),
+	// Completions are indexed by time, so costs and quotas don't need to
+	// read every session.
+	func(s *sqlStore, tx *sql.Tx) error {
+		err := execMigration(
+			`CREATE TABLE usage (
+				session_id TEXT NOT NULL,
+				seq INTEGER NOT NULL,
+				time BIGINT NOT NULL,
+				profile TEXT NOT NULL,
+				user_name TEXT NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (session_id, seq)
+			)`,
+			`CREATE INDEX usage_time ON usage (time)`,
+		)(s, tx)
+		if err != nil {
+			return err
+		}
+
+		sessions, err := s.scanSessions(tx.Query(`SELECT data FROM sessions`))
+		if err != nil {
+			return err
+		}
+
+		for _, sess := range sessions {
+			if err := s.insertUsage(tx, sess, 0); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	execMigration(`CREATE TABLE models (
+		provider TEXT PRIMARY KEY,
+		fetched BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`),
+}
+
+func execMigration(stmts ...string) func(s *sqlStore, tx *sql.Tx) error {
+	return func(s *sqlStore, tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func openSQLStore(driver, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqlStore{db: db, driver: driver}
+
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate the database: %v", err)
+	}
+
+	return s, nil
+}
+
+// migrate applies the migrations the database hasn't had yet.
+func (s *sqlStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	for {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		// Instances starting together on Postgres take turns.
+		if s.driver == "pgx" {
+			if _, err := tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		var version int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if version >= len(sqlMigrations) {
+			return tx.Rollback()
+		}
+
+		if err := sqlMigrations[version](s, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(s.query(`INSERT INTO schema_migrations (version) VALUES (?)`), version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// query rewrites ? placeholders for Postgres.
+func (s *sqlStore) query(q string) string {
+	if s.driver != "pgx" {
+		return q
+	}
+
+	var sb strings.Builder
+	n := 0
+
+	for _, r := range q {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+func (s *sqlStore) save(sess *session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(s.query(`INSERT INTO sessions (id, parent_id, workspace, updated, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET parent_id = excluded.parent_id, workspace = excluded.workspace, updated = excluded.updated, data = excluded.data`),
+		sess.ID, sess.ParentID, sess.Workspace, sess.Updated.UnixNano(), string(encrypt(data)))
+	if err != nil {
+		return err
+	}
+
+	// Turns are only ever added, so only the new ones are written.
+	var saved int
+	if err := tx.QueryRow(s.query(`SELECT COUNT(*) FROM usage WHERE session_id = ?`), sess.ID).Scan(&saved); err != nil {
+		return err
+	}
+	if saved > len(sess.Turns) {
+		if _, err := tx.Exec(s.query(`DELETE FROM usage WHERE session_id = ?`), sess.ID); err != nil {
+			return err
+		}
+		saved = 0
+	}
+
+	if err := s.insertUsage(tx, sess, saved); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertUsage records the turns of a session from the one numbered from.
+func (s *sqlStore) insertUsage(tx *sql.Tx, sess *session, from int) error {
+	for seq := from; seq < len(sess.Turns); seq++ {
+		data, err := json.Marshal(sess.Turns[seq])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.query(`INSERT INTO usage (session_id, seq, time, profile, user_name, data) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (session_id, seq) DO NOTHING`),
+			sess.ID, seq, sess.Turns[seq].Time.UnixNano(), sess.Profile, sess.User, string(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStore) load(id string) (*session, error) {
+	var data string
+
+	err := s.db.QueryRow(s.query(`SELECT data FROM sessions WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := decrypt([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %v", id, err)
+	}
+
+	var sess session
+	if err := json.Unmarshal(decrypted, &sess); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %v", id, err)
+	}
+
+	return &sess, nil
+}
+
+func (s *sqlStore) list(workspace string) ([]*session, error) {
+	return s.scanSessions(s.db.Query(s.query(`SELECT data FROM sessions WHERE ? = '' OR workspace = ? ORDER BY updated DESC`), workspace, workspace))
+}
+
+// scanSessions decodes the sessions in the data column of rows, skipping
+// those that don't decrypt or parse.
+func (s *sqlStore) scanSessions(rows *sql.Rows, err error) ([]*session, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*session
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		decrypted, err := decrypt([]byte(data))
+		if err != nil {
+			continue
+		}
+
+		var sess session
+		if err := json.Unmarshal(decrypted, &sess); err != nil {
+			continue
+		}
+
+		sessions = append(sessions, &sess)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *sqlStore) usage(since time.Time) ([]usageRecord, error) {
+	rows, err := s.db.Query(s.query(`SELECT session_id, profile, user_name, data FROM usage WHERE time >= ? ORDER BY time`), since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []usageRecord
+
+	for rows.Next() {
+		var (
+			record usageRecord
+			data   string
+		)
+		if err := rows.Scan(&record.Session, &record.Profile, &record.User, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &record.Turn); err != nil {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *sqlStore) cachedModels(provider string, maxAge time.Duration) ([]modelInfo, bool) {
+	var (
+		fetched int64
+		data    string
+	)
+
+	err := s.db.QueryRow(s.query(`SELECT fetched, data FROM models WHERE provider = ?`), provider).Scan(&fetched, &data)
+	if err != nil || time.Since(time.Unix(0, fetched)) > maxAge {
+		return nil, false
+	}
+
+	var models []modelInfo
+	if err := json.Unmarshal([]byte(data), &models); err != nil {
+		return nil, false
+	}
+
+	return models, true
+}
+
+func (s *sqlStore) cacheModels(provider string, models []modelInfo) error {
+	data, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(s.query(`INSERT INTO models (provider, fetched, data) VALUES (?, ?, ?)
+		ON CONFLICT (provider) DO UPDATE SET fetched = excluded.fetched, data = excluded.data`),
+		provider, time.Now().UnixNano(), string(data))
+
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
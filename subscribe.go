@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// subscriptionConfig subscribes interactive sessions to a resource of the
+// servers. When it changes, Task is run as a follow-up, or without one the
+// change is noted in the next task.
+type subscriptionConfig struct {
+	URI  string `json:"uri"`
+	Task string `json:"task,omitempty"`
+}
+
+// subscribe subscribes to the resource at uri, to run task when it changes,
+// or note the change in the next task if empty.
+func (r *repl) subscribe(ctx context.Context, uri, task string) error {
+	if err := r.agent.Subscribe(ctx, uri); err != nil {
+		return err
+	}
+
+	r.subscriptionsMu.Lock()
+	r.subscriptions[uri] = task
+	r.subscriptionsMu.Unlock()
+
+	return nil
+}
+
+// resourceUpdated is called by the agent when a resource subscribed to
+// changes. It must not block.
+func (r *repl) resourceUpdated(server, uri string) {
+	r.subscriptionsMu.Lock()
+	defer r.subscriptionsMu.Unlock()
+
+	task, ok := r.subscriptions[uri]
+	if !ok {
+		return
+	}
+	r.changed[uri] = true
+
+	if task == "" {
+		return
+	}
+
+	// Tasks that don't fit are dropped, as the resource will be noted as
+	// changed in the next one anyway.
+	select {
+	case r.followUps <- task:
+	default:
+	}
+}
+
+// resourceNotes returns the note to add to the next task about resources
+// that changed since the last, if any.
+func (r *repl) resourceNotes() string {
+	r.subscriptionsMu.Lock()
+	changed := slices.Sorted(maps.Keys(r.changed))
+	clear(r.changed)
+	r.subscriptionsMu.Unlock()
+
+	if len(changed) == 0 {
+		return ""
+	}
+
+	r.print("%s", statusStyle.Render(tr("Changed since the last task: %s", strings.Join(changed, ", "))))
+
+	var notes []string
+	for _, uri := range changed {
+		notes = append(notes, fmt.Sprintf("Resource %s changed since the last task.", uri))
+	}
+
+	return strings.Join(notes, "\n")
+}
+
+// nextInput asks for a follow-up, unless a subscribed resource with a task
+// changes first, in which case its task is the follow-up.
+func (r *repl) nextInput(ctx context.Context) (string, error) {
+	askCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	triggered := make(chan string, 1)
+	go func() {
+		select {
+		case task := <-r.followUps:
+			triggered <- task
+			cancel()
+		case <-askCtx.Done():
+		}
+	}()
+
+	next, err := askFollowUp(askCtx, r.draft)
+	r.draft = ""
+	cancel()
+
+	select {
+	case task := <-triggered:
+		if err == nil && strings.TrimSpace(next) != "" {
+			// The follow-up was entered as the resource changed, so its
+			// task waits for the next one.
+			select {
+			case r.followUps <- task:
+			default:
+			}
+			return next, nil
+		}
+		return task, nil
+	default:
+		return next, err
+	}
+}
+
+// cmdSubscribe subscribes to a resource, running a task when it changes if
+// one is given, or lists the subscriptions.
+func (r *repl) cmdSubscribe(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		if err := r.subscribe(ctx, args[0], strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	}
+
+	r.subscriptionsMu.Lock()
+	defer r.subscriptionsMu.Unlock()
+
+	if len(r.subscriptions) == 0 {
+		r.print("%s", tr("No subscriptions"))
+		return nil
+	}
+
+	for _, uri := range slices.Sorted(maps.Keys(r.subscriptions)) {
+		if task := r.subscriptions[uri]; task != "" {
+			r.print("  %s  %s", uri, tr("runs %q", task))
+		} else {
+			r.print("  %s", uri)
+		}
+	}
+
+	return nil
+}
+
+func (r *repl) cmdUnsubscribe(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", replCommands["/unsubscribe"].usage)
+	}
+
+	if err := r.agent.Unsubscribe(ctx, args[0]); err != nil {
+		return err
+	}
+
+	r.subscriptionsMu.Lock()
+	delete(r.subscriptions, args[0])
+	delete(r.changed, args[0])
+	r.subscriptionsMu.Unlock()
+
+	r.print("%s", tr("Unsubscribed from %s", args[0]))
+
+	return nil
+}
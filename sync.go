@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/openai/openai-go"
+)
+
+// syncConfig uploads the files of a local directory to the sandbox when the
+// agent starts, with Tool, which must run the Python code in its Argument,
+// into Remote. With Pull, the files changed there are copied back when it
+// exits.
+type syncConfig struct {
+	Dir      string `json:"dir,omitempty"`
+	Remote   string `json:"remote,omitempty"`
+	Pull     bool   `json:"pull,omitempty"`
+	Tool     string `json:"tool,omitempty"`
+	Argument string `json:"argument,omitempty"`
+}
+
+const (
+	// Files bigger than maxSyncFile aren't uploaded, and a call uploads at
+	// most syncBatch bytes.
+	maxSyncFile = 1 << 20
+	syncBatch   = 256 << 10
+)
+
+func (s syncConfig) remote() string {
+	return cmp.Or(s.Remote, filepath.Base(filepath.Clean(s.Dir)))
+}
+
+// message tells the model where the synced files are.
+func (s syncConfig) message() (openai.ChatCompletionMessageParamUnion, bool) {
+	if s.Dir == "" {
+		return openai.ChatCompletionMessageParamUnion{}, false
+	}
+
+	text := fmt.Sprintf("The files of the user's project are in the sandbox, in the directory %s relative to where code runs. Work on those files rather than asking for them.", s.remote())
+	if s.Pull {
+		text += " Changes you make to them are copied back to the user's machine when the session ends."
+	}
+
+	return openai.SystemMessage(text), true
+}
+
+// workspaceSync is a directory uploaded to the sandbox, with the hashes of
+// the files as they were uploaded.
+type workspaceSync struct {
+	cfg    syncConfig
+	agent  *agent.Agent
+	hashes map[string]string
+}
+
+// startSync uploads the directory of cfg to the sandbox. It returns nil if
+// there is nothing to sync.
+func startSync(ctx context.Context, cfg syncConfig, a *agent.Agent) (*workspaceSync, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+
+	s := &workspaceSync{cfg: cfg, agent: a, hashes: make(map[string]string)}
+
+	batch := make(map[string]string)
+	size := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		output, err := s.run(ctx, fmt.Sprintf(`import base64, json, os
+for path, data in json.loads(%s).items():
+    path = os.path.join(%s, path)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, "wb") as f:
+        f.write(base64.b64decode(data))
+print("ok")`, pythonString(batch), strconv.Quote(s.cfg.remote())))
+
+		clear(batch)
+		size = 0
+
+		if err == nil && lastLine(output) != "ok" {
+			err = fmt.Errorf("unexpected output: %s", output)
+		}
+
+		return err
+	}
+
+	err := filepath.WalkDir(cfg.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Version control and dependencies aren't worth uploading.
+		if entry.IsDir() {
+			if path != cfg.Dir && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(cfg.Dir, path)
+
+		if info.Size() > maxSyncFile {
+			log.Printf("Not syncing %s, which is bigger than %d bytes", rel, maxSyncFile)
+			return nil
+		}
+		if secretFilePattern.MatchString(entry.Name()) {
+			log.Printf("Not syncing %s, which may hold credentials", rel)
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		batch[rel] = base64.StdEncoding.EncodeToString(data)
+		sum := sha256.Sum256(data)
+		s.hashes[rel] = hex.EncodeToString(sum[:])
+
+		if size += len(batch[rel]); size >= syncBatch {
+			return flush()
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync %s to the sandbox: %v", cfg.Dir, err)
+	}
+
+	log.Printf("Synced %d files from %s to the sandbox", len(s.hashes), cfg.Dir)
+
+	return s, nil
+}
+
+// errLocalChange is why a file changed in the sandbox isn't copied back: the
+// local file changed too, or wasn't uploaded.
+var errLocalChange = errors.New("the local file would be overwritten")
+
+// pull copies the files that changed in the sandbox, or were created there,
+// back into the directory. Files deleted there are left alone, and so are
+// local files that changed since they were uploaded, or weren't uploaded.
+func (s *workspaceSync) pull(ctx context.Context) {
+	if s == nil || !s.cfg.Pull {
+		return
+	}
+
+	// Files are copied back even when the run was interrupted.
+	ctx = context.WithoutCancel(ctx)
+
+	remote := strconv.Quote(s.cfg.remote())
+
+	output, err := s.run(ctx, fmt.Sprintf(`import hashlib, json, os
+hashes = {}
+for root, dirs, files in os.walk(%s):
+    dirs[:] = [d for d in dirs if not d.startswith(".") and d != "node_modules"]
+    for name in files:
+        path = os.path.join(root, name)
+        if os.path.isfile(path) and os.path.getsize(path) <= %d:
+            with open(path, "rb") as f:
+                hashes[os.path.relpath(path, %s)] = hashlib.sha256(f.read()).hexdigest()
+print(json.dumps(hashes))`, remote, maxSyncFile, remote))
+	if err != nil {
+		log.Printf("Failed to list the files in the sandbox: %v", err)
+		return
+	}
+
+	var hashes map[string]string
+	if err := json.Unmarshal([]byte(lastLine(output)), &hashes); err != nil {
+		log.Printf("Failed to list the files in the sandbox: %v", err)
+		return
+	}
+
+	var changed []string
+	for path, h := range hashes {
+		if s.hashes[path] != h {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+
+	for _, path := range changed {
+		err := s.pullFile(ctx, path)
+		if errors.Is(err, errLocalChange) {
+			log.Printf("Not copying %s back from the sandbox: %v", path, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to copy %s back from the sandbox: %v", path, err)
+			continue
+		}
+		log.Printf("Copied %s back from the sandbox", path)
+	}
+}
+
+func (s *workspaceSync) pullFile(ctx context.Context, path string) error {
+	// Paths come from the sandbox, so they must not escape the directory,
+	// even through symlinks in it.
+	local := filepath.FromSlash(path)
+	if !filepath.IsLocal(local) {
+		return fmt.Errorf("path is outside %s", s.cfg.Dir)
+	}
+
+	root, err := os.OpenRoot(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if err := s.checkLocal(root, local, path); err != nil {
+		return err
+	}
+
+	output, err := s.run(ctx, fmt.Sprintf(`import base64, os
+with open(os.path.join(%s, %s), "rb") as f:
+    print(base64.b64encode(f.read()).decode())`, strconv.Quote(s.cfg.remote()), strconv.Quote(path)))
+	if err != nil {
+		return err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(lastLine(output))
+	if err != nil {
+		return err
+	}
+
+	if err := mkdirAllIn(root, filepath.Dir(local)); err != nil {
+		return err
+	}
+
+	f, err := root.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// checkLocal returns errLocalChange if the local file at path isn't the one
+// that was uploaded, so copying the sandbox's back would lose it.
+func (s *workspaceSync) checkLocal(root *os.Root, local, path string) error {
+	f, err := root.Open(local)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	uploaded, ok := s.hashes[path]
+	if !ok {
+		return fmt.Errorf("%w: it exists locally but wasn't uploaded", errLocalChange)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != uploaded {
+		return fmt.Errorf("%w: it changed locally since it was uploaded", errLocalChange)
+	}
+
+	return nil
+}
+
+// mkdirAllIn creates dir and its parents within root.
+func mkdirAllIn(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if err := mkdirAllIn(root, filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := root.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	return nil
+}
+
+// run runs Python code with the sync tool, returning what it printed.
+func (s *workspaceSync) run(ctx context.Context, code string) (string, error) {
+	tool := cmp.Or(s.cfg.Tool, "sandbox_run_code")
+
+	result, failed, err := s.agent.CallTool(ctx, tool, map[string]any{cmp.Or(s.cfg.Argument, "code"): code})
+	if err != nil {
+		return "", err
+	}
+	if failed {
+		return "", fmt.Errorf("%s failed: %s", tool, result)
+	}
+
+	return result, nil
+}
+
+// pythonString returns a Python string literal of v as JSON.
+func pythonString(v any) string {
+	data, _ := json.Marshal(v)
+	return strconv.Quote(string(data))
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	return s[strings.LastIndex(s, "\n")+1:]
+}
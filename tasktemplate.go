@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// expandTask expands the Go template placeholders in a task: the -var values
+// as {{.name}}, environment variables as {{.env.NAME}} or {{env "NAME"}},
+// and files as {{file "path"}}. Unless strict, a task that isn't a valid
+// template, such as a question about one, is returned as it is.
+func expandTask(task string, vars map[string]string, strict bool) (string, error) {
+	if !strings.Contains(task, "{{") {
+		return task, nil
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+
+	data := map[string]any{"env": env}
+	for name, value := range vars {
+		data[name] = value
+	}
+
+	tmpl, err := template.New("task").Option("missingkey=error").Funcs(template.FuncMap{
+		"env": os.Getenv,
+		"file": func(path string) (string, error) {
+			content, err := os.ReadFile(path)
+			return string(content), err
+		},
+	}).Parse(task)
+	if err != nil {
+		if !strict {
+			return task, nil
+		}
+		return "", fmt.Errorf("failed to parse task template: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		if !strict {
+			return task, nil
+		}
+		return "", fmt.Errorf("failed to expand task: %v", err)
+	}
+
+	return sb.String(), nil
+}
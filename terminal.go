@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"github.com/mattn/go-isatty"
+	"github.com/muesli/termenv"
+)
+
+// terminalOut is where output for the terminal goes: stdout, through
+// asciiWriter in minimal terminals. It is set by applyTheme.
+var terminalOut = io.Writer(os.Stdout)
+
+// minimalTerminal reports whether the terminal is dumb, or a Windows console
+// that can't take escape sequences, which are turned on where they can be.
+func minimalTerminal() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return true
+	}
+
+	if runtime.GOOS == "windows" && term.IsTerminal(os.Stdout.Fd()) {
+		if _, err := termenv.EnableVirtualTerminalProcessing(termenv.DefaultOutput()); err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// asciiGlyphs replaces the symbols output is decorated with by ASCII. Other
+// text, such as answers in other languages, is left alone.
+var asciiGlyphs = strings.NewReplacer(
+	"…", "...",
+	"·", "-",
+	"✓", "+",
+	"✗", "x",
+	"→", "->",
+	"›", ">",
+	"•", "*",
+	"─", "-",
+	"│", "|",
+	"“", `"`,
+	"”", `"`,
+	"‘", "'",
+	"’", "'",
+)
+
+type asciiWriter struct {
+	w io.Writer
+}
+
+func (a asciiWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(a.w, asciiGlyphs.Replace(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// newForm returns a form asked line by line in minimal terminals, and in
+// terminals forms can't take over, such as mintty without a console.
+func newForm(groups ...*huh.Group) *huh.Form {
+	return huh.NewForm(groups...).WithAccessible(minimalOutput || isatty.IsCygwinTerminal(os.Stdin.Fd()))
+}
+
+// normalizeNewlines turns Windows and old Mac line endings into \n, so text
+// written on Windows doesn't carry carriage returns into tasks and scripts.
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// terminalSize returns the size of the terminal on stdout, or 80x24 when it
+// isn't one.
+func terminalSize() (int, int) {
+	w, h, err := term.GetSize(os.Stdout.Fd())
+	if err != nil || w <= 0 || h <= 0 {
+		return 80, 24
+	}
+
+	return w, h
+}
+
+// renderBox renders content in a box no wider than the terminal, wrapping
+// long lines.
+func renderBox(style lipgloss.Style, content string) string {
+	width, _ := terminalSize()
+
+	// Width includes padding but not the border and margin.
+	maxWidth := width - style.GetHorizontalBorderSize() - style.GetHorizontalMargins()
+	if lipgloss.Width(content)+style.GetHorizontalPadding() > maxWidth {
+		style = style.Width(max(maxWidth, 20))
+	}
+
+	return style.Render(content)
+}
+
+// writePaged writes s to out, through $PAGER (less by default) if out is the
+// terminal and s doesn't fit on it.
+func writePaged(out io.Writer, s string, noPager bool) {
+	_, height := terminalSize()
+
+	if noPager || minimalOutput || out != terminalOut || !term.IsTerminal(os.Stdout.Fd()) || strings.Count(s, "\n") < height-2 {
+		io.WriteString(out, s)
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(s)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		io.WriteString(out, s)
+	}
+}
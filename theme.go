@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+type themeConfig struct {
+	// Name is the built-in theme to start from: auto, dark or light.
+	Name string `json:"name,omitempty"`
+	// Border is the style of box borders: rounded, normal, thick, double or
+	// hidden.
+	Border string `json:"border,omitempty"`
+	// CodeStyle is the chroma style code is highlighted with.
+	CodeStyle string `json:"code_style,omitempty"`
+	// Colors overrides colors of the theme by role, as ANSI numbers or hex.
+	Colors map[string]string `json:"colors,omitempty"`
+	// Minimal draws with ASCII and the 16 basic colors only, prints instead
+	// of running full screen, and asks questions line by line. It is on by
+	// default in dumb terminals and Windows consoles without escape
+	// sequences.
+	Minimal *bool `json:"minimal,omitempty"`
+}
+
+// theme holds the colors every style is built from.
+type theme struct {
+	colors    map[string]lipgloss.TerminalColor
+	codeStyle string
+	markdown  string
+}
+
+var themeColors = []string{"accent", "success", "warning", "error", "muted", "subtle", "text"}
+
+var themes = map[string]theme{
+	"dark": {
+		colors: map[string]lipgloss.TerminalColor{
+			"accent":  lipgloss.Color("62"),
+			"success": lipgloss.Color("42"),
+			"warning": lipgloss.Color("214"),
+			"error":   lipgloss.Color("196"),
+			"muted":   lipgloss.Color("241"),
+			"subtle":  lipgloss.Color("245"),
+			"text":    lipgloss.Color("252"),
+		},
+		codeStyle: "monokai",
+		markdown:  "dark",
+	},
+	// basic only uses the 16 colors every terminal has, whose shades the
+	// terminal picks.
+	"basic": {
+		colors: map[string]lipgloss.TerminalColor{
+			"accent":  lipgloss.Color("4"),
+			"success": lipgloss.Color("2"),
+			"warning": lipgloss.Color("3"),
+			"error":   lipgloss.Color("1"),
+			"muted":   lipgloss.Color("8"),
+			"subtle":  lipgloss.Color("7"),
+			"text":    lipgloss.Color("15"),
+		},
+		codeStyle: "bw",
+		markdown:  "ascii",
+	},
+	"light": {
+		colors: map[string]lipgloss.TerminalColor{
+			"accent":  lipgloss.Color("25"),
+			"success": lipgloss.Color("28"),
+			"warning": lipgloss.Color("130"),
+			"error":   lipgloss.Color("160"),
+			"muted":   lipgloss.Color("244"),
+			"subtle":  lipgloss.Color("240"),
+			"text":    lipgloss.Color("255"),
+		},
+		codeStyle: "github",
+		markdown:  "light",
+	},
+}
+
+var borders = map[string]lipgloss.Border{
+	"rounded": lipgloss.RoundedBorder(),
+	"normal":  lipgloss.NormalBorder(),
+	"thick":   lipgloss.ThickBorder(),
+	"double":  lipgloss.DoubleBorder(),
+	"hidden":  lipgloss.HiddenBorder(),
+	"ascii":   lipgloss.ASCIIBorder(),
+}
+
+// minimalOutput is set by applyTheme when the terminal is minimal.
+var minimalOutput bool
+
+// colorEnabled reports whether stdout is a terminal that should get colors.
+// NO_COLOR turns them off.
+func colorEnabled() bool {
+	return lipgloss.ColorProfile() != termenv.Ascii
+}
+
+// applyTheme builds the styles used for output. Without colors, code and
+// Markdown are printed without escape sequences.
+func applyTheme(cfg themeConfig) error {
+	minimalOutput = minimalTerminal()
+	if cfg.Minimal != nil {
+		minimalOutput = *cfg.Minimal
+	}
+	terminalOut = io.Writer(os.Stdout)
+	if minimalOutput {
+		terminalOut = asciiWriter{os.Stdout}
+	}
+
+	name := cfg.Name
+	switch {
+	case (name == "" || name == "auto") && minimalOutput:
+		name = "basic"
+	case name == "" || name == "auto":
+		name = "dark"
+		if colorEnabled() && !lipgloss.HasDarkBackground() {
+			name = "light"
+		}
+	}
+
+	t, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, expected auto or one of %s", cfg.Name, strings.Join(slices.Sorted(maps.Keys(themes)), ", "))
+	}
+	t.colors = maps.Clone(t.colors)
+
+	for role, color := range cfg.Colors {
+		if !slices.Contains(themeColors, role) {
+			return fmt.Errorf("unknown theme color %q, expected one of %s", role, strings.Join(themeColors, ", "))
+		}
+		t.colors[role] = lipgloss.Color(color)
+	}
+
+	if cfg.CodeStyle != "" {
+		if !slices.Contains(styles.Names(), cfg.CodeStyle) {
+			return fmt.Errorf("unknown code style %q", cfg.CodeStyle)
+		}
+		t.codeStyle = cfg.CodeStyle
+	}
+
+	border := borders["rounded"]
+	if minimalOutput {
+		border = borders["ascii"]
+	}
+	if cfg.Border != "" {
+		if border, ok = borders[cfg.Border]; !ok {
+			return fmt.Errorf("unknown border %q, expected one of %s", cfg.Border, strings.Join(slices.Sorted(maps.Keys(borders)), ", "))
+		}
+	}
+
+	if !colorEnabled() {
+		t.codeStyle = ""
+		t.markdown = "notty"
+	}
+	currentTheme = t
+
+	c := t.colors
+
+	codeBoxStyle = lipgloss.NewStyle().
+		Border(border).
+		BorderForeground(c["accent"]).
+		Padding(1, 2).
+		MarginLeft(2)
+	resultBoxStyle = lipgloss.NewStyle().
+		Border(border).
+		BorderForeground(c["success"]).
+		Padding(1, 2).
+		MarginLeft(2)
+	reasoningBoxStyle = lipgloss.NewStyle().
+		Border(border).
+		BorderForeground(c["muted"]).
+		Foreground(c["muted"]).
+		Faint(true).
+		Padding(0, 2).
+		MarginLeft(2)
+	warningStyle = lipgloss.NewStyle().Foreground(c["warning"]).MarginLeft(2)
+	toolNameStyle = lipgloss.NewStyle().Bold(true).Foreground(c["accent"]).MarginLeft(2)
+	statusStyle = lipgloss.NewStyle().Foreground(c["muted"]).MarginLeft(2)
+	serverLogStyle = lipgloss.NewStyle().Foreground(c["subtle"]).Faint(true).MarginLeft(2)
+
+	sessionIDStyle = lipgloss.NewStyle().Foreground(c["accent"])
+
+	passStyle = lipgloss.NewStyle().Bold(true).Foreground(c["success"])
+	failStyle = lipgloss.NewStyle().Bold(true).Foreground(c["error"])
+	warnStyle = lipgloss.NewStyle().Bold(true).Foreground(c["warning"])
+
+	compareTitleStyle = lipgloss.NewStyle().Bold(true).Foreground(c["accent"])
+	compareStatsStyle = lipgloss.NewStyle().Foreground(c["subtle"])
+
+	reviewFileStyle = lipgloss.NewStyle().Bold(true).Foreground(c["accent"])
+	reviewLineStyle = lipgloss.NewStyle().Foreground(c["subtle"])
+	reviewSeverityStyles = map[string]lipgloss.Style{
+		"issue":      lipgloss.NewStyle().Bold(true).Foreground(c["error"]),
+		"suggestion": lipgloss.NewStyle().Bold(true).Foreground(c["warning"]),
+		"nit":        lipgloss.NewStyle().Foreground(c["subtle"]),
+	}
+
+	tuiPaneStyle = lipgloss.NewStyle().
+		Border(border).
+		BorderForeground(c["accent"]).
+		Padding(0, 1)
+	tuiStatusStyle = lipgloss.NewStyle().
+		Foreground(c["text"]).
+		Background(c["accent"]).
+		Padding(0, 1)
+	tuiInputStyle = lipgloss.NewStyle().
+		Border(border).
+		BorderForeground(c["muted"])
+	tuiToolErrorStyle = lipgloss.NewStyle().Foreground(c["error"])
+
+	return nil
+}
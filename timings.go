@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// timings breaks down where the time of a task went, turn by turn, for
+// -timings.
+type timings struct {
+	started time.Time
+	turns   []turnTiming
+	current turnTiming
+
+	// finished is the turn the last event observed finished, if any.
+	finished *turnTiming
+}
+
+// turnTiming is the time taken by the completions of a turn, usually one
+// unless it was retried or continued, and the tool calls they made.
+type turnTiming struct {
+	completions int
+	completion  time.Duration
+	firstToken  time.Duration
+	queued      time.Duration
+	tools       []toolTiming
+}
+
+type toolTiming struct {
+	name     string
+	duration time.Duration
+	queued   time.Duration
+}
+
+func (t *timings) reset() {
+	*t = timings{started: time.Now()}
+}
+
+// observe adds the time taken by completions and tool calls to the current
+// turn, until it finishes.
+func (t *timings) observe(event agent.Event) {
+	t.finished = nil
+
+	switch event := event.(type) {
+	case agent.UsageUpdated:
+		t.current.completions++
+		t.current.completion += event.Turn.Duration
+		t.current.firstToken += event.Turn.FirstToken
+		t.current.queued += event.Turn.Queued
+	case agent.ToolCallFinished:
+		t.current.tools = append(t.current.tools, toolTiming{
+			name:     event.ToolCall.Function.Name,
+			duration: event.Duration,
+			queued:   event.Queued,
+		})
+	case agent.TurnFinished:
+		if t.current.completions == 0 && len(t.current.tools) == 0 {
+			return
+		}
+
+		turn := t.current
+		t.turns = append(t.turns, turn)
+		t.current = turnTiming{}
+		t.finished = &turn
+	}
+}
+
+func (t turnTiming) toolTime() time.Duration {
+	var total time.Duration
+	for _, tool := range t.tools {
+		total += tool.duration
+	}
+
+	return total
+}
+
+func (t turnTiming) toolQueued() time.Duration {
+	var total time.Duration
+	for _, tool := range t.tools {
+		total += tool.queued
+	}
+
+	return total
+}
+
+func (t turnTiming) String() string {
+	completion := "completion " + formatTiming(t.completion)
+	if t.completions > 1 {
+		completion = fmt.Sprintf("%d completions %s", t.completions, formatTiming(t.completion))
+	}
+
+	var details []string
+	if t.firstToken > 0 {
+		details = append(details, "first token "+formatTiming(t.firstToken))
+	}
+	if t.queued >= time.Millisecond {
+		details = append(details, "queued "+formatTiming(t.queued))
+	}
+	if len(details) > 0 {
+		completion += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	parts := []string{completion}
+
+	if len(t.tools) > 0 {
+		var tools []string
+		for _, tool := range t.tools {
+			s := tool.name + " " + formatTiming(tool.duration)
+			if tool.queued >= time.Millisecond {
+				s += " (queued " + formatTiming(tool.queued) + ")"
+			}
+			tools = append(tools, s)
+		}
+
+		parts = append(parts, "tools "+strings.Join(tools, ", "))
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// summary totals the turns of the task. Tool calls running in parallel are
+// counted in full, so the parts can add up to more than the total.
+func (t *timings) summary() string {
+	var (
+		completions, toolCalls, calling, streamed int
+		completion, firstToken, tool, queued      time.Duration
+	)
+
+	for _, turn := range t.turns {
+		completions += turn.completions
+		completion += turn.completion
+		queued += turn.queued + turn.toolQueued()
+		toolCalls += len(turn.tools)
+		tool += turn.toolTime()
+		if len(turn.tools) > 0 {
+			calling++
+		}
+
+		if turn.firstToken > 0 {
+			firstToken += turn.firstToken
+			streamed += turn.completions
+		}
+	}
+
+	parts := []string{
+		fmt.Sprintf("%s in %s", plural(len(t.turns), "turn"), formatTiming(time.Since(t.started))),
+		fmt.Sprintf("%s %s", plural(completions, "completion"), formatTiming(completion)),
+	}
+	if streamed > 0 {
+		parts = append(parts, "first token "+formatTiming(firstToken/time.Duration(streamed))+" on average")
+	}
+	if toolCalls > 0 {
+		parts = append(parts, fmt.Sprintf("%s %s (%.1f a turn)", plural(toolCalls, "tool call"), formatTiming(tool), float64(toolCalls)/float64(calling)))
+	}
+	if queued >= time.Millisecond {
+		parts = append(parts, "queued "+formatTiming(queued))
+	}
+
+	return "Timings: " + strings.Join(parts, " · ")
+}
+
+// timingsRecord is the breakdown in the -output json record of a run.
+type timingsRecord struct {
+	Turns []turnTimingRecord `json:"turns"`
+}
+
+type turnTimingRecord struct {
+	Completions  int                `json:"completions"`
+	CompletionMS int64              `json:"completion_ms"`
+	FirstTokenMS int64              `json:"first_token_ms,omitempty"`
+	QueuedMS     int64              `json:"queued_ms,omitempty"`
+	ToolCalls    []toolTimingRecord `json:"tool_calls,omitempty"`
+}
+
+type toolTimingRecord struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+	QueuedMS   int64  `json:"queued_ms,omitempty"`
+}
+
+func (t *timings) record() *timingsRecord {
+	record := &timingsRecord{Turns: []turnTimingRecord{}}
+
+	for _, turn := range t.turns {
+		r := turnTimingRecord{
+			Completions:  turn.completions,
+			CompletionMS: turn.completion.Milliseconds(),
+			FirstTokenMS: turn.firstToken.Milliseconds(),
+			QueuedMS:     turn.queued.Milliseconds(),
+		}
+		for _, tool := range turn.tools {
+			r.ToolCalls = append(r.ToolCalls, toolTimingRecord{
+				Name:       tool.name,
+				DurationMS: tool.duration.Milliseconds(),
+				QueuedMS:   tool.queued.Milliseconds(),
+			})
+		}
+
+		record.Turns = append(record.Turns, r)
+	}
+
+	return record
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func formatTiming(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+
+	return d.Round(10 * time.Millisecond).String()
+}
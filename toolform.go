@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolArgument is a form field for one parameter of a tool. Every field is
+// edited as text, except booleans, and converted back to the parameter's
+// type when the form is submitted.
+type toolArgument struct {
+	name     string
+	kind     string
+	required bool
+
+	text    string
+	boolean bool
+}
+
+// toolForm builds a form asking for the arguments of a tool from its input
+// schema. The returned function converts the answers into arguments once the
+// form has run. Optional parameters left empty are omitted.
+func toolForm(tool mcp.Tool) (*huh.Form, func() (map[string]any, error), error) {
+	schema := tool.InputSchema
+	if len(tool.RawInputSchema) > 0 {
+		if err := json.Unmarshal(tool.RawInputSchema, &schema); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse input schema: %v", err)
+		}
+	}
+
+	// Required parameters first, then alphabetically.
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		ra, rb := slices.Contains(schema.Required, a), slices.Contains(schema.Required, b)
+		if ra != rb {
+			if ra {
+				return -1
+			}
+			return 1
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	var (
+		args   []*toolArgument
+		fields []huh.Field
+	)
+
+	for _, name := range names {
+		prop, _ := schema.Properties[name].(map[string]any)
+
+		arg := &toolArgument{
+			name:     name,
+			required: slices.Contains(schema.Required, name),
+		}
+		arg.kind, _ = prop["type"].(string)
+		args = append(args, arg)
+
+		title := name
+		if arg.required {
+			title += " *"
+		}
+		description, _ := prop["description"].(string)
+
+		if def, ok := prop["default"]; ok {
+			if b, ok := def.(bool); ok {
+				arg.boolean = b
+			} else if s, ok := def.(string); ok {
+				arg.text = s
+			} else if data, err := json.Marshal(def); err == nil {
+				arg.text = string(data)
+			}
+		}
+
+		if enum, ok := prop["enum"].([]any); ok && len(enum) > 0 {
+			var options []huh.Option[string]
+			if !arg.required {
+				options = append(options, huh.NewOption("(none)", ""))
+			}
+			for _, value := range enum {
+				label := fmt.Sprint(value)
+				options = append(options, huh.NewOption(label, label))
+			}
+
+			if arg.text == "" && arg.required {
+				arg.text = fmt.Sprint(enum[0])
+			}
+
+			fields = append(fields, huh.NewSelect[string]().
+				Title(title).
+				Description(description).
+				Options(options...).
+				Value(&arg.text))
+			continue
+		}
+
+		switch arg.kind {
+		case "boolean":
+			fields = append(fields, huh.NewConfirm().
+				Title(title).
+				Description(description).
+				Value(&arg.boolean))
+		case "object", "array":
+			fields = append(fields, huh.NewText().
+				Title(title).
+				Description(strings.TrimSpace(description+" (JSON, ctrl+e to edit in $EDITOR)")).
+				Editor(editorCommand()...).
+				EditorExtension("json").
+				Value(&arg.text).
+				Validate(arg.validate))
+		default:
+			fields = append(fields, huh.NewInput().
+				Title(title).
+				Description(description).
+				Value(&arg.text).
+				Validate(arg.validate))
+		}
+	}
+
+	if len(fields) == 0 {
+		fields = append(fields, huh.NewNote().Title(tool.Name).Description(tr("This tool takes no arguments.")))
+	}
+
+	form := newForm(huh.NewGroup(fields...).Title(tool.Name).Description(tool.Description))
+
+	collect := func() (map[string]any, error) {
+		values := make(map[string]any)
+
+		for _, arg := range args {
+			value, ok, err := arg.value()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				values[arg.name] = value
+			}
+		}
+
+		return values, nil
+	}
+
+	return form, collect, nil
+}
+
+func (a *toolArgument) validate(text string) error {
+	if strings.TrimSpace(text) == "" {
+		if a.required {
+			return fmt.Errorf("%s is required", a.name)
+		}
+		return nil
+	}
+
+	_, _, err := a.parse(text)
+	return err
+}
+
+func (a *toolArgument) value() (any, bool, error) {
+	if a.kind == "boolean" {
+		return a.boolean, true, nil
+	}
+
+	return a.parse(a.text)
+}
+
+func (a *toolArgument) parse(text string) (any, bool, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, false, nil
+	}
+
+	switch a.kind {
+	case "integer":
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s must be an integer", a.name)
+		}
+		return v, true, nil
+	case "number":
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s must be a number", a.name)
+		}
+		return v, true, nil
+	case "object", "array":
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return nil, false, fmt.Errorf("%s must be JSON: %v", a.name, err)
+		}
+		return v, true, nil
+	default:
+		return text, true, nil
+	}
+}
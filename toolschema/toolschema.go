@@ -0,0 +1,128 @@
+// Package toolschema converts MCP tool definitions into the tool formats of
+// chat completion APIs. It has no state and makes no requests, so it can be
+// used on its own by anything bridging MCP servers and models.
+package toolschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/openai/openai-go"
+)
+
+// InputSchema returns the input schema of a tool as plain JSON values, the
+// way it is sent over the wire, from RawInputSchema if set. It is always an
+// object schema with properties, which some APIs insist on even for tools
+// without parameters. $schema is dropped, as several APIs reject it.
+func InputSchema(tool mcp.Tool) (map[string]any, error) {
+	data, err := json.Marshal(tool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tool %s: %v", tool.Name, err)
+	}
+
+	var wire struct {
+		InputSchema map[string]any `json:"inputSchema"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal input schema of %s: %v", tool.Name, err)
+	}
+
+	schema := wire.InputSchema
+	if schema == nil {
+		schema = make(map[string]any)
+	}
+
+	if t, _ := schema["type"].(string); t == "" {
+		schema["type"] = "object"
+	} else if t != "object" {
+		return nil, fmt.Errorf("input schema of %s has type %s, expected object", tool.Name, t)
+	}
+
+	if properties, ok := schema["properties"].(map[string]any); !ok || properties == nil {
+		schema["properties"] = map[string]any{}
+	}
+	if required, ok := schema["required"].([]any); ok && len(required) == 0 {
+		delete(schema, "required")
+	}
+	delete(schema, "$schema")
+
+	return schema, nil
+}
+
+// namePattern is what OpenAI accepts as function names, which other APIs
+// accept too.
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Validate reports what about a tool chat completion APIs would reject: a
+// name they don't accept, an input schema that isn't an object, or one
+// combining schemas at the top level.
+func Validate(tool mcp.Tool) error {
+	if !namePattern.MatchString(tool.Name) {
+		return fmt.Errorf("tool %q has a name APIs reject, which must be 1 to 64 letters, digits, underscores or dashes", tool.Name)
+	}
+
+	schema, err := InputSchema(tool)
+	if err != nil {
+		return err
+	}
+
+	for _, keyword := range []string{"anyOf", "oneOf", "allOf", "not", "enum"} {
+		if _, ok := schema[keyword]; ok {
+			return fmt.Errorf("input schema of %s has %s at the top level, which APIs reject", tool.Name, keyword)
+		}
+	}
+
+	return nil
+}
+
+// OpenAI converts tools to OpenAI function tools.
+func OpenAI(tools []mcp.Tool) ([]openai.ChatCompletionToolParam, error) {
+	var converted []openai.ChatCompletionToolParam
+
+	for _, tool := range tools {
+		schema, err := InputSchema(tool)
+		if err != nil {
+			return nil, err
+		}
+
+		converted = append(converted, openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        tool.Name,
+				Description: openai.String(tool.Description),
+				Parameters:  openai.FunctionParameters(schema),
+			},
+		})
+	}
+
+	return converted, nil
+}
+
+// AnthropicTool is a tool definition for Anthropic's Messages API. It
+// marshals to the JSON the API expects in the tools array.
+type AnthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// Anthropic converts tools to Anthropic tool definitions.
+func Anthropic(tools []mcp.Tool) ([]AnthropicTool, error) {
+	var converted []AnthropicTool
+
+	for _, tool := range tools {
+		schema, err := InputSchema(tool)
+		if err != nil {
+			return nil, err
+		}
+
+		converted = append(converted, AnthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: schema,
+		})
+	}
+
+	return converted, nil
+}
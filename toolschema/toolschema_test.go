@@ -0,0 +1,305 @@
+package toolschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Input schemas of tools offered by real MCP servers.
+var realSchemas = []string{
+	// filesystem read_file
+	`{"type":"object","properties":{"path":{"type":"string"}},"required":["path"],"additionalProperties":false,"$schema":"http://json-schema.org/draft-07/schema#"}`,
+	// github create_issue
+	`{"type":"object","properties":{"owner":{"type":"string","description":"Repository owner"},"repo":{"type":"string","description":"Repository name"},"title":{"type":"string","description":"Issue title"},"body":{"type":"string","description":"Issue body content"},"assignees":{"type":"array","items":{"type":"string"},"description":"Usernames to assign to this issue"},"labels":{"type":"array","items":{"type":"string"},"description":"Labels to apply to this issue"},"milestone":{"type":"number","description":"Milestone number"}},"required":["owner","repo","title"]}`,
+	// FastMCP tool taking a Pydantic model
+	`{"$defs":{"Filter":{"properties":{"field":{"title":"Field","type":"string"},"op":{"enum":["eq","ne","lt","gt"],"title":"Op","type":"string"},"value":{"title":"Value"}},"required":["field","op","value"],"title":"Filter","type":"object"}},"properties":{"table":{"title":"Table","type":"string"},"filters":{"items":{"$ref":"#/$defs/Filter"},"title":"Filters","type":"array"},"limit":{"default":100,"title":"Limit","type":"integer"}},"required":["table"],"title":"queryArguments","type":"object"}`,
+	// fetch
+	`{"description":"Parameters for fetching a URL.","properties":{"url":{"description":"URL to fetch","format":"uri","minLength":1,"title":"Url","type":"string"},"max_length":{"default":5000,"exclusiveMaximum":1000000,"exclusiveMinimum":0,"type":"integer"},"raw":{"default":false,"type":"boolean"}},"required":["url"],"title":"Fetch","type":"object"}`,
+	// a tool without parameters
+	`{"type":"object","properties":{},"required":[]}`,
+	`{}`,
+}
+
+func rawTool(name, schema string) mcp.Tool {
+	return mcp.NewToolWithRawSchema(name, "A tool.", json.RawMessage(schema))
+}
+
+func decode(t testing.TB, s string) map[string]any {
+	t.Helper()
+
+	var v map[string]any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestInputSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		tool   mcp.Tool
+		want   string
+		errMsg string
+	}{
+		{
+			name: "no parameters",
+			tool: mcp.NewTool("ping"),
+			want: `{"type":"object","properties":{}}`,
+		},
+		{
+			name: "empty raw schema",
+			tool: rawTool("ping", `{}`),
+			want: `{"type":"object","properties":{}}`,
+		},
+		{
+			name: "nested objects",
+			tool: rawTool("create", `{"type":"object","properties":{"user":{"type":"object","properties":{"name":{"type":"string"},"address":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}},"required":["name"]}},"required":["user"]}`),
+			want: `{"type":"object","properties":{"user":{"type":"object","properties":{"name":{"type":"string"},"address":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}},"required":["name"]}},"required":["user"]}`,
+		},
+		{
+			name: "refs and defs are kept",
+			tool: rawTool("query", `{"type":"object","$defs":{"Op":{"type":"string","enum":["eq","ne"]}},"properties":{"op":{"$ref":"#/$defs/Op"}}}`),
+			want: `{"type":"object","$defs":{"Op":{"type":"string","enum":["eq","ne"]}},"properties":{"op":{"$ref":"#/$defs/Op"}}}`,
+		},
+		{
+			name: "enums",
+			tool: mcp.NewTool("sort", mcp.WithString("order", mcp.Enum("asc", "desc"), mcp.Required())),
+			want: `{"type":"object","properties":{"order":{"type":"string","enum":["asc","desc"]}},"required":["order"]}`,
+		},
+		{
+			name: "missing required",
+			tool: rawTool("search", `{"type":"object","properties":{"q":{"type":"string"}}}`),
+			want: `{"type":"object","properties":{"q":{"type":"string"}}}`,
+		},
+		{
+			name: "empty required is dropped",
+			tool: rawTool("search", `{"type":"object","properties":{"q":{"type":"string"}},"required":[]}`),
+			want: `{"type":"object","properties":{"q":{"type":"string"}}}`,
+		},
+		{
+			name: "$schema is dropped",
+			tool: rawTool("read", `{"$schema":"http://json-schema.org/draft-07/schema#","type":"object","properties":{"path":{"type":"string"}}}`),
+			want: `{"type":"object","properties":{"path":{"type":"string"}}}`,
+		},
+		{
+			name: "missing type and properties",
+			tool: rawTool("read", `{"required":["path"]}`),
+			want: `{"type":"object","properties":{},"required":["path"]}`,
+		},
+		{
+			name:   "not an object",
+			tool:   rawTool("list", `{"type":"array","items":{"type":"string"}}`),
+			errMsg: "has type array, expected object",
+		},
+		{
+			name:   "invalid JSON",
+			tool:   rawTool("broken", `{"type":"object",`),
+			errMsg: "failed to marshal tool broken",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InputSchema(tt.tool)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(roundTrip(t, got), want) {
+				t.Errorf("schema = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tool   mcp.Tool
+		errMsg string
+	}{
+		{name: "simple", tool: mcp.NewTool("read_file", mcp.WithString("path"))},
+		{name: "nested anyOf", tool: rawTool("set", `{"type":"object","properties":{"v":{"anyOf":[{"type":"string"},{"type":"number"}]}}}`)},
+		{name: "nested enum", tool: rawTool("sort", `{"type":"object","properties":{"order":{"enum":["asc","desc"]}}}`)},
+		{name: "refs", tool: rawTool("query", realSchemas[2])},
+		{name: "empty name", tool: rawTool("", `{}`), errMsg: "has a name APIs reject"},
+		{name: "name with dots", tool: rawTool("fs.read", `{}`), errMsg: "has a name APIs reject"},
+		{name: "name too long", tool: rawTool(strings.Repeat("a", 65), `{}`), errMsg: "has a name APIs reject"},
+		{name: "top-level anyOf", tool: rawTool("set", `{"type":"object","anyOf":[{"required":["a"]},{"required":["b"]}]}`), errMsg: "has anyOf at the top level"},
+		{name: "top-level oneOf", tool: rawTool("set", `{"oneOf":[{"required":["a"]}]}`), errMsg: "has oneOf at the top level"},
+		{name: "top-level enum", tool: rawTool("set", `{"enum":[{}]}`), errMsg: "has enum at the top level"},
+		{name: "not an object", tool: rawTool("list", `{"type":"string"}`), errMsg: "expected object"},
+		{name: "invalid JSON", tool: rawTool("broken", `nope`), errMsg: "failed to marshal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.tool)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestOpenAI(t *testing.T) {
+	tools := []mcp.Tool{
+		rawTool("create_issue", realSchemas[1]),
+		rawTool("query", realSchemas[2]),
+	}
+
+	converted, err := OpenAI(tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != len(tools) {
+		t.Fatalf("got %d tools, want %d", len(converted), len(tools))
+	}
+
+	for i, tool := range converted {
+		if tool.Function.Name != tools[i].Name || tool.Function.Description.Value != tools[i].Description {
+			t.Errorf("tool %d is %s %q, want %s %q", i, tool.Function.Name, tool.Function.Description.Value, tools[i].Name, tools[i].Description)
+		}
+
+		schema, _ := InputSchema(tools[i])
+		if !reflect.DeepEqual(roundTrip(t, tool.Function.Parameters), roundTrip(t, schema)) {
+			t.Errorf("parameters of %s = %v, want %v", tool.Function.Name, tool.Function.Parameters, schema)
+		}
+	}
+
+	if _, err := OpenAI([]mcp.Tool{rawTool("broken", `{"type":"string"}`)}); err == nil {
+		t.Error("converted a tool whose schema isn't an object")
+	}
+}
+
+func TestAnthropic(t *testing.T) {
+	converted, err := Anthropic([]mcp.Tool{rawTool("read_file", realSchemas[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[{"name":"read_file","description":"A tool.","input_schema":{"additionalProperties":false,"properties":{"path":{"type":"string"}},"required":["path"],"type":"object"}}]`
+	if string(data) != want {
+		t.Errorf("JSON = %s, want %s", data, want)
+	}
+
+	if _, err := Anthropic([]mcp.Tool{rawTool("broken", `[]`)}); err == nil {
+		t.Error("converted a tool whose schema isn't an object")
+	}
+}
+
+// roundTrip returns v as it reads back from JSON.
+func roundTrip(t testing.TB, v any) map[string]any {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decode(t, string(data))
+}
+
+// checkLossless checks that a schema converts to OpenAI and Anthropic tools
+// without losing anything but what InputSchema drops or fills in.
+func checkLossless(t *testing.T, raw string, schema map[string]any) {
+	var original map[string]any
+	if json.Unmarshal([]byte(raw), &original) != nil {
+		t.Fatalf("InputSchema accepted %q, which isn't a JSON object", raw)
+	}
+
+	want := roundTrip(t, schema)
+	for key, value := range original {
+		switch key {
+		case "$schema", "type", "properties", "required":
+			continue
+		}
+		if !reflect.DeepEqual(want[key], value) {
+			t.Fatalf("InputSchema changed %s from %v to %v", key, value, want[key])
+		}
+	}
+
+	tool := rawTool("tool", raw)
+
+	openAI, err := OpenAI([]mcp.Tool{tool})
+	if err != nil {
+		t.Fatalf("OpenAI failed on a schema InputSchema accepted: %v", err)
+	}
+	if got := roundTrip(t, openAI[0].Function.Parameters); !reflect.DeepEqual(got, want) {
+		t.Fatalf("OpenAI parameters = %v, want %v", got, want)
+	}
+
+	anthropic, err := Anthropic([]mcp.Tool{tool})
+	if err != nil {
+		t.Fatalf("Anthropic failed on a schema InputSchema accepted: %v", err)
+	}
+	if got := roundTrip(t, anthropic[0].InputSchema); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Anthropic input schema = %v, want %v", got, want)
+	}
+}
+
+func FuzzInputSchema(f *testing.F) {
+	for _, schema := range realSchemas {
+		f.Add(schema)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		schema, err := InputSchema(rawTool("tool", raw))
+		if err != nil {
+			return
+		}
+
+		if schema["type"] != "object" {
+			t.Fatalf("type = %v, want object", schema["type"])
+		}
+		if _, ok := schema["properties"].(map[string]any); !ok {
+			t.Fatalf("properties = %v, want an object", schema["properties"])
+		}
+
+		checkLossless(t, raw, schema)
+	})
+}
+
+func FuzzValidate(f *testing.F) {
+	for _, schema := range realSchemas {
+		f.Add("tool", schema)
+	}
+	f.Add("fs.read", realSchemas[0])
+	f.Add("set", `{"anyOf":[{"required":["a"]}]}`)
+
+	f.Fuzz(func(t *testing.T, name, raw string) {
+		tool := rawTool(name, raw)
+		if err := Validate(tool); err != nil {
+			return
+		}
+
+		// What Validate accepts must convert.
+		schema, err := InputSchema(tool)
+		if err != nil {
+			t.Fatalf("InputSchema failed on a valid tool: %v", err)
+		}
+		checkLossless(t, raw, schema)
+	})
+}
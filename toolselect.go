@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+// toolSelectionConfig offers the model only the Results tools whose
+// descriptions are closest to each task, embedded with Model, when there are
+// more than that.
+type toolSelectionConfig struct {
+	Results int    `json:"results,omitempty"`
+	Model   string `json:"model,omitempty"`
+}
+
+// toolIndex holds the embeddings of tool descriptions, kept in the app
+// directory so tools are only embedded again when they change.
+type toolIndex struct {
+	embedder embedder
+	reranker *reranker
+	results  int
+
+	mu      sync.Mutex
+	vectors map[string][]float64
+}
+
+func newToolIndex(cfg toolSelectionConfig, embedder embedder, reranker *reranker) *toolIndex {
+	t := &toolIndex{
+		embedder: embedder,
+		reranker: reranker,
+		results:  cfg.Results,
+		vectors:  make(map[string][]float64),
+	}
+
+	if path, err := t.path(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &t.vectors)
+		}
+	}
+
+	return t
+}
+
+func (t *toolIndex) path() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(t.embedder.key()))
+
+	return filepath.Join(dir, "tools", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// selectTools returns the names of the tools closest to task, or nil if
+// there aren't more tools than would be picked.
+func (t *toolIndex) selectTools(ctx context.Context, task string, tools []openai.ChatCompletionToolParam) ([]string, error) {
+	if len(tools) <= t.results {
+		return nil, nil
+	}
+
+	vectors, err := t.embedTools(ctx, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := t.embedder.embed(ctx, []string{task})
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		tool  openai.ChatCompletionToolParam
+		score float64
+	}
+
+	matches := make([]match, len(tools))
+	for i, tool := range tools {
+		matches[i] = match{tool, cosine(query[0], vectors[i])}
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Compare(b.score, a.score)
+	})
+
+	matches = matches[:t.reranker.pool(t.results)]
+
+	descriptions := make([]string, len(matches))
+	for i, m := range matches {
+		descriptions[i] = toolText(m.tool)
+	}
+
+	var names []string
+	for _, i := range t.reranker.rerank(ctx, task, descriptions, t.results) {
+		names = append(names, matches[i].tool.Function.Name)
+	}
+
+	return names, nil
+}
+
+// embedTools returns the embeddings of tools, embedding those that aren't in
+// the index yet.
+func (t *toolIndex) embedTools(ctx context.Context, tools []openai.ChatCompletionToolParam) ([][]float64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, len(tools))
+	texts := make(map[string]string)
+
+	for i, tool := range tools {
+		text := toolText(tool)
+		sum := sha256.Sum256([]byte(text))
+
+		keys[i] = hex.EncodeToString(sum[:])
+		if _, ok := t.vectors[keys[i]]; !ok {
+			texts[keys[i]] = text
+		}
+	}
+
+	if len(texts) > 0 {
+		pending := slices.Sorted(maps.Keys(texts))
+
+		for batch := range slices.Chunk(pending, embeddingBatch) {
+			var inputs []string
+			for _, key := range batch {
+				inputs = append(inputs, texts[key])
+			}
+
+			vectors, err := t.embedder.embed(ctx, inputs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to embed tools: %v", err)
+			}
+			for i, key := range batch {
+				t.vectors[key] = vectors[i]
+			}
+		}
+
+		if err := t.save(keys); err != nil {
+			return nil, fmt.Errorf("failed to save tool embeddings: %v", err)
+		}
+	}
+
+	vectors := make([][]float64, len(tools))
+	for i, key := range keys {
+		vectors[i] = t.vectors[key]
+	}
+
+	return vectors, nil
+}
+
+// save writes the embeddings of the tools with keys, dropping those of tools
+// that are gone.
+func (t *toolIndex) save(keys []string) error {
+	path, err := t.path()
+	if err != nil {
+		return err
+	}
+
+	vectors := make(map[string][]float64)
+	for _, key := range keys {
+		vectors[key] = t.vectors[key]
+	}
+
+	data, err := json.Marshal(vectors)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// toolText is what is embedded of a tool: its name, description and the
+// names and descriptions of its parameters.
+func toolText(tool openai.ChatCompletionToolParam) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s: %s", tool.Function.Name, tool.Function.Description.Value)
+
+	properties, _ := tool.Function.Parameters["properties"].(map[string]any)
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		fmt.Fprintf(&sb, "\n%s", name)
+		if property, ok := properties[name].(map[string]any); ok {
+			if description, ok := property["description"].(string); ok {
+				fmt.Fprintf(&sb, ": %s", description)
+			}
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+// tripwireConfig is a regular expression that stops tool calls whose
+// arguments match it, either to ask the user (approve) or for good (abort).
+type tripwireConfig struct {
+	Pattern string `json:"pattern"`
+	Action  string `json:"action,omitempty"`
+}
+
+func compileTripwires(cfgs []tripwireConfig) ([]agent.Tripwire, error) {
+	var tripwires []agent.Tripwire
+
+	for _, cfg := range cfgs {
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tripwire %q: %v", cfg.Pattern, err)
+		}
+
+		action := agent.TripwireAction(cfg.Action)
+		switch action {
+		case "":
+			action = agent.TripwireApprove
+		case agent.TripwireApprove, agent.TripwireAbort:
+		default:
+			return nil, fmt.Errorf("unknown tripwire action %q, expected approve or abort", cfg.Action)
+		}
+
+		tripwires = append(tripwires, agent.Tripwire{Pattern: pattern, Action: action})
+	}
+
+	return tripwires, nil
+}
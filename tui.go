@@ -0,0 +1,841 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	tuiPaneStyle      lipgloss.Style
+	tuiStatusStyle    lipgloss.Style
+	tuiInputStyle     lipgloss.Style
+	tuiToolErrorStyle lipgloss.Style
+)
+
+// tui runs a REPL as a full screen application: a scrollable conversation,
+// a tool call pane that can be toggled, a status bar and an input box.
+type tui struct {
+	ctx  context.Context
+	repl *repl
+
+	conversation viewport.Model
+	input        textinput.Model
+	output       strings.Builder
+
+	tools     []tuiToolCall
+	showTools bool
+
+	// preview is the code of a tool call being streamed, shown below the
+	// conversation until the call starts.
+	preview string
+
+	// question is the first task, run on start.
+	question string
+
+	// The status bar shows a copy of the REPL's state, taken whenever no
+	// task is running.
+	sessionID string
+	model     string
+	usage     agent.Usage
+	credits   string
+	context   string
+
+	// recalled is the index of the history entry in the input, while
+	// browsing with up and down, and draft the input from before.
+	recalled int
+	draft    string
+
+	// search is the query of a ctrl+r search, and found the index of its
+	// match, or -1.
+	searching bool
+	search    string
+	found     int
+
+	// palette is open after ctrl+p, listing the commands matching
+	// paletteQuery, of which paletteIndex is selected.
+	palette      bool
+	paletteQuery string
+	paletteIndex int
+
+	// approval is the tool call waiting for y to run, n to decline or e to
+	// edit.
+	approval *tuiApproval
+
+	// cancel interrupts the running task or command, if any.
+	cancel      context.CancelFunc
+	interrupted bool
+
+	// followUps are the tasks of subscribed resources that changed while
+	// another task was running, to run once it's done.
+	followUps []string
+
+	width, height int
+}
+
+type tuiToolCall struct {
+	id       string
+	name     string
+	done     bool
+	err      error
+	duration time.Duration
+
+	prefetched bool
+}
+
+type (
+	tuiOutputMsg   string
+	tuiEventMsg    struct{ event agent.Event }
+	tuiDoneMsg     struct{}
+	tuiCreditsMsg  string
+	tuiFollowUpMsg string
+	tuiEditedMsg   struct {
+		text string
+		err  error
+	}
+	tuiApprovalMsg struct {
+		question approvalQuestion
+		reply    chan tuiApprovalReply
+	}
+	tuiApprovalEditedMsg struct {
+		text string
+		err  error
+	}
+)
+
+type tuiApproval struct {
+	question approvalQuestion
+	text     string
+	reply    chan tuiApprovalReply
+}
+
+type tuiApprovalReply struct {
+	text string
+	run  bool
+}
+
+// tuiWriter forwards REPL output to the conversation.
+type tuiWriter struct {
+	send func(tea.Msg)
+}
+
+func (w tuiWriter) Write(p []byte) (int, error) {
+	w.send(tuiOutputMsg(p))
+	return len(p), nil
+}
+
+func runTUI(ctx context.Context, r *repl, question string) error {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Placeholder = tr("Ask a follow-up question, or /help")
+	input.Focus()
+
+	t := &tui{
+		ctx:          ctx,
+		repl:         r,
+		conversation: viewport.New(0, 0),
+		input:        input,
+		question:     strings.TrimSpace(question),
+	}
+	t.snapshot()
+	t.resetHistory()
+
+	p := tea.NewProgram(t, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithContext(ctx))
+
+	// Output is sent from the goroutine running the task. Update must not
+	// write to it, as sending blocks until Update returns.
+	r.out = tuiWriter{send: p.Send}
+	r.render = func(event agent.Event) {
+		p.Send(tuiEventMsg{event})
+
+		// Images are handled by the TUI, as escape sequences for inline
+		// images can't be drawn inside the viewport.
+		if _, ok := event.(agent.ToolCallFinished); !ok {
+			r.renderEvent(event)
+		}
+	}
+
+	r.askApproval = func(ctx context.Context, q approvalQuestion) (string, bool, error) {
+		reply := make(chan tuiApprovalReply, 1)
+		p.Send(tuiApprovalMsg{question: q, reply: reply})
+
+		select {
+		case reply := <-reply:
+			return reply.text, reply.run, nil
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+
+	_, err := p.Run()
+	return err
+}
+
+func (t *tui) Init() tea.Cmd {
+	cmds := []tea.Cmd{textinput.Blink, t.fetchCredits(), t.waitFollowUp()}
+	if t.question != "" {
+		cmds = append(cmds, t.submit(t.question))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+func (t *tui) snapshot() {
+	t.sessionID = t.repl.sess.ID
+	t.model = t.repl.agent.Model
+	t.usage = t.repl.agent.Usage
+	t.context = t.repl.context.String()
+}
+
+func (t *tui) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		t.width, t.height = msg.Width, msg.Height
+		t.layout()
+	case tea.KeyMsg:
+		if t.approval != nil {
+			switch msg.String() {
+			case "y", "n":
+				run := msg.String() == "y"
+				t.approval.reply <- tuiApprovalReply{text: t.approval.text, run: run}
+				t.approval = nil
+				t.input.Placeholder = tr("Running, ctrl+c to interrupt")
+				return t, nil
+			case "e":
+				if t.approval.question.fixed {
+					return t, nil
+				}
+				return t, editCmd(t.approval.text, languageExtension(t.approval.question.language), func(text string, err error) tea.Msg {
+					return tuiApprovalEditedMsg{text: text, err: err}
+				})
+			}
+		}
+
+		if t.searching {
+			return t, t.updateSearch(msg)
+		}
+		if t.palette {
+			return t, t.updatePalette(msg)
+		}
+
+		switch msg.String() {
+		case "up", "down":
+			t.recall(msg.String() == "up")
+			return t, nil
+		case "ctrl+r":
+			t.searching, t.search, t.found = true, "", -1
+			return t, nil
+		case "ctrl+p":
+			t.palette, t.paletteQuery, t.paletteIndex = true, "", 0
+			return t, nil
+		case "ctrl+c", "ctrl+d":
+			if t.cancel != nil && !t.interrupted {
+				t.cancel()
+				t.interrupted = true
+				t.input.Placeholder = tr("Interrupting, ctrl+c again to quit")
+				return t, nil
+			}
+
+			return t, tea.Quit
+		case "ctrl+t":
+			t.showTools = !t.showTools
+			t.layout()
+			return t, nil
+		case "ctrl+e":
+			if t.cancel != nil {
+				return t, nil
+			}
+
+			return t, editCmd(t.input.Value(), ".md", func(text string, err error) tea.Msg {
+				return tuiEditedMsg{text: text, err: err}
+			})
+		case "pgup", "pgdown":
+			var cmd tea.Cmd
+			t.conversation, cmd = t.conversation.Update(msg)
+			return t, cmd
+		case "enter":
+			if t.cancel != nil {
+				t.queueInput()
+				return t, nil
+			}
+
+			input := strings.TrimSpace(t.input.Value())
+			t.input.SetValue("")
+
+			if input == "" {
+				return t, nil
+			}
+
+			return t, t.submit(input)
+		}
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		t.conversation, cmd = t.conversation.Update(msg)
+		return t, cmd
+	case tuiOutputMsg:
+		t.appendOutput(string(msg))
+	case tuiEventMsg:
+		t.handleEvent(msg.event)
+	case tuiDoneMsg:
+		t.approval = nil
+
+		// A preview is left behind when the task is interrupted.
+		t.preview = ""
+		t.refresh()
+		t.cancel = nil
+		t.interrupted = false
+		t.snapshot()
+		t.input.Placeholder = tr("Ask a follow-up question, or /help")
+
+		// Commands such as /prompt leave a task to edit.
+		if t.repl.draft != "" {
+			t.input.SetValue(t.repl.draft)
+			t.input.CursorEnd()
+			t.repl.draft = ""
+		}
+		cmds = append(cmds, t.fetchCredits())
+
+		if len(t.followUps) > 0 {
+			task := t.followUps[0]
+			t.followUps = t.followUps[1:]
+			cmds = append(cmds, t.submit(task))
+		} else if task, ok := t.repl.dequeue(); ok {
+			cmds = append(cmds, t.submit(task))
+		}
+	case tuiFollowUpMsg:
+		if t.cancel != nil {
+			t.followUps = append(t.followUps, string(msg))
+		} else {
+			cmds = append(cmds, t.submit(string(msg)))
+		}
+		cmds = append(cmds, t.waitFollowUp())
+	case tuiCreditsMsg:
+		t.credits = string(msg)
+	case tuiApprovalMsg:
+		t.approval = &tuiApproval{question: msg.question, text: msg.question.text, reply: msg.reply}
+		t.input.Placeholder = tr("y to run, n to decline, e to edit")
+		if msg.question.fixed {
+			t.input.Placeholder = tr("y to allow, n to block")
+		}
+		if msg.question.diff != nil {
+			t.appendOutput(codeBox(msg.question.diff(msg.question.text), "diff") + "\n")
+		}
+		t.appendOutput(warningStyle.Render(strings.TrimSpace(msg.question.title+" "+msg.question.reason)) + "\n")
+	case tuiApprovalEditedMsg:
+		if t.approval == nil {
+			break
+		}
+
+		err := msg.err
+		if err == nil {
+			err = t.approval.question.validate(msg.text)
+		}
+		if err != nil {
+			t.appendOutput(warningStyle.Render("Discarded the edit: "+err.Error()) + "\n")
+			break
+		}
+
+		t.approval.text = msg.text
+		if diff := t.approval.question.diff; diff != nil && diff(msg.text) != "" {
+			t.appendOutput(codeBox(diff(msg.text), "diff") + "\n")
+		} else {
+			t.appendOutput(codeBox(msg.text, t.approval.question.language) + "\n")
+		}
+	case tuiEditedMsg:
+		if msg.err != nil {
+			t.appendOutput(warningStyle.Render("Failed to edit task: "+msg.err.Error()) + "\n")
+		} else if msg.text != "" {
+			t.input.SetValue("")
+
+			return t, t.submit(msg.text)
+		}
+	}
+
+	var cmd tea.Cmd
+	t.input, cmd = t.input.Update(msg)
+	cmds = append(cmds, cmd)
+
+	return t, tea.Batch(cmds...)
+}
+
+// submit runs a command or a task in the background. Output reaches the
+// conversation through messages, so it must not run inside Update.
+func (t *tui) submit(input string) tea.Cmd {
+	r := t.repl
+
+	// Output from Update must not go through the REPL, see runTUI.
+	if err := r.remember(input); err != nil {
+		t.appendOutput(warningStyle.Render("Failed to save history: "+err.Error()) + "\n")
+	}
+	t.resetHistory()
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.cancel = cancel
+	t.input.Placeholder = tr("Running, ctrl+c to interrupt")
+
+	return func() tea.Msg {
+		defer cancel()
+
+		if strings.HasPrefix(input, "/") {
+			if err := r.command(ctx, input); err != nil {
+				r.print("%s", tr("Error: %v", err))
+			}
+
+			return tuiDoneMsg{}
+		}
+
+		r.print("%s", tr("Query: %s", input))
+		r.checkCredits(ctx)
+
+		before := r.agent.Usage.Cost
+		if _, err := r.runTask(ctx, input); err != nil {
+			r.warn("Failed to run agent: %v", err)
+		}
+		r.lastCost = r.agent.Usage.Cost - before
+		r.printVerdict()
+		r.printTimings()
+
+		return tuiDoneMsg{}
+	}
+}
+
+// queueInput queues the task in the input while another runs, if it is
+// given with /queue, or with queue.enter set any task.
+func (t *tui) queueInput() {
+	input := strings.TrimSpace(t.input.Value())
+
+	task, ok := strings.CutPrefix(input, "/queue ")
+	if !ok {
+		if !t.repl.cfg.Queue.Enter || input == "" || strings.HasPrefix(input, "/") {
+			return
+		}
+		task = input
+	}
+	task = strings.TrimSpace(task)
+	if task == "" {
+		return
+	}
+
+	if err := t.repl.enqueue(task); err != nil {
+		t.appendOutput(warningStyle.Render(err.Error()) + "\n")
+		return
+	}
+
+	t.input.SetValue("")
+	t.appendOutput(statusStyle.Render(tr("Queued: %s (%d waiting)", task, len(t.repl.queued()))) + "\n")
+}
+
+// edit suspends the TUI to write the task in $EDITOR, starting from the
+// input, and runs it once the editor exits.
+// editCmd opens text in the user's editor, suspending the TUI, and reports
+// the saved text with msg.
+func editCmd(text, ext string, msg func(text string, err error) tea.Msg) tea.Cmd {
+	f, err := os.CreateTemp("", "mcp-experiment-*"+ext)
+	if err != nil {
+		return func() tea.Msg { return msg("", err) }
+	}
+	f.WriteString(text)
+	f.Close()
+
+	return tea.ExecProcess(editFile(f.Name()), func(err error) tea.Msg {
+		defer os.Remove(f.Name())
+
+		if err != nil {
+			return msg("", err)
+		}
+
+		data, err := os.ReadFile(f.Name())
+		return msg(strings.TrimSpace(normalizeNewlines(string(data))), err)
+	})
+}
+
+func (t *tui) historyEntries() []string {
+	if t.repl.history == nil {
+		return nil
+	}
+
+	return t.repl.history.entries
+}
+
+func (t *tui) resetHistory() {
+	t.recalled = len(t.historyEntries())
+	t.draft = ""
+}
+
+// recall replaces the input with the previous or next history entry, and
+// restores what was typed after the newest.
+func (t *tui) recall(older bool) {
+	entries := t.historyEntries()
+
+	switch {
+	case older && t.recalled > 0:
+		if t.recalled == len(entries) {
+			t.draft = t.input.Value()
+		}
+		t.recalled--
+		t.input.SetValue(entries[t.recalled])
+	case !older && t.recalled < len(entries)-1:
+		t.recalled++
+		t.input.SetValue(entries[t.recalled])
+	case !older && t.recalled == len(entries)-1:
+		t.recalled++
+		t.input.SetValue(t.draft)
+	}
+
+	t.input.CursorEnd()
+}
+
+// updateSearch handles keys during a ctrl+r search. Typing refines the
+// query, ctrl+r finds an older match, enter takes the match into the input
+// and esc gives up.
+func (t *tui) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	history := t.repl.history
+	if history == nil {
+		t.searching = false
+		return nil
+	}
+
+	switch msg.Type {
+	case tea.KeyCtrlR:
+		if t.found > 0 {
+			if i := history.search(t.search, t.found); i >= 0 {
+				t.found = i
+			}
+		}
+	case tea.KeyEnter, tea.KeyTab:
+		if t.found >= 0 {
+			t.input.SetValue(history.entries[t.found])
+			t.input.CursorEnd()
+			t.recalled = t.found
+		}
+		t.searching = false
+	case tea.KeyEsc, tea.KeyCtrlG, tea.KeyCtrlC:
+		t.searching = false
+	case tea.KeyBackspace:
+		if runes := []rune(t.search); len(runes) > 0 {
+			t.search = string(runes[:len(runes)-1])
+			t.found = history.search(t.search, len(history.entries))
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		if msg.Type == tea.KeySpace {
+			t.search += " "
+		} else {
+			t.search += string(msg.Runes)
+		}
+		t.found = history.search(t.search, len(history.entries))
+	}
+
+	return nil
+}
+
+// paletteMatches returns the commands whose name has the letters of the
+// palette's query in order, or whose help contains it.
+func (t *tui) paletteMatches() []string {
+	query := strings.ToLower(t.paletteQuery)
+
+	var matches []string
+	for _, name := range slices.Sorted(maps.Keys(replCommands)) {
+		if containsInOrder(name, query) || strings.Contains(strings.ToLower(tr(replCommands[name].help)), query) {
+			matches = append(matches, name)
+		}
+	}
+
+	return matches
+}
+
+func containsInOrder(s, letters string) bool {
+	for _, r := range letters {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+
+	return true
+}
+
+// updatePalette handles keys while the command palette is open. Typing
+// filters the commands, up and down select one, and enter runs it, or puts
+// it in the input if it takes arguments.
+func (t *tui) updatePalette(msg tea.KeyMsg) tea.Cmd {
+	matches := t.paletteMatches()
+
+	switch msg.Type {
+	case tea.KeyUp, tea.KeyCtrlP:
+		t.paletteIndex = max(t.paletteIndex-1, 0)
+	case tea.KeyDown, tea.KeyCtrlN:
+		t.paletteIndex = min(t.paletteIndex+1, max(len(matches)-1, 0))
+	case tea.KeyEnter, tea.KeyTab:
+		t.palette = false
+		if len(matches) == 0 {
+			return nil
+		}
+
+		name := matches[t.paletteIndex]
+		if replCommands[name].usage != name {
+			t.input.SetValue(name + " ")
+			t.input.CursorEnd()
+			return nil
+		}
+		if t.cancel != nil {
+			return nil
+		}
+
+		return t.submit(name)
+	case tea.KeyEsc, tea.KeyCtrlG, tea.KeyCtrlC:
+		t.palette = false
+	case tea.KeyBackspace:
+		if runes := []rune(t.paletteQuery); len(runes) > 0 {
+			t.paletteQuery = string(runes[:len(runes)-1])
+			t.paletteIndex = 0
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		if msg.Type == tea.KeySpace {
+			t.paletteQuery += " "
+		} else {
+			t.paletteQuery += string(msg.Runes)
+		}
+		t.paletteIndex = 0
+	}
+
+	return nil
+}
+
+func (t *tui) paletteView() string {
+	width := t.conversation.Width - 4
+
+	lines := []string{toolNameStyle.UnsetMarginLeft().Render("Commands") + " " + t.paletteQuery}
+
+	matches := t.paletteMatches()
+	if len(matches) == 0 {
+		lines = append(lines, statusStyle.UnsetMarginLeft().Render("No matching commands"))
+	}
+
+	for i, name := range matches {
+		line := fmt.Sprintf("%s  %s", replCommands[name].usage, statusStyle.UnsetMarginLeft().Render(tr(replCommands[name].help)))
+		if i == t.paletteIndex {
+			line = "› " + line
+		} else {
+			line = "  " + line
+		}
+
+		lines = append(lines, lipgloss.NewStyle().MaxWidth(width-2).Render(line))
+	}
+
+	return tuiPaneStyle.Width(width).Render(strings.Join(lines, "\n"))
+}
+
+// waitFollowUp waits for the task of a subscribed resource that changed.
+func (t *tui) waitFollowUp() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case task := <-t.repl.followUps:
+			return tuiFollowUpMsg(task)
+		case <-t.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (t *tui) fetchCredits() tea.Cmd {
+	if t.repl.credits == nil {
+		return nil
+	}
+
+	return func() tea.Msg {
+		credits, err := t.repl.credits(t.ctx)
+		if err != nil {
+			return nil
+		}
+
+		return tuiCreditsMsg(credits.String())
+	}
+}
+
+func (t *tui) handleEvent(event agent.Event) {
+	// The context is measured as requests are sent, so it is kept current
+	// while the task runs.
+	t.context = t.repl.context.String()
+
+	switch event := event.(type) {
+	case agent.ToolCallDelta:
+		if code, language, ok := partialToolCode(t.repl.cfg.Output.CodeTools, event.Name, event.Arguments); ok {
+			t.preview = codeBox(code, language)
+			t.refresh()
+		}
+	case agent.ToolCallStarted:
+		t.preview = ""
+		t.refresh()
+		t.tools = append(t.tools, tuiToolCall{id: event.ToolCall.ID, name: event.ToolCall.Function.Name})
+	case agent.ToolCallFinished:
+		for i := len(t.tools) - 1; i >= 0; i-- {
+			if t.tools[i].id == event.ToolCall.ID {
+				t.tools[i].done = true
+				t.tools[i].err = event.Err
+				t.tools[i].duration = event.Duration
+				t.tools[i].prefetched = event.Prefetched
+				break
+			}
+		}
+
+		if output, ok := renderToolOutput(t.repl.cfg.Output, event); ok {
+			t.appendOutput(redact(output) + "\n")
+		}
+		for _, img := range event.Images {
+			if path, err := saveImage(img); err == nil {
+				t.appendOutput(statusStyle.Render("Image saved to "+path) + "\n")
+			}
+		}
+	case agent.UsageUpdated:
+		t.usage = event.Total
+	case agent.ServerLog:
+		t.appendOutput(serverLogStyle.Render(redact(serverLogText(event))) + "\n")
+	}
+}
+
+func (t *tui) appendOutput(s string) {
+	t.output.WriteString(s)
+	t.refresh()
+}
+
+// refresh updates the conversation, following it if it was scrolled to the
+// bottom.
+func (t *tui) refresh() {
+	atBottom := t.conversation.AtBottom()
+
+	content := t.output.String()
+	if t.preview != "" {
+		content += t.preview + "\n"
+	}
+	t.conversation.SetContent(content)
+
+	if atBottom {
+		t.conversation.GotoBottom()
+	}
+}
+
+func (t *tui) layout() {
+	inputHeight := 3
+	statusHeight := 1
+
+	width := t.width
+	if t.showTools {
+		width = t.width * 2 / 3
+	}
+
+	t.conversation.Width = width
+	t.conversation.Height = max(t.height-inputHeight-statusHeight, 1)
+	t.input.Width = t.width - 4 - len(t.input.Prompt)
+
+	t.conversation.GotoBottom()
+}
+
+func (t *tui) View() string {
+	main := t.conversation.View()
+
+	// The palette covers the bottom of the conversation.
+	if t.palette {
+		lines := strings.Split(main, "\n")
+		palette := strings.Split(t.paletteView(), "\n")
+		if len(palette) < len(lines) {
+			main = strings.Join(append(lines[:len(lines)-len(palette)], palette...), "\n")
+		}
+	}
+
+	if t.showTools {
+		main = lipgloss.JoinHorizontal(lipgloss.Top, main, t.toolPane())
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		main,
+		tuiInputStyle.Width(t.width-2).Render(t.inputView()),
+		t.statusBar(),
+	)
+}
+
+func (t *tui) inputView() string {
+	if !t.searching {
+		return t.input.View()
+	}
+
+	var match string
+	if t.found >= 0 {
+		match = strings.ReplaceAll(t.repl.history.entries[t.found], "\n", " ")
+	}
+
+	line := fmt.Sprintf("(search) %s: %s", t.search, match)
+	return lipgloss.NewStyle().MaxWidth(t.width - 4).Render(line)
+}
+
+func (t *tui) toolPane() string {
+	width := t.width - t.conversation.Width - 4
+	height := t.conversation.Height - 2
+
+	lines := []string{toolNameStyle.UnsetMarginLeft().Render("Tool calls")}
+
+	tools := t.tools
+	if len(tools) > height-1 {
+		tools = tools[len(tools)-(height-1):]
+	}
+
+	for _, call := range tools {
+		var line string
+
+		switch {
+		case !call.done:
+			line = "… " + call.name
+		case call.err != nil:
+			line = tuiToolErrorStyle.Render("✗ " + call.name + ": " + call.err.Error())
+		default:
+			line = fmt.Sprintf("✓ %s %s", call.name, call.duration.Round(time.Millisecond))
+			if call.prefetched {
+				line += " (prefetched)"
+			}
+		}
+
+		lines = append(lines, lipgloss.NewStyle().MaxWidth(width).Render(line))
+	}
+
+	return tuiPaneStyle.
+		Width(width).
+		Height(height).
+		Render(strings.Join(lines, "\n"))
+}
+
+func (t *tui) statusBar() string {
+	parts := []string{
+		t.sessionID[:8],
+		t.model,
+		t.usage.String(),
+	}
+	if t.credits != "" {
+		parts = append(parts, t.credits)
+	}
+	if t.context != "" {
+		parts = append(parts, t.context)
+	}
+	if queued := len(t.repl.queued()); queued > 0 {
+		parts = append(parts, tr("%d queued", queued))
+	}
+
+	help := tr("ctrl+p commands · ctrl+r history · ctrl+e editor · ctrl+t tools · pgup/pgdn scroll · ctrl+c quit")
+	status := strings.Join(parts, " · ")
+
+	gap := max(t.width-lipgloss.Width(status)-lipgloss.Width(help)-2, 1)
+
+	return tuiStatusStyle.Width(t.width).Render(status + strings.Repeat(" ", gap) + help)
+}
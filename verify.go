@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+)
+
+const verifyPrompt = "You are checking an AI agent's answer before it is shown to the user. " +
+	"Below are the question, the tool calls the agent made with their results, and its answer. " +
+	"Decide whether the answer is correct and supported by the tool results, score it from 1 (wrong) to 5 (correct and fully supported), " +
+	"list any claims in it that nothing below supports, and briefly say why."
+
+var verdictSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"correct":     map[string]any{"type": "boolean"},
+		"score":       map[string]any{"type": "integer"},
+		"unsupported": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"reason":      map[string]any{"type": "string"},
+	},
+	"required":             []any{"correct", "score", "unsupported", "reason"},
+	"additionalProperties": false,
+}
+
+// verifyConfig has every answer checked by Model, or the session's model.
+type verifyConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Model   string `json:"model,omitempty"`
+}
+
+// verdict is a judge model's assessment of an answer, for -verify.
+type verdict struct {
+	Model       string   `json:"model"`
+	Correct     bool     `json:"correct"`
+	Score       int      `json:"score"`
+	Unsupported []string `json:"unsupported,omitempty"`
+	Reason      string   `json:"reason"`
+}
+
+// verify asks model to judge the answer of a run, given the tool calls it
+// made as evidence.
+func (b *backend) verify(ctx context.Context, model, task string, result *agent.Result) (*verdict, error) {
+	judge := agent.New(
+		agent.WithProvider(b.provider()),
+		agent.WithRateLimits(b.rateLimits),
+		agent.WithModel(model),
+	)
+	judge.Schema = verdictSchema
+
+	answer, err := judge.Run(ctx, verifyPrompt+"\n\n"+verificationTranscript(task, result))
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verdict{Model: model}
+	if err := json.Unmarshal([]byte(answer.Answer), v); err != nil {
+		return nil, fmt.Errorf("failed to parse verdict: %v", err)
+	}
+
+	return v, nil
+}
+
+// verificationTranscript writes the question, the tool calls of a run and
+// their results, cut short like those of explained sessions, and the answer.
+func verificationTranscript(task string, result *agent.Result) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "## Question\n%s\n", task)
+
+	for _, event := range result.Events {
+		finished, ok := event.(agent.ToolCallFinished)
+		if !ok {
+			continue
+		}
+
+		output := finished.Result
+		if finished.Err != nil && output == "" {
+			output = "Error: " + finished.Err.Error()
+		}
+		if len(output) > maxExplainResult {
+			output = output[:cutAt(output, maxExplainResult)] + fmt.Sprintf("\n[… %d more characters]", len(output)-maxExplainResult)
+		}
+
+		fmt.Fprintf(&sb, "\n## Tool call %s\n%s\n\n## Tool result\n%s\n", finished.ToolCall.Function.Name, finished.ToolCall.Function.Arguments, output)
+	}
+
+	fmt.Fprintf(&sb, "\n## Answer\n%s\n", result.Answer)
+
+	return sb.String()
+}
+
+func (v *verdict) String() string {
+	status := passStyle.Render("correct")
+	if !v.Correct {
+		status = failStyle.Render("incorrect")
+	}
+
+	s := fmt.Sprintf("Verified by %s: %s, %d/5. %s", v.Model, status, v.Score, v.Reason)
+	for _, claim := range v.Unsupported {
+		s += "\n  Unsupported: " + claim
+	}
+
+	return s
+}
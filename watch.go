@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often watched files are checked for changes.
+const watchInterval = 250 * time.Millisecond
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanWatched returns the stamps of the files under paths, skipping hidden
+// directories such as .git.
+func scanWatched(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+
+			return nil
+		})
+	}
+
+	return stamps
+}
+
+// changedFiles returns the files added, removed or modified between two scans.
+func changedFiles(before, after map[string]fileStamp) []string {
+	var changed []string
+
+	for path, stamp := range after {
+		if old, ok := before[path]; !ok || old != stamp {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	return changed
+}
+
+// pollWatched sends the files that changed under paths, once they have
+// stopped changing for debounce.
+func pollWatched(ctx context.Context, paths []string, debounce time.Duration, changes chan<- []string) {
+	stamps := scanWatched(paths)
+
+	var (
+		pending    = make(map[string]bool)
+		lastChange time.Time
+	)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		next := scanWatched(paths)
+		if changed := changedFiles(stamps, next); len(changed) > 0 {
+			for _, path := range changed {
+				pending[path] = true
+			}
+			lastChange = time.Now()
+		}
+		stamps = next
+
+		if len(pending) == 0 || time.Since(lastChange) < debounce {
+			continue
+		}
+
+		select {
+		case changes <- slices.Sorted(maps.Keys(pending)):
+			pending = make(map[string]bool)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runWatch runs task, and again in a fresh session whenever files under
+// paths or the resources at uris change, until interrupted. A run still going
+// when they change is cancelled. Attached files are read, and the task
+// expanded, again for every run.
+func runWatch(ctx context.Context, cfg *config, paths, uris []string, debounce time.Duration, task string, templated bool, files []string, model string) error {
+	if task == "" {
+		return fmt.Errorf("-watch and -watch-resource need -task")
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	b, err := newBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	changes := make(chan []string)
+	go pollWatched(ctx, paths, debounce, changes)
+
+	// Resources are watched by a client of their own, which stays
+	// subscribed across runs.
+	updates := make(chan string, 16)
+	if len(uris) > 0 {
+		a, mcpClient, err := b.newAgent(ctx)
+		if err != nil {
+			return err
+		}
+		defer mcpClient.Close()
+
+		a.ResourceUpdated = func(server, uri string) {
+			select {
+			case updates <- uri:
+			default:
+			}
+		}
+
+		for _, uri := range uris {
+			if err := a.Subscribe(ctx, uri); err != nil {
+				return err
+			}
+		}
+	}
+	watched := strings.Join(slices.Concat(paths, uris), ", ")
+
+	start := func() (context.CancelFunc, <-chan struct{}) {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			if err := runWatched(runCtx, b, workspace, task, templated, files, model); err != nil && runCtx.Err() == nil {
+				printWarning("Failed to run agent: %v", err)
+			}
+			if runCtx.Err() == nil {
+				print("%s", statusStyle.Render("Watching "+watched+" for changes, ctrl+c to stop"))
+			}
+		}()
+
+		return cancel, done
+	}
+
+	cancel, done := start()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-done
+			return nil
+		case changed := <-changes:
+			cancel()
+			<-done
+
+			print("%s", statusStyle.Render("Changed: "+strings.Join(changed, ", ")))
+			cancel, done = start()
+		case uri := <-updates:
+			cancel()
+			<-done
+
+			print("%s", statusStyle.Render("Changed: "+uri))
+			cancel, done = start()
+		}
+	}
+}
+
+func runWatched(ctx context.Context, b *backend, workspace, task string, templated bool, files []string, model string) error {
+	task, err := expandTask(task, b.config().Vars, templated)
+	if err != nil {
+		return err
+	}
+
+	var attachments []*attachment
+	for _, path := range files {
+		a, err := readAttachment(path, b.config().Attachments)
+		if err != nil {
+			return fmt.Errorf("failed to attach file: %v", err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	a, mcpClient, err := b.newAgent(ctx)
+	if err != nil {
+		return err
+	}
+	defer mcpClient.Close()
+
+	r := newREPL(b.config(), newSession(workspace, model), a)
+	r.attachments = attachments
+	r.credits = b.credits
+	r.notify = notifier(b.config())
+	a.Approve = r.approve
+	a.OverrideGuardrail = r.overrideGuardrail
+
+	print("Query: %s", task)
+
+	if _, err := r.runTask(ctx, task); err != nil {
+		return err
+	}
+	r.printStatus(ctx)
+
+	return nil
+}
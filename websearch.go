@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cedws/mcp-experiment/agent"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// webSearchConfig selects the search API behind the built-in web_search
+// tool. URL is the instance to use for SearXNG, and overrides the API's
+// address for the others. The API key is read from the environment variable
+// named by APIKeyEnv, BRAVE_API_KEY or TAVILY_API_KEY by default.
+type webSearchConfig struct {
+	Provider  string `json:"provider,omitempty"`
+	URL       string `json:"url,omitempty"`
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+}
+
+const webSearchResults = 8
+
+var webSearchProviders = map[string]struct {
+	url       string
+	apiKeyEnv string
+}{
+	"searxng": {},
+	"brave":   {url: "https://api.search.brave.com/res/v1/web/search", apiKeyEnv: "BRAVE_API_KEY"},
+	"tavily":  {url: "https://api.tavily.com/search", apiKeyEnv: "TAVILY_API_KEY"},
+}
+
+type searchResult struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// webSearcher runs a query against one search API.
+type webSearcher struct {
+	httpClient *http.Client
+	provider   string
+	url        string
+	apiKey     string
+}
+
+func newWebSearcher(cfg webSearchConfig, httpClient *http.Client) (*webSearcher, error) {
+	provider, ok := webSearchProviders[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown web search provider %q", cfg.Provider)
+	}
+
+	s := &webSearcher{
+		httpClient: httpClient,
+		provider:   cfg.Provider,
+		url:        provider.url,
+	}
+	if cfg.URL != "" {
+		s.url = cfg.URL
+	}
+	if s.url == "" {
+		return nil, fmt.Errorf("web search provider %s needs a URL", cfg.Provider)
+	}
+
+	if env := cmp.Or(cfg.APIKeyEnv, provider.apiKeyEnv); env != "" {
+		s.apiKey = os.Getenv(env)
+		if s.apiKey == "" && cfg.Provider != "searxng" {
+			return nil, fmt.Errorf("%s environment variable not set", env)
+		}
+	}
+
+	return s, nil
+}
+
+func webSearchTool(s *webSearcher) agent.LocalTool {
+	return agent.LocalTool{
+		Tool: mcp.NewTool("web_search",
+			mcp.WithDescription("Search the web. Returns the title, URL and a snippet of the top results."),
+			mcp.WithString("query", mcp.Required(), mcp.Description("The search query.")),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+			query, err := request.RequireString("query")
+			if err != nil {
+				return "", err
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, builtinTimeout)
+			defer cancel()
+
+			results, err := s.search(ctx, query)
+			if err != nil {
+				return fmt.Sprintf("Failed to search: %v", err), nil
+			}
+			if len(results) == 0 {
+				return "No results.", nil
+			}
+
+			var sb strings.Builder
+			for i, result := range results {
+				fmt.Fprintf(&sb, "%d. %s\n%s\n%s\n\n", i+1, result.Title, result.URL, strings.TrimSpace(result.Snippet))
+			}
+
+			return limitOutput(strings.TrimSpace(sb.String())), nil
+		},
+	}
+}
+
+func (s *webSearcher) search(ctx context.Context, query string) ([]searchResult, error) {
+	switch s.provider {
+	case "searxng":
+		return s.searxng(ctx, query)
+	case "brave":
+		return s.brave(ctx, query)
+	default:
+		return s.tavily(ctx, query)
+	}
+}
+
+func (s *webSearcher) searxng(ctx context.Context, query string) ([]searchResult, error) {
+	u := strings.TrimSuffix(s.url, "/") + "/search?" + url.Values{"q": {query}, "format": {"json"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := s.do(req, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []searchResult
+	for _, r := range resp.Results {
+		results = append(results, searchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+
+	return limitResults(results), nil
+}
+
+func (s *webSearcher) brave(ctx context.Context, query string) ([]searchResult, error) {
+	u := s.url + "?" + url.Values{"q": {query}, "count": {fmt.Sprint(webSearchResults)}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", s.apiKey)
+
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := s.do(req, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []searchResult
+	for _, r := range resp.Web.Results {
+		results = append(results, searchResult{Title: r.Title, URL: r.URL, Snippet: r.Description})
+	}
+
+	return limitResults(results), nil
+}
+
+func (s *webSearcher) tavily(ctx context.Context, query string) ([]searchResult, error) {
+	body, err := json.Marshal(map[string]any{
+		"query":       query,
+		"max_results": webSearchResults,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := s.do(req, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []searchResult
+	for _, r := range resp.Results {
+		results = append(results, searchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+
+	return limitResults(results), nil
+}
+
+func (s *webSearcher) do(req *http.Request, v any) error {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", s.provider, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", s.provider, err)
+	}
+
+	return nil
+}
+
+func limitResults(results []searchResult) []searchResult {
+	if len(results) > webSearchResults {
+		return results[:webSearchResults]
+	}
+	return results
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// workdirConfig controls the scratch directories given to each session.
+type workdirConfig struct {
+	// Retention is how long a directory is kept after it was last used, as a
+	// duration. Zero keeps directories forever.
+	Retention string `json:"retention"`
+}
+
+func workdirsDir() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "workdirs"), nil
+}
+
+// workdir returns the session's scratch directory, creating it if needed.
+// Local tools that touch the filesystem are rooted in it, so concurrent
+// sessions don't see each other's files. Its modification time is bumped on
+// every call and used for retention.
+func (s *session) workdir() (string, error) {
+	dir, err := workdirsDir()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, s.ID)
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// useWorkdir marks the session's scratch directory as in use until the
+// returned function is called, so cleanWorkdirs leaves it alone, even in
+// another process. Tasks of the same session may share it.
+func (s *session) useWorkdir() (func(), error) {
+	dir, err := workdirsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, s.ID+".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFileShared(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+type workdirKey struct{}
+
+// withWorkdir returns a context carrying the scratch directory of the session
+// running a task, for local tools to find it.
+func withWorkdir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, workdirKey{}, dir)
+}
+
+func workdirFrom(ctx context.Context) (string, bool) {
+	dir, ok := ctx.Value(workdirKey{}).(string)
+	return dir, ok
+}
+
+// forkWorkdir copies the files of parent's scratch directory, if it has one,
+// into the child's.
+func forkWorkdir(parent, child *session) error {
+	dir, err := workdirsDir()
+	if err != nil {
+		return err
+	}
+
+	src := filepath.Join(dir, parent.ID)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return os.CopyFS(filepath.Join(dir, child.ID), os.DirFS(src))
+}
+
+// sessionCommands are the commands that start sessions, and clean up working
+// directories when they do.
+var sessionCommands = []string{"daemon", "schedule", "play", "eval", "github", "review", "mcp-server", "serve"}
+
+// cleanWorkdirs removes scratch directories that haven't been used within the
+// retention period, unless a task is using them. Only commands that start
+// sessions call it, so merely listing sessions doesn't remove anything.
+func cleanWorkdirs(cfg workdirConfig) error {
+	if cfg.Retention == "" {
+		return nil
+	}
+
+	retention, err := time.ParseDuration(cfg.Retention)
+	if err != nil {
+		return fmt.Errorf("invalid workdir retention: %v", err)
+	}
+	if retention <= 0 {
+		return nil
+	}
+
+	dir, err := workdirsDir()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-retention)
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := removeWorkdir(dir, entry.Name(), cutoff); err != nil {
+			log.Printf("Failed to remove working directory %s: %v", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// removeWorkdir removes the scratch directory of a session if no task is
+// using it, and none used it since cutoff.
+func removeWorkdir(dir, id string, cutoff time.Time) error {
+	lockPath := filepath.Join(dir, id+".lock")
+
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		return err
+	}
+	defer unlockFile(f)
+
+	// A task may have started using it since it was listed.
+	if info, err := os.Stat(filepath.Join(dir, id)); err != nil || info.ModTime().After(cutoff) {
+		return nil
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, id)); err != nil {
+		return err
+	}
+
+	return os.Remove(lockPath)
+}